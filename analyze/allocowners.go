@@ -0,0 +1,190 @@
+package analyze
+
+import (
+	"sort"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// An OwnerShare is the part of one allocation site's sampled objects
+// that one owner retains, or, seen from the owner, the part of the
+// objects it retains that came from one site.
+type OwnerShare struct {
+	Site    string     // innermost non-runtime frame of the site
+	Owner   read.ObjId // the retaining object
+	Name    string     // Owner's name, as a Namer gives it
+	Type    string     // Owner's type
+	Objects int        // sampled objects from Site which Owner retains
+	Bytes   uint64     // their total size
+}
+
+// A SiteOwners lists the owners retaining one allocation site's
+// sampled objects, most bytes first.
+type SiteOwners struct {
+	Site    string
+	Objects int
+	Bytes   uint64
+	Owners  []OwnerShare
+}
+
+// An OwnerSites lists the allocation sites of the sampled objects one
+// owner retains, most bytes first.
+type OwnerSites struct {
+	Owner    read.ObjId
+	Name     string
+	Type     string
+	Retained uint64 // Owner's retained size
+	Objects  int
+	Bytes    uint64
+	Sites    []OwnerShare
+}
+
+// AllocOwners is the result of FindAllocOwners.
+type AllocOwners struct {
+	// Sites and Owners are the two views of the cross table, each
+	// with the most bytes first.
+	Sites  []SiteOwners
+	Owners []OwnerSites
+	// Unreachable counts the sampled objects no root keeps alive, and
+	// UnreachableBytes their total size.
+	Unreachable      int
+	UnreachableBytes uint64
+}
+
+// FindAllocOwners joins the memory profile's allocation sites with the
+// objects keeping their allocations alive: for each site, which owners
+// retain its sampled objects, and for each owner, which sites the
+// sampled objects it retains were allocated at.  It connects where
+// memory was allocated with who is holding on to it.
+//
+// The owner of an object is its outermost dominator short of the
+// roots: the object which, when released, frees it.  An object only
+// the roots dominate owns itself.  Samples not at the start of a live
+// object are ignored; ReconcileMemProf reports them.
+func FindAllocOwners(d *read.Dump) *AllocOwners {
+	defer measure("FindAllocOwners")()
+	a := &AllocOwners{}
+	owner := ownerFinder(d)
+	var names *Namer
+
+	type key struct {
+		site  string
+		owner read.ObjId
+	}
+	cells := map[key]*OwnerShare{}
+	for _, smp := range d.AllocSamples {
+		if smp.Prof == nil {
+			continue
+		}
+		x := d.FindObj(smp.Addr)
+		if x == read.ObjNil || d.Addr(x) != smp.Addr {
+			continue
+		}
+		o := owner(x)
+		if o == read.ObjNil {
+			a.Unreachable++
+			a.UnreachableBytes += d.Size(x)
+			continue
+		}
+		k := key{allocSite(smp.Prof), o}
+		c := cells[k]
+		if c == nil {
+			if names == nil {
+				names = NewNamer(d)
+			}
+			c = &OwnerShare{Site: k.site, Owner: o, Name: names.Name(o), Type: d.Ft(o).Name}
+			cells[k] = c
+		}
+		c.Objects++
+		c.Bytes += d.Size(x)
+	}
+
+	sites := map[string]*SiteOwners{}
+	owners := map[read.ObjId]*OwnerSites{}
+	for _, c := range cells {
+		s := sites[c.Site]
+		if s == nil {
+			s = &SiteOwners{Site: c.Site}
+			sites[c.Site] = s
+		}
+		s.Objects += c.Objects
+		s.Bytes += c.Bytes
+		s.Owners = append(s.Owners, *c)
+
+		o := owners[c.Owner]
+		if o == nil {
+			o = &OwnerSites{Owner: c.Owner, Name: c.Name, Type: c.Type, Retained: d.RetainedSize(c.Owner)}
+			owners[c.Owner] = o
+		}
+		o.Objects += c.Objects
+		o.Bytes += c.Bytes
+		o.Sites = append(o.Sites, *c)
+	}
+	for _, s := range sites {
+		sortShares(s.Owners)
+		a.Sites = append(a.Sites, *s)
+	}
+	for _, o := range owners {
+		sortShares(o.Sites)
+		a.Owners = append(a.Owners, *o)
+	}
+	sort.Slice(a.Sites, func(i, j int) bool {
+		si, sj := &a.Sites[i], &a.Sites[j]
+		if si.Bytes != sj.Bytes {
+			return si.Bytes > sj.Bytes
+		}
+		return si.Site < sj.Site
+	})
+	sort.Slice(a.Owners, func(i, j int) bool {
+		oi, oj := &a.Owners[i], &a.Owners[j]
+		if oi.Bytes != oj.Bytes {
+			return oi.Bytes > oj.Bytes
+		}
+		return oi.Owner < oj.Owner
+	})
+	return a
+}
+
+// sortShares sorts s by bytes, largest first, then by site and owner.
+func sortShares(s []OwnerShare) {
+	sort.Slice(s, func(i, j int) bool {
+		if s[i].Bytes != s[j].Bytes {
+			return s[i].Bytes > s[j].Bytes
+		}
+		if s[i].Site != s[j].Site {
+			return s[i].Site < s[j].Site
+		}
+		return s[i].Owner < s[j].Owner
+	})
+}
+
+// ownerFinder returns a function giving the owner of an object, as
+// FindAllocOwners defines it, or ObjNil if it is unreachable.  Owners
+// are remembered, so each dominator chain is walked once.
+func ownerFinder(d *read.Dump) func(x read.ObjId) read.ObjId {
+	reachable := d.Reachable()
+	owners := map[read.ObjId]read.ObjId{}
+	return func(x read.ObjId) read.ObjId {
+		if !reachable.Has(x) {
+			return read.ObjNil
+		}
+		var path []read.ObjId
+		o := x
+		for {
+			if p, ok := owners[o]; ok {
+				o = p
+				break
+			}
+			path = append(path, o)
+			p := d.Idom(o)
+			if p == read.ObjNil {
+				break
+			}
+			o = p
+		}
+		for _, y := range path {
+			owners[y] = o
+		}
+		return o
+	}
+}
@@ -0,0 +1,180 @@
+package analyze
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// BlameOptions controls BlameAnonymous.
+type BlameOptions struct {
+	Types  int // anonymous types to blame, largest first; 0 means 10
+	Shares int // referrers and allocation sites to list for each; 0 means 5
+}
+
+// A BlameShare is the part of an anonymous type's objects which one
+// kind of referrer points to, or which were allocated at one site.
+type BlameShare struct {
+	Name    string // the referrer or site
+	Objects int
+	Bytes   uint64 // the objects' total size
+}
+
+// A TypeBlame says what an anonymous type's objects are referred to by
+// and allocated at.
+type TypeBlame struct {
+	Type    string // the type's name, its size and GC signature
+	Objects int
+	Bytes   uint64
+	// Referrers counts the objects by what points to them: the type
+	// and field of referring objects, such as "main.Cache.buf", or the
+	// kind and name of referring roots, such as "data main.pool".  An
+	// object with several kinds of referrer counts under each; one with
+	// none, under "(none)".  Most bytes first.
+	Referrers []BlameShare
+	// Sites counts the sampled objects by the site which allocated
+	// them, most bytes first.
+	Sites   []BlameShare
+	Sampled int // the objects with an allocation sample
+}
+
+// BlameAnonymous describes the largest anonymous types of d, those with
+// no type information but their size and GC signature, such as
+// "8192_", by what refers to their objects and where they were
+// allocated.  The referrers of a type of anonymous blobs, and the
+// sites allocating them, usually say what they are without looking at
+// them one by one.
+func BlameAnonymous(d *read.Dump, opts *BlameOptions) []TypeBlame {
+	defer measure("BlameAnonymous")()
+	var o BlameOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Types == 0 {
+		o.Types = 10
+	}
+	if o.Shares == 0 {
+		o.Shares = 5
+	}
+
+	counts := make([]int, len(d.FTList))
+	sizes := make([]uint64, len(d.FTList))
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		counts[d.Ft(x).Id]++
+		sizes[d.Ft(x).Id] += d.Size(x)
+	}
+	var anon []*read.FullType
+	for _, ft := range d.FTList {
+		if counts[ft.Id] > 0 && ft.Type == nil {
+			anon = append(anon, ft)
+		}
+	}
+	sort.Slice(anon, func(i, j int) bool {
+		if sizes[anon[i].Id] != sizes[anon[j].Id] {
+			return sizes[anon[i].Id] > sizes[anon[j].Id]
+		}
+		return anon[i].Name < anon[j].Name
+	})
+	if len(anon) > o.Types {
+		anon = anon[:o.Types]
+	}
+	blamed := map[*read.FullType]int{} // index in r
+	r := make([]TypeBlame, len(anon))
+	for i, ft := range anon {
+		blamed[ft] = i
+		r[i] = TypeBlame{Type: ft.Name, Objects: counts[ft.Id], Bytes: sizes[ft.Id]}
+	}
+	if len(r) == 0 {
+		return nil
+	}
+
+	refs := make([]map[string]*BlameShare, len(r))
+	sites := make([]map[string]*BlameShare, len(r))
+	for i := range r {
+		refs[i] = map[string]*BlameShare{}
+		sites[i] = map[string]*BlameShare{}
+	}
+	add := func(m map[string]*BlameShare, name string, size uint64) {
+		s := m[name]
+		if s == nil {
+			s = &BlameShare{Name: name}
+			m[name] = s
+		}
+		s.Objects++
+		s.Bytes += size
+	}
+	// Index the roots of blamed objects once; RootReferrers may
+	// list all the roots on every call.
+	rootRefs := map[read.ObjId][]read.Root{}
+	for _, root := range d.Roots() {
+		if _, ok := blamed[d.Ft(root.Edge.To)]; ok {
+			rootRefs[root.Edge.To] = append(rootRefs[root.Edge.To], root)
+		}
+	}
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		j, ok := blamed[d.Ft(x)]
+		if !ok {
+			continue
+		}
+		size := d.Size(x)
+		seen := map[string]bool{}
+		for _, y := range d.Referrers(x) {
+			for _, e := range d.Edges(y) {
+				if e.To != x {
+					continue
+				}
+				name := d.Ft(y).Name
+				if e.FieldName != "" {
+					name += "." + e.FieldName
+				}
+				seen[name] = true
+			}
+		}
+		for _, root := range rootRefs[x] {
+			seen[fmt.Sprintf("%s %s", root.Kind, root.Name)] = true
+		}
+		if len(seen) == 0 {
+			seen["(none)"] = true
+		}
+		for name := range seen {
+			add(refs[j], name, size)
+		}
+	}
+	for _, s := range d.AllocSamples {
+		x := d.FindObj(s.Addr)
+		if x == read.ObjNil || d.Addr(x) != s.Addr || s.Prof == nil {
+			continue
+		}
+		if j, ok := blamed[d.Ft(x)]; ok {
+			add(sites[j], allocSite(s.Prof), d.Size(x))
+			r[j].Sampled++
+		}
+	}
+	for i := range r {
+		r[i].Referrers = topShares(refs[i], o.Shares)
+		r[i].Sites = topShares(sites[i], o.Shares)
+	}
+	return r
+}
+
+// topShares returns the n shares of m with the most bytes, largest
+// first.
+func topShares(m map[string]*BlameShare, n int) []BlameShare {
+	r := []BlameShare{}
+	for _, s := range m {
+		r = append(r, *s)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Bytes != r[j].Bytes {
+			return r[i].Bytes > r[j].Bytes
+		}
+		return r[i].Name < r[j].Name
+	})
+	if len(r) > n {
+		r = r[:n]
+	}
+	return r
+}
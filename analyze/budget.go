@@ -0,0 +1,351 @@
+package analyze
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// A HeapBudget limits the live bytes of a heap, in all and by type and
+// package, for checking dumps taken periodically in production, or by
+// canaries, against what the program is expected to hold.
+type HeapBudget struct {
+	Total    uint64            // most bytes of all live objects; 0 for no limit
+	Types    map[string]uint64 // most bytes of the live objects of each named type
+	Packages map[string]uint64 // most bytes of the live objects of each package's types, as PackageOf gives it
+}
+
+// ParseHeapBudget reads a budget file, in JSON or YAML, such as
+//
+//	{
+//		"total": "2GiB",
+//		"types": {"main.Session": "64MiB", "[]uint8": 536870912},
+//		"packages": {"example.com/cache": "1GB"}
+//	}
+//
+// or, the same,
+//
+//	total: 2GiB
+//	types:
+//	  main.Session: 64MiB
+//	  "[]uint8": 536870912
+//	packages:
+//	  example.com/cache: 1GB
+//
+// Sizes are numbers of bytes, or strings with a unit: B, KB, MB, GB
+// and TB for powers of 1000, or KiB, MiB, GiB and TiB for powers of
+// 1024.
+// Only the YAML above is understood: the three keys, each type or
+// package on its own indented line, and # comments.  Names starting
+// with characters YAML treats specially, like "[]" or "*", must be
+// quoted.
+func ParseHeapBudget(data []byte) (*HeapBudget, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return parseJSONBudget(data)
+	}
+	return parseYAMLBudget(data)
+}
+
+func parseJSONBudget(data []byte) (*HeapBudget, error) {
+	var f struct {
+		Total    budgetSize
+		Types    map[string]budgetSize
+		Packages map[string]budgetSize
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("budget: %v", err)
+	}
+	b := &HeapBudget{Total: uint64(f.Total), Types: map[string]uint64{}, Packages: map[string]uint64{}}
+	for k, v := range f.Types {
+		b.Types[k] = uint64(v)
+	}
+	for k, v := range f.Packages {
+		b.Packages[k] = uint64(v)
+	}
+	return b, nil
+}
+
+// A budgetSize is a size in a JSON budget: a number, or a string with
+// a unit.
+type budgetSize uint64
+
+func (s *budgetSize) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		str = string(data)
+	}
+	n, err := parseSize(str)
+	*s = budgetSize(n)
+	return err
+}
+
+func parseYAMLBudget(data []byte) (*HeapBudget, error) {
+	b := &HeapBudget{Types: map[string]uint64{}, Packages: map[string]uint64{}}
+	var section map[string]uint64 // the map of the key being indented under
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		errorf := func(format string, args ...interface{}) error {
+			return fmt.Errorf("budget line %d: %s", line, fmt.Sprintf(format, args...))
+		}
+		text := sc.Text()
+		key, value, err := yamlPair(text)
+		if err != nil {
+			return nil, errorf("%v", err)
+		}
+		if key == "" {
+			continue
+		}
+		if text[0] == ' ' || text[0] == '\t' {
+			if section == nil {
+				return nil, errorf("indented %q is not under types or packages", key)
+			}
+			n, err := parseSize(value)
+			if err != nil {
+				return nil, errorf("%v", err)
+			}
+			section[key] = n
+			continue
+		}
+		section = nil
+		switch key {
+		case "total":
+			b.Total, err = parseSize(value)
+			if err != nil {
+				return nil, errorf("%v", err)
+			}
+		case "types", "packages":
+			if value != "" {
+				return nil, errorf("%s must list its limits on the lines after it", key)
+			}
+			section = b.Types
+			if key == "packages" {
+				section = b.Packages
+			}
+		default:
+			return nil, errorf("unknown key %q; want total, types or packages", key)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("budget: %v", err)
+	}
+	return b, nil
+}
+
+// yamlPair splits a line of a YAML budget into its key, unquoted, and
+// its value, with comments removed.  The key is "" for lines with
+// none.
+func yamlPair(line string) (key, value string, err error) {
+	s := strings.TrimSpace(line)
+	if s == "" || s[0] == '#' {
+		return "", "", nil
+	}
+	if s[0] == '"' || s[0] == '\'' {
+		end := strings.IndexByte(s[1:], s[0])
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated key %s", s)
+		}
+		key, s = s[1:1+end], s[2+end:]
+		if !strings.HasPrefix(s, ":") {
+			return "", "", fmt.Errorf("missing colon after key %q", key)
+		}
+		s = s[1:]
+	} else {
+		// Type names may contain colons, so the key ends at a colon
+		// followed by a space, or at the end.
+		i := strings.Index(s, ": ")
+		switch {
+		case i >= 0:
+			key, s = s[:i], s[i+2:]
+		case strings.HasSuffix(s, ":"):
+			key, s = s[:len(s)-1], ""
+		default:
+			return "", "", fmt.Errorf("missing colon in %q", s)
+		}
+	}
+	if i := strings.Index(s, "#"); i >= 0 {
+		s = s[:i]
+	}
+	value = strings.Trim(strings.TrimSpace(s), `"'`)
+	return key, value, nil
+}
+
+// sizeUnits are the units parseSize understands, longest first so
+// that "KiB" isn't read as "B".
+var sizeUnits = []struct {
+	name string
+	mult uint64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// parseSize parses a size in bytes, with an optional unit, such as
+// "1024", "64MiB" or "1.5 GB".
+func parseSize(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	mult := uint64(1)
+	num := s
+	for _, u := range sizeUnits {
+		if len(s) > len(u.name) && strings.EqualFold(s[len(s)-len(u.name):], u.name) {
+			mult, num = u.mult, strings.TrimSpace(s[:len(s)-len(u.name)])
+			break
+		}
+	}
+	if n, err := strconv.ParseUint(num, 10, 64); err == nil {
+		if n > math.MaxUint64/mult {
+			return 0, fmt.Errorf("size %q is too large", s)
+		}
+		return n * mult, nil
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || !(f >= 0) { // NaN fails f >= 0
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	// 1<<64 is the smallest float64 too large for a uint64.
+	v := f * float64(mult)
+	if !(v < 1<<64) {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return uint64(v), nil
+}
+
+// A BudgetScope says what a limit of a HeapBudget applies to.
+type BudgetScope int
+
+const (
+	BudgetTotal   BudgetScope = iota // all live objects
+	BudgetType                       // the live objects of one type
+	BudgetPackage                    // the live objects of one package's types
+	numBudgetScopes
+)
+
+var budgetScopeNames = [...]string{
+	BudgetTotal:   "total",
+	BudgetType:    "type",
+	BudgetPackage: "package",
+}
+
+func (s BudgetScope) String() string {
+	if s < 0 || s >= numBudgetScopes {
+		return fmt.Sprintf("BudgetScope(%d)", int(s))
+	}
+	return budgetScopeNames[s]
+}
+
+// MarshalText encodes s as its name, so that JSON gives "type" rather
+// than a number.
+func (s BudgetScope) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// A BudgetViolation is a limit of a HeapBudget which a dump exceeds.
+type BudgetViolation struct {
+	Scope   BudgetScope
+	Name    string // the type or package; "" for BudgetTotal
+	Limit   uint64 // the budget's limit, in bytes
+	Bytes   uint64 // the live bytes it limits
+	Objects int    // the live objects counted
+}
+
+// Over returns the bytes by which v exceeds its limit.
+func (v BudgetViolation) Over() uint64 {
+	return v.Bytes - v.Limit
+}
+
+func (v BudgetViolation) String() string {
+	what := v.Scope.String()
+	if v.Name != "" {
+		what += " " + v.Name
+	}
+	return fmt.Sprintf("%s: %d live bytes in %d objects, over the limit of %d by %d", what, v.Bytes, v.Objects, v.Limit, v.Over())
+}
+
+// Check evaluates d against the budget, returning the limits it
+// exceeds, most bytes over first.  Only live objects, those reachable
+// from the roots, count against the budget; types and packages named
+// by the budget with no live objects use none of it.
+func (b *HeapBudget) Check(d *read.Dump) []BudgetViolation {
+	defer measure("HeapBudget.Check")()
+	live := d.Reachable()
+	counts := make([]int, len(d.FTList))
+	sizes := make([]uint64, len(d.FTList))
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		if !live.Has(x) {
+			continue
+		}
+		counts[d.Ft(x).Id]++
+		sizes[d.Ft(x).Id] += d.Size(x)
+	}
+
+	type use struct {
+		objects int
+		bytes   uint64
+	}
+	var total use
+	types := map[string]*use{}
+	pkgs := map[string]*use{}
+	add := func(m map[string]*use, k string, ft *read.FullType) {
+		u := m[k]
+		if u == nil {
+			u = &use{}
+			m[k] = u
+		}
+		u.objects += counts[ft.Id]
+		u.bytes += sizes[ft.Id]
+	}
+	for _, ft := range d.FTList {
+		if counts[ft.Id] == 0 {
+			continue
+		}
+		total.objects += counts[ft.Id]
+		total.bytes += sizes[ft.Id]
+		if _, ok := b.Types[ft.Name]; ok {
+			add(types, ft.Name, ft)
+		}
+		if p := PackageOf(ft.Name); p != "" {
+			if _, ok := b.Packages[p]; ok {
+				add(pkgs, p, ft)
+			}
+		}
+	}
+
+	var r []BudgetViolation
+	if b.Total != 0 && total.bytes > b.Total {
+		r = append(r, BudgetViolation{BudgetTotal, "", b.Total, total.bytes, total.objects})
+	}
+	for _, s := range []struct {
+		scope  BudgetScope
+		limits map[string]uint64
+		uses   map[string]*use
+	}{
+		{BudgetType, b.Types, types},
+		{BudgetPackage, b.Packages, pkgs},
+	} {
+		for k, u := range s.uses {
+			if limit := s.limits[k]; u.bytes > limit {
+				r = append(r, BudgetViolation{s.scope, k, limit, u.bytes, u.objects})
+			}
+		}
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Over() != r[j].Over() {
+			return r[i].Over() > r[j].Over()
+		}
+		if r[i].Scope != r[j].Scope {
+			return r[i].Scope < r[j].Scope
+		}
+		return r[i].Name < r[j].Name
+	})
+	return r
+}
@@ -0,0 +1,81 @@
+package analyze
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseHeapBudget(t *testing.T) {
+	want := &HeapBudget{
+		Total:    2 << 30,
+		Types:    map[string]uint64{"main.Session": 64 << 20, "[]uint8": 536870912},
+		Packages: map[string]uint64{"example.com/cache": 1e9},
+	}
+	inputs := []string{`{
+	"total": "2GiB",
+	"types": {"main.Session": "64MiB", "[]uint8": 536870912},
+	"packages": {"example.com/cache": "1GB"}
+}`, `# limits for the cache server
+total: 2GiB
+types:
+  main.Session: 64MiB  # one per user
+  "[]uint8": 536870912
+packages:
+  example.com/cache: 1 GB
+`}
+	for _, in := range inputs {
+		b, err := ParseHeapBudget([]byte(in))
+		if err != nil {
+			t.Errorf("ParseHeapBudget(%q): %v", in, err)
+			continue
+		}
+		if !reflect.DeepEqual(b, want) {
+			t.Errorf("ParseHeapBudget(%q) = %+v, want %+v", in, b, want)
+		}
+	}
+
+	bad := []struct {
+		in, err string
+	}{
+		{`{"total": "2 parsecs"}`, "bad size"},
+		{`{"limit": 5}`, "unknown field"},
+		{"total: 16777216TiB", "too large"},
+		{"  main.T: 5", "not under types or packages"},
+		{"types: 5", "must list its limits"},
+		{"limit: 5", "unknown key"},
+		{"types:\n  'main.T: 5", "unterminated key"},
+	}
+	for _, tt := range bad {
+		if _, err := ParseHeapBudget([]byte(tt.in)); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ParseHeapBudget(%q) error = %v, want one containing %q", tt.in, err, tt.err)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want uint64
+		ok   bool
+	}{
+		{"1024", 1024, true},
+		{"64MiB", 64 << 20, true},
+		{"1.5 GB", 1.5e9, true},
+		{"2kb", 2000, true},
+		{"18446744073709551615", 1<<64 - 1, true},
+		{"16777215TiB", 16777215 << 40, true},
+		{"16777216TiB", 0, false},
+		{"1e30", 0, false},
+		{"Inf", 0, false},
+		{"NaN", 0, false},
+		{"-1", 0, false},
+		{"MiB", 0, false},
+	}
+	for _, tt := range tests {
+		n, err := parseSize(tt.s)
+		if (err == nil) != tt.ok || n != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d, ok %v", tt.s, n, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package analyze
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// A BufferKind says what holds a byte buffer.
+type BufferKind int
+
+const (
+	AdHocBuffer   BufferKind = iota // allocated and held directly by program code
+	PooledBuffer                    // held by a sync.Pool, free for reuse
+	BytesBuffer                     // the storage of a bytes.Buffer
+	BufioBuffer                     // the storage of a bufio.Reader or Writer
+	GarbageBuffer                   // unreachable, waiting to be collected
+	numBufferKinds
+)
+
+var bufferKindNames = [...]string{
+	AdHocBuffer:   "ad hoc",
+	PooledBuffer:  "pooled",
+	BytesBuffer:   "bytes.Buffer",
+	BufioBuffer:   "bufio",
+	GarbageBuffer: "garbage",
+}
+
+func (k BufferKind) String() string {
+	if k < 0 || int(k) >= len(bufferKindNames) {
+		return fmt.Sprintf("BufferKind(%d)", int(k))
+	}
+	return bufferKindNames[k]
+}
+
+// BufferOptions controls ClassifyBuffers.
+type BufferOptions struct {
+	// MinSize is the size of the smallest buffer to classify.  0 means
+	// 1024 bytes.
+	MinSize uint64
+	// Depth is how many dominators up from a buffer to look for the
+	// structure holding it.  0 means 8.
+	Depth int
+}
+
+// A BufferSummary totals the buffers of one kind.
+type BufferSummary struct {
+	Kind    BufferKind
+	Objects int
+	Bytes   uint64
+}
+
+// ClassifyBuffers totals the memory in large byte buffers by what
+// holds them, to answer the capacity-planning question of how much
+// buffer memory is pooled for reuse and how much is allocated ad hoc.
+// Buffers are the objects without pointers, which are mostly the
+// backing arrays of []byte and strings.  The result is indexed by
+// BufferKind.  opts may be nil.
+//
+// The structure holding a buffer is found from the type names of the
+// objects pointing to it and of its dominators, so buffers are only
+// classified usefully when the dump is read with dwarf information.
+func ClassifyBuffers(d *read.Dump, opts *BufferOptions) []BufferSummary {
+	defer measure("ClassifyBuffers")()
+	if opts == nil {
+		opts = &BufferOptions{}
+	}
+	min := opts.MinSize
+	if min == 0 {
+		min = 1024
+	}
+	depth := opts.Depth
+	if depth == 0 {
+		depth = 8
+	}
+	r := make([]BufferSummary, numBufferKinds)
+	for k := range r {
+		r[k].Kind = BufferKind(k)
+	}
+	reach := d.Reachable()
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		if d.Size(x) < min || hasPointers(d.Ft(x)) {
+			continue
+		}
+		k := GarbageBuffer
+		if reach.Has(x) {
+			k = bufferHolder(d, x, depth)
+		}
+		r[k].Objects++
+		r[k].Bytes += d.Size(x)
+	}
+	return r
+}
+
+// bufferHolder returns what holds the buffer x, looking up to depth
+// dominators above it.  x must be reachable.
+func bufferHolder(d *read.Dump, x read.ObjId, depth int) BufferKind {
+	k := AdHocBuffer
+	for n := 0; n < depth && x != read.ObjNil; n++ {
+		for _, y := range d.Referrers(x) {
+			switch h := holderKind(d.Ft(y).Name); {
+			case h == PooledBuffer:
+				// A pool holding a bytes.Buffer still holds its storage.
+				return PooledBuffer
+			case h != AdHocBuffer && k == AdHocBuffer:
+				k = h
+			}
+		}
+		x = d.Idom(x)
+	}
+	return k
+}
+
+// holderKind returns the kind of buffer held by an object of the
+// named type.
+func holderKind(name string) BufferKind {
+	switch {
+	case strings.Contains(name, "sync.Pool"), strings.Contains(name, "sync.pool"):
+		return PooledBuffer
+	case strings.Contains(name, "bytes.Buffer"):
+		return BytesBuffer
+	case strings.Contains(name, "bufio.Reader"), strings.Contains(name, "bufio.Writer"), strings.Contains(name, "bufio.Scanner"):
+		return BufioBuffer
+	}
+	return AdHocBuffer
+}
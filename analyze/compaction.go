@@ -0,0 +1,112 @@
+package analyze
+
+import (
+	"math/bits"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// PageSize is the size of the runtime's heap pages, the unit in which
+// it takes memory from the OS for spans.
+const PageSize = 8192
+
+// A Compaction compares the heap's address space with what its objects
+// would need if they were packed together.
+type Compaction struct {
+	Objects int
+	Bytes   uint64 // total size of the objects
+	// Compacted is the address space the objects would need packed
+	// end to end, each aligned as its size requires, and
+	// CompactedPages the pages that would take.
+	Compacted      uint64
+	CompactedPages uint64
+	// Span is the distance from the start of the lowest object to the
+	// end of the highest, and Pages the number of distinct pages
+	// holding part of an object.
+	Span  uint64
+	Pages uint64
+	// HeapInuse and HeapSys are the runtime's figures, from the dump's
+	// memstats, or 0 if it has none.
+	HeapInuse, HeapSys uint64
+}
+
+// Fragmentation returns the fraction of the pages in use which
+// compaction would free.
+func (c *Compaction) Fragmentation() float64 {
+	if c.Pages == 0 {
+		return 0
+	}
+	return 1 - float64(c.CompactedPages)/float64(c.Pages)
+}
+
+// SimulateCompaction computes how much address space the dump's
+// objects would take if they were moved together, against what they
+// take now.  The difference is the cost of fragmentation: memory a
+// compacting collector, or allocation with less churn, could give
+// back.  If reachable is set, unreachable objects are left out, as a
+// collection would free them.
+func SimulateCompaction(d *read.Dump, reachable bool) *Compaction {
+	defer measure("SimulateCompaction")()
+	c := &Compaction{}
+	if m := d.Memstats; m != nil {
+		c.HeapInuse, c.HeapSys = m.HeapInuse, m.HeapSys
+	}
+	var live read.ObjSet
+	if reachable {
+		live = d.Reachable()
+	}
+	lo, hi := ^uint64(0), uint64(0)
+	lastPage := ^uint64(0)
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		if reachable && !live.Has(x) {
+			continue
+		}
+		a, n := d.Addr(x), d.Size(x)
+		c.Objects++
+		c.Bytes += n
+		c.Compacted = alignUp(c.Compacted, objectAlign(d, n)) + n
+		if a < lo {
+			lo = a
+		}
+		if a+n > hi {
+			hi = a + n
+		}
+		if n == 0 {
+			continue
+		}
+		// Objects are in address order, so pages are counted once
+		// each by remembering the last one counted.
+		first, last := a/PageSize, (a+n-1)/PageSize
+		if first == lastPage {
+			first++
+		}
+		if last >= first {
+			c.Pages += last - first + 1
+			lastPage = last
+		}
+	}
+	if c.Objects > 0 {
+		c.Span = hi - lo
+	}
+	c.CompactedPages = (c.Compacted + PageSize - 1) / PageSize
+	return c
+}
+
+// objectAlign returns the alignment an object of size n needs: the
+// smallest power of two at least n, up to the largest alignment of any
+// type.
+func objectAlign(d *read.Dump, n uint64) uint64 {
+	if n <= 1 {
+		return 1
+	}
+	a := uint64(1) << uint(bits.Len64(n-1))
+	if a > d.MaxAlign {
+		a = d.MaxAlign
+	}
+	return a
+}
+
+func alignUp(n, a uint64) uint64 {
+	return (n + a - 1) &^ (a - 1)
+}
@@ -0,0 +1,12 @@
+// Package analyze computes reports about a heap dump from the object
+// graph package read provides.  Each analysis is a function of a
+// *read.Dump which uses only read's exported interface.  OnRun
+// reports the time and memory each one takes, and a ResultCache keeps
+// results for servers to reuse across requests.
+//
+// RunFindings runs detectors for common problems, such as leaked
+// goroutines.  The other analyses say where memory goes, by type,
+// owner, allocation site or data structure; compare two dumps, as
+// DiffHeaps and DiffTypes do; or estimate, and split into shards,
+// dumps too large to analyze whole.
+package analyze
@@ -0,0 +1,37 @@
+package analyze
+
+import (
+	"github.com/randall77/heapdump14/binutil"
+	"github.com/randall77/heapdump14/read"
+)
+
+// TypeGraph returns the relations between the dwarf types of d's
+// objects, and the types they refer to.  It is empty if d was read
+// without executables.
+func TypeGraph(d *read.Dump) *binutil.TypeGraph {
+	defer measure("TypeGraph")()
+	var types []binutil.Type
+	for _, ft := range d.FTList {
+		if ft.Type != nil {
+			types = append(types, ft.Type)
+		}
+	}
+	return binutil.NewTypeGraph(types)
+}
+
+// TypesEmbedding returns the types of d's objects which are, or embed,
+// the type with the given name, so that, for instance, all the objects
+// containing a sync.Mutex can be counted together.  g is d's type
+// graph; if nil, it is computed.
+func TypesEmbedding(d *read.Dump, g *binutil.TypeGraph, name string) []*read.FullType {
+	if g == nil {
+		g = TypeGraph(d)
+	}
+	var r []*read.FullType
+	for _, ft := range d.FTList {
+		if ft.Type != nil && g.EmbedsName(ft.Type, name) {
+			r = append(r, ft)
+		}
+	}
+	return r
+}
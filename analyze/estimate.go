@@ -0,0 +1,317 @@
+package analyze
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// An Estimate is the result of an analysis which may have looked at
+// only a sample of the heap.
+type Estimate struct {
+	Value     float64 // best estimate
+	Low, High float64 // 95% confidence interval
+	Samples   int     // number of units examined
+	Exact     bool    // the whole population was examined; Low == Value == High
+}
+
+// A Budget limits the work a best-effort analysis may do.
+type Budget struct {
+	Time time.Duration // 0 means no limit
+	Seed int64         // seed for choosing samples
+}
+
+func (b Budget) deadline() time.Time {
+	if b.Time == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(b.Time)
+}
+
+func expired(deadline time.Time) bool {
+	return !deadline.IsZero() && time.Now().After(deadline)
+}
+
+// A ratioSampler estimates what fraction of a population's bytes have
+// some property, from a sample of units of varying size.
+type ratioSampler struct {
+	n        int
+	hit, all float64 // bytes sampled with the property, bytes sampled
+	d, d2    []float64
+}
+
+func (s *ratioSampler) add(hit, all float64) {
+	s.n++
+	s.hit += hit
+	s.all += all
+	s.d = append(s.d, hit)
+	s.d2 = append(s.d2, all)
+}
+
+// estimate extrapolates the sample to a population of size units
+// totalling total bytes.  known is added to every value.
+func (s *ratioSampler) estimate(size int, total, known float64) Estimate {
+	if s.n == size {
+		v := known + s.hit
+		return Estimate{v, v, v, s.n, true}
+	}
+	if s.n == 0 || s.all == 0 {
+		return Estimate{known, known, known + total, s.n, false}
+	}
+	r := s.hit / s.all
+	// Variance of the ratio estimator, with finite population correction.
+	var ss float64
+	for i := range s.d {
+		e := s.d[i] - r*s.d2[i]
+		ss += e * e
+	}
+	mean := s.all / float64(s.n)
+	v := 0.0
+	if s.n > 1 {
+		v = ss / float64(s.n-1) / (float64(s.n) * mean * mean) * (1 - float64(s.n)/float64(size))
+	}
+	w := 1.96 * math.Sqrt(v)
+	// The sampled part is known exactly; only the rest is uncertain.
+	rest := total - s.all
+	lo := known + s.hit + math.Max(0, r-w)*rest
+	hi := known + s.hit + math.Min(1, r+w)*rest
+	return Estimate{known + s.hit + r*rest, lo, hi, s.n, false}
+}
+
+// EstimateRetained estimates the number of bytes retained by x, as
+// computed exactly by (*read.Dump).RetainedSize, without computing the
+// dominator tree.  It samples the objects reachable from x and checks
+// whether each can be reached from a root without going through x.
+// x should be reachable; unreachable objects retain nothing.
+//
+// With a time limit, if the dump's referrer index isn't built yet,
+// the referrers are found by a scan of the dump which gives up when
+// the budget runs out, rather than by building the index, which takes
+// as long as the dump is large.
+func EstimateRetained(d *read.Dump, x read.ObjId, b Budget) Estimate {
+	defer measure("EstimateRetained")()
+	deadline := b.deadline()
+	giveUp := Estimate{float64(d.Size(x)), float64(d.Size(x)), float64(d.HeapEnd - d.HeapStart), 0, false}
+	referrers := d.Referrers
+	if !deadline.IsZero() && !d.HasReferrers() {
+		var ok bool
+		if referrers, ok = scanReferrers(d, deadline); !ok {
+			return giveUp
+		}
+	}
+	rooted := map[read.ObjId]bool{}
+	for _, r := range d.Roots() {
+		rooted[r.Edge.To] = true
+	}
+
+	// Find everything reachable from x.  Only those objects can be retained by it.
+	var reach []read.ObjId
+	var total float64
+	seen := map[read.ObjId]bool{x: true}
+	q := []read.ObjId{x}
+	for len(q) > 0 && !expired(deadline) {
+		y := q[len(q)-1]
+		q = q[:len(q)-1]
+		for _, e := range d.Edges(y) {
+			if !seen[e.To] {
+				seen[e.To] = true
+				reach = append(reach, e.To)
+				total += float64(d.Size(e.To))
+				q = append(q, e.To)
+			}
+		}
+	}
+	if len(q) > 0 {
+		// We couldn't even find the candidates.
+		return giveUp
+	}
+
+	dominated := map[read.ObjId]bool{}
+	free := map[read.ObjId]bool{}
+	var s ratioSampler
+	rnd := rand.New(rand.NewSource(b.Seed))
+	for _, i := range rnd.Perm(len(reach)) {
+		if expired(deadline) {
+			break
+		}
+		y := reach[i]
+		size := float64(d.Size(y))
+		if retainedBy(referrers, x, y, rooted, dominated, free) {
+			s.add(size, size)
+		} else {
+			s.add(0, size)
+		}
+	}
+	return s.estimate(len(reach), total, float64(d.Size(x)))
+}
+
+// retainedBy reports whether every path from a root to y goes through x.
+// dominated and free cache objects known to be, and not to be, retained by x.
+func retainedBy(referrers func(read.ObjId) []read.ObjId, x, y read.ObjId, rooted, dominated, free map[read.ObjId]bool) bool {
+	// Search backwards from y for a root, avoiding x.  Everything the
+	// search visits can reach y without going through x, so if the
+	// search fails, it is all retained by x too.
+	visited := []read.ObjId{y}
+	seen := map[read.ObjId]bool{y: true}
+	for i := 0; i < len(visited); i++ {
+		z := visited[i]
+		if rooted[z] || free[z] {
+			free[y] = true
+			return false
+		}
+		if dominated[z] {
+			continue
+		}
+		for _, w := range referrers(z) {
+			if w != x && !seen[w] {
+				seen[w] = true
+				visited = append(visited, w)
+			}
+		}
+	}
+	for _, z := range visited {
+		dominated[z] = true
+	}
+	return true
+}
+
+// scanReferrers returns a function giving the referrers of each object
+// of d, as Referrers does, from a scan of every object's edges.  It
+// reports false if the deadline passes first.
+func scanReferrers(d *read.Dump, deadline time.Time) (func(read.ObjId) []read.ObjId, bool) {
+	n := d.NumObjects()
+	// The referrers of y are refs[start[y]:start[y+1]].  last[y] is the
+	// latest referrer of y seen, so that each is recorded once.
+	start := make([]int, n+1)
+	last := make([]read.ObjId, n)
+	scan := func(f func(x, y read.ObjId)) bool {
+		for i := range last {
+			last[i] = read.ObjNil
+		}
+		for i := 0; i < n; i++ {
+			if i%1024 == 0 && expired(deadline) {
+				return false
+			}
+			x := read.ObjId(i)
+			for _, e := range d.Edges(x) {
+				if last[e.To] != x {
+					last[e.To] = x
+					f(x, e.To)
+				}
+			}
+		}
+		return true
+	}
+	if !scan(func(x, y read.ObjId) { start[y+1]++ }) {
+		return nil, false
+	}
+	for i := 0; i < n; i++ {
+		start[i+1] += start[i]
+	}
+	refs := make([]read.ObjId, start[n])
+	next := append([]int(nil), start[:n]...)
+	if !scan(func(x, y read.ObjId) {
+		refs[next[y]] = x
+		next[y]++
+	}) {
+		return nil, false
+	}
+	return func(y read.ObjId) []read.ObjId {
+		return refs[start[y]:start[y+1]]
+	}, true
+}
+
+// EstimateDuplicateBytes estimates the number of bytes of heap wasted
+// on duplicate objects: objects with the same type and contents as
+// another object.  It examines whole types, chosen at random, until
+// the budget runs out.
+func EstimateDuplicateBytes(d *read.Dump, b Budget) Estimate {
+	defer measure("EstimateDuplicateBytes")()
+	deadline := b.deadline()
+	byType := make([][]read.ObjId, len(d.FTList))
+	var total float64
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		id := d.Ft(x).Id
+		byType[id] = append(byType[id], x)
+		total += float64(d.Size(x))
+	}
+	var types [][]read.ObjId
+	for _, objs := range byType {
+		if len(objs) > 0 {
+			types = append(types, objs)
+		}
+	}
+
+	var s ratioSampler
+	rnd := rand.New(rand.NewSource(b.Seed))
+	for _, i := range rnd.Perm(len(types)) {
+		dup, all, ok := duplicateBytes(d, types[i], deadline)
+		if !ok {
+			break
+		}
+		s.add(dup, all)
+	}
+	return s.estimate(len(types), total, 0)
+}
+
+// duplicateBytes returns the number of bytes of objs which duplicate
+// an earlier object in objs, and the total size of objs.  All objects
+// must have the same type.  It reports false if the deadline passed.
+func duplicateBytes(d *read.Dump, objs []read.ObjId, deadline time.Time) (float64, float64, bool) {
+	size := d.Size(objs[0])
+	all := float64(size) * float64(len(objs))
+	if len(objs) == 1 {
+		return 0, all, true
+	}
+	byHash := map[uint64][]read.ObjId{}
+	var dup float64
+	for _, x := range objs {
+		if expired(deadline) {
+			return 0, 0, false
+		}
+		c := string(d.Contents(x))
+		h := fnv.New64a()
+		h.Write([]byte(c))
+		k := h.Sum64()
+		found := false
+		for _, y := range byHash[k] {
+			if string(d.Contents(y)) == c {
+				found = true
+				break
+			}
+		}
+		if found {
+			dup += float64(size)
+		} else {
+			byHash[k] = append(byHash[k], x)
+		}
+	}
+	return dup, all, true
+}
+
+// EstimateBytes estimates the number of heap bytes in objects for
+// which f returns true, by calling f on a uniform sample of n objects
+// chosen with (*read.Dump).SampleObjects.  It is for properties too
+// expensive to check on every object, like whether an object's
+// contents duplicate another's.
+func EstimateBytes(d *read.Dump, n int, seed int64, f func(x read.ObjId) bool) Estimate {
+	defer measure("EstimateBytes")()
+	var s ratioSampler
+	var total float64
+	for i := 0; i < d.NumObjects(); i++ {
+		total += float64(d.Size(read.ObjId(i)))
+	}
+	for _, x := range d.SampleObjects(n, seed) {
+		size := float64(d.Size(x))
+		hit := 0.0
+		if f(x) {
+			hit = size
+		}
+		s.add(hit, size)
+	}
+	return s.estimate(d.NumObjects(), total, 0)
+}
@@ -0,0 +1,86 @@
+package analyze
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// FieldStats summarizes the values of one scalar field (see
+// read.Dump.Scalars) over the live objects of a type.  Values are
+// compared as float64s, so integers above 2^53 lose precision.
+type FieldStats struct {
+	Type, Field string
+	Objects     int // live objects of the type
+	Min, Max    float64
+	Mean        float64
+	// Median, P90 and P99 are percentiles of the values, by the
+	// nearest-rank method.
+	Median, P90, P99 float64
+
+	values []float64 // sorted
+}
+
+// Percentile returns the value at or below which p percent of the
+// values fall.
+func (s *FieldStats) Percentile(p float64) float64 {
+	return percentile(s.values, p)
+}
+
+// AggregateField computes statistics of field over the live objects of
+// the named type, such as AggregateField(d, "main.Conn", "bytesRead").
+// Objects are live if they are reachable from the roots.  The field
+// is named as Scalars names it, so a string's length is "name.len",
+// and in untyped objects the field is the index of a word.
+func AggregateField(d *read.Dump, typ, field string) (*FieldStats, error) {
+	defer measure("AggregateField")()
+	live := d.Reachable()
+	s := &FieldStats{Type: typ, Field: field}
+	found := false
+	var sum float64
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		if d.Ft(x).Name != typ || !live.Has(x) {
+			continue
+		}
+		found = true
+		for _, v := range d.Scalars(x) {
+			if v.Name == field {
+				f := v.Float()
+				s.values = append(s.values, f)
+				sum += f
+				break
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no live objects of type %s", typ)
+	}
+	if len(s.values) == 0 {
+		return nil, fmt.Errorf("type %s has no scalar field %s", typ, field)
+	}
+	sort.Float64s(s.values)
+	s.Objects = len(s.values)
+	s.Min = s.values[0]
+	s.Max = s.values[len(s.values)-1]
+	s.Mean = sum / float64(len(s.values))
+	s.Median = s.Percentile(50)
+	s.P90 = s.Percentile(90)
+	s.P99 = s.Percentile(99)
+	return s, nil
+}
+
+// percentile returns the p'th percentile of sorted, which must not be
+// empty.
+func percentile(sorted []float64, p float64) float64 {
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
@@ -0,0 +1,679 @@
+package analyze
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// A Severity ranks how much a Finding matters.
+type Severity int
+
+const (
+	SeverityInfo     Severity = iota // worth knowing, probably harmless
+	SeverityWarning                  // likely wasting memory or a sign of a bug
+	SeverityCritical                 // dominates the heap, or will
+)
+
+var severityNames = [...]string{
+	SeverityInfo:     "info",
+	SeverityWarning:  "warning",
+	SeverityCritical: "critical",
+}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+// A Finding is a problem a Detector found in a dump.
+type Finding struct {
+	Detector string // name of the detector which found it
+	Severity Severity
+	Summary  string // one line describing the problem
+	// Bytes is the memory involved, for ranking findings of the same
+	// severity.  It is 0 if the finding isn't about memory.
+	Bytes uint64
+	// Objects are example objects, most significant first, and
+	// Evidence lines of supporting detail, for the reader to follow
+	// up on.
+	Objects  []read.ObjId
+	Evidence []string
+}
+
+// A Detector looks for one kind of problem in a dump.
+type Detector struct {
+	Name string
+	Run  func(d *read.Dump) []Finding
+}
+
+var (
+	detectorsMu sync.Mutex
+	detectors   = []Detector{
+		{"goroutine-leak", findGoroutineLeaks},
+		{"duplicate-strings", findDuplicateStrings},
+		{"slice-capacity", findSliceCapacity},
+		{"pool-hoarding", findPoolHoarding},
+		{"finalizer-backlog", findFinalizerBacklog},
+		{"finalizer-types", findFinalizerTypes},
+		{"defer-chain", findDeferChains},
+		{"panic", findPanics},
+		{"map-keys", findLargeMaps},
+	}
+)
+
+// RegisterDetector adds det to the detectors RunFindings runs, after
+// the built-in ones.  It is meant to be called from init functions,
+// but is safe to call while dumps are being analyzed.
+func RegisterDetector(det Detector) {
+	detectorsMu.Lock()
+	detectors = append(detectors, det)
+	detectorsMu.Unlock()
+}
+
+// registered returns the registered detectors.
+func registered() []Detector {
+	detectorsMu.Lock()
+	defer detectorsMu.Unlock()
+	return detectors[:len(detectors):len(detectors)]
+}
+
+// Detectors returns the names of the registered detectors.
+func Detectors() []string {
+	var r []string
+	for _, det := range registered() {
+		r = append(r, det.Name)
+	}
+	return r
+}
+
+// RunFindings runs all the registered detectors against d and returns
+// what they found, most severe first, and within a severity the ones
+// involving the most memory first.  Detectors which need information
+// the dump lacks, like field names, find nothing rather than fail.
+func RunFindings(d *read.Dump) []Finding {
+	defer measure("RunFindings")()
+	var r []Finding
+	for _, det := range registered() {
+		for _, f := range det.Run(d) {
+			f.Detector = det.Name
+			r = append(r, f)
+		}
+	}
+	sort.SliceStable(r, func(i, j int) bool {
+		if r[i].Severity != r[j].Severity {
+			return r[i].Severity > r[j].Severity
+		}
+		return r[i].Bytes > r[j].Bytes
+	})
+	return r
+}
+
+// heapBytes returns the total size of the objects in d.
+func heapBytes(d *read.Dump) uint64 {
+	var n uint64
+	for i := 0; i < d.NumObjects(); i++ {
+		n += d.Size(read.ObjId(i))
+	}
+	return n
+}
+
+// memorySeverity rates a finding about n bytes of a heap of total
+// bytes.  It returns false if n is too small to report.
+func memorySeverity(n, total uint64) (Severity, bool) {
+	switch {
+	case total == 0 || n < 64<<10:
+		return 0, false
+	case n >= total/4:
+		return SeverityCritical, true
+	case n >= total/20:
+		return SeverityWarning, true
+	case n >= total/100:
+		return SeverityInfo, true
+	}
+	return 0, false
+}
+
+// Goroutines blocked at one site in numbers above these are probably
+// leaking.
+const (
+	leakGoroutines         = 100
+	leakGoroutinesCritical = 10000
+)
+
+// findGoroutineLeaks reports large groups of goroutines started at the
+// same place and blocked for the same reason: usually goroutines
+// waiting on channels nobody will send to again.
+func findGoroutineLeaks(d *read.Dump) []Finding {
+	type site struct {
+		gopc   uint64
+		reason string
+	}
+	type group struct {
+		site
+		gs    []*read.GoRoutine
+		bytes uint64
+	}
+	groups := map[site]*group{}
+	var order []*group
+	for _, g := range d.Goroutines {
+		if g.Status != 4 || g.IsSystem { // waiting
+			continue
+		}
+		s := site{g.Gopc, g.WaitReason}
+		p := groups[s]
+		if p == nil {
+			p = &group{site: s}
+			groups[s] = p
+			order = append(order, p)
+		}
+		p.gs = append(p.gs, g)
+		for f := g.Bos; f != nil; f = f.Parent {
+			p.bytes += uint64(len(f.Data))
+		}
+	}
+	var r []Finding
+	for _, p := range order {
+		if len(p.gs) < leakGoroutines {
+			continue
+		}
+		sev := SeverityWarning
+		if len(p.gs) >= leakGoroutinesCritical {
+			sev = SeverityCritical
+		}
+		name := "?"
+		for f := p.gs[0].Bos; f != nil; f = f.Parent {
+			if f.Name != "runtime.goexit" {
+				name = f.Name
+			}
+		}
+		f := Finding{
+			Severity: sev,
+			Summary:  fmt.Sprintf("%d goroutines running %s are blocked in %s", len(p.gs), name, p.reason),
+			Bytes:    p.bytes,
+			Evidence: []string{
+				fmt.Sprintf("started by the go statement at %#x", p.gopc),
+				fmt.Sprintf("%d bytes of stack frames", p.bytes),
+			},
+		}
+		var goids []string
+		for i, g := range p.gs {
+			if i == 5 {
+				goids = append(goids, "...")
+				break
+			}
+			goids = append(goids, strconv.FormatUint(g.Goid, 10))
+		}
+		f.Evidence = append(f.Evidence, "goroutines "+strings.Join(goids, ", "))
+		r = append(r, f)
+	}
+	return r
+}
+
+// findDuplicateStrings reports memory spent on separate copies of the
+// same string contents, which interning would save.  It needs to know
+// which fields are strings, so it finds nothing without dwarf types.
+func findDuplicateStrings(d *read.Dump) []Finding {
+	type str struct {
+		hash uint64 // of the contents
+		n    uint64 // length
+		ptr  uint64
+	}
+	var strs []str
+	w := d.PtrSize
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		var fields []read.Field
+		for _, f := range d.Ft(x).Fields {
+			if f.Kind == read.FieldKindString {
+				fields = append(fields, f)
+			}
+		}
+		if fields == nil {
+			continue
+		}
+		b := append([]byte(nil), d.Contents(x)...)
+		for _, f := range fields {
+			if f.Offset+2*w > uint64(len(b)) {
+				continue
+			}
+			p := d.Word(b[f.Offset:])
+			n := d.Word(b[f.Offset+w:])
+			y := d.FindObj(p)
+			if y == read.ObjNil || n == 0 || p-d.Addr(y)+n > d.Size(y) {
+				continue
+			}
+			off := p - d.Addr(y)
+			h := fnv.New64a()
+			h.Write(d.Contents(y)[off : off+n])
+			strs = append(strs, str{h.Sum64(), n, p})
+		}
+	}
+	sort.Slice(strs, func(i, j int) bool {
+		a, b := strs[i], strs[j]
+		if a.hash != b.hash {
+			return a.hash < b.hash
+		}
+		if a.n != b.n {
+			return a.n < b.n
+		}
+		return a.ptr < b.ptr
+	})
+
+	// Copies of a string are the distinct backing addresses holding
+	// the same contents; strings sharing a backing array cost nothing.
+	type dup struct {
+		ptr    uint64 // of one copy
+		n      uint64
+		copies int
+	}
+	var dups []dup
+	var waste uint64
+	for i := 0; i < len(strs); {
+		j := i + 1
+		copies := 1
+		for ; j < len(strs) && strs[j].hash == strs[i].hash && strs[j].n == strs[i].n; j++ {
+			if strs[j].ptr != strs[j-1].ptr {
+				copies++
+			}
+		}
+		if copies > 1 {
+			dups = append(dups, dup{strs[i].ptr, strs[i].n, copies})
+			waste += uint64(copies-1) * strs[i].n
+		}
+		i = j
+	}
+	sev, ok := memorySeverity(waste, heapBytes(d))
+	if !ok {
+		return nil
+	}
+	sort.SliceStable(dups, func(i, j int) bool {
+		return uint64(dups[i].copies-1)*dups[i].n > uint64(dups[j].copies-1)*dups[j].n
+	})
+	f := Finding{
+		Severity: sev,
+		Summary:  fmt.Sprintf("%d bytes are spent on duplicate copies of %d strings", waste, len(dups)),
+		Bytes:    waste,
+	}
+	for i, s := range dups {
+		if i == 5 {
+			break
+		}
+		y := d.FindObj(s.ptr)
+		off := s.ptr - d.Addr(y)
+		text := string(d.Contents(y)[off : off+s.n])
+		if len(text) > 40 {
+			text = text[:40] + "..."
+		}
+		f.Objects = append(f.Objects, y)
+		f.Evidence = append(f.Evidence, fmt.Sprintf("%d copies of %q (%d bytes each)", s.copies, text, s.n))
+	}
+	return []Finding{f}
+}
+
+// findSliceCapacity reports slices using a small part of a large
+// backing array, which keeps the whole array alive.  It needs to know
+// which fields are slices, so it finds nothing without dwarf types.
+func findSliceCapacity(d *read.Dump) []Finding {
+	const (
+		minArray = 64 << 10 // smallest backing array worth reporting
+		maxUse   = 4        // report slices using less than 1/maxUse of their capacity
+	)
+	type holder struct {
+		typ, field string
+		waste      uint64
+		arrays     []read.ObjId
+	}
+	holders := map[string]*holder{}
+	var order []*holder
+	w := d.PtrSize
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		ft := d.Ft(x)
+		var fields []read.Field
+		for _, f := range ft.Fields {
+			if f.Kind == read.FieldKindSlice {
+				fields = append(fields, f)
+			}
+		}
+		if fields == nil {
+			continue
+		}
+		b := d.Contents(x)
+		for _, f := range fields {
+			if f.Offset+3*w > uint64(len(b)) {
+				continue
+			}
+			p := d.Word(b[f.Offset:])
+			n := d.Word(b[f.Offset+w:])
+			c := d.Word(b[f.Offset+2*w:])
+			y := d.FindObj(p)
+			if y == read.ObjNil || c == 0 || n >= c/maxUse || d.Size(y) < minArray {
+				continue
+			}
+			// The element size isn't known, but the backing array
+			// is about cap elements long.
+			waste := d.Size(y) - d.Size(y)/c*n
+			k := ft.Name + "." + f.Name
+			h := holders[k]
+			if h == nil {
+				h = &holder{typ: ft.Name, field: f.Name}
+				holders[k] = h
+				order = append(order, h)
+			}
+			h.waste += waste
+			if len(h.arrays) < 5 {
+				h.arrays = append(h.arrays, y)
+			}
+		}
+	}
+	total := heapBytes(d)
+	var r []Finding
+	for _, h := range order {
+		sev, ok := memorySeverity(h.waste, total)
+		if !ok {
+			continue
+		}
+		f := Finding{
+			Severity: sev,
+			Summary:  fmt.Sprintf("slices in %s.%s use under 1/%d of their capacity, keeping %d unused bytes alive", h.typ, h.field, maxUse, h.waste),
+			Bytes:    h.waste,
+			Objects:  h.arrays,
+		}
+		for _, y := range h.arrays {
+			f.Evidence = append(f.Evidence, fmt.Sprintf("backing array %x, %d bytes", d.Addr(y), d.Size(y)))
+		}
+		r = append(r, f)
+	}
+	return r
+}
+
+// findPoolHoarding reports memory retained by sync.Pool caches.  The
+// pools are emptied at each garbage collection, so a lot of memory in
+// them means objects too large to be worth pooling, or a GC cycle
+// which hasn't come around.  It needs type names, so it finds nothing
+// without dwarf types.
+func findPoolHoarding(d *read.Dump) []Finding {
+	d.ComputeDominators()
+	var locals []read.ObjId
+	var bytes uint64
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		if !strings.Contains(d.Ft(x).Name, "sync.poolLocal") {
+			continue
+		}
+		// Count only the outermost poolLocal of a dominator chain.
+		nested := false
+		for y := d.Idom(x); y != read.ObjNil; y = d.Idom(y) {
+			if strings.Contains(d.Ft(y).Name, "sync.poolLocal") {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			locals = append(locals, x)
+			bytes += d.RetainedSize(x)
+		}
+	}
+	sev, ok := memorySeverity(bytes, heapBytes(d))
+	if !ok {
+		return nil
+	}
+	sort.SliceStable(locals, func(i, j int) bool { return d.RetainedSize(locals[i]) > d.RetainedSize(locals[j]) })
+	f := Finding{
+		Severity: sev,
+		Summary:  fmt.Sprintf("sync.Pool caches retain %d bytes", bytes),
+		Bytes:    bytes,
+	}
+	for i, x := range locals {
+		if i == 5 {
+			break
+		}
+		f.Objects = append(f.Objects, x)
+		f.Evidence = append(f.Evidence, fmt.Sprintf("%s %x retains %d bytes", d.Ft(x).Name, d.Addr(x), d.RetainedSize(x)))
+	}
+	return []Finding{f}
+}
+
+// Queued finalizers above these numbers mean the finalizer goroutine
+// is falling behind, or is blocked.
+const (
+	finalizerBacklog         = 100
+	finalizerBacklogCritical = 10000
+)
+
+// findFinalizerBacklog reports a long queue of finalizers ready to
+// run.  Their objects, and everything the objects point to, stay
+// alive until the finalizers have run.
+func findFinalizerBacklog(d *read.Dump) []Finding {
+	if len(d.QFinal) < finalizerBacklog {
+		return nil
+	}
+	sev := SeverityWarning
+	if len(d.QFinal) >= finalizerBacklogCritical {
+		sev = SeverityCritical
+	}
+	var objs []read.ObjId
+	var bytes uint64
+	for _, q := range d.QFinal {
+		for _, e := range q.Edges {
+			bytes += d.Size(e.To)
+			if len(objs) < 5 {
+				objs = append(objs, e.To)
+			}
+		}
+	}
+	return []Finding{{
+		Severity: sev,
+		Summary:  fmt.Sprintf("%d finalizers are queued to run", len(d.QFinal)),
+		Bytes:    bytes,
+		Objects:  objs,
+		Evidence: []string{
+			fmt.Sprintf("%d pending finalizers not yet queued", len(d.Finalizers)),
+			fmt.Sprintf("the queued finalizers' objects take %d bytes", bytes),
+		},
+	}}
+}
+
+// A type with more queued finalizers than this is producing garbage
+// faster than its finalizers run.
+const finalizerTypeBacklog = 100
+
+// findFinalizerTypes reports the types whose objects make up a
+// finalizer backlog: many instances queued for finalization, and the
+// memory only those queued objects keep alive.  A type whose queued
+// objects point to other objects with finalizers frees a link of the
+// chain per GC cycle, which is a common cause of a backlog.
+func findFinalizerTypes(d *read.Dump) []Finding {
+	type stats struct {
+		queued, registered, instances int
+		roots                         []read.Root
+		chained                       int // queued objects pointing to finalizable objects
+	}
+	byType := map[*read.FullType]*stats{}
+	get := func(ft *read.FullType) *stats {
+		s := byType[ft]
+		if s == nil {
+			s = &stats{}
+			byType[ft] = s
+		}
+		return s
+	}
+	finalizable := d.NewObjSet()
+	for _, f := range d.Finalizers {
+		if x := d.FindObj(f.Obj()); x != read.ObjNil {
+			finalizable.Add(x)
+			get(d.Ft(x)).registered++
+		}
+	}
+	var queued []read.ObjId
+	inQueue := d.NewObjSet()
+	for _, r := range d.Roots() {
+		if r.QFinal == nil || d.Addr(r.Edge.To)+r.Edge.ToOffset != r.QFinal.Obj() {
+			continue // the finalizer's function or types, not its object
+		}
+		x := r.Edge.To
+		s := get(d.Ft(x))
+		s.roots = append(s.roots, r)
+		if !inQueue.Has(x) {
+			inQueue.Add(x)
+			finalizable.Add(x)
+			s.queued++
+			queued = append(queued, x)
+		}
+	}
+	if len(queued) == 0 {
+		return nil
+	}
+	for _, x := range queued {
+		for _, e := range d.Edges(x) {
+			if e.To != x && finalizable.Has(e.To) {
+				get(d.Ft(x)).chained++
+				break
+			}
+		}
+	}
+	for i := 0; i < d.NumObjects(); i++ {
+		if s := byType[d.Ft(read.ObjId(i))]; s != nil {
+			s.instances++
+		}
+	}
+
+	total := heapBytes(d)
+	var r []Finding
+	for _, ft := range d.FTList {
+		s := byType[ft]
+		if s == nil || s.queued == 0 {
+			continue
+		}
+		pinned := OnlyReachableVia(d, s.roots...).Bytes(d)
+		sev, ok := memorySeverity(pinned, total)
+		if s.queued >= finalizerTypeBacklog && (!ok || sev < SeverityWarning) {
+			sev, ok = SeverityWarning, true
+		}
+		if !ok {
+			continue
+		}
+		f := Finding{
+			Severity: sev,
+			Summary:  fmt.Sprintf("%d objects of type %s are queued for finalization, pinning %d bytes", s.queued, ft.Name, pinned),
+			Bytes:    pinned,
+			Evidence: []string{
+				fmt.Sprintf("%d of %d instances are queued for finalization", s.queued, s.instances),
+				fmt.Sprintf("%d more instances have finalizers set", s.registered),
+				fmt.Sprintf("%d bytes are reachable only from the queued finalizers", pinned),
+			},
+		}
+		if s.chained > 0 {
+			f.Evidence = append(f.Evidence, fmt.Sprintf("%d queued objects point to other objects with finalizers, which wait for a later GC cycle", s.chained))
+		}
+		var objs []read.ObjId
+		for _, x := range queued {
+			if d.Ft(x) == ft {
+				objs = append(objs, x)
+			}
+		}
+		sort.SliceStable(objs, func(i, j int) bool { return d.RetainedSize(objs[i]) > d.RetainedSize(objs[j]) })
+		if len(objs) > 5 {
+			objs = objs[:5]
+		}
+		f.Objects = objs
+		r = append(r, f)
+	}
+	return r
+}
+
+// Goroutines with more deferred calls than these are probably
+// deferring in a loop.
+const (
+	deferChain         = 100
+	deferChainCritical = 10000
+)
+
+// findDeferChains reports goroutines with long chains of pending
+// deferred calls.  Deferring inside a loop accumulates a call per
+// iteration, and the calls and whatever they refer to stay alive until
+// the function returns.
+func findDeferChains(d *read.Dump) []Finding {
+	var r []Finding
+	for _, g := range d.Goroutines {
+		if len(g.Defers) < deferChain {
+			continue
+		}
+		sev := SeverityWarning
+		if len(g.Defers) >= deferChainCritical {
+			sev = SeverityCritical
+		}
+		// Count the calls by deferred function and defer statement,
+		// to show which one is repeating.
+		type site struct {
+			fn string
+			pc uint64
+		}
+		counts := map[site]int{}
+		var order []site
+		for _, x := range g.Defers {
+			s := site{x.Func, x.Pc}
+			if s.fn == "" {
+				s.fn = fmt.Sprintf("func at %#x", x.Code)
+			}
+			if counts[s] == 0 {
+				order = append(order, s)
+			}
+			counts[s]++
+		}
+		sort.SliceStable(order, func(i, j int) bool { return counts[order[i]] > counts[order[j]] })
+		f := Finding{
+			Severity: sev,
+			Summary:  fmt.Sprintf("goroutine %d has %d deferred calls pending", g.Goid, len(g.Defers)),
+		}
+		for _, e := range g.Edges() {
+			if e.Via == "defer" {
+				f.Bytes += d.Size(e.To)
+			}
+		}
+		for i, s := range order {
+			if i == 3 {
+				break
+			}
+			f.Evidence = append(f.Evidence, fmt.Sprintf("%d calls of %s, deferred at pc %#x", counts[s], s.fn, s.pc))
+		}
+		r = append(r, f)
+	}
+	return r
+}
+
+// findPanics reports the goroutines which were panicking when the dump
+// was written, with the values they were panicking with.
+func findPanics(d *read.Dump) []Finding {
+	var r []Finding
+	for _, g := range d.Goroutines {
+		if len(g.Panics) == 0 {
+			continue
+		}
+		f := Finding{
+			Severity: SeverityWarning,
+			Summary:  fmt.Sprintf("goroutine %d is panicking with %s", g.Goid, g.Panics[0].Value()),
+		}
+		for i, p := range g.Panics {
+			if i > 0 {
+				f.Evidence = append(f.Evidence, "during an earlier panic with "+p.Value())
+			}
+			if p.Defer != nil && p.Defer.Func != "" {
+				f.Evidence = append(f.Evidence, "while running deferred "+p.Defer.Func)
+			}
+		}
+		if g.Bos != nil {
+			f.Evidence = append(f.Evidence, "in "+g.Bos.Name)
+		}
+		r = append(r, f)
+	}
+	return r
+}
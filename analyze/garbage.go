@@ -0,0 +1,65 @@
+package analyze
+
+import (
+	"sort"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// A GarbageType counts the unreachable objects of one type.
+type GarbageType struct {
+	Type    string
+	Objects int
+	Bytes   uint64
+	Largest read.ObjId // the largest of the objects
+}
+
+// Garbage is the result of FindGarbage.
+type Garbage struct {
+	Objects int           // unreachable objects
+	Bytes   uint64        // their total size
+	Types   []GarbageType // by type, most bytes first
+}
+
+// FindGarbage lists the objects no root reaches, by type.  They are
+// floating garbage: objects dropped since the last collection, which
+// the next one would free.  Memory a leak is suspected of holding
+// should be reachable; if much of a type's memory is garbage instead,
+// the program is churning through it rather than keeping it.
+func FindGarbage(d *read.Dump) *Garbage {
+	defer measure("FindGarbage")()
+	live := d.Reachable()
+	g := &Garbage{}
+	types := map[string]*GarbageType{}
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		if live.Has(x) {
+			continue
+		}
+		size := d.Size(x)
+		g.Objects++
+		g.Bytes += size
+		name := d.Ft(x).Name
+		t := types[name]
+		if t == nil {
+			t = &GarbageType{Type: name, Largest: x}
+			types[name] = t
+		}
+		t.Objects++
+		t.Bytes += size
+		if size > d.Size(t.Largest) {
+			t.Largest = x
+		}
+	}
+	for _, t := range types {
+		g.Types = append(g.Types, *t)
+	}
+	sort.Slice(g.Types, func(i, j int) bool {
+		ti, tj := &g.Types[i], &g.Types[j]
+		if ti.Bytes != tj.Bytes {
+			return ti.Bytes > tj.Bytes
+		}
+		return ti.Type < tj.Type
+	})
+	return g
+}
@@ -0,0 +1,214 @@
+package analyze
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// A SiteGrowth is the memory held by the goroutines started at one
+// site, followed through a series of dumps of the same process.
+type SiteGrowth struct {
+	// Site names the function the goroutines run, and the pc of the
+	// go statement which started them.
+	Site string
+	// Goroutines and Bytes give, for each dump, the number of
+	// goroutines started at the site and the bytes reachable only from
+	// them: from their stacks, closure contexts, deferred calls and
+	// panics.  Objects reachable from globals or other roots aren't
+	// counted, nor are objects reachable from goroutines started at
+	// more than one site.
+	Goroutines []int
+	Bytes      []uint64
+	// Growth is the least-squares slope of Bytes, in bytes per dump.
+	Growth float64
+	// AllocSites lists where the sampled objects among the last
+	// dump's Bytes were allocated, most frequent first.
+	AllocSites []string
+}
+
+// GoroutineGrowth attributes memory to goroutine creation sites in
+// each of a series of dumps, oldest first, and returns the sites with
+// the fastest-growing memory first.  A goroutine site whose memory
+// keeps growing points at the subsystem which is leaking.
+func GoroutineGrowth(dumps []*read.Dump) []SiteGrowth {
+	defer measure("GoroutineGrowth")()
+	sites := map[string]*SiteGrowth{}
+	for i, d := range dumps {
+		owner := goroutineOwners(d)
+		for _, g := range d.Goroutines {
+			siteOf(sites, goroutineSite(g), len(dumps)).Goroutines[i]++
+		}
+		for x, site := range owner {
+			if site != "" {
+				siteOf(sites, site, len(dumps)).Bytes[i] += d.Size(x)
+			}
+		}
+		if i != len(dumps)-1 {
+			continue
+		}
+		counts := map[string]map[string]int{}
+		for _, a := range d.AllocSamples {
+			x := d.FindObj(a.Addr)
+			if x == read.ObjNil || owner[x] == "" || a.Prof == nil {
+				continue
+			}
+			c := counts[owner[x]]
+			if c == nil {
+				c = map[string]int{}
+				counts[owner[x]] = c
+			}
+			c[allocSite(a.Prof)]++
+		}
+		for site, c := range counts {
+			s := sites[site]
+			for a := range c {
+				s.AllocSites = append(s.AllocSites, a)
+			}
+			sort.Slice(s.AllocSites, func(i, j int) bool {
+				a, b := s.AllocSites[i], s.AllocSites[j]
+				if c[a] != c[b] {
+					return c[a] > c[b]
+				}
+				return a < b
+			})
+		}
+	}
+
+	var r []SiteGrowth
+	for _, s := range sites {
+		s.Growth = slope(s.Bytes)
+		r = append(r, *s)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Growth != r[j].Growth {
+			return r[i].Growth > r[j].Growth
+		}
+		return r[i].Site < r[j].Site
+	})
+	return r
+}
+
+func siteOf(sites map[string]*SiteGrowth, site string, n int) *SiteGrowth {
+	s := sites[site]
+	if s == nil {
+		s = &SiteGrowth{Site: site, Goroutines: make([]int, n), Bytes: make([]uint64, n)}
+		sites[site] = s
+	}
+	return s
+}
+
+// goroutineOwners maps each object reachable only from goroutines
+// (see GoRoutine.Edges) to the creation site of the goroutines which reach it, or
+// to "" if goroutines from several sites reach it.
+func goroutineOwners(d *read.Dump) map[read.ObjId]string {
+	// Mark everything reachable from roots other than stacks.
+	global := map[read.ObjId]bool{}
+	var q []read.ObjId
+	for _, r := range d.Roots() {
+		if r.Kind != read.RootFrame && !global[r.Edge.To] {
+			global[r.Edge.To] = true
+			q = append(q, r.Edge.To)
+		}
+	}
+	for len(q) > 0 {
+		x := q[len(q)-1]
+		q = q[:len(q)-1]
+		for _, e := range d.Edges(x) {
+			if !global[e.To] {
+				global[e.To] = true
+				q = append(q, e.To)
+			}
+		}
+	}
+
+	// Flood from each site's goroutines in turn.
+	bySite := map[string][]read.ObjId{}
+	var names []string
+	for _, g := range d.Goroutines {
+		site := goroutineSite(g)
+		for _, e := range g.Edges() {
+			if global[e.To] {
+				continue
+			}
+			if bySite[site] == nil {
+				names = append(names, site)
+			}
+			bySite[site] = append(bySite[site], e.To)
+		}
+	}
+	owner := map[read.ObjId]string{}
+	for _, site := range names {
+		seen := map[read.ObjId]bool{}
+		q = q[:0]
+		for _, x := range bySite[site] {
+			if !seen[x] {
+				seen[x] = true
+				q = append(q, x)
+			}
+		}
+		for len(q) > 0 {
+			x := q[len(q)-1]
+			q = q[:len(q)-1]
+			if s, ok := owner[x]; ok && s != site {
+				owner[x] = ""
+			} else {
+				owner[x] = site
+			}
+			for _, e := range d.Edges(x) {
+				if !seen[e.To] && !global[e.To] {
+					seen[e.To] = true
+					q = append(q, e.To)
+				}
+			}
+		}
+	}
+	return owner
+}
+
+// goroutineSite names the place goroutine g was started: the
+// outermost function on its stack, and the pc of the go statement.
+func goroutineSite(g *read.GoRoutine) string {
+	name := "?"
+	for f := g.Bos; f != nil; f = f.Parent {
+		if f.Name != "runtime.goexit" {
+			name = f.Name
+		}
+	}
+	return fmt.Sprintf("%s (go at %#x)", name, g.Gopc)
+}
+
+// allocSite names the innermost non-runtime frame of an allocation
+// stack.
+func allocSite(p *read.MemProfEntry) string {
+	stack := p.Stack()
+	if len(stack) == 0 {
+		return "?"
+	}
+	f := stack[0]
+	for _, g := range stack {
+		if len(g.Func) < 8 || g.Func[:8] != "runtime." {
+			f = g
+			break
+		}
+	}
+	return fmt.Sprintf("%s %s:%d", f.Func, f.File, f.Line)
+}
+
+// slope returns the least-squares slope of y against its index.
+func slope(y []uint64) float64 {
+	n := float64(len(y))
+	if n < 2 {
+		return 0
+	}
+	var sx, sy, sxy, sxx float64
+	for i, v := range y {
+		x := float64(i)
+		sx += x
+		sy += float64(v)
+		sxy += x * float64(v)
+		sxx += x * x
+	}
+	return (n*sxy - sx*sy) / (n*sxx - sx*sx)
+}
@@ -0,0 +1,244 @@
+package analyze
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// A HeapDelta totals the objects, of one type or allocated at one site,
+// which a HeapDiff found added, removed, grown or shrunk between two
+// dumps.
+type HeapDelta struct {
+	Key string // the type name or allocation site; "" for the total
+
+	Added        int    // objects only in the second dump
+	AddedBytes   uint64 // their size
+	Removed      int    // objects only in the first dump
+	RemovedBytes uint64 // their size
+	Grown        int    // matched objects larger in the second dump
+	GrownBytes   uint64 // the bytes they grew by
+	Shrunk       int    // matched objects smaller in the second dump
+	ShrunkBytes  uint64 // the bytes they shrank by
+}
+
+// Net returns the bytes d's objects gained, less those they lost.
+func (d HeapDelta) Net() int64 {
+	return int64(d.AddedBytes+d.GrownBytes) - int64(d.RemovedBytes+d.ShrunkBytes)
+}
+
+// A HeapDiff compares the objects of two dumps of the same process.
+type HeapDiff struct {
+	// Objects matched between the dumps: by the chain of fields from
+	// a root, as a Matcher does; by address and type; or by type and
+	// contents.
+	ByPath, ByAddr, ByContent int
+
+	Total HeapDelta
+	Types []HeapDelta // by type, most net bytes first
+	// Sites totals the sampled objects by the site which allocated
+	// them, as allocSite names it, most net bytes first.  Only one
+	// object in the memory profile rate's worth is sampled, so these
+	// are far less than the totals.
+	Sites []HeapDelta
+}
+
+// DiffHeaps matches the objects of dump a with those of the later dump
+// b, and totals those added, removed, grown and shrunk.  Objects are matched
+// first as the same logical object, reached from the same root through
+// the same fields, so an object the program reallocated larger, such
+// as a slice's array, shows as grown.  Then objects of the same type
+// and size at the same address are matched, and last those with the
+// same type and contents.
+//
+// Types which grow the most across two dumps taken some time apart
+// are the usual suspects for a leak, and the sites allocating them
+// where to look.
+func DiffHeaps(a, b *read.Dump) *HeapDiff {
+	defer measure("DiffHeaps")()
+	r := &HeapDiff{}
+	matchA := make([]read.ObjId, a.NumObjects())
+	matchB := make([]read.ObjId, b.NumObjects())
+	for i := range matchA {
+		matchA[i] = read.ObjNil
+	}
+	for i := range matchB {
+		matchB[i] = read.ObjNil
+	}
+	match := func(x, y read.ObjId) {
+		matchA[x] = y
+		matchB[y] = x
+	}
+
+	m := NewMatcher(a, b)
+	for i := range matchA {
+		x := read.ObjId(i)
+		if y, ok := m.Match(x); ok {
+			match(x, y)
+			r.ByPath++
+		}
+	}
+
+	for i := range matchA {
+		x := read.ObjId(i)
+		if matchA[x] != read.ObjNil {
+			continue
+		}
+		y := b.FindObj(a.Addr(x))
+		if y == read.ObjNil || matchB[y] != read.ObjNil || b.Addr(y) != a.Addr(x) ||
+			b.Size(y) != a.Size(x) || b.Ft(y).Name != a.Ft(x).Name {
+			continue
+		}
+		match(x, y)
+		r.ByAddr++
+	}
+
+	// Pair up the rest with equal contents, in address order.  The
+	// hash only finds candidates; their contents must be equal too.
+	same := map[uint64][]read.ObjId{}
+	for i := range matchA {
+		x := read.ObjId(i)
+		if matchA[x] == read.ObjNil {
+			h := contentHash(a, x)
+			same[h] = append(same[h], x)
+		}
+	}
+	for i := range matchB {
+		y := read.ObjId(i)
+		if matchB[y] != read.ObjNil {
+			continue
+		}
+		h := contentHash(b, y)
+		xs := same[h]
+		if len(xs) == 0 {
+			continue
+		}
+		// Contents returns a buffer the next call reuses, and a and b
+		// may be the same dump.
+		c := append([]byte(nil), b.Contents(y)...)
+		for j, x := range xs {
+			if a.Size(x) == b.Size(y) && a.Ft(x).Name == b.Ft(y).Name && bytes.Equal(a.Contents(x), c) {
+				match(x, y)
+				same[h] = append(xs[:j:j], xs[j+1:]...)
+				r.ByContent++
+				break
+			}
+		}
+	}
+
+	tallyDiff(r, a, b, matchA, matchB, sampleSites(a), sampleSites(b))
+	return r
+}
+
+// The objects of a dump, as tallyDiff sees them.
+type diffObjects interface {
+	NumObjects() int
+	Addr(x read.ObjId) uint64
+	Size(x read.ObjId) uint64
+	Ft(x read.ObjId) *read.FullType
+}
+
+// tallyDiff totals into r the objects of a and b, given the matches
+// between them: those of a unmatched as removed, those of b unmatched
+// as added, and matched ones by how their size changed.  sitesA and
+// sitesB map sampled objects' addresses to their allocation sites.
+func tallyDiff(r *HeapDiff, a, b diffObjects, matchA, matchB []read.ObjId, sitesA, sitesB map[uint64]string) {
+	types := map[string]*HeapDelta{}
+	sites := map[string]*HeapDelta{}
+	delta := func(m map[string]*HeapDelta, key string) *HeapDelta {
+		d := m[key]
+		if d == nil {
+			d = &HeapDelta{Key: key}
+			m[key] = d
+		}
+		return d
+	}
+	for i := range matchA {
+		x := read.ObjId(i)
+		if matchA[x] != read.ObjNil {
+			continue
+		}
+		size := a.Size(x)
+		for _, d := range []*HeapDelta{&r.Total, delta(types, a.Ft(x).Name)} {
+			d.Removed++
+			d.RemovedBytes += size
+		}
+		if s, ok := sitesA[a.Addr(x)]; ok {
+			d := delta(sites, s)
+			d.Removed++
+			d.RemovedBytes += size
+		}
+	}
+	for i := range matchB {
+		y := read.ObjId(i)
+		size := b.Size(y)
+		ds := []*HeapDelta{&r.Total, delta(types, b.Ft(y).Name)}
+		if s, ok := sitesB[b.Addr(y)]; ok {
+			ds = append(ds, delta(sites, s))
+		}
+		x := matchB[y]
+		switch {
+		case x == read.ObjNil:
+			for _, d := range ds {
+				d.Added++
+				d.AddedBytes += size
+			}
+		case size > a.Size(x):
+			for _, d := range ds {
+				d.Grown++
+				d.GrownBytes += size - a.Size(x)
+			}
+		case size < a.Size(x):
+			for _, d := range ds {
+				d.Shrunk++
+				d.ShrunkBytes += a.Size(x) - size
+			}
+		}
+	}
+	r.Types = sortDeltas(types)
+	r.Sites = sortDeltas(sites)
+}
+
+// contentHash hashes the type name, size and contents of object x.
+func contentHash(d *read.Dump, x read.ObjId) uint64 {
+	h := fnv.New64a()
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], d.Size(x))
+	h.Write(size[:])
+	h.Write([]byte(d.Ft(x).Name))
+	h.Write(d.Contents(x))
+	return h.Sum64()
+}
+
+// sampleSites maps the addresses of d's sampled objects to the sites
+// which allocated them.
+func sampleSites(d *read.Dump) map[uint64]string {
+	m := map[uint64]string{}
+	for _, s := range d.AllocSamples {
+		if s.Prof != nil {
+			m[s.Addr] = allocSite(s.Prof)
+		}
+	}
+	return m
+}
+
+// sortDeltas returns the deltas of m, most net bytes first, omitting
+// those with nothing added, removed, grown or shrunk.
+func sortDeltas(m map[string]*HeapDelta) []HeapDelta {
+	var r []HeapDelta
+	for _, d := range m {
+		if d.Added+d.Removed+d.Grown+d.Shrunk > 0 {
+			r = append(r, *d)
+		}
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Net() != r[j].Net() {
+			return r[i].Net() > r[j].Net()
+		}
+		return r[i].Key < r[j].Key
+	})
+	return r
+}
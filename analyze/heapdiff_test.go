@@ -0,0 +1,73 @@
+package analyze
+
+import (
+	"testing"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// fakeObjects is a heap of objects with just addresses, sizes and
+// type names.
+type fakeObjects []struct {
+	addr, size uint64
+	name       string
+}
+
+func (f fakeObjects) NumObjects() int                { return len(f) }
+func (f fakeObjects) Addr(x read.ObjId) uint64       { return f[x].addr }
+func (f fakeObjects) Size(x read.ObjId) uint64       { return f[x].size }
+func (f fakeObjects) Ft(x read.ObjId) *read.FullType { return &read.FullType{Name: f[x].name} }
+
+func TestTallyDiff(t *testing.T) {
+	a := fakeObjects{
+		{0x1000, 64, "[]int"},  // grows to 128
+		{0x2000, 32, "main.T"}, // removed
+		{0x3000, 48, "[]int"},  // shrinks to 16
+		{0x4000, 16, "main.T"}, // unchanged
+	}
+	b := fakeObjects{
+		{0x5000, 128, "[]int"},
+		{0x3000, 16, "[]int"},
+		{0x4000, 16, "main.T"},
+		{0x6000, 8, "main.T"}, // added
+	}
+	matchA := []read.ObjId{0, read.ObjNil, 1, 2}
+	matchB := []read.ObjId{0, 2, 3, read.ObjNil}
+	sitesA := map[uint64]string{0x1000: "main.f"}
+	sitesB := map[uint64]string{0x5000: "main.g", 0x6000: "main.g"}
+
+	r := &HeapDiff{}
+	tallyDiff(r, a, b, matchA, matchB, sitesA, sitesB)
+
+	want := HeapDelta{
+		Added: 1, AddedBytes: 8,
+		Removed: 1, RemovedBytes: 32,
+		Grown: 1, GrownBytes: 64,
+		Shrunk: 1, ShrunkBytes: 32,
+	}
+	if r.Total != want {
+		t.Errorf("Total = %+v, want %+v", r.Total, want)
+	}
+	if got := r.Total.Net(); got != 8 {
+		t.Errorf("Total.Net() = %d, want 8", got)
+	}
+	wantTypes := []HeapDelta{
+		{Key: "[]int", Grown: 1, GrownBytes: 64, Shrunk: 1, ShrunkBytes: 32},
+		{Key: "main.T", Added: 1, AddedBytes: 8, Removed: 1, RemovedBytes: 32},
+	}
+	if len(r.Types) != len(wantTypes) {
+		t.Fatalf("Types = %+v, want %+v", r.Types, wantTypes)
+	}
+	for i := range wantTypes {
+		if r.Types[i] != wantTypes[i] {
+			t.Errorf("Types[%d] = %+v, want %+v", i, r.Types[i], wantTypes[i])
+		}
+	}
+	// The grown object is charged to the site which allocated it in b.
+	wantSites := []HeapDelta{
+		{Key: "main.g", Added: 1, AddedBytes: 8, Grown: 1, GrownBytes: 64},
+	}
+	if len(r.Sites) != len(wantSites) || r.Sites[0] != wantSites[0] {
+		t.Errorf("Sites = %+v, want %+v", r.Sites, wantSites)
+	}
+}
@@ -0,0 +1,442 @@
+package analyze
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// A MapKeys is a sample of the keys of a map, grouped by what they
+// look like.  A map which keeps growing is usually filled by one code
+// path, and the shape of its keys, like "session-<hex32>", says which.
+type MapKeys struct {
+	Map     read.ObjId // the map's header
+	KeyType string     // e.g. "string", from the header's type name
+	// Count is the number of entries, from the header's count field
+	// if it has one, else the live keys found in its buckets.
+	Count int
+	// Sampled is the number of keys decoded.
+	Sampled int
+	// Patterns groups the sampled keys by KeyPattern, most common
+	// first.
+	Patterns []KeyPatternCount
+}
+
+// A KeyPatternCount is the sampled keys of a map with one pattern.
+type KeyPatternCount struct {
+	Pattern  string
+	Count    int
+	Examples []string // up to 3 keys, strings quoted
+}
+
+// Summary describes the most common pattern of the keys, e.g. "90% of
+// 1000 sampled keys look like session-<hex32>".
+func (k *MapKeys) Summary() string {
+	if k.Sampled == 0 {
+		return "no keys sampled"
+	}
+	p := k.Patterns[0]
+	return fmt.Sprintf("%d%% of %d sampled keys look like %s", p.Count*100/k.Sampled, k.Sampled, p.Pattern)
+}
+
+// The runtime's bucket tophash values below minTopHash mark empty and
+// evacuated slots.
+const minTopHash = 4
+
+// maxKeyBytes limits how much of a string key is decoded.
+const maxKeyBytes = 256
+
+// SampleMapKeys decodes up to n keys of the map whose header is x, an
+// object of type map.hdr[K]V, from both its buckets and, while it
+// grows, its old buckets.  Keys are taken in bucket order, which is
+// hash order, so the first n are a fair sample.  It needs the dwarf
+// types of the header and buckets.
+func SampleMapKeys(d *read.Dump, x read.ObjId, n int) (*MapKeys, error) {
+	defer measure("SampleMapKeys")()
+	ft := d.Ft(x)
+	key, _, ok := mapTypes(ft.Name)
+	if !ok {
+		return nil, fmt.Errorf("object %x is a %s, not a map header", d.Addr(x), ft.Name)
+	}
+	k := &MapKeys{Map: x, KeyType: key}
+	hdr := append([]byte(nil), d.Contents(x)...)
+	var buckets []uint64
+	count := -1
+	for _, f := range ft.Fields {
+		switch {
+		case f.Name == "count" && f.Offset+d.PtrSize <= uint64(len(hdr)):
+			count = int(d.Word(hdr[f.Offset:]))
+		case (f.Name == "buckets" || f.Name == "oldbuckets") && f.Kind == read.FieldKindPtr:
+			if p := d.Word(hdr[f.Offset:]); p != 0 {
+				buckets = append(buckets, p)
+			}
+		}
+	}
+	if buckets == nil && count <= 0 {
+		return k, nil // an empty map
+	}
+	patterns := map[string]*KeyPatternCount{}
+	found := 0
+	for _, p := range buckets {
+		y := d.FindObj(p)
+		if y == read.ObjNil {
+			continue
+		}
+		b, err := newBucketReader(d, y)
+		if err != nil {
+			return nil, err
+		}
+		// Follow the buckets' overflow chains, guarding against cycles
+		// in a corrupt dump.
+		seen := map[read.ObjId]bool{y: true}
+		for q := []read.ObjId{y}; len(q) > 0; {
+			y, q = q[len(q)-1], q[:len(q)-1]
+			keys, over := b.read(y)
+			for _, z := range over {
+				if !seen[z] {
+					seen[z] = true
+					q = append(q, z)
+				}
+			}
+			for _, key := range keys {
+				found++
+				if k.Sampled >= n {
+					continue
+				}
+				k.Sampled++
+				pat := KeyPattern(key.text)
+				c := patterns[pat]
+				if c == nil {
+					c = &KeyPatternCount{Pattern: pat}
+					patterns[pat] = c
+				}
+				c.Count++
+				if len(c.Examples) < 3 {
+					c.Examples = append(c.Examples, key.String())
+				}
+			}
+		}
+	}
+	k.Count = count
+	if count < 0 {
+		k.Count = found
+	}
+	for _, c := range patterns {
+		k.Patterns = append(k.Patterns, *c)
+	}
+	sort.Slice(k.Patterns, func(i, j int) bool {
+		a, b := k.Patterns[i], k.Patterns[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Pattern < b.Pattern
+	})
+	return k, nil
+}
+
+// mapTypes returns the key and value types of a map header type named
+// name, like "map.hdr[string]*main.T".
+func mapTypes(name string) (key, value string, ok bool) {
+	const prefix = "map.hdr"
+	if !strings.HasPrefix(name, prefix+"[") {
+		return "", "", false
+	}
+	i := matchBracket(name, len(prefix))
+	if i < 0 {
+		return "", "", false
+	}
+	return name[len(prefix)+1 : i], name[i+1:], true
+}
+
+// A bucketReader decodes the buckets of one map.  A bucket object
+// may be an array of buckets; its fields describe the first.
+type bucketReader struct {
+	d        *read.Dump
+	size     uint64         // of one bucket
+	tophash  []uint64       // offset of each slot's tophash
+	keys     [][]read.Field // fields of each slot's key
+	overflow int64          // offset of the overflow pointer, or -1
+}
+
+func newBucketReader(d *read.Dump, y read.ObjId) (*bucketReader, error) {
+	ft := d.Ft(y)
+	if ft.Type == nil {
+		return nil, fmt.Errorf("map buckets at %x have no dwarf type", d.Addr(y))
+	}
+	r := &bucketReader{d: d, size: ft.Type.Size(), overflow: -1}
+	for _, f := range ft.Fields {
+		if i, rest, ok := slotField(f.Name, "tophash"); ok && rest == "" {
+			for len(r.tophash) <= i {
+				r.tophash = append(r.tophash, 0)
+			}
+			r.tophash[i] = f.Offset
+		} else if i, _, ok := slotField(f.Name, "keys"); ok {
+			for len(r.keys) <= i {
+				r.keys = append(r.keys, nil)
+			}
+			r.keys[i] = append(r.keys[i], f)
+		} else if f.Name == "overflow" && f.Kind == read.FieldKindPtr {
+			r.overflow = int64(f.Offset)
+		}
+	}
+	if r.size == 0 || len(r.tophash) == 0 || len(r.keys) != len(r.tophash) {
+		return nil, fmt.Errorf("map buckets at %x, of type %s, don't have the expected fields", d.Addr(y), ft.Name)
+	}
+	return r, nil
+}
+
+// slotField parses a field name like "keys.[3].id", or "keys[3].id",
+// returning the slot 3 and the rest of the name, "id".
+func slotField(name, array string) (int, string, bool) {
+	if !strings.HasPrefix(name, array) {
+		return 0, "", false
+	}
+	s := strings.TrimPrefix(name[len(array):], ".")
+	if !strings.HasPrefix(s, "[") {
+		return 0, "", false
+	}
+	j := strings.Index(s, "]")
+	if j < 0 {
+		return 0, "", false
+	}
+	i, err := strconv.Atoi(s[1:j])
+	if err != nil || i < 0 {
+		return 0, "", false
+	}
+	return i, strings.TrimPrefix(s[j+1:], "."), true
+}
+
+// A mapKey is a decoded key.
+type mapKey struct {
+	text   string
+	quoted bool // text is the contents of a string
+}
+
+func (k mapKey) String() string {
+	if k.quoted {
+		return strconv.Quote(k.text)
+	}
+	return k.text
+}
+
+// read decodes the live keys in the buckets of object y, and returns
+// them with the overflow buckets chained from them.
+func (r *bucketReader) read(y read.ObjId) ([]mapKey, []read.ObjId) {
+	d := r.d
+	b := append([]byte(nil), d.Contents(y)...)
+	var keys []mapKey
+	var over []read.ObjId
+	for base := uint64(0); base+r.size <= uint64(len(b)); base += r.size {
+		for i, off := range r.tophash {
+			if b[base+off] < minTopHash {
+				continue
+			}
+			var parts []string
+			quoted := false
+			fields := r.keys[i]
+			for j := 0; j < len(fields); j++ {
+				f := fields[j]
+				if isString(fields[j:]) {
+					parts = append(parts, readString(d, b[base+f.Offset:]))
+					quoted = true
+					if f.Kind != read.FieldKindString {
+						j++ // the len field
+					}
+					continue
+				}
+				f0 := f
+				f0.Offset = 0
+				for _, v := range d.DescribeFields(b[base+f.Offset:], []read.Field{f0}, nil) {
+					parts = append(parts, v.String())
+				}
+			}
+			k := mapKey{strings.Join(parts, ", "), quoted && len(parts) == 1}
+			if len(parts) > 1 {
+				k.text = "{" + k.text + "}"
+			}
+			keys = append(keys, k)
+		}
+		if r.overflow >= 0 {
+			if p := d.Word(b[base+uint64(r.overflow):]); p != 0 {
+				if z := d.FindObj(p); z != read.ObjNil {
+					over = append(over, z)
+				}
+			}
+		}
+	}
+	return keys, over
+}
+
+// isString reports whether fields starts with a string header: a
+// string field, or, as dwarf types flatten strings, a pointer field
+// x.str followed by an x.len field.
+func isString(fields []read.Field) bool {
+	f := fields[0]
+	if f.Kind == read.FieldKindString {
+		return true
+	}
+	return f.Kind == read.FieldKindPtr && strings.HasSuffix(f.Name, "str") && len(fields) > 1 &&
+		fields[1].Name == strings.TrimSuffix(f.Name, "str")+"len"
+}
+
+// readString returns the contents of the string whose header starts
+// b, up to maxKeyBytes of it, if they are in the heap or the data or
+// bss section.
+func readString(d *read.Dump, b []byte) string {
+	s, ok := lookupString(d, b, maxKeyBytes)
+	if !ok {
+		p, _ := d.StringHeader(b)
+		return fmt.Sprintf("<string at %#x>", p)
+	}
+	return s
+}
+
+// lookupString returns the contents of the string whose header starts
+// b, up to limit bytes of it followed by "..." if it is longer, and
+// whether they are in the heap or the data or bss section.
+func lookupString(d *read.Dump, b []byte, limit uint64) (string, bool) {
+	p, n := d.StringHeader(b)
+	trunc := false
+	if n > limit {
+		n, trunc = limit, true
+	}
+	var s string
+	if y := d.FindObj(p); y != read.ObjNil && p-d.Addr(y)+n <= d.Size(y) {
+		off := p - d.Addr(y)
+		s = string(d.Contents(y)[off : off+n])
+	} else {
+		found := false
+		for _, sec := range []*read.Data{d.Data, d.Bss} {
+			if sec != nil && p >= sec.Addr && p-sec.Addr+n <= uint64(len(sec.Data)) {
+				s, found = string(sec.Data[p-sec.Addr:p-sec.Addr+n]), true
+				break
+			}
+		}
+		if !found {
+			return "", false
+		}
+	}
+	if trunc {
+		s += "..."
+	}
+	return s, true
+}
+
+var uuidRE = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
+// KeyPattern generalizes a key to its shape, so that keys made by the
+// same code look alike: runs of digits become <int>, UUIDs <uuid>,
+// runs of 8 or more hex digits <hexN>, and other runs of 16 or more
+// letters and digits mixed <alnumN>, where N is the run's length.
+// Runs of digits too long to be integers are taken for hex.
+// Words and punctuation are kept, so "session-8f14e45fceea167a" and
+// "user:1234" become "session-<hex16>" and "user:<int>".
+func KeyPattern(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if m := uuidRE.FindStringIndex(s[i:]); m != nil {
+			b.WriteString("<uuid>")
+			i += m[1]
+			continue
+		}
+		j := i
+		digits, hex, letters := 0, 0, 0
+		for ; j < len(s) && isAlnum(s[j]); j++ {
+			c := s[j]
+			switch {
+			case c >= '0' && c <= '9':
+				digits++
+			case c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F':
+				hex++
+			default:
+				letters++
+			}
+		}
+		if j == i {
+			c := s[i]
+			if c < ' ' || c >= 127 {
+				c = '?'
+			}
+			b.WriteByte(c)
+			i++
+			continue
+		}
+		n := j - i
+		switch {
+		case digits == n && n <= 20:
+			b.WriteString("<int>")
+		case letters == 0 && n >= 8 && (digits > 0 || n > 20):
+			fmt.Fprintf(&b, "<hex%d>", n)
+		case n >= 16 && digits > 0:
+			fmt.Fprintf(&b, "<alnum%d>", n)
+		default:
+			b.WriteString(s[i:j])
+		}
+		i = j
+	}
+	return b.String()
+}
+
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// mapKeySample is the number of keys the map-keys detector samples
+// from each map it reports.
+const mapKeySample = 1000
+
+// findLargeMaps reports maps retaining much of the heap, with a sample
+// of their keys, which usually says what code fills them.  It needs
+// dwarf types to recognize maps, so it finds nothing without them.
+func findLargeMaps(d *read.Dump) []Finding {
+	var maps []read.ObjId
+	var heap uint64
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		heap += d.Size(x)
+		if _, _, ok := mapTypes(d.Ft(x).Name); ok {
+			maps = append(maps, x)
+		}
+	}
+	if maps == nil {
+		return nil
+	}
+	d.ComputeDominators()
+	var r []Finding
+	for _, x := range maps {
+		n := d.RetainedSize(x)
+		var sev Severity
+		switch {
+		case n < 64<<10 || n < heap/20:
+			continue
+		case n >= heap/4:
+			sev = SeverityCritical
+		default:
+			sev = SeverityWarning
+		}
+		k, err := SampleMapKeys(d, x, mapKeySample)
+		if err != nil || k.Sampled == 0 {
+			continue
+		}
+		_, v, _ := mapTypes(d.Ft(x).Name)
+		name := "map[" + k.KeyType + "]" + v
+		f := Finding{
+			Severity: sev,
+			Summary:  fmt.Sprintf("%s at %x retains %d bytes in %d entries; %s", name, d.Addr(x), n, k.Count, k.Summary()),
+			Bytes:    n,
+			Objects:  []read.ObjId{x},
+		}
+		for i, p := range k.Patterns {
+			if i == 3 {
+				break
+			}
+			f.Evidence = append(f.Evidence, fmt.Sprintf("%d keys like %s, e.g. %s", p.Count, p.Pattern, strings.Join(p.Examples, ", ")))
+		}
+		r = append(r, f)
+	}
+	return r
+}
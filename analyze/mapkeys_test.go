@@ -0,0 +1,25 @@
+package analyze
+
+import "testing"
+
+func TestKeyPattern(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"user:1234", "user:<int>"},
+		{"session-8f14e45fceea167a", "session-<hex16>"},
+		{"req/6ba7b810-9dad-11d1-80b4-00c04fd430c8/done", "req/<uuid>/done"},
+		{"deadbeef", "deadbeef"},
+		{"cafe1234", "<hex8>"},
+		{"123456789012345678901234", "<hex24>"},
+		{"tok_aZ3kQ9xP2mL7vB1c", "tok_<alnum16>"},
+		{"GetUser", "GetUser"},
+		{"a\x00b", "a?b"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := KeyPattern(tt.in); got != tt.want {
+			t.Errorf("KeyPattern(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
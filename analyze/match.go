@@ -0,0 +1,143 @@
+package analyze
+
+import (
+	"github.com/randall77/heapdump14/read"
+)
+
+// A Matcher pairs up the objects of two dumps taken from the same
+// process.  Objects are matched if they are reached from roots with
+// the same name through the same chain of fields, and have the same
+// type, so "the same logical object" can be compared across snapshots
+// even though it has moved.
+type Matcher struct {
+	a, b     *read.Dump
+	fwd, rev map[read.ObjId]read.ObjId
+}
+
+// NewMatcher matches the objects of a with those of b.
+func NewMatcher(a, b *read.Dump) *Matcher {
+	defer measure("NewMatcher")()
+	m := &Matcher{a, b, map[read.ObjId]read.ObjId{}, map[read.ObjId]read.ObjId{}}
+
+	// Match objects pointed to by roots whose names are unique in both dumps.
+	rb := uniqueRoots(b)
+	var q []read.ObjId // pairs of matched objects, a's first
+	for name, r := range uniqueRoots(a) {
+		s, ok := rb[name]
+		if !ok || r.Kind != s.Kind || r.Edge.FromOffset != s.Edge.FromOffset {
+			continue
+		}
+		if m.add(r.Edge.To, s.Edge.To) {
+			q = append(q, r.Edge.To, s.Edge.To)
+		}
+	}
+
+	// Follow the same fields from matched objects.
+	for len(q) > 0 {
+		x, y := q[0], q[1]
+		q = q[2:]
+		ex := append([]read.Edge(nil), a.Edges(x)...)
+		ey := b.Edges(y)
+		j := 0
+		for _, e := range ex {
+			// Edges are in increasing offset order.
+			for j < len(ey) && ey[j].FromOffset < e.FromOffset {
+				j++
+			}
+			if j == len(ey) {
+				break
+			}
+			f := ey[j]
+			if f.FromOffset != e.FromOffset || f.ToOffset != e.ToOffset {
+				continue
+			}
+			if m.add(e.To, f.To) {
+				q = append(q, e.To, f.To)
+			}
+		}
+	}
+	return m
+}
+
+// add matches x in a with y in b, if neither is matched already and
+// they have the same type.  It reports whether it did.
+func (m *Matcher) add(x, y read.ObjId) bool {
+	if _, ok := m.fwd[x]; ok {
+		return false
+	}
+	if _, ok := m.rev[y]; ok {
+		return false
+	}
+	if m.a.Ft(x).Name != m.b.Ft(y).Name {
+		return false
+	}
+	m.fwd[x] = y
+	m.rev[y] = x
+	return true
+}
+
+// uniqueRoots returns the roots of d, by name, whose names are unique.
+func uniqueRoots(d *read.Dump) map[string]read.Root {
+	r := map[string]read.Root{}
+	dup := map[string]bool{}
+	for _, s := range d.Roots() {
+		if _, ok := r[s.Name]; ok {
+			dup[s.Name] = true
+		}
+		r[s.Name] = s
+	}
+	for name := range dup {
+		delete(r, name)
+	}
+	return r
+}
+
+// Match returns the object in the second dump matching x in the first.
+func (m *Matcher) Match(x read.ObjId) (read.ObjId, bool) {
+	y, ok := m.fwd[x]
+	return y, ok
+}
+
+// Reverse returns the object in the first dump matching y in the second.
+func (m *Matcher) Reverse(y read.ObjId) (read.ObjId, bool) {
+	x, ok := m.rev[y]
+	return x, ok
+}
+
+// Len returns the number of matched objects.
+func (m *Matcher) Len() int {
+	return len(m.fwd)
+}
+
+// TranslateAddr translates an address in the first dump to the
+// corresponding address in the second.  Global addresses in the data
+// and bss segments translate to themselves, relative to the segment;
+// heap addresses translate through matched objects.
+func (m *Matcher) TranslateAddr(addr uint64) (uint64, bool) {
+	if t, ok := translateSegment(m.a.Data, m.b.Data, addr); ok {
+		return t, true
+	}
+	if t, ok := translateSegment(m.a.Bss, m.b.Bss, addr); ok {
+		return t, true
+	}
+	x := m.a.FindObj(addr)
+	if x == read.ObjNil {
+		return 0, false
+	}
+	y, ok := m.fwd[x]
+	if !ok {
+		return 0, false
+	}
+	return m.b.Addr(y) + addr - m.a.Addr(x), true
+}
+
+func translateSegment(a, b *read.Data, addr uint64) (uint64, bool) {
+	if a == nil || b == nil || addr < a.Addr || addr >= a.Addr+uint64(len(a.Data)) {
+		return 0, false
+	}
+	off := addr - a.Addr
+	if off >= uint64(len(b.Data)) {
+		return 0, false
+	}
+	return b.Addr + off, true
+}
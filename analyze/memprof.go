@@ -0,0 +1,187 @@
+package analyze
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// DefaultMemProfRate is the runtime's default runtime.MemProfRate:
+// one allocation is sampled per this many bytes allocated.
+const DefaultMemProfRate = 512 * 1024
+
+// AccountingOptions controls ReconcileMemProf.
+type AccountingOptions struct {
+	// Rate is the runtime.MemProfRate of the dumped process, which the
+	// dump doesn't record.  0 means DefaultMemProfRate.  With a rate
+	// of 1 every allocation is sampled, and the accounting is strict:
+	// every live object should have a sample.
+	Rate int
+	// MinSamples is the number of live samples below which a site's
+	// estimate is flagged as unreliable.  0 means 10.
+	MinSamples int
+}
+
+// A SiteAccount reconciles one allocation site of the memory profile
+// with the objects live in the heap.
+type SiteAccount struct {
+	Entry *read.MemProfEntry
+	Site  string // innermost non-runtime frame of the site
+	// InUse is the number of sampled objects the profile says are
+	// still allocated: allocations less frees.
+	InUse int64
+	// Live counts the site's samples which are at the start of a live
+	// object big enough for the site's allocations, and Bytes is the
+	// total size of those objects.
+	Live  int
+	Bytes uint64
+	// Estimate is the site's estimated in-use bytes, scaling Bytes by
+	// the probability of an object of its size being sampled.
+	Estimate float64
+	// UnderSampled is set if Live is below MinSamples, so that
+	// Estimate is unreliable.
+	UnderSampled bool
+	// Problems describes the discrepancies found at the site.
+	Problems []string
+}
+
+// Accounting is the result of ReconcileMemProf.
+type Accounting struct {
+	// Sites are the allocation sites with live samples or a nonzero
+	// in-use count, the ones with problems first, then by estimated
+	// bytes.
+	Sites []SiteAccount
+	// Orphans counts samples with no memory profile entry, and
+	// Dangling samples not at the start of a live object.
+	Orphans, Dangling int
+	// With strict accounting, Unsampled counts the live objects which
+	// have no sample, and UnsampledBytes their total size.
+	Strict         bool
+	Unsampled      int
+	UnsampledBytes uint64
+	// Discrepancies counts the sites with problems.
+	Discrepancies int
+}
+
+// ReconcileMemProf checks the dump's memory profile against the heap:
+// that each sampled object is live and big enough for its site, and
+// that each site's in-use count from the profile matches its live
+// samples.  It validates the dump and the profiler both, and flags the
+// sites sampled too little for their numbers to be trusted.
+//
+// The profile's counts are as of the last garbage collection, which
+// the runtime runs just before writing a dump, so in a consistent dump
+// they match exactly.
+func ReconcileMemProf(d *read.Dump, opts *AccountingOptions) *Accounting {
+	defer measure("ReconcileMemProf")()
+	var o AccountingOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Rate == 0 {
+		o.Rate = DefaultMemProfRate
+	}
+	if o.MinSamples == 0 {
+		o.MinSamples = 10
+	}
+	a := &Accounting{Strict: o.Rate == 1}
+
+	type account struct {
+		SiteAccount
+		dangling, small int // samples not at a live object, or at one too small
+	}
+	sites := map[*read.MemProfEntry]*account{}
+	var order []*account
+	site := func(e *read.MemProfEntry) *account {
+		s := sites[e]
+		if s == nil {
+			s = &account{SiteAccount: SiteAccount{Entry: e, Site: allocSite(e), InUse: int64(e.Allocs()) - int64(e.Frees())}}
+			sites[e] = s
+			order = append(order, s)
+		}
+		return s
+	}
+	for _, e := range d.MemProf {
+		site(e)
+	}
+	sampled := d.NewObjSet()
+	for _, smp := range d.AllocSamples {
+		if smp.Prof == nil {
+			a.Orphans++
+			continue
+		}
+		s := site(smp.Prof)
+		x := d.FindObj(smp.Addr)
+		if x == read.ObjNil || d.Addr(x) != smp.Addr {
+			a.Dangling++
+			s.dangling++
+			continue
+		}
+		if d.Size(x) < smp.Prof.Size() {
+			s.small++
+			continue
+		}
+		sampled.Add(x)
+		s.Live++
+		s.Bytes += d.Size(x)
+	}
+
+	for _, s := range order {
+		if s.dangling > 0 {
+			s.Problems = append(s.Problems, fmt.Sprintf("%d samples are not at the start of a live object", s.dangling))
+		}
+		if s.small > 0 {
+			s.Problems = append(s.Problems, fmt.Sprintf("%d samples are at objects smaller than the site's %d bytes", s.small, s.Entry.Size()))
+		}
+		if s.InUse == 0 && s.Live == 0 && len(s.Problems) == 0 {
+			continue
+		}
+		if s.InUse < 0 {
+			s.Problems = append(s.Problems, fmt.Sprintf("profile has more frees than allocations (%d)", s.InUse))
+		}
+		if int64(s.Live) != s.InUse {
+			s.Problems = append(s.Problems, fmt.Sprintf("profile has %d in use, heap has %d live samples", s.InUse, s.Live))
+		}
+		if s.Live > 0 {
+			size := float64(s.Bytes) / float64(s.Live)
+			s.Estimate = float64(s.Bytes) / sampleProbability(size, o.Rate)
+		}
+		s.UnderSampled = s.Live < o.MinSamples && !a.Strict
+		if len(s.Problems) > 0 {
+			a.Discrepancies++
+		}
+		a.Sites = append(a.Sites, s.SiteAccount)
+	}
+	sort.SliceStable(a.Sites, func(i, j int) bool {
+		si, sj := &a.Sites[i], &a.Sites[j]
+		if (len(si.Problems) > 0) != (len(sj.Problems) > 0) {
+			return len(si.Problems) > 0
+		}
+		if si.Estimate != sj.Estimate {
+			return si.Estimate > sj.Estimate
+		}
+		return si.Entry.Key() < sj.Entry.Key()
+	})
+
+	if a.Strict {
+		for i := 0; i < d.NumObjects(); i++ {
+			if x := read.ObjId(i); !sampled.Has(x) {
+				a.Unsampled++
+				a.UnsampledBytes += d.Size(x)
+			}
+		}
+	}
+	return a
+}
+
+// sampleProbability returns the probability that the runtime samples
+// an allocation of size bytes when sampling once per rate bytes on
+// average.
+func sampleProbability(size float64, rate int) float64 {
+	if rate <= 1 {
+		return 1
+	}
+	return 1 - math.Exp(-size/float64(rate))
+}
@@ -0,0 +1,162 @@
+package analyze
+
+import (
+	"math/bits"
+	"sort"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// A Histogram counts values in power-of-two buckets.  Buckets[0]
+// counts zeros, and Buckets[i] for i > 0 counts values in
+// [1<<(i-1), 1<<i).
+type Histogram struct {
+	Buckets []int
+	Max     int
+	Sum     int
+	N       int
+}
+
+func (h *Histogram) add(v int) {
+	b := bits.Len(uint(v))
+	for len(h.Buckets) <= b {
+		h.Buckets = append(h.Buckets, 0)
+	}
+	h.Buckets[b]++
+	if v > h.Max {
+		h.Max = v
+	}
+	h.Sum += v
+	h.N++
+}
+
+// Merge adds the values counted by o to h.
+func (h *Histogram) Merge(o Histogram) {
+	for len(h.Buckets) < len(o.Buckets) {
+		h.Buckets = append(h.Buckets, 0)
+	}
+	for i, n := range o.Buckets {
+		h.Buckets[i] += n
+	}
+	if o.Max > h.Max {
+		h.Max = o.Max
+	}
+	h.Sum += o.Sum
+	h.N += o.N
+}
+
+// Mean returns the mean of the values counted.
+func (h *Histogram) Mean() float64 {
+	if h.N == 0 {
+		return 0
+	}
+	return float64(h.Sum) / float64(h.N)
+}
+
+// A TypeEdges counts the edges out of the objects of one type.
+type TypeEdges struct {
+	Type    *read.FullType
+	Objects int
+	Edges   int
+}
+
+// GraphMetrics describes the overall shape of the heap graph.
+type GraphMetrics struct {
+	Objects int
+	Edges   int // edges between objects, not counting roots
+	Roots   int
+
+	InDegree  Histogram // edges into each object
+	OutDegree Histogram // edges out of each object
+
+	// Depth counts the reachable objects by their distance, in edges,
+	// from the nearest root; objects pointed to by a root have depth 0.
+	Depth       Histogram
+	Unreachable int // objects not reachable from any root
+
+	// Components is the number of weakly connected components: groups
+	// of objects linked by edges in either direction.
+	Components int
+
+	// ByType counts edges by the type of the object they come from,
+	// most edges first.
+	ByType []TypeEdges
+}
+
+// Metrics computes statistics about the heap graph as a whole.  They
+// help in understanding the heap's structure, and in sizing work for
+// other analyses: a large maximum depth, for instance, means paths to
+// roots are long.
+func Metrics(d *read.Dump) *GraphMetrics {
+	defer measure("Metrics")()
+	n := d.NumObjects()
+	m := &GraphMetrics{Objects: n}
+
+	in := make([]int, n)
+	byType := make([]TypeEdges, len(d.FTList))
+	comp := newUnionFind(n)
+	for i := 0; i < n; i++ {
+		x := read.ObjId(i)
+		edges := d.Edges(x)
+		m.Edges += len(edges)
+		m.OutDegree.add(len(edges))
+		t := &byType[d.Ft(x).Id]
+		t.Type = d.Ft(x)
+		t.Objects++
+		t.Edges += len(edges)
+		for _, e := range edges {
+			in[e.To]++
+			comp.union(int(x), int(e.To))
+		}
+	}
+	for _, k := range in {
+		m.InDegree.add(k)
+	}
+	for i := 0; i < n; i++ {
+		if comp.find(i) == i {
+			m.Components++
+		}
+	}
+	for _, t := range byType {
+		if t.Objects > 0 {
+			m.ByType = append(m.ByType, t)
+		}
+	}
+	sort.SliceStable(m.ByType, func(i, j int) bool { return m.ByType[i].Edges > m.ByType[j].Edges })
+
+	m.Roots = len(d.Roots())
+	for i := 0; i < n; i++ {
+		if k := d.Depth(read.ObjId(i)); k < 0 {
+			m.Unreachable++
+		} else {
+			m.Depth.add(k)
+		}
+	}
+	return m
+}
+
+// A unionFind is a disjoint-set forest over [0,n).
+type unionFind []int
+
+func newUnionFind(n int) unionFind {
+	u := make(unionFind, n)
+	for i := range u {
+		u[i] = i
+	}
+	return u
+}
+
+func (u unionFind) find(i int) int {
+	for u[i] != i {
+		u[i] = u[u[i]]
+		i = u[i]
+	}
+	return i
+}
+
+func (u unionFind) union(i, j int) {
+	i, j = u.find(i), u.find(j)
+	if i != j {
+		u[i] = j
+	}
+}
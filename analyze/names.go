@@ -0,0 +1,127 @@
+package analyze
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// A Namer names heap objects by the chain of fields leading to them
+// from a root, like "main.cache.entries[3].value".  Unlike addresses,
+// these names are the same from run to run of a program, so reports
+// keyed by them can be compared and diffed.
+type Namer struct {
+	d      *read.Dump
+	parent []read.ObjId // object whose field points to x, ObjNil if a root does
+	field  []string     // name of that field, or of the root
+	offset []uint64     // offset of that field in parent
+}
+
+// NewNamer computes names for all the reachable objects in d.  Each
+// object is named by a shortest path to it.  Ties are broken by root
+// name and then by field order, never by address.
+func NewNamer(d *read.Dump) *Namer {
+	defer measure("NewNamer")()
+	n := d.NumObjects()
+	m := &Namer{
+		d:      d,
+		parent: make([]read.ObjId, n),
+		field:  make([]string, n),
+		offset: make([]uint64, n),
+	}
+	seen := make([]bool, n)
+	roots := append([]read.Root(nil), d.Roots()...)
+	sort.Stable(byRootName(roots))
+	var q []read.ObjId
+	for _, r := range roots {
+		x := r.Edge.To
+		if !seen[x] {
+			seen[x] = true
+			m.parent[x] = read.ObjNil
+			m.field[x] = r.Name
+			q = append(q, x)
+		}
+	}
+	for len(q) > 0 {
+		x := q[0]
+		q = q[1:]
+		for _, e := range d.Edges(x) {
+			if !seen[e.To] {
+				seen[e.To] = true
+				m.parent[e.To] = x
+				m.field[e.To] = e.FieldName
+				m.offset[e.To] = e.FromOffset
+				q = append(q, e.To)
+			}
+		}
+	}
+	for i := range seen {
+		if !seen[i] {
+			m.parent[i] = read.ObjNil
+		}
+	}
+	return m
+}
+
+// Name returns the logical name of x, or "" if x is unreachable.
+func (m *Namer) Name(x read.ObjId) string {
+	var parts []string
+	for ; m.parent[x] != read.ObjNil; x = m.parent[x] {
+		parts = append(parts, fieldPart(m.field[x], m.offset[x]))
+	}
+	s := m.field[x]
+	if s == "" {
+		return ""
+	}
+	for i := len(parts) - 1; i >= 0; i-- {
+		s += parts[i]
+	}
+	return s
+}
+
+// PathName describes a path by the root it starts at and the fields it
+// goes through, in the same form as Namer.
+func PathName(d *read.Dump, p read.Path) string {
+	s := p.Root.Name
+	for _, e := range p.Edges {
+		s += fieldPart(e.FieldName, e.FromOffset)
+	}
+	return s
+}
+
+// fieldPart returns the suffix naming the field f, at offset off, of an object.
+func fieldPart(f string, off uint64) string {
+	if f == "" {
+		return fmt.Sprintf("+%d", off)
+	}
+	// Array elements are named by their index: "3.value" is the value
+	// field of element 3.
+	i := strings.IndexByte(f, '.')
+	if i < 0 {
+		i = len(f)
+	}
+	if isIndex(f[:i]) {
+		return "[" + f[:i] + "]" + f[i:]
+	}
+	return "." + f
+}
+
+func isIndex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+type byRootName []read.Root
+
+func (a byRootName) Len() int           { return len(a) }
+func (a byRootName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byRootName) Less(i, j int) bool { return a[i].Name < a[j].Name }
@@ -0,0 +1,182 @@
+package analyze
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// PackageOf returns the import path of the package defining the type
+// named name, looking through pointers, slices and arrays: "main" for
+// "[]*main.T", "github.com/a/b" for "github.com/a/b.Pair[int,int]".  It
+// returns "" for built-in and unnamed types, and for types without
+// real names, whose package is unknown.
+func PackageOf(name string) string {
+	for {
+		switch {
+		case strings.HasPrefix(name, "*"):
+			name = name[1:]
+			continue
+		case strings.HasPrefix(name, "[]"):
+			name = name[2:]
+			continue
+		case strings.HasPrefix(name, "["):
+			i := 1
+			for i < len(name) && name[i] >= '0' && name[i] <= '9' {
+				i++
+			}
+			if i > 1 && i < len(name) && name[i] == ']' {
+				name = name[i+1:]
+				continue
+			}
+		}
+		break
+	}
+	if i := instantiation(name, 0); i > 0 {
+		name = name[:i]
+	}
+	if notGeneric[name] {
+		return ""
+	}
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; !isIdentByte(c) && c != '.' && c != '/' && c != '-' && c != '~' && c != '%' {
+			return "" // map, func, struct, ...
+		}
+	}
+	// The linker escapes dots in the last element of a path, as in
+	// "gopkg.in/yaml%2ev2.Node", so the first dot after the last slash
+	// ends the package.
+	slash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[slash+1:], ".")
+	if dot <= 0 {
+		return ""
+	}
+	return name[:slash+1+dot]
+}
+
+// An EdgeClass says whether an edge stays within a package.
+type EdgeClass int
+
+const (
+	EdgeInternal EdgeClass = iota // both ends' types are in the same package
+	EdgeExternal                  // the ends' types are in different packages
+	EdgeUnknown                   // the package of either end is unknown
+	numEdgeClasses
+)
+
+var edgeClassNames = [...]string{
+	EdgeInternal: "internal",
+	EdgeExternal: "external",
+	EdgeUnknown:  "unknown",
+}
+
+func (c EdgeClass) String() string {
+	if c < 0 || c >= numEdgeClasses {
+		return fmt.Sprintf("EdgeClass(%d)", int(c))
+	}
+	return edgeClassNames[c]
+}
+
+// ClassifyEdge classifies an edge between objects whose types are in
+// packages from and to, as PackageOf gives them.
+func ClassifyEdge(from, to string) EdgeClass {
+	switch {
+	case from == "" || to == "":
+		return EdgeUnknown
+	case from == to:
+		return EdgeInternal
+	}
+	return EdgeExternal
+}
+
+// A PackageRef is the volume of references from the objects of one
+// package to those of another.
+type PackageRef struct {
+	From, To string
+	// Edges counts the pointers from From's objects to To's, and
+	// Bytes is the total size of the objects they point to, counted
+	// once per pointer.
+	Edges int
+	Bytes uint64
+	// Retained is the memory of To's objects, with everything they
+	// retain, whose immediate dominator is one of From's objects: the
+	// memory From's objects alone keep alive.  Where such ownership
+	// nests, as when a to b to a again, the inner memory is counted
+	// in both pairs.
+	Retained uint64
+}
+
+// PackageCoupling is the result of PackageEdges.
+type PackageCoupling struct {
+	// Edges counts the edges of each class, indexed by EdgeClass.
+	Edges [numEdgeClasses]int
+	// Refs are the pairs of distinct packages with references between
+	// them, those retaining the most first, then those with the most
+	// edges.
+	Refs []PackageRef
+}
+
+// PackageEdges classifies every edge of d by whether its ends' types
+// are in the same package, and measures the references between each
+// pair of packages.  References from one package's objects retaining
+// much of another's show unexpected coupling, like a logging library
+// holding on to requests.
+func PackageEdges(d *read.Dump) *PackageCoupling {
+	defer measure("PackageEdges")()
+	pkgs := make([]string, len(d.FTList))
+	for i, ft := range d.FTList {
+		pkgs[i] = PackageOf(ft.Name)
+	}
+	pkg := func(x read.ObjId) string { return pkgs[d.Ft(x).Id] }
+	type pair struct{ from, to string }
+	refs := map[pair]*PackageRef{}
+	get := func(from, to string) *PackageRef {
+		p := pair{from, to}
+		r := refs[p]
+		if r == nil {
+			r = &PackageRef{From: from, To: to}
+			refs[p] = r
+		}
+		return r
+	}
+	c := &PackageCoupling{}
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		from := pkg(x)
+		for _, e := range d.Edges(x) {
+			to := pkg(e.To)
+			k := ClassifyEdge(from, to)
+			c.Edges[k]++
+			if k == EdgeExternal {
+				r := get(from, to)
+				r.Edges++
+				r.Bytes += d.Size(e.To)
+			}
+		}
+		if y := d.Idom(x); y != read.ObjNil {
+			if from, to := pkg(y), pkg(x); ClassifyEdge(from, to) == EdgeExternal {
+				get(from, to).Retained += d.RetainedSize(x)
+			}
+		}
+	}
+	c.Refs = []PackageRef{}
+	for _, r := range refs {
+		c.Refs = append(c.Refs, *r)
+	}
+	sort.Slice(c.Refs, func(i, j int) bool {
+		a, b := c.Refs[i], c.Refs[j]
+		if a.Retained != b.Retained {
+			return a.Retained > b.Retained
+		}
+		if a.Edges != b.Edges {
+			return a.Edges > b.Edges
+		}
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+	return c
+}
@@ -0,0 +1,26 @@
+package analyze
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+func TestTopRanked(t *testing.T) {
+	rank := []float64{0.1, 0.4, 0.1, 0.3}
+	tests := []struct {
+		n    int
+		want []read.ObjId
+	}{
+		{2, []read.ObjId{1, 3}},
+		{10, []read.ObjId{1, 3, 0, 2}}, // ties in id order
+		{0, []read.ObjId{}},
+		{-1, []read.ObjId{}},
+	}
+	for _, tt := range tests {
+		if got := TopRanked(rank, tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TopRanked(%d) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
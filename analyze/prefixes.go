@@ -0,0 +1,224 @@
+package analyze
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// PrefixOptions controls StringPrefixes.
+type PrefixOptions struct {
+	// MinStrings is the fewest strings a cluster is reported with;
+	// smaller clusters are merged into their shorter prefix.  0 means
+	// 10.
+	MinStrings int
+	// MaxPrefix is the most bytes of each string considered.  0 means
+	// 64.
+	MaxPrefix int
+}
+
+// A PrefixCluster is a set of live strings sharing a prefix.
+type PrefixCluster struct {
+	// Prefix is the shared prefix, with each run of digits replaced
+	// by "*", so that strings differing in IDs, counters or
+	// timestamps share it: "GET /users/*?page=" clusters request
+	// lines for all users and pages.
+	Prefix  string
+	Strings int    // the strings in the cluster, each counted once
+	Bytes   uint64 // their total length
+	Example string // one of them, up to MaxPrefix bytes
+}
+
+// PrefixSummary is the result of StringPrefixes.
+type PrefixSummary struct {
+	Strings  int    // live strings in the heap, each counted once
+	Bytes    uint64 // their total length
+	Clusters []PrefixCluster
+	// Unclustered counts the strings sharing no prefix with enough
+	// others, and UnclusteredBytes their total length.
+	Unclustered      int
+	UnclusteredBytes uint64
+}
+
+// StringPrefixes clusters the heap's live strings by their common
+// prefixes and totals the bytes of each cluster, most bytes first.
+// Where duplicate detection finds only strings which are the same, this
+// finds the URLs, log lines and JSON documents built from the same
+// template, whose suffixes differ in IDs or timestamps.
+//
+// Strings are found through the string fields of objects, so they are
+// found only with type information.  A string is counted once however
+// many fields refer to it, and only if its bytes are in the heap:
+// literals in the data section cost nothing to keep.
+//
+// Prefixes end at separators such as '/', '=', ':', ' ' or '"', and
+// each string starts in the cluster of its longest one.  Clusters with
+// fewer than MinStrings strings move to the next shorter prefix, so
+// the strings of a template with many variants join one cluster for
+// the template, until they reach the empty prefix and are counted as
+// unclustered.
+func StringPrefixes(d *read.Dump, opts PrefixOptions) *PrefixSummary {
+	defer measure("StringPrefixes")()
+	if opts.MinStrings <= 0 {
+		opts.MinStrings = 10
+	}
+	if opts.MaxPrefix <= 0 {
+		opts.MaxPrefix = 64
+	}
+	r := &PrefixSummary{}
+	clusters := map[string]*PrefixCluster{}
+
+	type str struct{ p, n uint64 }
+	seen := map[str]bool{}
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		fields := d.Ft(x).Fields
+		var b []byte
+		for j := range fields {
+			if !isString(fields[j:]) {
+				continue
+			}
+			if b == nil {
+				b = append([]byte(nil), d.Contents(x)...)
+			}
+			off := fields[j].Offset
+			if off+2*d.PtrSize > uint64(len(b)) {
+				continue
+			}
+			p, n := d.StringHeader(b[off:])
+			if n == 0 || seen[str{p, n}] {
+				continue
+			}
+			// A stale or garbage header may have any length; compare
+			// it with the room left so the sum can't wrap around.
+			y := d.FindObj(p)
+			if y == read.ObjNil || n > d.Size(y)-(p-d.Addr(y)) {
+				continue
+			}
+			seen[str{p, n}] = true
+			m := n
+			if m > uint64(opts.MaxPrefix) {
+				m = uint64(opts.MaxPrefix)
+			}
+			start := p - d.Addr(y)
+			s := string(d.Contents(y)[start : start+m])
+
+			r.Strings++
+			r.Bytes += n
+			k := stringPrefix(s)
+			c := clusters[k]
+			if c == nil {
+				c = &PrefixCluster{Prefix: k, Example: s}
+				clusters[k] = c
+			}
+			c.Strings++
+			c.Bytes += n
+		}
+	}
+
+	// Merge small clusters into their parents, longest prefixes first,
+	// so a parent has all its children's strings before it is judged.
+	keys := make([]string, 0, len(clusters))
+	for k := range clusters {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	for i := 0; i < len(keys); i++ {
+		k := keys[i]
+		c := clusters[k]
+		if c.Strings >= opts.MinStrings && k != "" {
+			continue
+		}
+		delete(clusters, k)
+		if k == "" {
+			r.Unclustered += c.Strings
+			r.UnclusteredBytes += c.Bytes
+			continue
+		}
+		pk := parentPrefix(k)
+		p := clusters[pk]
+		if p == nil {
+			// Parents are shorter, so not yet visited; add pk to the
+			// keys still to judge, in its place by length.
+			p = &PrefixCluster{Prefix: pk, Example: c.Example}
+			clusters[pk] = p
+			keys = insertPrefixKey(keys, i+1, pk)
+		}
+		p.Strings += c.Strings
+		p.Bytes += c.Bytes
+	}
+
+	for _, c := range clusters {
+		r.Clusters = append(r.Clusters, *c)
+	}
+	sort.Slice(r.Clusters, func(i, j int) bool {
+		ci, cj := &r.Clusters[i], &r.Clusters[j]
+		if ci.Bytes != cj.Bytes {
+			return ci.Bytes > cj.Bytes
+		}
+		return ci.Prefix < cj.Prefix
+	})
+	return r
+}
+
+// prefixSeparators end the prefixes StringPrefixes clusters by.
+const prefixSeparators = "/?&=:;,. \t\n-_\"'{}[]()<>|@#"
+
+// stringPrefix returns the longest prefix of s ending at a separator,
+// with its digit runs replaced by "*".  s itself is the prefix if it
+// ends in a separator.
+func stringPrefix(s string) string {
+	i := strings.LastIndexAny(s, prefixSeparators)
+	return starDigits(s[:i+1])
+}
+
+// parentPrefix returns the next shorter prefix of k, as stringPrefix
+// makes them: k up to its separator before the last.
+func parentPrefix(k string) string {
+	i := strings.LastIndexAny(k[:len(k)-1], prefixSeparators)
+	return k[:i+1]
+}
+
+// starDigits replaces each run of decimal digits in s by "*".
+func starDigits(s string) string {
+	if strings.IndexAny(s, "0123456789") < 0 {
+		return s
+	}
+	var b strings.Builder
+	digits := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= '0' && c <= '9' {
+			if !digits {
+				b.WriteByte('*')
+			}
+			digits = true
+			continue
+		}
+		digits = false
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// insertPrefixKey inserts k into keys[from:], which are sorted by
+// decreasing length and then increasing value, in its place.
+func insertPrefixKey(keys []string, from int, k string) []string {
+	i := from + sort.Search(len(keys)-from, func(i int) bool {
+		i += from
+		if len(keys[i]) != len(k) {
+			return len(keys[i]) < len(k)
+		}
+		return keys[i] >= k
+	})
+	keys = append(keys, "")
+	copy(keys[i+1:], keys[i:])
+	keys[i] = k
+	return keys
+}
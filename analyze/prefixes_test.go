@@ -0,0 +1,106 @@
+package analyze
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+const testHeap = 0xc000000000
+
+// A testObject is an object of a test dump: its address, contents,
+// and the offsets of the pointers in it.
+type testObject struct {
+	addr uint64
+	data []byte
+	ptrs []uint64
+}
+
+// readTestDump writes a go1.5 heap dump of objs, with a data section
+// holding the pointer words roots, and reads it.
+func readTestDump(t *testing.T, objs []testObject, roots ...uint64) *read.Dump {
+	var b []byte
+	u := func(xs ...uint64) {
+		for _, x := range xs {
+			b = binary.AppendUvarint(b, x)
+		}
+	}
+	bytes := func(p []byte) {
+		u(uint64(len(p)))
+		b = append(b, p...)
+	}
+	b = append(b, "go1.5 heap dump\n"...)
+	u(6, 0, 8, testHeap, testHeap+1<<20, '6', 0, 4) // params
+	for _, o := range objs {
+		u(1, o.addr)
+		bytes(o.data)
+		for _, p := range o.ptrs {
+			u(1, p)
+		}
+		u(0)
+	}
+	u(12, 0x600000) // data segment
+	bytes(words(roots...))
+	for i := range roots {
+		u(1, uint64(8*i))
+	}
+	u(0)
+	u(13, 0x700000) // bss segment
+	bytes(words(0))
+	u(0)
+	u(10) // memstats
+	for i := 0; i < 24; i++ {
+		u(1000)
+	}
+	for i := 0; i < 256; i++ {
+		u(0)
+	}
+	u(1) // gc count
+	u(0) // eof
+	name := filepath.Join(t.TempDir(), "test.dump")
+	if err := os.WriteFile(name, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	return read.ReadWithOptions(name, &read.Options{})
+}
+
+// words returns ws as little-endian 8-byte words.
+func words(ws ...uint64) []byte {
+	var b []byte
+	for _, w := range ws {
+		b = binary.LittleEndian.AppendUint64(b, w)
+	}
+	return b
+}
+
+func TestStringPrefixesCorruptHeader(t *testing.T) {
+	const text = testHeap + 64
+	d := readTestDump(t, []testObject{
+		{testHeap, words(text, 7), []uint64{0}},
+		// A string header whose length, added to its offset in the
+		// object it points into, wraps around.
+		{testHeap + 16, words(text+8, 1<<64-4), []uint64{0}},
+		{testHeap + 32, words(text+8, 100), []uint64{0}},
+		{text, []byte("hello, world. 16"), nil},
+	}, testHeap, testHeap+16, testHeap+32)
+	// The dump has no dwarf info; type the objects with headers as
+	// strings.
+	ft := d.Ft(d.FindObj(testHeap))
+	ft.Fields = []read.Field{{Kind: read.FieldKindString, Offset: 0, Name: "s"}}
+	for _, a := range []uint64{testHeap + 16, testHeap + 32} {
+		if d.Ft(d.FindObj(a)) != ft {
+			t.Fatalf("object %x has type %s, want %s", a, d.Ft(d.FindObj(a)).Name, ft.Name)
+		}
+	}
+
+	r := StringPrefixes(d, PrefixOptions{MinStrings: 1})
+	if r.Strings != 1 || r.Bytes != 7 {
+		t.Errorf("StringPrefixes found %d strings of %d bytes, want 1 of 7", r.Strings, r.Bytes)
+	}
+	if len(r.Clusters) != 1 || r.Clusters[0].Example != "hello, " {
+		t.Errorf("Clusters = %+v, want one with example \"hello, \"", r.Clusters)
+	}
+}
@@ -0,0 +1,115 @@
+package analyze
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// A ResultCache holds the results of analyses of a dump, so that
+// servers compute each once rather than on every request.  Each result
+// is kept with the options it was computed with; asking for it with
+// other options recomputes it and increments its generation, so that
+// a Handle to the old result, like "histogram@gen3", is known to be
+// stale.  A ResultCache is safe for concurrent use, but computes one
+// result at a time.
+type ResultCache struct {
+	d       *read.Dump
+	mu      sync.Mutex
+	results map[string]*cachedResult
+}
+
+type cachedResult struct {
+	gen   int
+	opts  string // the options, formatted with %#v
+	value interface{}
+}
+
+// A Handle names a result of a ResultCache and its generation.
+type Handle struct {
+	Name string
+	Gen  int
+}
+
+// String returns the handle as name@genN.
+func (h Handle) String() string {
+	return fmt.Sprintf("%s@gen%d", h.Name, h.Gen)
+}
+
+// ParseHandle parses a handle written by Handle.String.
+func ParseHandle(s string) (Handle, error) {
+	i := strings.LastIndex(s, "@gen")
+	if i < 0 {
+		return Handle{}, fmt.Errorf("bad result handle %q", s)
+	}
+	n, err := strconv.Atoi(s[i+len("@gen"):])
+	if err != nil || n < 1 {
+		return Handle{}, fmt.Errorf("bad result handle %q", s)
+	}
+	return Handle{s[:i], n}, nil
+}
+
+// NewResultCache returns an empty cache for the results of d.
+func NewResultCache(d *read.Dump) *ResultCache {
+	return &ResultCache{d: d, results: map[string]*cachedResult{}}
+}
+
+// Get returns the result named name computed with options opts, and
+// its handle.  If the cache holds none, or holds one computed with
+// other options, it calls compute to compute it.  Options are compared
+// by their %#v formatting, so they should be values, or pointers to
+// structs of values, and not hold pointers themselves.  compute must
+// not return nil.  Generations start at 1.
+func (c *ResultCache) Get(name string, opts interface{}, compute func(d *read.Dump) interface{}) (Handle, interface{}) {
+	o := fmt.Sprintf("%#v", opts)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.results[name]
+	if r == nil {
+		r = &cachedResult{}
+		c.results[name] = r
+	}
+	if r.gen == 0 || r.opts != o || r.value == nil {
+		r.gen++
+		r.opts = o
+		r.value = compute(c.d)
+	}
+	return Handle{name, r.gen}, r.value
+}
+
+// Lookup returns the result h names, if it is still the current
+// generation.
+func (c *ResultCache) Lookup(h Handle) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.results[h.Name]
+	if r == nil || r.gen != h.Gen || r.value == nil {
+		return nil, false
+	}
+	return r.value, true
+}
+
+// Current returns the handle of the current generation of the result
+// named name, and whether there is one.
+func (c *ResultCache) Current(name string) (Handle, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.results[name]
+	if r == nil || r.value == nil {
+		return Handle{}, false
+	}
+	return Handle{name, r.gen}, true
+}
+
+// Invalidate drops the result named name, so that the next Get
+// recomputes it as a new generation even with the same options.
+func (c *ResultCache) Invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r := c.results[name]; r != nil {
+		r.value = nil
+	}
+}
@@ -0,0 +1,28 @@
+package analyze
+
+import "testing"
+
+func TestParseHandle(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Handle
+		ok   bool
+	}{
+		{"histogram@gen3", Handle{"histogram", 3}, true},
+		{"a@gen1@gen12", Handle{"a@gen1", 12}, true},
+		{"@gen1", Handle{"", 1}, true},
+		{"histogram", Handle{}, false},
+		{"histogram@gen0", Handle{}, false},
+		{"histogram@gen-1", Handle{}, false},
+		{"histogram@genx", Handle{}, false},
+	}
+	for _, tt := range tests {
+		h, err := ParseHandle(tt.in)
+		if (err == nil) != tt.ok || h != tt.want {
+			t.Errorf("ParseHandle(%q) = %v, %v; want %v, ok %v", tt.in, h, err, tt.want, tt.ok)
+		}
+		if tt.ok && h.String() != tt.in {
+			t.Errorf("ParseHandle(%q).String() = %q", tt.in, h.String())
+		}
+	}
+}
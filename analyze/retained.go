@@ -0,0 +1,166 @@
+package analyze
+
+import (
+	"sort"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// OnlyReachableVia returns the objects which are reachable from the
+// given roots and from no others: those which would become garbage
+// if the roots were cleared.  This is the memory a cache or other
+// global structure owns exclusively.
+//
+// Unlike Dump.RetainedSize, which answers the question for an object, it
+// doesn't need the dominator tree, and it works for several roots at
+// once, whose objects no single object might dominate.
+func OnlyReachableVia(d *read.Dump, roots ...read.Root) read.ObjSet {
+	defer measure("OnlyReachableVia")()
+	drop := map[read.Root]bool{}
+	for _, r := range roots {
+		drop[r] = true
+	}
+	var rest []read.Root
+	for _, r := range d.Roots() {
+		if !drop[r] {
+			rest = append(rest, r)
+		}
+	}
+	return d.ReachableFrom(roots).Minus(d.ReachableFrom(rest))
+}
+
+// ReachableFromGoroutine returns the objects reachable only from the
+// goroutine with id goid, through its stack frames, closure context,
+// deferred calls and panics, and their total size: the memory which
+// would be freed if the goroutine exited.  It reports false if the
+// dump has no such goroutine.
+func ReachableFromGoroutine(d *read.Dump, goid uint64) (read.ObjSet, uint64, bool) {
+	defer measure("ReachableFromGoroutine")()
+	var g *read.GoRoutine
+	for _, x := range d.Goroutines {
+		if x.Goid == goid {
+			g = x
+			break
+		}
+	}
+	if g == nil {
+		return nil, 0, false
+	}
+	var mine, rest []read.Root
+	for _, r := range d.Roots() {
+		if r.Goroutine == g {
+			mine = append(mine, r)
+		} else {
+			rest = append(rest, r)
+		}
+	}
+	// Roots has only the goroutines' frames.  Their other pointers
+	// keep objects alive too.
+	for _, x := range d.Goroutines {
+		for _, e := range x.Edges() {
+			if e.Via == "frame" {
+				continue
+			}
+			r := read.Root{Kind: read.RootOther, Name: e.FieldName, Edge: e.Edge, Goroutine: x}
+			if x == g {
+				mine = append(mine, r)
+			} else {
+				rest = append(rest, r)
+			}
+		}
+	}
+	s := d.ReachableFrom(mine).Minus(d.ReachableFrom(rest))
+	return s, s.Bytes(d), true
+}
+
+// TypeUniqueRetained returns the number of bytes which would be freed
+// if every object of type ft disappeared: the reachable objects of ft
+// themselves, and the objects reachable only through them.  This is
+// what the type really costs.  Summing Dump.RetainedSize over the instances
+// instead misses objects which several instances share, since none of
+// them dominates those alone, and double counts when one instance is
+// retained by another.
+func TypeUniqueRetained(d *read.Dump, ft *read.FullType) uint64 {
+	defer measure("TypeUniqueRetained")()
+	live := d.Reachable()
+	s := d.NewObjSet()
+	var q []read.ObjId
+	add := func(x read.ObjId) {
+		if !s.Has(x) && d.Ft(x) != ft {
+			s.Add(x)
+			q = append(q, x)
+		}
+	}
+	for _, r := range d.Roots() {
+		add(r.Edge.To)
+	}
+	for len(q) > 0 {
+		x := q[len(q)-1]
+		q = q[:len(q)-1]
+		for _, e := range d.Edges(x) {
+			add(e.To)
+		}
+	}
+	return live.Minus(s).Bytes(d)
+}
+
+// A TypeRetained is the memory the objects of one type retain.
+type TypeRetained struct {
+	Type     *read.FullType
+	Count    int    // reachable objects of the type
+	Bytes    uint64 // their total size
+	Retained uint64 // bytes they dominate, each counted once
+}
+
+// RetainedByType totals the retained sizes of the reachable objects of
+// each type, most retained first.  An object dominated by another of
+// the same type, like a node of a linked list, is counted within the
+// outermost one only, so no bytes are counted twice.  Objects which
+// several objects of the type keep alive together, none of them alone,
+// are not counted; TypeUniqueRetained counts those too, for one type
+// at a time.
+func RetainedByType(d *read.Dump) []TypeRetained {
+	defer measure("RetainedByType")()
+	s := d.Dominators()
+	r := make([]TypeRetained, len(d.FTList))
+	inside := make([]int, len(d.FTList)) // objects of each type on the stack
+
+	// Walk the tree depth first, leaving each object after its
+	// subtree, as a negative entry on the stack.
+	stack := append([]read.ObjId(nil), s.Top()...)
+	for len(stack) > 0 {
+		x := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if x < 0 {
+			inside[d.Ft(^x).Id]--
+			continue
+		}
+		ft := d.Ft(x)
+		e := &r[ft.Id]
+		e.Type = ft
+		e.Count++
+		e.Bytes += d.Size(x)
+		if inside[ft.Id] == 0 {
+			e.Retained += s.RetainedSize(x)
+		}
+		inside[ft.Id]++
+		stack = append(stack, ^x)
+		stack = append(stack, s.Children(x)...)
+	}
+
+	n := 0
+	for _, e := range r {
+		if e.Count > 0 {
+			r[n] = e
+			n++
+		}
+	}
+	r = r[:n]
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Retained != r[j].Retained {
+			return r[i].Retained > r[j].Retained
+		}
+		return r[i].Type.Id < r[j].Type.Id
+	})
+	return r
+}
@@ -0,0 +1,94 @@
+package analyze
+
+import (
+	"expvar"
+	"runtime/metrics"
+	"sync/atomic"
+	"time"
+)
+
+// A Run reports one run of an analysis in this package, such as
+// FindStructures or PageRank, for services which run many to monitor
+// and tune their workloads.
+type Run struct {
+	Analysis string // the name of the function, e.g. "FindStructures"
+	Duration time.Duration
+	// Allocs and AllocBytes count the heap allocations made while the
+	// analysis ran.  They are approximate: they include the
+	// allocations of other goroutines running at the same time, and
+	// analyses called by this one, which also report their own runs,
+	// and the runtime counts small allocations in batches, so runs of
+	// a millisecond or so may show none.
+	Allocs, AllocBytes uint64
+}
+
+// the hook set by OnRun, a func(Run), or nil
+var runHook atomic.Value
+
+type runFunc func(Run)
+
+// OnRun sets f to be called after each run of an analysis, or stops
+// calling anything if f is nil.  f is called on the goroutine which
+// ran the analysis, so it may be called concurrently.  Measuring costs
+// nothing without a hook.
+//
+// The fields of each Run add to counters by analysis name, like the
+// expvar.Map ExpvarRuns updates, or a Prometheus CounterVec with an
+// analysis label.
+func OnRun(f func(Run)) {
+	runHook.Store(runFunc(f))
+}
+
+// ExpvarRuns returns a hook for OnRun adding each run to counters in
+// m: the analysis name with ".runs", ".nanoseconds", ".allocs" and
+// ".bytes" appended.
+func ExpvarRuns(m *expvar.Map) func(Run) {
+	return func(r Run) {
+		m.Add(r.Analysis+".runs", 1)
+		m.Add(r.Analysis+".nanoseconds", int64(r.Duration))
+		m.Add(r.Analysis+".allocs", int64(r.Allocs))
+		m.Add(r.Analysis+".bytes", int64(r.AllocBytes))
+	}
+}
+
+// the runtime metrics counting allocations, read at the start and end
+// of each run
+var allocMetrics = []string{"/gc/heap/allocs:objects", "/gc/heap/allocs:bytes"}
+
+// measure starts a run of the named analysis.  Analyses call
+//
+//	defer measure("Name")()
+//
+// to report the run to the OnRun hook when they return.
+func measure(name string) func() {
+	f, _ := runHook.Load().(runFunc)
+	if f == nil {
+		return func() {}
+	}
+	before := readAllocs()
+	start := time.Now()
+	return func() {
+		r := Run{Analysis: name, Duration: time.Since(start)}
+		after := readAllocs()
+		r.Allocs = after[0] - before[0]
+		r.AllocBytes = after[1] - before[1]
+		f(r)
+	}
+}
+
+// readAllocs returns the number of heap objects, and bytes, allocated
+// so far by the process.
+func readAllocs() [2]uint64 {
+	s := make([]metrics.Sample, len(allocMetrics))
+	for i, n := range allocMetrics {
+		s[i].Name = n
+	}
+	metrics.Read(s)
+	var r [2]uint64
+	for i := range s {
+		if s[i].Value.Kind() == metrics.KindUint64 {
+			r[i] = s[i].Value.Uint64()
+		}
+	}
+	return r
+}
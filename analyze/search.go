@@ -0,0 +1,188 @@
+package analyze
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// An Encoding is a way text may be stored in an object.
+type Encoding int
+
+const (
+	UTF8  Encoding = 1 << iota // also matches ASCII and Latin-1 text in its ASCII range
+	UTF16                      // in the byte order of the dumped process
+)
+
+func (e Encoding) String() string {
+	switch e {
+	case UTF8:
+		return "utf-8"
+	case UTF16:
+		return "utf-16"
+	}
+	return "?"
+}
+
+// SearchOptions controls Search.
+type SearchOptions struct {
+	// Encodings is the set of encodings to decode object contents
+	// with.  0 means UTF8|UTF16.
+	Encodings Encoding
+	// IgnoreCase makes the pattern match regardless of case.
+	IgnoreCase bool
+	// AllObjects searches objects which contain pointers too.  By
+	// default only pointer-free objects, like the backing stores of
+	// strings and []byte, are searched.
+	AllObjects bool
+	// Limit is the maximum number of matches to return, 0 for no limit.
+	Limit int
+	// Context is the number of characters of text to show on each side
+	// of a match in its preview.  0 means 20.
+	Context int
+}
+
+// A SearchMatch is a piece of text found in an object.
+type SearchMatch struct {
+	Obj      read.ObjId
+	Offset   uint64 // byte offset of the match in the object
+	Encoding Encoding
+	Text     string // the matched text
+	// Preview is the matched text with some context, with unprintable
+	// characters replaced by '.'.
+	Preview string
+}
+
+// Search finds the text in the heap matching the regular expression
+// pattern.  It answers questions like "which object holds this
+// session token?": the objects found can be looked up with Referrers
+// and PathToRoot to see who owns them.
+func Search(d *read.Dump, pattern string, opts *SearchOptions) ([]SearchMatch, error) {
+	defer measure("Search")()
+	if opts == nil {
+		opts = &SearchOptions{}
+	}
+	if opts.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	enc := opts.Encodings
+	if enc == 0 {
+		enc = UTF8 | UTF16
+	}
+	ctx := opts.Context
+	if ctx == 0 {
+		ctx = 20
+	}
+
+	var r []SearchMatch
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		if !opts.AllObjects && hasPointers(d.Ft(x)) {
+			continue
+		}
+		b := d.Contents(x)
+		if enc&UTF8 != 0 {
+			r = searchText(r, re, x, UTF8, string(b), nil, ctx)
+		}
+		if enc&UTF16 != 0 && len(b) >= 2 {
+			s, off := decodeUTF16(d, b)
+			r = searchText(r, re, x, UTF16, s, off, ctx)
+		}
+		if opts.Limit > 0 && len(r) >= opts.Limit {
+			return r[:opts.Limit], nil
+		}
+	}
+	return r, nil
+}
+
+// hasPointers reports whether objects of type ft may contain pointers.
+func hasPointers(ft *read.FullType) bool {
+	if ft.Kind == read.TypeKindConservative {
+		return true
+	}
+	for _, f := range ft.Fields {
+		switch f.Kind {
+		case read.FieldKindPtr, read.FieldKindString, read.FieldKindSlice, read.FieldKindIface, read.FieldKindEface:
+			return true
+		}
+	}
+	return false
+}
+
+// searchText appends to r the matches of re in s, the text of x
+// decoded with encoding enc.  off maps byte offsets in s to byte
+// offsets in x; nil means they are the same.
+func searchText(r []SearchMatch, re *regexp.Regexp, x read.ObjId, enc Encoding, s string, off []uint64, ctx int) []SearchMatch {
+	for _, m := range re.FindAllStringIndex(s, -1) {
+		if m[0] == m[1] {
+			continue
+		}
+		o := uint64(m[0])
+		if off != nil {
+			o = off[m[0]]
+		}
+		r = append(r, SearchMatch{
+			Obj:      x,
+			Offset:   o,
+			Encoding: enc,
+			Text:     s[m[0]:m[1]],
+			Preview:  preview(s, m[0], m[1], ctx),
+		})
+	}
+	return r
+}
+
+// decodeUTF16 decodes b as UTF-16 in d's byte order.  It returns the
+// text as UTF-8, and the offset in b of each byte of the text.
+func decodeUTF16(d *read.Dump, b []byte) (string, []uint64) {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = d.Order.Uint16(b[2*i:])
+	}
+	var s []byte
+	var off []uint64
+	var buf [utf8.UTFMax]byte
+	for i := 0; i < len(u); i++ {
+		start := i
+		c := rune(u[i])
+		if utf16.IsSurrogate(c) && i+1 < len(u) {
+			if p := utf16.DecodeRune(c, rune(u[i+1])); p != unicode.ReplacementChar {
+				c = p
+				i++
+			}
+		}
+		n := utf8.EncodeRune(buf[:], c)
+		for j := 0; j < n; j++ {
+			off = append(off, uint64(2*start))
+		}
+		s = append(s, buf[:n]...)
+	}
+	off = append(off, uint64(2*len(u)))
+	return string(s), off
+}
+
+// preview returns s[i:j] with up to ctx runes of context on each side.
+func preview(s string, i, j, ctx int) string {
+	for n := 0; n < ctx && i > 0; n++ {
+		_, w := utf8.DecodeLastRuneInString(s[:i])
+		i -= w
+	}
+	for n := 0; n < ctx && j < len(s); n++ {
+		_, w := utf8.DecodeRuneInString(s[j:])
+		j += w
+	}
+	return strings.Map(func(c rune) rune {
+		if c == utf8.RuneError || !unicode.IsPrint(c) {
+			return '.'
+		}
+		return c
+	}, s[i:j])
+}
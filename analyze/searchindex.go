@@ -0,0 +1,317 @@
+package analyze
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// A HitKind is the kind of thing a SearchIndex finds.
+type HitKind int
+
+const (
+	HitType     HitKind = iota // a type
+	HitField                   // a field of a type
+	HitFunction                // a function on goroutine stacks
+	HitString                  // a string held by objects
+	numHitKinds
+)
+
+var hitKindNames = [...]string{
+	HitType:     "type",
+	HitField:    "field",
+	HitFunction: "function",
+	HitString:   "string",
+}
+
+func (k HitKind) String() string {
+	if k < 0 || k >= numHitKinds {
+		return fmt.Sprintf("HitKind(%d)", int(k))
+	}
+	return hitKindNames[k]
+}
+
+// A SearchHit is a result of SearchIndex.Search.
+type SearchHit struct {
+	Kind HitKind
+	// Text is what matched: the type, field or function name, or the
+	// string, up to 256 bytes of it.
+	Text string
+	// Type is the type, for HitType, the type with the field, for
+	// HitField, and the type of the first object holding the string,
+	// for HitString.
+	Type *read.FullType
+	// Field is the field holding the string, for HitString.
+	Field string
+	// Obj is the first object holding the string, for HitString, and
+	// ObjNil otherwise.
+	Obj read.ObjId
+	// Goroutines are the goroutines with the function on their stacks,
+	// for HitFunction.
+	Goroutines []*read.GoRoutine
+	// Count and Bytes are the number and total size of the objects of
+	// the type, for HitType and HitField.  For HitFunction, Count is
+	// the number of goroutines, and for HitString the number of
+	// fields holding the string.
+	Count int
+	Bytes uint64
+}
+
+// SearchIndexOptions controls NewSearchIndex.
+type SearchIndexOptions struct {
+	// Strings indexes the strings held by objects' fields too, which
+	// takes a pass over the heap and memory for each distinct string.
+	Strings bool
+}
+
+// A SearchIndex is an inverted index of the names in a dump, for
+// answering the queries typed into a search box: which types, fields
+// and functions, and optionally strings, have words starting with each
+// word of the query.
+type SearchIndex struct {
+	hits     []SearchHit
+	words    []string  // sorted
+	postings [][]int32 // for each word, the hits containing it, in order
+}
+
+// NewSearchIndex indexes the type names, field names and function
+// names on goroutine stacks of d.  Names are split into words at
+// punctuation and where case changes, so "net/http.(*conn).serve"
+// has the words net, http, conn and serve, and "readBuffer" has
+// readbuffer, read and buffer.
+func NewSearchIndex(d *read.Dump, opts *SearchIndexOptions) *SearchIndex {
+	defer measure("NewSearchIndex")()
+	if opts == nil {
+		opts = &SearchIndexOptions{}
+	}
+	count := make([]int, len(d.FTList))
+	bytes := make([]uint64, len(d.FTList))
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		count[d.Ft(x).Id]++
+		bytes[d.Ft(x).Id] += d.Size(x)
+	}
+	var hits []SearchHit
+	for _, ft := range d.FTList {
+		hits = append(hits, SearchHit{Kind: HitType, Text: ft.Name, Type: ft, Obj: read.ObjNil, Count: count[ft.Id], Bytes: bytes[ft.Id]})
+		seen := map[string]bool{}
+		for _, f := range ft.Fields {
+			if f.Name == "" || seen[f.Name] {
+				continue
+			}
+			seen[f.Name] = true
+			hits = append(hits, SearchHit{Kind: HitField, Text: f.Name, Type: ft, Obj: read.ObjNil, Count: count[ft.Id], Bytes: bytes[ft.Id]})
+		}
+	}
+
+	funcs := map[string]int{} // function name to index in hits
+	for _, g := range d.Goroutines {
+		seen := map[string]bool{}
+		for f := g.Bos; f != nil; f = f.Parent {
+			if f.Name == "" || seen[f.Name] {
+				continue
+			}
+			seen[f.Name] = true
+			i, ok := funcs[f.Name]
+			if !ok {
+				i = len(hits)
+				funcs[f.Name] = i
+				hits = append(hits, SearchHit{Kind: HitFunction, Text: f.Name, Obj: read.ObjNil})
+			}
+			hits[i].Goroutines = append(hits[i].Goroutines, g)
+			hits[i].Count++
+		}
+	}
+
+	if opts.Strings {
+		strs := map[string]int{} // string to index in hits
+		for i := 0; i < d.NumObjects(); i++ {
+			x := read.ObjId(i)
+			fields := d.Ft(x).Fields
+			var b []byte
+			for j := range fields {
+				if !isString(fields[j:]) {
+					continue
+				}
+				f := fields[j]
+				if b == nil {
+					// lookupString reads the string's object, reusing
+					// the buffer Contents returns.
+					b = append([]byte(nil), d.Contents(x)...)
+				}
+				if f.Offset+2*d.PtrSize > uint64(len(b)) {
+					continue
+				}
+				s, ok := lookupString(d, b[f.Offset:], maxKeyBytes)
+				if !ok || s == "" {
+					continue
+				}
+				k, ok := strs[s]
+				if !ok {
+					k = len(hits)
+					strs[s] = k
+					name := f.Name
+					if f.Kind == read.FieldKindPtr {
+						name = strings.TrimSuffix(strings.TrimSuffix(name, "str"), ".")
+					}
+					hits = append(hits, SearchHit{Kind: HitString, Text: s, Type: d.Ft(x), Field: name, Obj: x})
+				}
+				hits[k].Count++
+			}
+		}
+	}
+
+	post := map[string][]int32{}
+	for i, h := range hits {
+		for _, w := range searchWords(h.Text) {
+			p := post[w]
+			if len(p) > 0 && p[len(p)-1] == int32(i) {
+				continue
+			}
+			post[w] = append(p, int32(i))
+		}
+	}
+	s := &SearchIndex{hits: hits}
+	for w := range post {
+		s.words = append(s.words, w)
+	}
+	sort.Strings(s.words)
+	for _, w := range s.words {
+		s.postings = append(s.postings, post[w])
+	}
+	return s
+}
+
+// Search returns up to limit hits, or all of them if limit is 0,
+// having a word starting with each word of query, ignoring case.  Hits
+// whose whole text is the query come first, then those with each word
+// of the query as a whole word, then the rest; within each, types come
+// before fields, functions and strings, and larger types and more
+// common functions and strings first.
+func (s *SearchIndex) Search(query string, limit int) []SearchHit {
+	defer measure("SearchIndex.Search")()
+	q := strings.FieldsFunc(strings.ToLower(query), isWordSep)
+	if len(q) == 0 {
+		return nil
+	}
+	var found []int32
+	for i, w := range q {
+		var m []int32
+		j := sort.SearchStrings(s.words, w)
+		for ; j < len(s.words) && strings.HasPrefix(s.words[j], w); j++ {
+			m = append(m, s.postings[j]...)
+		}
+		m = sortedUniqueInt32(m)
+		if i > 0 {
+			m = intersectInt32(found, m)
+		}
+		found = m
+	}
+
+	rank := func(h *SearchHit) int {
+		if strings.EqualFold(h.Text, query) {
+			return 0
+		}
+		words := map[string]bool{}
+		for _, w := range searchWords(h.Text) {
+			words[w] = true
+		}
+		for _, w := range q {
+			if !words[w] {
+				return 2
+			}
+		}
+		return 1
+	}
+	ranks := map[int32]int{}
+	for _, i := range found {
+		ranks[i] = rank(&s.hits[i])
+	}
+	sort.Slice(found, func(i, j int) bool {
+		a, b := &s.hits[found[i]], &s.hits[found[j]]
+		if ra, rb := ranks[found[i]], ranks[found[j]]; ra != rb {
+			return ra < rb
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return found[i] < found[j]
+	})
+	if limit > 0 && len(found) > limit {
+		found = found[:limit]
+	}
+	r := make([]SearchHit, len(found))
+	for i, k := range found {
+		r[i] = s.hits[k]
+	}
+	return r
+}
+
+// isWordSep reports whether c separates words in names.
+func isWordSep(c rune) bool {
+	return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+}
+
+// searchWords returns the lower-cased words of s: its runs of letters
+// and digits, and, for those in mixed case, their parts, split before
+// each upper-case letter following a lower-case one and before the
+// last of a run of upper-case letters followed by a lower-case one, so
+// "HTTPServer" has the parts http and server.
+func searchWords(s string) []string {
+	var r []string
+	for _, w := range strings.FieldsFunc(s, isWordSep) {
+		r = append(r, strings.ToLower(w))
+		c := []rune(w)
+		start, parts := 0, 0
+		for i := 1; i < len(c); i++ {
+			if unicode.IsUpper(c[i]) && (unicode.IsLower(c[i-1]) || i+1 < len(c) && unicode.IsUpper(c[i-1]) && unicode.IsLower(c[i+1])) {
+				r = append(r, strings.ToLower(string(c[start:i])))
+				start = i
+				parts++
+			}
+		}
+		if parts > 0 {
+			r = append(r, strings.ToLower(string(c[start:])))
+		}
+	}
+	return r
+}
+
+// sortedUniqueInt32 sorts a and removes duplicates, in place.
+func sortedUniqueInt32(a []int32) []int32 {
+	sort.Slice(a, func(i, j int) bool { return a[i] < a[j] })
+	n := 0
+	for i, x := range a {
+		if i == 0 || x != a[n-1] {
+			a[n] = x
+			n++
+		}
+	}
+	return a[:n]
+}
+
+// intersectInt32 returns the elements of both a and b, which are sorted.
+func intersectInt32(a, b []int32) []int32 {
+	var r []int32
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case a[0] < b[0]:
+			a = a[1:]
+		case a[0] > b[0]:
+			b = b[1:]
+		default:
+			r = append(r, a[0])
+			a, b = a[1:], b[1:]
+		}
+	}
+	return r
+}
@@ -0,0 +1,25 @@
+package analyze
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchWords(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"main.Session", []string{"main", "session"}},
+		{"net/http.HTTPServer", []string{"net", "http", "httpserver", "http", "server"}},
+		{"getUserID", []string{"getuserid", "get", "user", "id"}},
+		{"map[string]*cache.entry", []string{"map", "string", "cache", "entry"}},
+		{"Ünïcode42", []string{"ünïcode42"}},
+		{"--", nil},
+	}
+	for _, tt := range tests {
+		if got := searchWords(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("searchWords(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
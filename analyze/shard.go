@@ -0,0 +1,289 @@
+package analyze
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// Huge heaps can be analyzed in shards: each machine loads the objects
+// of one window of addresses (see read.Options.Window), AnalyzeShard
+// computes partial results for it, and Merge combines them.  Counts,
+// histograms and type totals merge directly.  Reachability needs
+// rounds, since a path from a root may wander through several windows:
+// each round, every window whose Pending list is not empty is
+// loaded again and ExtendShard follows the pointers into it, until
+// the merged result is Converged.
+
+// An AddrSet is a set of addresses in a window, kept as a bitmap with
+// a bit for each Align bytes.
+type AddrSet struct {
+	Window read.AddrRange
+	Align  uint64
+	Bits   []uint64
+}
+
+// NewAddrSet returns an empty set of addresses in w, which are
+// multiples of align.
+func NewAddrSet(w read.AddrRange, align uint64) *AddrSet {
+	n := (w.Hi - w.Lo + align - 1) / align
+	return &AddrSet{w, align, make([]uint64, (n+63)/64)}
+}
+
+// Add adds addr, which must be in the set's window, to the set.
+func (s *AddrSet) Add(addr uint64) {
+	i := (addr - s.Window.Lo) / s.Align
+	s.Bits[i/64] |= 1 << (i % 64)
+}
+
+// Has reports whether addr is in the set.  A nil set is empty.
+func (s *AddrSet) Has(addr uint64) bool {
+	if s == nil || addr < s.Window.Lo || addr >= s.Window.Hi {
+		return false
+	}
+	i := (addr - s.Window.Lo) / s.Align
+	return s.Bits[i/64]&(1<<(i%64)) != 0
+}
+
+// Len returns the number of addresses in the set.
+func (s *AddrSet) Len() int {
+	n := 0
+	for _, w := range s.Bits {
+		for ; w != 0; w &= w - 1 {
+			n++
+		}
+	}
+	return n
+}
+
+// Union adds the addresses of t, a set of the same window and
+// alignment, to s.
+func (s *AddrSet) Union(t *AddrSet) error {
+	if s.Window != t.Window || s.Align != t.Align || len(s.Bits) != len(t.Bits) {
+		return fmt.Errorf("can't merge address sets of %v (align %d) and %v (align %d)", s.Window, s.Align, t.Window, t.Align)
+	}
+	for i, w := range t.Bits {
+		s.Bits[i] |= w
+	}
+	return nil
+}
+
+// A TypeCount is the number and total size of the objects of a type.
+type TypeCount struct {
+	Count int
+	Bytes uint64
+}
+
+// A ShardResult is the partial result of analyzing some windows of a
+// heap, which can be merged with those of other windows.
+type ShardResult struct {
+	// Windows are the windows whose objects are counted.  Results of
+	// ExtendShard count no objects, and have none.
+	Windows []read.AddrRange
+
+	Objects int
+	Bytes   uint64
+	Sizes   Histogram            // object sizes
+	Types   map[string]TypeCount // by type name
+	// Edges counts the pointers between objects of the same window,
+	// and CrossEdges the pointers from objects of one window into
+	// another, which may not all point to objects.
+	Edges, CrossEdges int
+
+	// Reached holds, for each window, the addresses of the objects
+	// found reachable from the roots so far, and ReachedObjects and
+	// ReachedBytes count them.
+	Reached        []*AddrSet
+	ReachedObjects int
+	ReachedBytes   uint64
+	// Frontier lists the pointers from reached objects into other
+	// windows which haven't been followed yet, and Followed those
+	// which have, both sorted.
+	Frontier []uint64
+	Followed []uint64
+}
+
+// AnalyzeShard computes the partial results for the window d was
+// loaded with, following the roots as far as the window goes.
+func AnalyzeShard(d *read.Dump) *ShardResult {
+	defer measure("AnalyzeShard")()
+	w := d.Window()
+	r := &ShardResult{Windows: []read.AddrRange{w}, Types: map[string]TypeCount{}}
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		r.Objects++
+		r.Bytes += d.Size(x)
+		r.Sizes.add(int(d.Size(x)))
+		t := r.Types[d.Ft(x).Name]
+		t.Count++
+		t.Bytes += d.Size(x)
+		r.Types[d.Ft(x).Name] = t
+		r.Edges += len(d.Edges(x))
+		r.CrossEdges += len(d.OutsidePointers(x))
+	}
+	var from []read.ObjId
+	for _, root := range d.Roots() {
+		from = append(from, root.Edge.To)
+	}
+	r.reach(d, from, nil)
+	return r
+}
+
+// ExtendShard follows the pointers from, which are into the window d
+// was loaded with, typically the merged result's Pending list for the
+// window, and returns the objects they newly reach.  done is what is
+// already known to be reached in the window, from the merged result's
+// ReachedIn, or nil.
+func ExtendShard(d *read.Dump, from []uint64, done *AddrSet) *ShardResult {
+	defer measure("ExtendShard")()
+	r := &ShardResult{Types: map[string]TypeCount{}}
+	var start []read.ObjId
+	for _, p := range from {
+		if x := d.FindObj(p); x != read.ObjNil {
+			start = append(start, x)
+		}
+	}
+	r.reach(d, start, done)
+	r.Followed = sortedUnique(append([]uint64(nil), from...))
+	return r
+}
+
+// reach marks the objects of d reachable from from which aren't in
+// done, and records the pointers out of the window it comes across.
+func (r *ShardResult) reach(d *read.Dump, from []read.ObjId, done *AddrSet) {
+	set := NewAddrSet(d.Window(), d.PtrSize) // objects are word-aligned
+	mark := func(x read.ObjId) bool {
+		a := d.Addr(x)
+		if !set.Window.Contains(a) || set.Has(a) || done.Has(a) {
+			return false
+		}
+		set.Add(a)
+		r.ReachedObjects++
+		r.ReachedBytes += d.Size(x)
+		return true
+	}
+	var q []read.ObjId
+	for _, x := range from {
+		if mark(x) {
+			q = append(q, x)
+		}
+	}
+	for len(q) > 0 {
+		x := q[len(q)-1]
+		q = q[:len(q)-1]
+		for _, e := range d.Edges(x) {
+			if mark(e.To) {
+				q = append(q, e.To)
+			}
+		}
+		r.Frontier = append(r.Frontier, d.OutsidePointers(x)...)
+	}
+	r.Reached = append(r.Reached, set)
+	r.Frontier = sortedUnique(r.Frontier)
+}
+
+// ReachedIn returns the addresses known to be reached in window w, or
+// nil if none are.
+func (r *ShardResult) ReachedIn(w read.AddrRange) *AddrSet {
+	for _, s := range r.Reached {
+		if s.Window == w {
+			return s
+		}
+	}
+	return nil
+}
+
+// Pending returns the pointers into window w still to be followed by
+// ExtendShard.
+func (r *ShardResult) Pending(w read.AddrRange) []uint64 {
+	var p []uint64
+	for _, a := range r.Frontier {
+		if w.Contains(a) {
+			p = append(p, a)
+		}
+	}
+	return p
+}
+
+// Converged reports whether every pointer between windows has been
+// followed, so that the reachability counts are final.  If the
+// windows merged don't cover the heap, pointers into the rest are
+// never followed.
+func (r *ShardResult) Converged() bool {
+	return len(r.Frontier) == 0
+}
+
+// Unreachable returns the number of objects not reached from any
+// root, once r has converged.
+func (r *ShardResult) Unreachable() int {
+	return r.Objects - r.ReachedObjects
+}
+
+// Merge combines the results of shards into one.  The windows of
+// results of AnalyzeShard must not overlap, since their objects would
+// be counted twice.  The inputs are not modified.
+func Merge(rs ...*ShardResult) (*ShardResult, error) {
+	defer measure("Merge")()
+	m := &ShardResult{Types: map[string]TypeCount{}}
+	var frontier, followed []uint64
+	for _, r := range rs {
+		for _, w := range r.Windows {
+			for _, v := range m.Windows {
+				if w.Lo < v.Hi && v.Lo < w.Hi {
+					return nil, fmt.Errorf("windows %v and %v overlap", v, w)
+				}
+			}
+			m.Windows = append(m.Windows, w)
+		}
+		m.Objects += r.Objects
+		m.Bytes += r.Bytes
+		m.Sizes.Merge(r.Sizes)
+		for name, t := range r.Types {
+			u := m.Types[name]
+			u.Count += t.Count
+			u.Bytes += t.Bytes
+			m.Types[name] = u
+		}
+		m.Edges += r.Edges
+		m.CrossEdges += r.CrossEdges
+		for _, s := range r.Reached {
+			if t := m.ReachedIn(s.Window); t != nil {
+				if err := t.Union(s); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			c := *s
+			c.Bits = append([]uint64(nil), s.Bits...)
+			m.Reached = append(m.Reached, &c)
+		}
+		m.ReachedObjects += r.ReachedObjects
+		m.ReachedBytes += r.ReachedBytes
+		frontier = append(frontier, r.Frontier...)
+		followed = append(followed, r.Followed...)
+	}
+	sort.Slice(m.Windows, func(i, j int) bool { return m.Windows[i].Lo < m.Windows[j].Lo })
+	sort.Slice(m.Reached, func(i, j int) bool { return m.Reached[i].Window.Lo < m.Reached[j].Window.Lo })
+	m.Followed = sortedUnique(followed)
+	for _, a := range sortedUnique(frontier) {
+		i := sort.Search(len(m.Followed), func(i int) bool { return m.Followed[i] >= a })
+		if i == len(m.Followed) || m.Followed[i] != a {
+			m.Frontier = append(m.Frontier, a)
+		}
+	}
+	return m, nil
+}
+
+// sortedUnique sorts a and removes duplicates, in place.
+func sortedUnique(a []uint64) []uint64 {
+	sort.Slice(a, func(i, j int) bool { return a[i] < a[j] })
+	n := 0
+	for i, x := range a {
+		if i == 0 || x != a[n-1] {
+			a[n] = x
+			n++
+		}
+	}
+	return a[:n]
+}
@@ -0,0 +1,106 @@
+package analyze
+
+import (
+	"math/bits"
+	"sort"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// SizeClasses are the sizes the runtime rounds small allocations up
+// to, as in the Go 1.4 and 1.5 runtimes.  Larger objects get their own
+// spans.
+var SizeClasses = []uint64{
+	8, 16, 32, 48, 64, 80, 96, 112, 128, 144, 160, 176, 192, 208, 224, 240,
+	256, 288, 320, 352, 384, 416, 448, 480, 512, 576, 640, 704, 768, 896,
+	1024, 1152, 1280, 1408, 1536, 1664, 2048, 2304, 2560, 2816, 3072, 3328,
+	4096, 4608, 5376, 6144, 6528, 6784, 6912, 8192, 9472, 9728, 10240, 10880,
+	12288, 13568, 14336, 16384, 18432, 19072, 20480, 21760, 24576, 27264,
+	28672, 32768,
+}
+
+// sizeClassFormats are the dump formats of the runtimes whose size
+// classes SizeClasses are.
+var sizeClassFormats = map[string]bool{"go1.4": true, "go1.5": true}
+
+// SizeClassesCover reports whether SizeClasses are the size classes of
+// the runtime which wrote d.  If not, SizeClassSizes still bins by
+// them, which may not match the allocator's spans.
+func SizeClassesCover(d *read.Dump) bool {
+	return sizeClassFormats[d.Compat().Format]
+}
+
+// A SizeBin counts the objects whose sizes are in [Min,Max].
+type SizeBin struct {
+	Min, Max uint64
+	Count    int
+	Bytes    uint64
+}
+
+// PowerOfTwoSizes counts objects by size in power-of-two bins: sizes
+// 0, 1, 2-3, 4-7, and so on.  It answers whether memory is in many
+// small objects or a few huge ones.  If ft is not nil, only objects of
+// that type are counted.  Empty bins at either end are left out.
+func PowerOfTwoSizes(d *read.Dump, ft *read.FullType) []SizeBin {
+	defer measure("PowerOfTwoSizes")()
+	var bins []SizeBin
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		if ft != nil && d.Ft(x) != ft {
+			continue
+		}
+		s := d.Size(x)
+		b := bits.Len64(s)
+		for len(bins) <= b {
+			k := len(bins)
+			var bin SizeBin
+			if k > 0 {
+				bin = SizeBin{Min: 1 << uint(k-1), Max: 1<<uint(k) - 1}
+			}
+			bins = append(bins, bin)
+		}
+		bins[b].Count++
+		bins[b].Bytes += s
+	}
+	return trimBins(bins)
+}
+
+// SizeClassSizes counts objects by the runtime size class holding
+// them, with a final bin for objects larger than the largest class.
+// Since the runtime rounds sizes up to their class, the bins show how
+// memory is spread over the allocator's spans.  If ft is not nil,
+// only objects of that type are counted.  Empty bins at either end
+// are left out.
+func SizeClassSizes(d *read.Dump, ft *read.FullType) []SizeBin {
+	defer measure("SizeClassSizes")()
+	bins := make([]SizeBin, len(SizeClasses)+1)
+	var prev uint64
+	for i, c := range SizeClasses {
+		bins[i] = SizeBin{Min: prev + 1, Max: c}
+		prev = c
+	}
+	bins[len(SizeClasses)] = SizeBin{Min: prev + 1, Max: ^uint64(0)}
+	bins[0].Min = 0
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		if ft != nil && d.Ft(x) != ft {
+			continue
+		}
+		s := d.Size(x)
+		b := sort.Search(len(SizeClasses), func(i int) bool { return SizeClasses[i] >= s })
+		bins[b].Count++
+		bins[b].Bytes += s
+	}
+	return trimBins(bins)
+}
+
+// trimBins removes the empty bins from each end of bins.
+func trimBins(bins []SizeBin) []SizeBin {
+	for len(bins) > 0 && bins[0].Count == 0 {
+		bins = bins[1:]
+	}
+	for len(bins) > 0 && bins[len(bins)-1].Count == 0 {
+		bins = bins[:len(bins)-1]
+	}
+	return bins
+}
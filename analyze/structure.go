@@ -0,0 +1,273 @@
+package analyze
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// A StructureKind is a shape of recursive data structure.
+type StructureKind int
+
+const (
+	List StructureKind = iota // singly or doubly linked list
+	Ring                      // linked list whose last element points to its first
+	Tree                      // tree, or DAG, with more than one link per node
+)
+
+var structureKindNames = [...]string{
+	List: "list",
+	Ring: "ring",
+	Tree: "tree",
+}
+
+func (k StructureKind) String() string {
+	if k < 0 || int(k) >= len(structureKindNames) {
+		return fmt.Sprintf("StructureKind(%d)", int(k))
+	}
+	return structureKindNames[k]
+}
+
+// A Structure is a linked structure made of objects of a single type.
+type Structure struct {
+	Kind   StructureKind
+	Type   *read.FullType
+	Fields []string   // the fields linking the nodes together
+	Head   read.ObjId // first element of a list, root of a tree
+	Length int        // number of nodes
+	Bytes  uint64     // total size of the nodes
+	Owner  string     // what the head hangs off, e.g. "main.queue.head"; "" if unreachable
+}
+
+// StructureOptions controls which structures FindStructures reports.
+type StructureOptions struct {
+	MinLength int // smallest structure to report; 0 means 16
+	Limit     int // maximum number of structures to report; 0 means no limit
+}
+
+// A link is a field of a type which points to another object of the same type.
+type link struct {
+	ft  *read.FullType
+	off uint64
+}
+
+// FindStructures finds lists, rings and trees: groups of objects of the
+// same type linked together through pointer fields.  The structures are
+// returned largest first.  opts may be nil.
+func FindStructures(d *read.Dump, opts *StructureOptions) []Structure {
+	defer measure("FindStructures")()
+	if opts == nil {
+		opts = &StructureOptions{}
+	}
+	minLength := opts.MinLength
+	if minLength == 0 {
+		minLength = 16
+	}
+
+	// Find all the pointers between objects of the same type, grouped
+	// by the field they are found in.
+	next := map[link]map[read.ObjId]read.ObjId{}
+	names := map[link]string{}
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		ft := d.Ft(x)
+		for _, e := range d.Edges(x) {
+			if e.To == x || e.ToOffset != 0 || d.Ft(e.To) != ft {
+				continue
+			}
+			k := link{ft, e.FromOffset}
+			m := next[k]
+			if m == nil {
+				m = map[read.ObjId]read.ObjId{}
+				next[k] = m
+				names[k] = e.FieldName
+			}
+			m[x] = e.To
+		}
+	}
+
+	// Group links by type.
+	byType := map[*read.FullType][]uint64{}
+	for k := range next {
+		byType[k.ft] = append(byType[k.ft], k.off)
+	}
+	var types []*read.FullType
+	for ft, offs := range byType {
+		types = append(types, ft)
+		sort.Sort(byOffset(offs))
+	}
+	sort.Sort(byTypeId(types))
+
+	var r []Structure
+	for _, ft := range types {
+		offs := byType[ft]
+		// Drop links which just point back along another link (the
+		// prev pointers of doubly linked lists, parent pointers in trees).
+		var fwd []uint64
+		for i, b := range offs {
+			inverse := false
+			for _, a := range offs[:i] {
+				if isInverse(next[link{ft, a}], next[link{ft, b}]) {
+					inverse = true
+					break
+				}
+			}
+			if !inverse {
+				fwd = append(fwd, b)
+			}
+		}
+		var fields []string
+		for _, off := range fwd {
+			fields = append(fields, names[link{ft, off}])
+		}
+		if len(fwd) == 1 {
+			r = append(r, lists(d, ft, fields, next[link{ft, fwd[0]}], minLength)...)
+			continue
+		}
+		var ms []map[read.ObjId]read.ObjId
+		for _, off := range fwd {
+			ms = append(ms, next[link{ft, off}])
+		}
+		r = append(r, trees(d, ft, fields, ms, minLength)...)
+	}
+
+	sort.Sort(byBytes(r))
+	if opts.Limit > 0 && len(r) > opts.Limit {
+		r = r[:opts.Limit]
+	}
+	if len(r) > 0 {
+		names := NewNamer(d)
+		for i := range r {
+			r[i].Owner = names.Name(r[i].Head)
+		}
+	}
+	return r
+}
+
+// isInverse reports whether most of the links in b point back along links in a.
+func isInverse(a, b map[read.ObjId]read.ObjId) bool {
+	n := 0
+	for x, y := range a {
+		if b[y] == x {
+			n++
+		}
+	}
+	return n*10 >= len(a)*9
+}
+
+// lists finds the lists and rings linked by next.
+func lists(d *read.Dump, ft *read.FullType, fields []string, next map[read.ObjId]read.ObjId, minLength int) []Structure {
+	hasPred := map[read.ObjId]bool{}
+	for _, y := range next {
+		hasPred[y] = true
+	}
+	var r []Structure
+	visited := map[read.ObjId]bool{}
+	walk := func(x read.ObjId) (int, uint64) {
+		n := 0
+		var bytes uint64
+		for y, ok := x, true; ok && !visited[y]; y, ok = next[y] {
+			visited[y] = true
+			n++
+			bytes += d.Size(y)
+		}
+		return n, bytes
+	}
+	keys := sortedKeys(next)
+	for _, x := range keys {
+		if hasPred[x] {
+			continue
+		}
+		if n, bytes := walk(x); n >= minLength {
+			r = append(r, Structure{List, ft, fields, x, n, bytes, ""})
+		}
+	}
+	// Anything left is part of a cycle.
+	for _, x := range keys {
+		if visited[x] {
+			continue
+		}
+		if n, bytes := walk(x); n >= minLength {
+			r = append(r, Structure{Ring, ft, fields, x, n, bytes, ""})
+		}
+	}
+	return r
+}
+
+// trees finds the trees whose nodes are linked to their children by the maps in children.
+func trees(d *read.Dump, ft *read.FullType, fields []string, children []map[read.ObjId]read.ObjId, minLength int) []Structure {
+	hasParent := map[read.ObjId]bool{}
+	nodes := map[read.ObjId]read.ObjId{}
+	for _, m := range children {
+		for x, y := range m {
+			hasParent[y] = true
+			nodes[x] = y
+		}
+	}
+	var r []Structure
+	visited := map[read.ObjId]bool{}
+	for _, x := range sortedKeys(nodes) {
+		if hasParent[x] {
+			continue
+		}
+		n := 0
+		var bytes uint64
+		q := []read.ObjId{x}
+		visited[x] = true
+		for len(q) > 0 {
+			y := q[len(q)-1]
+			q = q[:len(q)-1]
+			n++
+			bytes += d.Size(y)
+			for _, m := range children {
+				if z, ok := m[y]; ok && !visited[z] {
+					visited[z] = true
+					q = append(q, z)
+				}
+			}
+		}
+		if n >= minLength {
+			r = append(r, Structure{Tree, ft, fields, x, n, bytes, ""})
+		}
+	}
+	return r
+}
+
+func sortedKeys(m map[read.ObjId]read.ObjId) []read.ObjId {
+	var r []read.ObjId
+	for x := range m {
+		r = append(r, x)
+	}
+	sort.Sort(byObjId(r))
+	return r
+}
+
+type byObjId []read.ObjId
+
+func (a byObjId) Len() int           { return len(a) }
+func (a byObjId) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byObjId) Less(i, j int) bool { return a[i] < a[j] }
+
+type byOffset []uint64
+
+func (a byOffset) Len() int           { return len(a) }
+func (a byOffset) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byOffset) Less(i, j int) bool { return a[i] < a[j] }
+
+type byTypeId []*read.FullType
+
+func (a byTypeId) Len() int           { return len(a) }
+func (a byTypeId) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTypeId) Less(i, j int) bool { return a[i].Id < a[j].Id }
+
+type byBytes []Structure
+
+func (a byBytes) Len() int      { return len(a) }
+func (a byBytes) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byBytes) Less(i, j int) bool {
+	if a[i].Bytes != a[j].Bytes {
+		return a[i].Bytes > a[j].Bytes
+	}
+	return a[i].Head < a[j].Head
+}
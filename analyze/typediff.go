@@ -0,0 +1,217 @@
+package analyze
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// A TypeMatchKind says how a type of one dump was matched with a type
+// of another.
+type TypeMatchKind int
+
+const (
+	TypeSame     TypeMatchKind = iota // same name
+	TypeRenamed                       // same package, size and fields, different name
+	TypeReshaped                      // same package, similar fields
+	TypeAdded                         // only in the second dump
+	TypeRemoved                       // only in the first dump
+	numTypeMatchKinds
+)
+
+var typeMatchKindNames = [...]string{
+	TypeSame:     "same",
+	TypeRenamed:  "renamed",
+	TypeReshaped: "reshaped",
+	TypeAdded:    "added",
+	TypeRemoved:  "removed",
+}
+
+func (k TypeMatchKind) String() string {
+	if k < 0 || k >= numTypeMatchKinds {
+		return fmt.Sprintf("TypeMatchKind(%d)", int(k))
+	}
+	return typeMatchKindNames[k]
+}
+
+// MinLayoutSimilarity is the LayoutSimilarity above which types of the
+// same package, in dumps of different builds, are taken to be the
+// same type reshaped.
+const MinLayoutSimilarity = 0.6
+
+// A TypeChange compares the instances of a type in two dumps.
+type TypeChange struct {
+	// Name is the type's name in the second dump, or in the first if
+	// the type was removed.  OldName is its name in the first dump,
+	// if that differs.
+	Name, OldName string
+	Match         TypeMatchKind
+	// Similarity is the LayoutSimilarity of the matched types.
+	Similarity float64
+
+	CountA, CountB int
+	BytesA, BytesB uint64
+}
+
+// DiffTypes compares the live instances of each type in dump a with
+// those in dump b, largest change in bytes first.  Types are matched by
+// name, and then, so that dumps of different builds of a program
+// still compare, types of the same package and of similar layout are
+// matched as renamed or reshaped.  Only types which have instances
+// are compared.
+func DiffTypes(a, b *read.Dump) []TypeChange {
+	defer measure("DiffTypes")()
+	ta, tb := typeTotals(a), typeTotals(b)
+	var r []TypeChange
+	var goneA, newB []string
+	for name, x := range ta {
+		if y, ok := tb[name]; ok {
+			r = append(r, TypeChange{Name: name, Match: TypeSame, Similarity: 1,
+				CountA: x.count, CountB: y.count, BytesA: x.bytes, BytesB: y.bytes})
+		} else {
+			goneA = append(goneA, name)
+		}
+	}
+	for name := range tb {
+		if _, ok := ta[name]; !ok {
+			newB = append(newB, name)
+		}
+	}
+	// Match the largest types first, so they get the best candidates.
+	sort.Slice(goneA, func(i, j int) bool {
+		x, y := ta[goneA[i]], ta[goneA[j]]
+		if x.bytes != y.bytes {
+			return x.bytes > y.bytes
+		}
+		return goneA[i] < goneA[j]
+	})
+	sort.Strings(newB)
+	taken := map[string]bool{}
+	for _, name := range goneA {
+		x := ta[name]
+		pkg := PackageOf(name)
+		best, bestSim := "", 0.0
+		for _, cand := range newB {
+			if taken[cand] || pkg == "" || PackageOf(cand) != pkg {
+				continue
+			}
+			y := tb[cand]
+			sim := LayoutSimilarity(a, x.ft, b, y.ft)
+			// Between equally similar candidates, prefer one of the
+			// same size.
+			sameSize := best != "" && y.ft.Size == x.ft.Size && tb[best].ft.Size != x.ft.Size
+			if sim > bestSim || sim == bestSim && sameSize {
+				best, bestSim = cand, sim
+			}
+		}
+		if bestSim < MinLayoutSimilarity {
+			r = append(r, TypeChange{Name: name, Match: TypeRemoved, CountA: x.count, BytesA: x.bytes})
+			continue
+		}
+		taken[best] = true
+		y := tb[best]
+		k := TypeReshaped
+		if bestSim == 1 && x.ft.Size == y.ft.Size {
+			k = TypeRenamed
+		}
+		r = append(r, TypeChange{Name: best, OldName: name, Match: k, Similarity: bestSim,
+			CountA: x.count, CountB: y.count, BytesA: x.bytes, BytesB: y.bytes})
+	}
+	for _, name := range newB {
+		if !taken[name] {
+			y := tb[name]
+			r = append(r, TypeChange{Name: name, Match: TypeAdded, CountB: y.count, BytesB: y.bytes})
+		}
+	}
+	sort.Slice(r, func(i, j int) bool {
+		di, dj := byteChange(r[i]), byteChange(r[j])
+		if di != dj {
+			return di > dj
+		}
+		return r[i].Name < r[j].Name
+	})
+	return r
+}
+
+// byteChange returns the size of the change in c's bytes.
+func byteChange(c TypeChange) uint64 {
+	if c.BytesA > c.BytesB {
+		return c.BytesA - c.BytesB
+	}
+	return c.BytesB - c.BytesA
+}
+
+// A typeTotal is the instances of a type name in a dump, and the
+// FullType with that name having the most of them.
+type typeTotal struct {
+	ft    *read.FullType
+	count int
+	bytes uint64
+	most  int // instances of ft
+}
+
+// typeTotals totals the instances of each type name of d.  Several
+// FullTypes may share a name, such as the same type allocated in
+// arrays of different lengths.
+func typeTotals(d *read.Dump) map[string]*typeTotal {
+	counts := make([]int, len(d.FTList))
+	sizes := make([]uint64, len(d.FTList))
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		counts[d.Ft(x).Id]++
+		sizes[d.Ft(x).Id] += d.Size(x)
+	}
+	m := map[string]*typeTotal{}
+	for i, ft := range d.FTList {
+		if counts[i] == 0 {
+			continue
+		}
+		t := m[ft.Name]
+		if t == nil {
+			t = &typeTotal{}
+			m[ft.Name] = t
+		}
+		t.count += counts[i]
+		t.bytes += sizes[i]
+		if counts[i] > t.most {
+			t.ft, t.most = ft, counts[i]
+		}
+	}
+	return m
+}
+
+// LayoutSimilarity returns how alike the fields of type s of dump a
+// and type t of dump b are, from 0 for nothing in common to 1 for the
+// same fields: the number of fields with the same name and kind in
+// both, over the number of distinct fields in either.  Fields are
+// compared by name rather than offset, so that adding a field doesn't
+// make those after it differ; unnamed fields are compared by offset.
+func LayoutSimilarity(a *read.Dump, s *read.FullType, b *read.Dump, t *read.FullType) float64 {
+	fs, ft := a.TypeFields(s), b.TypeFields(t)
+	if len(fs) == 0 && len(ft) == 0 {
+		if s.Size == t.Size {
+			return 1
+		}
+		return 0
+	}
+	key := func(f read.Field) string {
+		if f.Name == "" {
+			return fmt.Sprintf("%d %d", f.Offset, f.Kind)
+		}
+		return fmt.Sprintf("%s %d", f.Name, f.Kind)
+	}
+	m := map[string]int{}
+	for _, f := range fs {
+		m[key(f)]++
+	}
+	common := 0
+	for _, f := range ft {
+		k := key(f)
+		if m[k] > 0 {
+			m[k]--
+			common++
+		}
+	}
+	return float64(common) / float64(len(fs)+len(ft)-common)
+}
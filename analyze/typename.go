@@ -0,0 +1,110 @@
+package analyze
+
+import (
+	"strings"
+)
+
+// Type names which are followed by brackets but aren't generic.
+var notGeneric = map[string]bool{
+	"map":        true,
+	"map.hdr":    true,
+	"map.bucket": true,
+}
+
+// SplitInstantiation splits the name of an instantiated generic type,
+// like "main.Pair[int,*main.T]", into the name of the generic type,
+// "main.Pair", and its type arguments, "int" and "*main.T".  It
+// reports false if name is not an instantiation.
+func SplitInstantiation(name string) (base string, args []string, ok bool) {
+	i := instantiation(name, 0)
+	if i <= 0 || !strings.HasSuffix(name, "]") || matchBracket(name, i) != len(name)-1 {
+		return "", nil, false
+	}
+	base = name[:i]
+	for j := 0; j < len(base); j++ {
+		if !isIdentByte(base[j]) && base[j] != '.' && base[j] != '/' {
+			// e.g. a slice of an instantiated type
+			return "", nil, false
+		}
+	}
+	depth := 0
+	start := i + 1
+	for j := i + 1; j < len(name)-1; j++ {
+		switch name[j] {
+		case '[', '(', '{':
+			depth++
+		case ']', ')', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(name[start:j]))
+				start = j + 1
+			}
+		}
+	}
+	args = append(args, strings.TrimSpace(name[start:len(name)-1]))
+	return base, args, true
+}
+
+// CollapseInstantiations replaces the type arguments of every
+// instantiated generic type in name with "...", so that, for example,
+// "[]*main.List[int]" and "[]*main.List[string]" both become
+// "[]*main.List[...]".
+func CollapseInstantiations(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); {
+		j := instantiation(name, i)
+		if j < 0 {
+			b.WriteString(name[i:])
+			break
+		}
+		k := matchBracket(name, j)
+		if k < 0 {
+			b.WriteString(name[i:])
+			break
+		}
+		b.WriteString(name[i:j])
+		b.WriteString("[...]")
+		i = k + 1
+	}
+	return b.String()
+}
+
+// instantiation returns the index of the first '[' at or after start
+// which opens a list of type arguments, or -1 if there is none.
+func instantiation(name string, start int) int {
+	for i := start; i < len(name); i++ {
+		if name[i] != '[' || i == 0 || !isIdentByte(name[i-1]) {
+			continue
+		}
+		j := i
+		for j > 0 && (isIdentByte(name[j-1]) || name[j-1] == '.' || name[j-1] == '/') {
+			j--
+		}
+		if !notGeneric[name[j:i]] {
+			return i
+		}
+	}
+	return -1
+}
+
+// matchBracket returns the index of the ']' matching the '[' at name[i], or -1.
+func matchBracket(name string, i int) int {
+	depth := 0
+	for j := i; j < len(name); j++ {
+		switch name[j] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
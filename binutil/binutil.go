@@ -0,0 +1,333 @@
+// Package binutil reads the symbol and type information of the
+// executables and shared objects that make up a Go process.
+//
+// Types, members, global variables and frame layouts are exported so
+// that packages like read can interpret raw memory; the way they are
+// extracted from the dwarf info is not.  A FuncTable reads the
+// runtime's function table, which binaries without dwarf info still
+// have.
+package binutil
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// DWARF constants
+const (
+	dwOpCallFrameCFA = 156
+	dwOpConsts       = 17
+	dwOpPlus         = 34
+	dwOpPlusUconst   = 35
+	dwOpAddr         = 3
+)
+
+// Encodings of base types.
+const (
+	AteBoolean      = 2
+	AteComplexFloat = 3 // complex64/complex128
+	AteFloat        = 4 // float32/float64
+	AteSigned       = 5 // int8/int16/int32/int64/int
+	AteUnsigned     = 7 // uint8/uint16/uint32/uint64/uint/uintptr
+)
+
+// A Binary is the dwarf information of a single executable or
+// shared object.  It is safe for concurrent use, so dumps of the same
+// program may share one; see Cache.
+type Binary struct {
+	Path string
+	// Base is the address at which the binary was loaded.  Addresses
+	// in the dwarf info are relative to it.
+	Base uint64
+
+	Dwarf *dwarf.Data
+	// Types maps dwarf offsets to the types at those offsets.
+	Types map[dwarf.Offset]Type
+
+	// Platform describes the process the binary was loaded into.
+	Platform Platform
+
+	loc, loclists, addr []byte // location list sections, read on demand
+	locOnce             sync.Once
+}
+
+// OpenOptions controls how a binary is read.
+type OpenOptions struct {
+	// NameRewrites maps the names of types in the dwarf info to the
+	// names the runtime uses for them.  If nil, DefaultNameRewrites is
+	// used.
+	NameRewrites []NameRewrite
+}
+
+// Open reads the dwarf information from the binary at path, which was
+// loaded at address base into a process running on platform p.
+func Open(path string, base uint64, p Platform) (*Binary, error) {
+	return OpenWithOptions(path, base, p, nil)
+}
+
+// OpenWithOptions is like Open, but takes options controlling how the
+// binary is read.  opts may be nil.
+func OpenWithOptions(path string, base uint64, p Platform, opts *OpenOptions) (*Binary, error) {
+	if opts == nil {
+		opts = &OpenOptions{}
+	}
+	rw := opts.NameRewrites
+	if rw == nil {
+		rw = DefaultNameRewrites
+	}
+	w, err := getDwarf(path)
+	if err != nil {
+		return nil, err
+	}
+	b := &Binary{Path: path, Base: base, Dwarf: w, Platform: p}
+	b.Types, err = typeMap(w, p, rw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return b, nil
+}
+
+// SortedTypes returns the binary's types in increasing dwarf offset
+// order, that is, in the order they appear in the dwarf info.  Use it
+// rather than ranging over Types when the order matters.
+func (b *Binary) SortedTypes() []Type {
+	offs := make([]dwarf.Offset, 0, len(b.Types))
+	for o := range b.Types {
+		offs = append(offs, o)
+	}
+	sort.Sort(byOffset(offs))
+	r := make([]Type, len(offs))
+	for i, o := range offs {
+		r[i] = b.Types[o]
+	}
+	return r
+}
+
+type byOffset []dwarf.Offset
+
+func (a byOffset) Len() int           { return len(a) }
+func (a byOffset) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byOffset) Less(i, j int) bool { return a[i] < a[j] }
+
+func getDwarf(execname string) (*dwarf.Data, error) {
+	e, err := elf.Open(execname)
+	if err == nil {
+		defer e.Close()
+		d, err := e.DWARF()
+		if err == nil {
+			return d, nil
+		}
+	}
+	m, err := macho.Open(execname)
+	if err == nil {
+		defer m.Close()
+		d, err := m.DWARF()
+		if err == nil {
+			return d, nil
+		}
+	}
+	p, err := pe.Open(execname)
+	if err == nil {
+		defer p.Close()
+		d, err := p.DWARF()
+		if err == nil {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("can't get dwarf info from executable %s: %v", execname, err)
+}
+
+// Globals returns the global variables of the binary.  The offset of
+// each is its address.
+func (b *Binary) Globals() []Member {
+	var roots []Member
+	t := b.Types
+	r := b.Dwarf.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if e == nil {
+			break
+		}
+		if e.Tag != dwarf.TagVariable {
+			continue
+		}
+		name := e.Val(dwarf.AttrName).(string)
+		typ := t[e.Val(dwarf.AttrType).(dwarf.Offset)]
+		locexpr := e.Val(dwarf.AttrLocation).([]uint8)
+		if len(locexpr) == 0 || locexpr[0] != dwOpAddr {
+			continue
+		}
+		// Addresses in shared objects are relative to their load address.
+		loc := b.Platform.Word(locexpr[1:]) + b.Base
+		if typ == nil {
+			// lots of non-Go global symbols hit here (rodata, type..gc,
+			// static function closures, ...)
+			//fmt.Printf("nontyped global %s %d\n", name, loc)
+			continue
+		}
+		roots = append(roots, Member{loc, name, typ})
+	}
+	return roots
+}
+
+// A FrameLayout describes the variables in a function's stack frame.
+type FrameLayout struct {
+	// offset is distance down from FP
+	Locals []Member
+	// offset is distance up from first arg slot
+	Args []Member
+	// LocalPCs and ArgPCs give, for each of Locals and Args, the pcs
+	// at which the variable is in that slot, for variables whose
+	// location changes as the function runs.  A nil entry means the
+	// variable is there throughout the function.
+	LocalPCs, ArgPCs [][]PCRange
+}
+
+// LocalLive reports whether Locals[i] is in its slot at pc.
+func (l FrameLayout) LocalLive(i int, pc uint64) bool {
+	return i >= len(l.LocalPCs) || livePCs(l.LocalPCs[i], pc)
+}
+
+// ArgLive reports whether Args[i] is in its slot at pc.
+func (l FrameLayout) ArgLive(i int, pc uint64) bool {
+	return i >= len(l.ArgPCs) || livePCs(l.ArgPCs[i], pc)
+}
+
+// FrameLayouts returns a map from function names to FrameLayouts describing that function's stack frame.
+func (b *Binary) FrameLayouts() map[string]FrameLayout {
+	m := map[string]FrameLayout{}
+	var l FrameLayout
+	t := b.Types
+	r := b.Dwarf.Reader()
+	var funcname string
+	var cu compUnit
+	for {
+		e, err := r.Next()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if e == nil {
+			break
+		}
+		switch e.Tag {
+		case dwarf.TagCompileUnit:
+			cu = newCompUnit(e)
+		case dwarf.TagSubprogram:
+			if funcname != "" {
+				m[funcname] = l
+				l = FrameLayout{}
+			}
+			funcname, _ = e.Val(dwarf.AttrName).(string)
+		case dwarf.TagVariable, dwarf.TagFormalParameter:
+			name, ok := e.Val(dwarf.AttrName).(string)
+			if !ok {
+				continue
+			}
+			off, ok := e.Val(dwarf.AttrType).(dwarf.Offset)
+			if !ok {
+				continue
+			}
+			typ := t[off]
+			offs, pcs := b.slots(e, cu)
+			for i, o := range offs {
+				if e.Tag == dwarf.TagVariable {
+					l.Locals = append(l.Locals, Member{uint64(-o), name, typ})
+					l.LocalPCs = append(l.LocalPCs, pcs[i])
+				} else {
+					l.Args = append(l.Args, Member{uint64(o), name, typ})
+					l.ArgPCs = append(l.ArgPCs, pcs[i])
+				}
+			}
+		}
+	}
+	if funcname != "" {
+		m[funcname] = l
+	}
+	return m
+}
+
+// slots returns the stack slots variable entry e occupies, as offsets
+// from the CFA, and the pcs at which it occupies each.  A variable
+// with a single location occupies it throughout, and has nil pcs.
+// cu is e's compilation unit.
+func (b *Binary) slots(e *dwarf.Entry, cu compUnit) ([]int64, [][]PCRange) {
+	// A variable whose location changes has a location list, given by
+	// its offset or, with DW_FORM_loclistx, by its index in the unit's
+	// table.  Registers aren't in the dump, so only the list's entries
+	// for stack slots matter.
+	var list []locEntry
+	switch loc := e.Val(dwarf.AttrLocation).(type) {
+	case []byte:
+		if o, ok := cfaOffset(loc); ok {
+			return []int64{o}, [][]PCRange{nil}
+		}
+		return nil, nil
+	case int64:
+		if cu.addrBase >= 0 {
+			list = b.locList5(loc, cu)
+		} else {
+			list = b.locList(loc, cu.base)
+		}
+	case uint64:
+		off, ok := b.loclistOffset(loc, cu)
+		if !ok {
+			return nil, nil
+		}
+		list = b.locList5(off, cu)
+	default:
+		return nil, nil
+	}
+	var offs []int64
+	var pcs [][]PCRange
+	for _, le := range list {
+		o, ok := cfaOffset(le.expr)
+		if !ok {
+			continue
+		}
+		i := 0
+		for i < len(offs) && offs[i] != o {
+			i++
+		}
+		if i == len(offs) {
+			offs = append(offs, o)
+			pcs = append(pcs, []PCRange{})
+		}
+		hi := le.hi
+		if hi != ^uint64(0) { // not a default location
+			hi += b.Base
+		}
+		pcs[i] = append(pcs[i], PCRange{le.lo + b.Base, hi})
+	}
+	return offs, pcs
+}
+
+func readUleb(b []byte) ([]byte, uint64) {
+	r := uint64(0)
+	s := uint(0)
+	for {
+		x := b[0]
+		b = b[1:]
+		r |= uint64(x&127) << s
+		if x&128 == 0 {
+			break
+		}
+		s += 7
+
+	}
+	return b, r
+}
+func readSleb(b []byte) ([]byte, int64) {
+	c, v := readUleb(b)
+	// sign extend
+	k := (len(b) - len(c)) * 7
+	return c, int64(v) << uint(64-k) >> uint(64-k)
+}
@@ -0,0 +1,57 @@
+package binutil
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+)
+
+// BuildID returns the build ID of the ELF binary at path: the GNU
+// build ID in hex if it has one, which is what symbol servers index
+// by, or else the Go build ID.
+func BuildID(path string) (string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var goID string
+	for _, s := range f.Sections {
+		if s.Type != elf.SHT_NOTE {
+			continue
+		}
+		data, err := s.Data()
+		if err != nil {
+			return "", err
+		}
+		for len(data) >= 12 {
+			namesz := f.ByteOrder.Uint32(data)
+			descsz := f.ByteOrder.Uint32(data[4:])
+			typ := f.ByteOrder.Uint32(data[8:])
+			data = data[12:]
+			nameEnd := align4(namesz)
+			descEnd := nameEnd + align4(descsz)
+			if uint64(descEnd) > uint64(len(data)) {
+				break
+			}
+			name := string(bytes.TrimRight(data[:namesz], "\x00"))
+			desc := data[nameEnd : nameEnd+descsz]
+			switch {
+			case name == "GNU" && typ == 3: // NT_GNU_BUILD_ID
+				return hex.EncodeToString(desc), nil
+			case name == "Go" && typ == 4: // Go's build ID note
+				goID = string(desc)
+			}
+			data = data[descEnd:]
+		}
+	}
+	if goID != "" {
+		return goID, nil
+	}
+	return "", fmt.Errorf("%s has no build ID", path)
+}
+
+func align4(n uint32) uint32 {
+	return (n + 3) &^ 3
+}
@@ -0,0 +1,53 @@
+package binutil
+
+import (
+	"fmt"
+	"sync"
+)
+
+// A Cache shares Binaries between readers of dumps from the same
+// program, so that a server loading several dumps at once reads each
+// executable's dwarf information only once.  It is safe for concurrent
+// use.
+type Cache struct {
+	mu sync.Mutex
+	m  map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	once sync.Once
+	b    *Binary
+	err  error
+}
+
+// NewCache returns an empty Cache.
+func NewCache() *Cache {
+	return &Cache{m: map[string]*cacheEntry{}}
+}
+
+// Open is like OpenWithOptions, but returns the Binary already opened
+// with the same arguments if there is one.  Concurrent calls with the
+// same arguments wait for one of them to read the binary.
+func (c *Cache) Open(path string, base uint64, p Platform, opts *OpenOptions) (*Binary, error) {
+	if opts == nil {
+		opts = &OpenOptions{}
+	}
+	rw := opts.NameRewrites
+	if rw == nil {
+		rw = DefaultNameRewrites
+	}
+	// The types read depend on the platform and the name rewrites as
+	// well as the binary.
+	key := fmt.Sprintf("%s\x00%x\x00%v\x00%d\x00%d\x00%q", path, base, p.Order, p.PtrSize, p.MaxAlign, rw)
+	c.mu.Lock()
+	e := c.m[key]
+	if e == nil {
+		e = &cacheEntry{}
+		c.m[key] = e
+	}
+	c.mu.Unlock()
+	e.once.Do(func() {
+		e.b, e.err = OpenWithOptions(path, base, p, &OpenOptions{NameRewrites: rw})
+	})
+	return e.b, e.err
+}
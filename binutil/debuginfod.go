@@ -0,0 +1,105 @@
+package binutil
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FetchByBuildID downloads the binary with the given build ID from a
+// debuginfod-style symbol server, which serves
+// server/buildid/ID/debuginfo and server/buildid/ID/executable.  The
+// debug info is preferred, since it has the dwarf info even when the
+// deployed executable was stripped.  Files are kept in cacheDir, and
+// found there on later calls without contacting the server.  Files,
+// cached or downloaded, are used only if BuildID gives them the
+// requested ID.  It returns the path of the file.
+func FetchByBuildID(server, id, cacheDir string) (string, error) {
+	if id == "" || strings.ContainsAny(id, "/\\.") {
+		return "", fmt.Errorf("bad build ID %q", id)
+	}
+	dir := filepath.Join(cacheDir, id)
+	var errs []string
+	for _, kind := range []string{"debuginfo", "executable"} {
+		path := filepath.Join(dir, kind)
+		if _, err := os.Stat(path); err == nil {
+			if err := checkBuildID(path, id); err == nil {
+				return path, nil
+			}
+			os.Remove(path) // fetch it again
+		}
+		url := strings.TrimRight(server, "/") + "/buildid/" + id + "/" + kind
+		if err := download(url, path, id); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		return path, nil
+	}
+	return "", fmt.Errorf("can't fetch build ID %s: %s", id, strings.Join(errs, "; "))
+}
+
+// checkBuildID returns an error unless the binary at path has build ID
+// id.
+func checkBuildID(path, id string) error {
+	got, err := BuildID(path)
+	if err != nil {
+		return err
+	}
+	if got != id {
+		return fmt.Errorf("has build ID %s", got)
+	}
+	return nil
+}
+
+// fetchClient is the client symbol servers are contacted with.  It
+// gives up on servers which hang, since fetches happen whenever a dump
+// is read with servers configured.
+var fetchClient = &http.Client{
+	Timeout: 10 * time.Minute, // for the whole download
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   30 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+}
+
+// download copies the contents of url to path, atomically, if it is
+// a binary with build ID id.
+func download(url, path, id string) error {
+	resp, err := fetchClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "fetch")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = checkBuildID(f.Name(), id)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("%s: %v", url, err)
+	}
+	return nil
+}
@@ -0,0 +1,356 @@
+package binutil
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+func joinNames(a, b string) string {
+	if a == "" {
+		return b
+	}
+	if b == "" {
+		return a
+	}
+	return fmt.Sprintf("%s.%s", a, b)
+}
+
+// A Type is a type described by the dwarf info.
+type Type interface {
+	// Name returns the name of this type
+	Name() string
+	// Size returns the size of this type in bytes
+	Size() uint64
+	// Members returns a list of fields within the type.
+	// The list is "flattened", so only base & ptr types remain. (TODO: and func, for now)
+	// We call this dynamically instead of building it for each type
+	// when the type is constructed, so we avoid constructing this list for
+	// crazy types that are never instantiated, e.g. [1000000000]byte.
+	// It is safe to call concurrently.
+	Members() []Member
+}
+type typeImpl struct {
+	name     string
+	size     uint64
+	flat     []Member // flattened members, computed lazily
+	flatOnce sync.Once
+}
+
+// A BaseType is a numeric or boolean type.
+type BaseType struct {
+	typeImpl
+	Encoding int64 // one of the Ate constants
+}
+
+// A Typedef is another name for a type.
+type Typedef struct {
+	typeImpl
+	Type Type
+}
+
+// A StructType is a struct, or a string or slice header.
+type StructType struct {
+	typeImpl
+	members  []Member
+	embedded []bool // whether each of members is an embedded field
+	isSlice  bool
+}
+
+// Fields returns the struct's fields, unflattened.
+func (t *StructType) Fields() []Member {
+	return t.members
+}
+
+// Embedded reports whether the i'th of Fields is an embedded field.
+func (t *StructType) Embedded(i int) bool {
+	return t.embedded[i]
+}
+
+// A Member is a named, typed piece of a type, variable or frame.
+type Member struct {
+	Offset uint64
+	Name   string
+	Type   Type
+}
+
+// A PtrType is a pointer.  Elem is nil for unsafe.Pointer.
+type PtrType struct {
+	typeImpl
+	Elem Type
+}
+
+// An ArrayType is a fixed-size array.
+type ArrayType struct {
+	typeImpl
+	Elem Type
+}
+
+// A FuncType is a func value, a pointer to a closure.
+type FuncType struct {
+	typeImpl
+	closure Type
+}
+
+// An IfaceType is a non-empty interface.
+type IfaceType struct {
+	typeImpl
+}
+
+// An EfaceType is an empty interface.
+type EfaceType struct {
+	typeImpl
+}
+
+func (t *typeImpl) Name() string {
+	return t.name
+}
+func (t *typeImpl) Size() uint64 {
+	return t.size
+}
+func (t *BaseType) Members() []Member {
+	t.flatOnce.Do(func() {
+		t.flat = append(t.flat, Member{0, "", t})
+	})
+	return t.flat
+}
+
+func (t *Typedef) Members() []Member {
+	return t.Type.Members()
+}
+func (t *Typedef) Size() uint64 {
+	return t.Type.Size()
+}
+
+func (t *PtrType) Members() []Member {
+	t.flatOnce.Do(func() {
+		t.flat = append(t.flat, Member{0, "", t})
+	})
+	return t.flat
+}
+
+// We treat a func as a *uintptr.  (It is actually a pointer to a closure, which is
+// in turn a pointer to code.)
+// TODO: how do we deduce types of closure parameters???  We could look at the code
+// pointer and figure it out somehow.
+func closureType(ptrSize uint64) Type {
+	codePtr := &BaseType{typeImpl{name: "<codeptr>", size: ptrSize}, AteUnsigned}
+	return &PtrType{typeImpl{name: "*<closure>", size: ptrSize}, codePtr}
+}
+
+func (t *FuncType) Members() []Member {
+	t.flatOnce.Do(func() {
+		t.flat = append(t.flat, Member{0, "", t.closure})
+	})
+	return t.flat
+}
+
+func (t *StructType) Members() []Member {
+	t.flatOnce.Do(func() {
+		// Iterate over members, flatten fields.
+		for _, m := range t.members {
+			for _, f := range m.Type.Members() {
+				t.flat = append(t.flat, Member{m.Offset + f.Offset, joinNames(m.Name, f.Name), f.Type})
+			}
+		}
+	})
+	return t.flat
+}
+
+func (t *ArrayType) Members() []Member {
+	t.flatOnce.Do(func() {
+		s := t.Elem.Size()
+		if s == 0 {
+			return
+		}
+		n := t.Size() / s
+		fields := t.Elem.Members()
+		for i := uint64(0); i < n; i++ {
+			name := fmt.Sprintf("[%d]", i)
+			for _, f := range fields {
+				t.flat = append(t.flat, Member{i*s + f.Offset, joinNames(name, f.Name), f.Type})
+			}
+		}
+	})
+	return t.flat
+}
+
+func (t *IfaceType) Members() []Member {
+	t.flatOnce.Do(func() {
+		t.flat = append(t.flat, Member{0, "", t})
+	})
+	return t.flat
+}
+
+func (t *EfaceType) Members() []Member {
+	t.flatOnce.Do(func() {
+		t.flat = append(t.flat, Member{0, "", t})
+	})
+	return t.flat
+}
+
+// attrGoEmbeddedField is the DW_AT_go_embedded_field attribute, with
+// which newer Go toolchains mark embedded fields.
+const attrGoEmbeddedField = dwarf.Attr(0x2903)
+
+// isEmbedded reports whether the struct member entry e, named name and
+// of type typ, is an embedded field.  Older toolchains don't mark
+// embedded fields, but name them after their type.
+func isEmbedded(e *dwarf.Entry, name string, typ Type) bool {
+	if v, ok := e.Val(attrGoEmbeddedField).(bool); ok {
+		return v
+	}
+	if typ == nil {
+		return false
+	}
+	tn := strings.TrimPrefix(typ.Name(), "*")
+	if i := strings.LastIndex(tn, "."); i >= 0 {
+		tn = tn[i+1:]
+	}
+	return tn != "" && tn == name
+}
+
+// load a map of all of the dwarf types
+func typeMap(w *dwarf.Data, p Platform, rw []NameRewrite) (map[dwarf.Offset]Type, error) {
+	t := make(map[dwarf.Offset]Type)
+	ptrSize := p.PtrSize
+	closure := closureType(ptrSize)
+
+	// pass 1: make a Type for all of the types in the file
+	r := w.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			break
+		}
+		if e.Val(dwarf.AttrName) == nil {
+			// Dwarf info from non-go sources might be missing a name
+			continue
+		}
+		name := rewriteName(rw, e.Val(dwarf.AttrName).(string))
+		switch e.Tag {
+		case dwarf.TagBaseType:
+			x := new(BaseType)
+			x.name = name
+			x.size = uint64(e.Val(dwarf.AttrByteSize).(int64))
+			x.Encoding = e.Val(dwarf.AttrEncoding).(int64)
+			t[e.Offset] = x
+		case dwarf.TagPointerType:
+			x := new(PtrType)
+			x.name = name
+			x.size = ptrSize
+			t[e.Offset] = x
+		case dwarf.TagStructType:
+			if name == "runtime.iface" {
+				x := new(IfaceType)
+				x.name = name
+				x.size = 2 * ptrSize
+				t[e.Offset] = x
+				continue
+			}
+			if name == "runtime.eface" {
+				x := new(EfaceType)
+				x.name = name
+				x.size = 2 * ptrSize
+				t[e.Offset] = x
+				continue
+			}
+			x := new(StructType)
+			x.name = name
+			x.size = uint64(e.Val(dwarf.AttrByteSize).(int64))
+			if len(x.name) >= 2 && x.name[:2] == "[]" {
+				// TODO: check array/len/cap
+				x.isSlice = true
+			}
+			t[e.Offset] = x
+		case dwarf.TagArrayType:
+			x := new(ArrayType)
+			x.name = name
+			x.size = uint64(e.Val(dwarf.AttrByteSize).(int64))
+			t[e.Offset] = x
+		case dwarf.TagTypedef:
+			x := new(Typedef)
+			x.name = name
+			t[e.Offset] = x
+		case dwarf.TagSubroutineType:
+			x := new(FuncType)
+			x.name = name
+			x.size = ptrSize
+			x.closure = closure
+			t[e.Offset] = x
+		}
+	}
+
+	// pass 2: fill in / link up the types
+	r = w.Reader()
+	var currentStruct *StructType
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			break
+		}
+		switch e.Tag {
+		case dwarf.TagTypedef:
+			t[e.Offset].(*Typedef).Type = t[e.Val(dwarf.AttrType).(dwarf.Offset)]
+			if t[e.Offset].(*Typedef).Type == nil {
+				return nil, fmt.Errorf("can't find referent for %s %d", t[e.Offset].(*Typedef).name, e.Val(dwarf.AttrType).(dwarf.Offset))
+			}
+		case dwarf.TagPointerType:
+			i := e.Val(dwarf.AttrType)
+			if i != nil {
+				t[e.Offset].(*PtrType).Elem = t[i.(dwarf.Offset)]
+			} else {
+				// The only nil cases are unsafe.Pointer and reflect.iword
+				if t[e.Offset].Name() != "unsafe.Pointer" &&
+					t[e.Offset].Name() != "crypto/x509._Ctype_CFTypeRef" {
+					return nil, fmt.Errorf("pointer without base pointer %s", t[e.Offset].Name())
+				}
+			}
+		case dwarf.TagArrayType:
+			t[e.Offset].(*ArrayType).Elem = t[e.Val(dwarf.AttrType).(dwarf.Offset)]
+		case dwarf.TagStructType:
+			name := e.Val(dwarf.AttrName).(string)
+			switch name {
+			case "runtime.iface":
+				currentStruct = nil
+			case "runtime.eface":
+				currentStruct = nil
+			default:
+				currentStruct = t[e.Offset].(*StructType)
+			}
+		case dwarf.TagMember:
+			if currentStruct == nil {
+				continue
+			}
+			name := e.Val(dwarf.AttrName).(string)
+			typ := t[e.Val(dwarf.AttrType).(dwarf.Offset)]
+			loc, _ := e.Val(dwarf.AttrDataMemberLoc).([]uint8)
+			var offset uint64
+			if c, ok := e.Val(dwarf.AttrDataMemberLoc).(int64); ok {
+				// Newer toolchains give the offset as a constant.
+				offset = uint64(c)
+			} else if len(loc) == 0 {
+				offset = 0
+			} else if loc[0] == dwOpPlusUconst {
+				loc, offset = readUleb(loc[1:])
+			} else if len(loc) >= 2 && loc[0] == dwOpConsts && loc[len(loc)-1] == dwOpPlus {
+				loc, offset = readUleb(loc[1 : len(loc)-1])
+				if len(loc) != 0 {
+					break
+				}
+			} else {
+				return nil, fmt.Errorf("bad dwarf location spec %#v", loc)
+			}
+			currentStruct.members = append(currentStruct.members, Member{offset, name, typ})
+			currentStruct.embedded = append(currentStruct.embedded, isEmbedded(e, name, typ))
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,69 @@
+package binutil
+
+import (
+	"debug/dwarf"
+	"log"
+	"sort"
+)
+
+// A Func is the code of a function in a binary.
+type Func struct {
+	Name       string
+	Entry, End uint64 // [Entry,End) is the function's code, at its load address
+}
+
+// Funcs returns the functions described by the binary's dwarf
+// info, sorted by entry point.
+func (b *Binary) Funcs() []Func {
+	var funcs []Func
+	r := b.Dwarf.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if e == nil {
+			break
+		}
+		if e.Tag != dwarf.TagSubprogram {
+			continue
+		}
+		name, ok := e.Val(dwarf.AttrName).(string)
+		if !ok {
+			continue
+		}
+		lo, ok := e.Val(dwarf.AttrLowpc).(uint64)
+		if !ok {
+			continue
+		}
+		var hi uint64
+		switch v := e.Val(dwarf.AttrHighpc).(type) {
+		case uint64:
+			hi = v
+		case int64:
+			// dwarf 4 gives the high pc as an offset from the low pc.
+			hi = lo + uint64(v)
+		default:
+			continue
+		}
+		funcs = append(funcs, Func{name, lo + b.Base, hi + b.Base})
+	}
+	sort.Sort(byEntry(funcs))
+	return funcs
+}
+
+// FindFunc returns the function in funcs, as returned by Funcs, whose
+// code contains pc.
+func FindFunc(funcs []Func, pc uint64) (Func, bool) {
+	i := sort.Search(len(funcs), func(i int) bool { return funcs[i].End > pc })
+	if i < len(funcs) && funcs[i].Entry <= pc {
+		return funcs[i], true
+	}
+	return Func{}, false
+}
+
+type byEntry []Func
+
+func (a byEntry) Len() int           { return len(a) }
+func (a byEntry) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byEntry) Less(i, j int) bool { return a[i].Entry < a[j].Entry }
@@ -0,0 +1,122 @@
+package binutil
+
+import (
+	"debug/dwarf"
+	"io"
+)
+
+// A SourceFrame is a logical frame of a call stack: a call to a
+// function as it appears in the source.  When calls are inlined,
+// one physical stack frame holds several logical ones.
+type SourceFrame struct {
+	Func    string
+	File    string
+	Line    int
+	Inlined bool // the call was inlined into the next frame out
+}
+
+// SourceFrames returns the logical frames executing at pc, innermost
+// first.  All but the last are calls inlined into the function
+// containing pc, as described by the dwarf info's inlined subroutine
+// records.  pc is at the binary's load address.  It returns nil if
+// the binary doesn't describe pc.
+func (b *Binary) SourceFrames(pc uint64) []SourceFrame {
+	if pc < b.Base {
+		return nil
+	}
+	pc -= b.Base
+	r := b.Dwarf.Reader()
+	cu, err := r.SeekPC(pc)
+	if err != nil {
+		return nil
+	}
+
+	// Find the subprogram containing pc, and the inlined subroutines
+	// nested in it which contain pc, outermost first.
+	var chain []*dwarf.Entry
+	for {
+		e, err := r.Next()
+		if err != nil || e == nil || e.Tag == dwarf.TagCompileUnit {
+			break
+		}
+		switch e.Tag {
+		case dwarf.TagSubprogram, dwarf.TagInlinedSubroutine:
+			if b.containsPC(e, pc) {
+				chain = append(chain, e)
+			} else if e.Children {
+				r.SkipChildren()
+			}
+		}
+	}
+	if len(chain) == 0 {
+		return nil
+	}
+
+	lr, err := b.Dwarf.LineReader(cu)
+	if err != nil || lr == nil {
+		return nil
+	}
+	var files []*dwarf.LineFile
+	var le dwarf.LineEntry
+	file, line := "", 0
+	if lr.SeekPC(pc, &le) == nil {
+		file, line = le.File.Name, le.Line
+	}
+	// Read the whole table so that all its files are known.
+	for lr.Next(&le) != io.EOF {
+	}
+	files = lr.Files()
+
+	var frames []SourceFrame
+	for i := len(chain) - 1; i >= 0; i-- {
+		e := chain[i]
+		frames = append(frames, SourceFrame{b.entryName(e), file, line, i > 0})
+		// The call site of an inlined call is the position in the
+		// function it was inlined into.
+		file, line = "", 0
+		if k, ok := e.Val(dwarf.AttrCallFile).(int64); ok && k >= 0 && int(k) < len(files) && files[k] != nil {
+			file = files[k].Name
+		}
+		if l, ok := e.Val(dwarf.AttrCallLine).(int64); ok {
+			line = int(l)
+		}
+	}
+	return frames
+}
+
+// containsPC reports whether the code of entry e contains pc, which
+// is relative to the binary's load address.
+func (b *Binary) containsPC(e *dwarf.Entry, pc uint64) bool {
+	ranges, err := b.Dwarf.Ranges(e)
+	if err != nil {
+		return false
+	}
+	for _, r := range ranges {
+		if r[0] <= pc && pc < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// entryName returns the name of the function of subprogram or inlined
+// subroutine entry e.  Inlined subroutines name their function through
+// their abstract origin.
+func (b *Binary) entryName(e *dwarf.Entry) string {
+	for i := 0; i < 4; i++ {
+		if name, ok := e.Val(dwarf.AttrName).(string); ok {
+			return name
+		}
+		off, ok := e.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset)
+		if !ok {
+			break
+		}
+		r := b.Dwarf.Reader()
+		r.Seek(off)
+		var err error
+		if e, err = r.Next(); err != nil || e == nil {
+			break
+		}
+	}
+	return "?"
+}
@@ -0,0 +1,276 @@
+package binutil
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+)
+
+// DW_OP_fbreg: an offset from the frame base, which Go sets to the CFA.
+const dwOpFbreg = 0x91
+
+// A PCRange is a range [Lo,Hi) of code addresses, at the binary's load
+// address.
+type PCRange struct {
+	Lo, Hi uint64
+}
+
+// livePCs reports whether pc is in one of ranges.  A nil list means
+// live everywhere.
+func livePCs(ranges []PCRange, pc uint64) bool {
+	if ranges == nil {
+		return true
+	}
+	for _, r := range ranges {
+		if r.Lo <= pc && pc < r.Hi {
+			return true
+		}
+	}
+	return false
+}
+
+// A compUnit holds what reading the location lists of a compilation
+// unit's variables needs to know about the unit.
+type compUnit struct {
+	base     uint64 // base address
+	addrBase int64  // offset of the unit's entries in .debug_addr; -1 before dwarf 5
+	// loclistsBase is the offset of the unit's table of location list
+	// offsets in .debug_loclists, or -1 if it has none.
+	loclistsBase int64
+}
+
+func newCompUnit(e *dwarf.Entry) compUnit {
+	cu := compUnit{addrBase: -1, loclistsBase: -1}
+	cu.base, _ = e.Val(dwarf.AttrLowpc).(uint64)
+	if a, ok := e.Val(dwarf.AttrAddrBase).(int64); ok {
+		cu.addrBase = a
+	}
+	if a, ok := e.Val(dwarf.AttrLoclistsBase).(int64); ok {
+		cu.loclistsBase = a
+	}
+	return cu
+}
+
+// readLoc reads the binary's .debug_loc and .debug_loclists sections,
+// which hold the location lists of variables whose location changes as
+// the function runs, in the formats of dwarf 2-4 and dwarf 5
+// respectively, and the .debug_addr address table dwarf 5 lists refer
+// to.  Any of them may be missing.
+func (b *Binary) readLoc() {
+	b.locOnce.Do(b.loadLoc)
+}
+
+func (b *Binary) loadLoc() {
+	names := []string{".debug_loc", ".debug_loclists", ".debug_addr"}
+	secs := []*[]byte{&b.loc, &b.loclists, &b.addr}
+	if f, err := elf.Open(b.Path); err == nil {
+		defer f.Close()
+		for i, name := range names {
+			if s := f.Section(name); s != nil {
+				*secs[i], _ = s.Data()
+			}
+		}
+	} else if f, err := macho.Open(b.Path); err == nil {
+		defer f.Close()
+		for i, name := range names {
+			if s := f.Section("__" + name[1:]); s != nil {
+				*secs[i], _ = s.Data()
+			}
+		}
+	} else if f, err := pe.Open(b.Path); err == nil {
+		defer f.Close()
+		for i, name := range names {
+			if s := f.Section(name); s != nil {
+				*secs[i], _ = s.Data()
+			}
+		}
+	}
+}
+
+// A locEntry is an entry of a location list: the variable is at the
+// location computed by expr while the pc is in [lo,hi).
+type locEntry struct {
+	lo, hi uint64
+	expr   []byte
+}
+
+// locList reads the location list at offset off in .debug_loc.  base
+// is the base address of the compilation unit, which the addresses in
+// the list are relative to.
+func (b *Binary) locList(off int64, base uint64) []locEntry {
+	b.readLoc()
+	sec := b.loc
+	p := b.Platform
+	w := int64(p.PtrSize)
+	max := ^uint64(0) >> (64 - 8*p.PtrSize)
+	var r []locEntry
+	for off >= 0 && off+2*w <= int64(len(sec)) {
+		lo, hi := p.Word(sec[off:]), p.Word(sec[off+w:])
+		off += 2 * w
+		if lo == 0 && hi == 0 {
+			break
+		}
+		if lo == max {
+			// base address selection entry
+			base = hi
+			continue
+		}
+		if off+2 > int64(len(sec)) {
+			break
+		}
+		n := int64(p.Order.Uint16(sec[off:]))
+		off += 2
+		if off+n > int64(len(sec)) {
+			break
+		}
+		r = append(r, locEntry{lo + base, hi + base, sec[off : off+n]})
+		off += n
+	}
+	return r
+}
+
+// Dwarf 5 location list entry kinds.
+const (
+	lleEndOfList       = 0
+	lleBaseAddressx    = 1
+	lleStartxEndx      = 2
+	lleStartxLength    = 3
+	lleOffsetPair      = 4
+	lleDefaultLocation = 5
+	lleBaseAddress     = 6
+	lleStartEnd        = 7
+	lleStartLength     = 8
+)
+
+// loclistOffset returns the offset in .debug_loclists of the location
+// list with index i in compilation unit cu's table, as an attribute of
+// form DW_FORM_loclistx refers to it.  The table, at the unit's
+// DW_AT_loclists_base, holds offsets relative to that base.
+func (b *Binary) loclistOffset(i uint64, cu compUnit) (int64, bool) {
+	b.readLoc()
+	sec := b.loclists
+	base := cu.loclistsBase
+	// The unit's header, of 12 bytes in 32-bit dwarf and 20 in 64-bit
+	// dwarf, ends with the number of entries in the table.
+	if base < 12 || base > int64(len(sec)) {
+		return 0, false
+	}
+	order := b.Platform.Order
+	n := uint64(order.Uint32(sec[base-4:]))
+	// Offsets are 4 bytes in 32-bit dwarf and 8 in 64-bit dwarf, whose
+	// headers start with 0xffffffff.
+	size := uint64(4)
+	if base >= 20 && order.Uint32(sec[base-20:]) == 0xffffffff {
+		size = 8
+	}
+	if i >= n || i >= (uint64(len(sec))-uint64(base))/size {
+		return 0, false
+	}
+	k := uint64(base) + i*size
+	var off uint64
+	if size == 4 {
+		off = uint64(order.Uint32(sec[k:]))
+	} else {
+		off = order.Uint64(sec[k:])
+	}
+	return base + int64(off), true
+}
+
+// locList5 reads the dwarf 5 location list at offset off in
+// .debug_loclists, for a variable of compilation unit cu.
+func (b *Binary) locList5(off int64, cu compUnit) []locEntry {
+	b.readLoc()
+	sec := b.loclists
+	p := b.Platform
+	w := int(p.PtrSize)
+	if off < 0 || off >= int64(len(sec)) {
+		return nil
+	}
+	buf := sec[off:]
+	ok := true
+	addr := func() uint64 {
+		if len(buf) < w {
+			ok = false
+			return 0
+		}
+		a := p.Word(buf)
+		buf = buf[w:]
+		return a
+	}
+	// addrx reads an index into the unit's part of .debug_addr.
+	addrx := func() uint64 {
+		var i uint64
+		buf, i = readUleb(buf)
+		k := uint64(cu.addrBase) + i*uint64(w)
+		if k+uint64(w) > uint64(len(b.addr)) {
+			ok = false
+			return 0
+		}
+		return p.Word(b.addr[k:])
+	}
+	uleb := func() uint64 {
+		var n uint64
+		buf, n = readUleb(buf)
+		return n
+	}
+	base := cu.base
+	var r []locEntry
+	for ok && len(buf) > 0 {
+		kind := buf[0]
+		buf = buf[1:]
+		var lo, hi uint64
+		switch kind {
+		case lleEndOfList:
+			return r
+		case lleBaseAddressx:
+			base = addrx()
+			continue
+		case lleBaseAddress:
+			base = addr()
+			continue
+		case lleStartxEndx:
+			lo = addrx()
+			hi = addrx()
+		case lleStartxLength:
+			lo = addrx()
+			hi = lo + uleb()
+		case lleOffsetPair:
+			lo = base + uleb()
+			hi = base + uleb()
+		case lleDefaultLocation:
+			lo, hi = 0, ^uint64(0)
+		case lleStartEnd:
+			lo = addr()
+			hi = addr()
+		case lleStartLength:
+			lo = addr()
+			hi = lo + uleb()
+		default:
+			return r
+		}
+		n := uleb()
+		if !ok || n > uint64(len(buf)) {
+			return r
+		}
+		r = append(r, locEntry{lo, hi, buf[:n]})
+		buf = buf[n:]
+	}
+	return r
+}
+
+// cfaOffset returns the offset from the CFA of the stack slot
+// described by location expression loc, if that is what it describes.
+func cfaOffset(loc []byte) (int64, bool) {
+	switch {
+	case len(loc) == 1 && loc[0] == dwOpCallFrameCFA:
+		return 0, true
+	case len(loc) >= 3 && loc[0] == dwOpCallFrameCFA && loc[1] == dwOpConsts && loc[len(loc)-1] == dwOpPlus:
+		rest, off := readSleb(loc[2 : len(loc)-1])
+		return off, len(rest) == 0
+	case len(loc) >= 2 && loc[0] == dwOpFbreg:
+		rest, off := readSleb(loc[1:])
+		return off, len(rest) == 0
+	}
+	return 0, false
+}
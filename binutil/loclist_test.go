@@ -0,0 +1,54 @@
+package binutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestLoclistOffset(t *testing.T) {
+	// A 32-bit dwarf 5 .debug_loclists unit with a table of two
+	// lists, each a single offset pair in a slot off the frame base.
+	le := binary.LittleEndian
+	var sec []byte
+	sec = le.AppendUint32(sec, 0) // unit length, patched below
+	sec = le.AppendUint16(sec, 5) // version
+	sec = append(sec, 8, 0)       // address and segment selector sizes
+	sec = le.AppendUint32(sec, 2) // offset entry count
+	base := len(sec)
+	sec = le.AppendUint32(sec, 8)
+	sec = le.AppendUint32(sec, 8+7)
+	sec = append(sec, lleOffsetPair, 0x10, 0x20, 2, dwOpFbreg, 0x10, lleEndOfList)
+	sec = append(sec, lleOffsetPair, 0x30, 0x40, 2, dwOpFbreg, 0x08, lleEndOfList)
+	le.PutUint32(sec, uint32(len(sec)-4))
+
+	p, err := NewPlatform(le, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Binary{Platform: p, loclists: sec}
+	cu := compUnit{base: 0x1000, addrBase: 0, loclistsBase: int64(base)}
+	tests := []struct {
+		lo, hi uint64
+		expr   []byte
+	}{
+		{0x1010, 0x1020, []byte{dwOpFbreg, 0x10}},
+		{0x1030, 0x1040, []byte{dwOpFbreg, 0x08}},
+	}
+	for i, tt := range tests {
+		off, ok := b.loclistOffset(uint64(i), cu)
+		if !ok {
+			t.Fatalf("loclistOffset(%d) failed", i)
+		}
+		list := b.locList5(off, cu)
+		if len(list) != 1 || list[0].lo != tt.lo || list[0].hi != tt.hi || !bytes.Equal(list[0].expr, tt.expr) {
+			t.Errorf("list %d = %+v, want [{%#x %#x %x}]", i, list, tt.lo, tt.hi, tt.expr)
+		}
+	}
+	if _, ok := b.loclistOffset(2, cu); ok {
+		t.Errorf("loclistOffset(2) succeeded past the end of the table")
+	}
+	if _, ok := b.loclistOffset(0, compUnit{loclistsBase: -1}); ok {
+		t.Errorf("loclistOffset succeeded without a loclists base")
+	}
+}
@@ -0,0 +1,82 @@
+package binutil
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Some type names in the dwarf info don't match the corresponding
+// type names in the binary, and the mismatches vary between Go
+// versions.  A NameRewrite maps between the two: each match of
+// Pattern in a dwarf name is replaced by Format, applied to the
+// pattern's submatches.
+type NameRewrite struct {
+	Pattern *regexp.Regexp
+	Format  string
+}
+
+// go14NameRewrites are the rewrites needed for go1.4 binaries, whose
+// dwarf info names map headers and buckets after the C++-like
+// templates in the runtime's dwarf generator.
+var go14NameRewrites = []NameRewrite{
+	{regexp.MustCompile(`hash<(.*),(.*)>`), "map.hdr[%s]%s"},
+	{regexp.MustCompile(`bucket<(.*),(.*)>`), "map.bucket[%s]%s"},
+	// TODO: hchan<>?
+}
+
+// DefaultNameRewrites are the rewrites used when none are given.
+var DefaultNameRewrites = go14NameRewrites
+
+// nameRewritePresets are the rewrites for each Go release whose heap
+// dumps can be read.  The linker named map types in the dwarf info the
+// same way from go1.4 to go1.7, and the compiler named them the same
+// way in the binary.
+var nameRewritePresets = map[string][]NameRewrite{
+	"go1.4": go14NameRewrites,
+	"go1.5": go14NameRewrites,
+	"go1.6": go14NameRewrites,
+	"go1.7": go14NameRewrites,
+}
+
+// NameRewritesFor returns the preset rewrites for binaries built by
+// the given Go release, e.g. "go1.4".  Callers may append their own.
+// It returns an error for releases it knows nothing about.
+func NameRewritesFor(version string) ([]NameRewrite, error) {
+	rw, ok := nameRewritePresets[version]
+	if !ok {
+		return nil, fmt.Errorf("no name rewrites known for %q", version)
+	}
+	return append([]NameRewrite(nil), rw...), nil
+}
+
+// rewriteName applies the rewrites rw to the dwarf name s, giving the
+// runtime's name for the type.  Each rewrite replaces the matches of
+// its pattern in what the rewrites before it left, once each, so a
+// rewrite whose output matches its pattern again still ends.
+func rewriteName(rw []NameRewrite, s string) string {
+	for _, a := range rw {
+		ms := a.Pattern.FindAllStringSubmatchIndex(s, -1)
+		if ms == nil {
+			continue
+		}
+		var b strings.Builder
+		end := 0
+		for _, k := range ms {
+			var i []interface{}
+			for j := 2; j < len(k); j += 2 {
+				if k[j] < 0 {
+					i = append(i, "")
+					continue
+				}
+				i = append(i, s[k[j]:k[j+1]])
+			}
+			b.WriteString(s[end:k[0]])
+			fmt.Fprintf(&b, a.Format, i...)
+			end = k[1]
+		}
+		b.WriteString(s[end:])
+		s = b.String()
+	}
+	return s
+}
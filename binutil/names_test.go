@@ -0,0 +1,49 @@
+package binutil
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestRewriteName(t *testing.T) {
+	tests := []struct {
+		rw   []NameRewrite
+		in   string
+		want string
+	}{
+		{go14NameRewrites, "hash<string,int>", "map.hdr[string]int"},
+		{go14NameRewrites, "*bucket<int,*main.T>", "*map.bucket[int]*main.T"},
+		{go14NameRewrites, "main.T", "main.T"},
+		// Output matching the pattern again is not rewritten again.
+		{[]NameRewrite{{regexp.MustCompile(`Foo`), "FooBar"}}, "Foo.Foo", "FooBar.FooBar"},
+		{[]NameRewrite{{regexp.MustCompile(`(x)`), "%s"}}, "axb", "axb"},
+	}
+	for _, tt := range tests {
+		if got := rewriteName(tt.rw, tt.in); got != tt.want {
+			t.Errorf("rewriteName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNameRewritesFor(t *testing.T) {
+	for _, v := range []string{"go1.4", "go1.5", "go1.6", "go1.7"} {
+		rw, err := NameRewritesFor(v)
+		if err != nil {
+			t.Errorf("NameRewritesFor(%q): %v", v, err)
+			continue
+		}
+		if got := rewriteName(rw, "hash<string,int>"); got != "map.hdr[string]int" {
+			t.Errorf("%s rewrites hash<string,int> to %q, want map.hdr[string]int", v, got)
+		}
+		// Changing the result doesn't change the preset.
+		rw[0] = NameRewrite{regexp.MustCompile(`x`), "y"}
+		if again, _ := NameRewritesFor(v); again[0].Pattern == rw[0].Pattern {
+			t.Errorf("changing the result of NameRewritesFor(%q) changed the preset", v)
+		}
+	}
+	for _, v := range []string{"go1.3", "go1.8", "1.5", ""} {
+		if _, err := NameRewritesFor(v); err == nil {
+			t.Errorf("NameRewritesFor(%q) succeeded", v)
+		}
+	}
+}
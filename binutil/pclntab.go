@@ -0,0 +1,324 @@
+package binutil
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// A FuncTable is the runtime's table of the functions in a binary,
+// read from its pclntab.  Binaries built without dwarf info, or with
+// it stripped, still have one, since the runtime walks stacks with it.
+// For each function and pc it gives the stack maps: which words of the
+// function's locals, and of its arguments, hold live pointers.
+//
+// Only the table format of Go 1.2 to 1.15 is understood, which is the
+// one the runtimes writing heap dumps used.
+type FuncTable struct {
+	Path string
+	// Base is the address at which the binary was loaded.  Addresses
+	// in the table are relative to it.
+	Base     uint64
+	Platform Platform
+
+	tab      []byte    // the pclntab
+	quantum  uint64    // pc quantum, the unit of pc deltas in pc-value tables
+	nfunc    int       // entries in the function table
+	sections []section // the binary's loaded data, where stack maps are
+}
+
+// A section is the contents of a loaded section of a binary.
+type section struct {
+	addr uint64
+	data []byte
+}
+
+// The pclntab's magic number, for the table format of Go 1.2 to 1.15.
+const pclntabMagic = 0xfffffffb
+
+// Indexes of the funcdata a function's stack maps are at.
+const (
+	funcdataArgsPointerMaps   = 0
+	funcdataLocalsPointerMaps = 1
+)
+
+// OpenFuncTable reads the function table of the binary at path, which
+// was loaded at address base into a process running on platform p.
+func OpenFuncTable(path string, base uint64, p Platform) (*FuncTable, error) {
+	tab, sections, err := readPclntab(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &FuncTable{Path: path, Base: base, Platform: p, tab: tab, sections: sections}
+	ptr := p.PtrSize
+	if uint64(len(tab)) < 8+ptr || p.Order.Uint32(tab) != pclntabMagic || tab[4] != 0 || tab[5] != 0 {
+		return nil, fmt.Errorf("%s: function table is not in the format of Go 1.2 to 1.15", path)
+	}
+	if uint64(tab[7]) != ptr {
+		return nil, fmt.Errorf("%s: function table has %d-byte pointers, the dump %d-byte ones", path, tab[7], ptr)
+	}
+	t.quantum = uint64(tab[6])
+	n := p.Word(tab[8:])
+	if n > uint64(len(tab))/(2*ptr) {
+		return nil, fmt.Errorf("%s: function table has %d functions, more than fit", path, n)
+	}
+	t.nfunc = int(n)
+	return t, nil
+}
+
+// readPclntab returns the pclntab of the binary at path, and the
+// contents of its loaded sections.
+func readPclntab(path string) ([]byte, []section, error) {
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		var tab []byte
+		var secs []section
+		for _, s := range f.Sections {
+			if s.Flags&elf.SHF_ALLOC == 0 || s.Type == elf.SHT_NOBITS || s.Flags&elf.SHF_EXECINSTR != 0 {
+				continue
+			}
+			data, err := s.Data()
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", path, err)
+			}
+			if s.Name == ".gopclntab" {
+				tab = data
+			}
+			secs = append(secs, section{s.Addr, data})
+		}
+		if tab == nil {
+			return nil, nil, fmt.Errorf("%s has no .gopclntab section", path)
+		}
+		return tab, sortSections(secs), nil
+	}
+	if f, err := macho.Open(path); err == nil {
+		defer f.Close()
+		var tab []byte
+		var secs []section
+		for _, s := range f.Sections {
+			if s.Seg == "__TEXT" && s.Name == "__text" || s.Flags&0xff == 1 { // S_ZEROFILL
+				continue
+			}
+			data, err := s.Data()
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", path, err)
+			}
+			if s.Name == "__gopclntab" {
+				tab = data
+			}
+			secs = append(secs, section{s.Addr, data})
+		}
+		if tab == nil {
+			return nil, nil, fmt.Errorf("%s has no __gopclntab section", path)
+		}
+		return tab, sortSections(secs), nil
+	}
+	if f, err := pe.Open(path); err == nil {
+		defer f.Close()
+		var imageBase uint64
+		switch h := f.OptionalHeader.(type) {
+		case *pe.OptionalHeader32:
+			imageBase = uint64(h.ImageBase)
+		case *pe.OptionalHeader64:
+			imageBase = h.ImageBase
+		}
+		// The pclntab is part of a data section, between two symbols.
+		var start, end *pe.Symbol
+		for _, s := range f.Symbols {
+			switch s.Name {
+			case "runtime.pclntab":
+				start = s
+			case "runtime.epclntab":
+				end = s
+			}
+		}
+		if start == nil || end == nil || start.SectionNumber != end.SectionNumber || start.SectionNumber < 1 {
+			return nil, nil, fmt.Errorf("%s has no runtime.pclntab symbols", path)
+		}
+		var tab []byte
+		var secs []section
+		for i, s := range f.Sections {
+			data, err := s.Data()
+			if err != nil {
+				continue // uninitialized data
+			}
+			if i+1 == int(start.SectionNumber) && start.Value <= end.Value && uint64(end.Value) <= uint64(len(data)) {
+				tab = data[start.Value:end.Value]
+			}
+			secs = append(secs, section{imageBase + uint64(s.VirtualAddress), data})
+		}
+		if tab == nil {
+			return nil, nil, fmt.Errorf("%s: runtime.pclntab symbols are outside their section", path)
+		}
+		return tab, sortSections(secs), nil
+	}
+	return nil, nil, fmt.Errorf("%s is not an ELF, Mach-O or PE binary", path)
+}
+
+func sortSections(s []section) []section {
+	sort.Slice(s, func(i, j int) bool { return s[i].addr < s[j].addr })
+	return s
+}
+
+// read returns the n bytes at address addr, relative to Base, of the
+// binary's loaded sections, or nil if they aren't in one.
+func (t *FuncTable) read(addr, n uint64) []byte {
+	i := sort.Search(len(t.sections), func(i int) bool { return t.sections[i].addr > addr }) - 1
+	if i < 0 {
+		return nil
+	}
+	s := t.sections[i]
+	if addr-s.addr+n > uint64(len(s.data)) {
+		return nil
+	}
+	return s.data[addr-s.addr : addr-s.addr+n]
+}
+
+// A FuncFrame describes the frame of a function at one pc, as the
+// function table gives it.
+type FuncFrame struct {
+	Func  string // the function's name
+	Entry uint64 // its entry point, at its load address
+	// ArgSize is the size in bytes of the function's arguments and
+	// results, or -1 if the table doesn't know it, as for some
+	// assembly functions.
+	ArgSize int64
+	// Locals and Args are the stack maps in force at the pc: which
+	// words of the function's locals, starting with the lowest
+	// addressed, and of its arguments, hold live pointers.  Either may
+	// be empty if the function has no maps.
+	Locals, Args StackMap
+}
+
+// A StackMap is a bitmap of the words of part of a frame, as the
+// compiler writes them.  Compilers before Go 1.5 gave each word two
+// bits, and later ones one.
+type StackMap struct {
+	N    int    // number of bits
+	Bits []byte // bit i is Bits[i/8]>>(i%8)&1
+}
+
+// Bit returns bit i of m.
+func (m StackMap) Bit(i int) bool {
+	return m.Bits[i/8]>>uint(i%8)&1 != 0
+}
+
+// Frame returns the frame of the function whose code contains pc, an
+// address in the process, and the stack maps in force there.  Which
+// of a function's stack maps is in force at each pc is given by its
+// pc-value table number index: 1 for Go 1.4, 0 from Go 1.5 on.
+func (t *FuncTable) Frame(pc uint64, index int) (FuncFrame, bool) {
+	ptr := t.Platform.PtrSize
+	if pc < t.Base {
+		return FuncFrame{}, false
+	}
+	pc -= t.Base
+	ftab := t.tab[8+ptr:]
+	entry := func(i int) uint64 { return t.Platform.Word(ftab[uint64(i)*2*ptr:]) }
+	if t.nfunc == 0 || uint64(len(ftab)) < uint64(t.nfunc)*2*ptr+ptr || pc < entry(0) || pc >= entry(t.nfunc) {
+		return FuncFrame{}, false
+	}
+	i := sort.Search(t.nfunc, func(i int) bool { return entry(i+1) > pc })
+	off := t.Platform.Word(ftab[uint64(i)*2*ptr+ptr:])
+
+	// The function's _func record: its entry, then nameoff, args,
+	// frame, pcsp, pcfile, pcln, npcdata and nfuncdata, all int32s,
+	// then the offsets of its pc-value tables and, aligned to a
+	// word, the addresses of its funcdata.
+	if off+ptr+32 > uint64(len(t.tab)) {
+		return FuncFrame{}, false
+	}
+	f := t.tab[off:]
+	field := func(k uint64) uint32 { return t.Platform.Order.Uint32(f[ptr+4*k:]) }
+	fr := FuncFrame{Entry: t.Platform.Word(f) + t.Base, ArgSize: int64(int32(field(1)))}
+	if fr.ArgSize < 0 {
+		fr.ArgSize = -1
+	}
+	if nameoff := uint64(field(0)); nameoff < uint64(len(t.tab)) {
+		name := t.tab[nameoff:]
+		if j := bytes.IndexByte(name, 0); j >= 0 {
+			fr.Func = string(name[:j])
+		}
+	}
+	npcdata, nfuncdata := uint64(field(6)), uint64(field(7))
+	fdoff := ptr + 32 + 4*npcdata
+	fdoff = (fdoff + ptr - 1) &^ (ptr - 1)
+	if off+fdoff+nfuncdata*ptr > uint64(len(t.tab)) {
+		return fr, true
+	}
+
+	// Like the runtime, take the first stack map if the pc has none,
+	// which happens only in the function's prologue.
+	k := int64(-1)
+	if uint64(index) < npcdata {
+		k = t.pcvalue(uint64(field(8+uint64(index))), fr.Entry-t.Base, pc)
+	}
+	if k < 0 {
+		k = 0
+	}
+	stackMap := func(j uint64) StackMap {
+		if j >= nfuncdata {
+			return StackMap{}
+		}
+		addr := t.Platform.Word(f[fdoff+j*ptr:])
+		if addr == 0 {
+			return StackMap{}
+		}
+		hdr := t.read(addr, 8)
+		if hdr == nil {
+			return StackMap{}
+		}
+		n, nbit := uint64(t.Platform.Order.Uint32(hdr)), uint64(t.Platform.Order.Uint32(hdr[4:]))
+		if uint64(k) >= n {
+			return StackMap{}
+		}
+		// Each bitmap is padded to 32 bits, as the runtimes reading
+		// them expect.
+		stride := (nbit + 31) / 32 * 4
+		b := t.read(addr+8+uint64(k)*stride, (nbit+7)/8)
+		if b == nil {
+			return StackMap{}
+		}
+		return StackMap{int(nbit), b}
+	}
+	fr.Args = stackMap(funcdataArgsPointerMaps)
+	fr.Locals = stackMap(funcdataLocalsPointerMaps)
+	return fr, true
+}
+
+// pcvalue returns the value at pc of the pc-value table at offset off
+// of the pclntab, for a function with the given entry, or -1 if the
+// table doesn't cover pc.  Entries and pcs are relative to Base.
+func (t *FuncTable) pcvalue(off, entry, pc uint64) int64 {
+	if off == 0 || off >= uint64(len(t.tab)) {
+		return -1
+	}
+	p := t.tab[off:]
+	val := int64(-1)
+	cur := entry
+	for first := true; ; first = false {
+		uv, n := binary.Uvarint(p)
+		if n <= 0 || uv == 0 && !first {
+			return -1
+		}
+		p = p[n:]
+		if uv&1 != 0 {
+			val += int64(^(uv >> 1))
+		} else {
+			val += int64(uv >> 1)
+		}
+		dpc, n := binary.Uvarint(p)
+		if n <= 0 {
+			return -1
+		}
+		p = p[n:]
+		cur += dpc * t.quantum
+		if pc < cur {
+			return val
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package binutil
+
+import "testing"
+
+func TestPcvalue(t *testing.T) {
+	// A pc-value table at offset 1 of the pclntab: 0 for the first
+	// 4 quanta of the function, 8 for the next 6, then 4 for 2.  Value
+	// deltas are zigzag encoded, so +1 is 2, +8 is 16 and -4 is 7.
+	tab := []byte{0, 2, 4, 16, 6, 7, 2, 0}
+	tests := []struct {
+		quantum uint64
+		pc      uint64
+		want    int64
+	}{
+		{1, 0x100, 0},
+		{1, 0x103, 0},
+		{1, 0x104, 8},
+		{1, 0x109, 8},
+		{1, 0x10a, 4},
+		{1, 0x10b, 4},
+		{1, 0x10c, -1}, // past the end of the table
+		{4, 0x10c, 0},
+		{4, 0x110, 8},
+		{4, 0x128, 4},
+		{4, 0x130, -1},
+	}
+	for _, tt := range tests {
+		ft := &FuncTable{tab: tab, quantum: tt.quantum}
+		if got := ft.pcvalue(1, 0x100, tt.pc); got != tt.want {
+			t.Errorf("quantum %d: pcvalue(%#x) = %d, want %d", tt.quantum, tt.pc, got, tt.want)
+		}
+	}
+	ft := &FuncTable{tab: tab, quantum: 1}
+	if got := ft.pcvalue(0, 0x100, 0x100); got != -1 {
+		t.Errorf("pcvalue at offset 0 = %d, want -1", got)
+	}
+	if got := ft.pcvalue(uint64(len(tab)), 0x100, 0x100); got != -1 {
+		t.Errorf("pcvalue past the pclntab = %d, want -1", got)
+	}
+}
@@ -0,0 +1,78 @@
+package binutil
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// A Platform describes how the dumped process laid out memory.  Code
+// which decodes raw memory, in this package and others, should make
+// its layout decisions from a Platform rather than assume a 64-bit
+// little-endian machine.
+type Platform struct {
+	Order   binary.ByteOrder
+	PtrSize uint64 // in bytes; also the size of int, uint and uintptr
+	// MaxAlign is the largest alignment of any type.  It is
+	// PtrSize, except on amd64p32, where 64-bit values are 8-aligned.
+	MaxAlign uint64
+
+	word func([]byte) uint64 // decodes a word; chosen by NewPlatform
+}
+
+// NewPlatform returns the Platform with the given byte order and
+// pointer size, and the usual alignment for that pointer size.  It
+// returns an error if the pointer size is neither 4 nor 8.  Platforms
+// should be made by NewPlatform, which checks the pointer size once so
+// that decoding words needn't.
+func NewPlatform(order binary.ByteOrder, ptrSize uint64) (Platform, error) {
+	p := Platform{Order: order, PtrSize: ptrSize, MaxAlign: ptrSize}
+	switch ptrSize {
+	case 4:
+		p.word = func(b []byte) uint64 { return uint64(order.Uint32(b)) }
+	case 8:
+		p.word = order.Uint64
+	default:
+		return Platform{}, fmt.Errorf("unsupported pointer size %d", ptrSize)
+	}
+	return p, nil
+}
+
+// Word decodes the pointer-sized word at the start of b.  Pointers,
+// ints and the lengths and capacities of strings and slices are
+// all words.  A Platform not made by NewPlatform, such as the zero
+// Platform, decodes with its Order and PtrSize, taking a nil Order as
+// little-endian and a PtrSize other than 4 as 8.
+func (p Platform) Word(b []byte) uint64 {
+	if p.word != nil {
+		return p.word(b)
+	}
+	order := p.Order
+	if order == nil {
+		order = binary.LittleEndian
+	}
+	if p.PtrSize == 4 {
+		return uint64(order.Uint32(b))
+	}
+	return order.Uint64(b)
+}
+
+// StringHeader decodes the string header at the start of b.
+func (p Platform) StringHeader(b []byte) (ptr, n uint64) {
+	return p.Word(b), p.Word(b[p.PtrSize:])
+}
+
+// SliceHeader decodes the slice header at the start of b.
+func (p Platform) SliceHeader(b []byte) (ptr, n, c uint64) {
+	return p.Word(b), p.Word(b[p.PtrSize:]), p.Word(b[2*p.PtrSize:])
+}
+
+// Align returns the alignment of a scalar of the given size.
+func (p Platform) Align(size uint64) uint64 {
+	if size > p.MaxAlign {
+		return p.MaxAlign
+	}
+	if size == 0 {
+		return 1
+	}
+	return size
+}
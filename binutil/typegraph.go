@@ -0,0 +1,142 @@
+package binutil
+
+// A TypeGraph records relations between types: which struct types
+// embed which types, and which types point to which.  Analyses can use
+// it to roll up related types, such as all the types which embed
+// sync.Mutex, rather than matching type names exactly.
+type TypeGraph struct {
+	embeds, embeddedIn    map[Type][]Type
+	pointsTo, pointedToBy map[Type][]Type
+}
+
+// NewTypeGraph returns the graph of relations between the given types
+// and the types they refer to.
+func NewTypeGraph(types []Type) *TypeGraph {
+	g := &TypeGraph{
+		embeds:      map[Type][]Type{},
+		embeddedIn:  map[Type][]Type{},
+		pointsTo:    map[Type][]Type{},
+		pointedToBy: map[Type][]Type{},
+	}
+	seen := map[Type]bool{}
+	q := append([]Type(nil), types...)
+	for len(q) > 0 {
+		t := q[len(q)-1]
+		q = q[:len(q)-1]
+		if t == nil || seen[t] {
+			continue
+		}
+		seen[t] = true
+		if s, ok := t.(*StructType); ok {
+			for i, f := range s.Fields() {
+				if s.Embedded(i) && f.Type != nil {
+					g.embeds[t] = append(g.embeds[t], f.Type)
+					g.embeddedIn[f.Type] = append(g.embeddedIn[f.Type], t)
+				}
+			}
+		}
+		for _, p := range pointees(t) {
+			g.pointsTo[t] = append(g.pointsTo[t], p)
+			g.pointedToBy[p] = append(g.pointedToBy[p], t)
+		}
+		q = append(q, children(t)...)
+	}
+	return g
+}
+
+// children returns the types t is made of.
+func children(t Type) []Type {
+	switch t := t.(type) {
+	case *Typedef:
+		return []Type{t.Type}
+	case *PtrType:
+		return []Type{t.Elem}
+	case *ArrayType:
+		return []Type{t.Elem}
+	case *StructType:
+		var r []Type
+		for _, f := range t.Fields() {
+			r = append(r, f.Type)
+		}
+		return r
+	}
+	return nil
+}
+
+// pointees returns the types pointed to by pointers in values of type
+// t, each once, in the order they are found.
+func pointees(t Type) []Type {
+	var r []Type
+	seen := map[Type]bool{}
+	var walk func(t Type)
+	walk = func(t Type) {
+		if t == nil || seen[t] {
+			return
+		}
+		seen[t] = true
+		switch t := t.(type) {
+		case *PtrType:
+			if t.Elem != nil {
+				r = append(r, t.Elem)
+			}
+		case *Typedef:
+			walk(t.Type)
+		case *ArrayType:
+			walk(t.Elem)
+		case *StructType:
+			for _, f := range t.Fields() {
+				walk(f.Type)
+			}
+		}
+	}
+	walk(t)
+	return r
+}
+
+// Embeds returns the types embedded directly in struct type t.
+func (g *TypeGraph) Embeds(t Type) []Type {
+	return g.embeds[t]
+}
+
+// EmbeddedIn returns the struct types which embed t directly.
+func (g *TypeGraph) EmbeddedIn(t Type) []Type {
+	return g.embeddedIn[t]
+}
+
+// PointsTo returns the types which values of type t point to directly.
+func (g *TypeGraph) PointsTo(t Type) []Type {
+	return g.pointsTo[t]
+}
+
+// PointedToBy returns the types whose values point directly to values
+// of type t.
+func (g *TypeGraph) PointedToBy(t Type) []Type {
+	return g.pointedToBy[t]
+}
+
+// EmbedsName reports whether t is, or embeds directly or through other
+// embedded types, a type with the given name.  Embedded pointers count:
+// a struct embedding *sync.Mutex embeds sync.Mutex.
+func (g *TypeGraph) EmbedsName(t Type, name string) bool {
+	seen := map[Type]bool{}
+	var walk func(t Type) bool
+	walk = func(t Type) bool {
+		if t == nil || seen[t] {
+			return false
+		}
+		seen[t] = true
+		if t.Name() == name {
+			return true
+		}
+		if p, ok := t.(*PtrType); ok {
+			return walk(p.Elem)
+		}
+		for _, e := range g.embeds[t] {
+			if walk(e) {
+				return true
+			}
+		}
+		return false
+	}
+	return walk(t)
+}
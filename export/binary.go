@@ -0,0 +1,275 @@
+package export
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// The binary graph format is a compact encoding of the object graph
+// for programs which load very large graphs, too large for text
+// formats.  All numbers are unsigned varints, as encoding/binary writes
+// them, except where noted.  A file is:
+//
+//	header  "HDGRAPH\x00", version (BinaryVersion),
+//	        node count, edge count, type count, root count
+//	types   for each type: name length, name bytes, size
+//	nodes   for each object, in address order: address minus the
+//	        previous object's address (the first's is its own),
+//	        size, type index, number of outgoing edges
+//	edges   for each node in order, its edges in the order
+//	        read.Dump.Edges gives them: target node minus source
+//	        node as a zigzag-encoded signed varint, offset of the
+//	        pointer in the source object
+//	roots   for each root: kind (a read.RootKind), name length,
+//	        name bytes, target node
+//
+// Nodes are numbered from 0 in the order written, which is their
+// read.ObjId.  Nodes near each other in memory tend to point to each
+// other, so edge targets are written relative to their source.
+const BinaryVersion = 1
+
+const binaryMagic = "HDGRAPH\x00"
+
+// WriteBinary writes the object graph of d to w in the binary graph
+// format described above.  ReadBinary reads it back.
+func WriteBinary(w io.Writer, d *read.Dump) error {
+	n := d.NumObjects()
+	if uint64(n) > math.MaxUint32 {
+		return fmt.Errorf("%d objects is too many for the binary graph format", n)
+	}
+	b := &varintWriter{w: bufio.NewWriter(w)}
+	var edges uint64
+	for i := 0; i < n; i++ {
+		edges += uint64(len(d.Edges(read.ObjId(i))))
+	}
+	roots := d.Roots()
+
+	b.w.WriteString(binaryMagic)
+	b.uvarint(BinaryVersion)
+	b.uvarint(uint64(n))
+	b.uvarint(edges)
+	b.uvarint(uint64(len(d.FTList)))
+	b.uvarint(uint64(len(roots)))
+	for _, ft := range d.FTList {
+		b.string(ft.Name)
+		b.uvarint(ft.Size)
+	}
+	var prev uint64
+	for i := 0; i < n; i++ {
+		x := read.ObjId(i)
+		b.uvarint(d.Addr(x) - prev)
+		prev = d.Addr(x)
+		b.uvarint(d.Size(x))
+		b.uvarint(uint64(d.Ft(x).Id))
+		b.uvarint(uint64(len(d.Edges(x))))
+	}
+	for i := 0; i < n; i++ {
+		for _, e := range d.Edges(read.ObjId(i)) {
+			b.varint(int64(e.To) - int64(i))
+			b.uvarint(e.FromOffset)
+		}
+	}
+	for _, r := range roots {
+		b.uvarint(uint64(r.Kind))
+		b.string(r.Name)
+		b.uvarint(uint64(r.Edge.To))
+	}
+	return b.w.Flush()
+}
+
+type varintWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (b *varintWriter) uvarint(v uint64) {
+	b.w.Write(b.buf[:binary.PutUvarint(b.buf[:], v)])
+}
+
+func (b *varintWriter) varint(v int64) {
+	b.w.Write(b.buf[:binary.PutVarint(b.buf[:], v)])
+}
+
+func (b *varintWriter) string(s string) {
+	b.uvarint(uint64(len(s)))
+	b.w.WriteString(s)
+}
+
+// A BinaryGraph is an object graph read from the binary graph format.
+// Nodes are numbered from 0, and their attributes are in parallel
+// slices; edges are in compressed sparse row form.
+type BinaryGraph struct {
+	Version int
+	Types   []BinaryType
+
+	Addr []uint64 // address of each node
+	Size []uint64 // size of each node
+	Type []uint32 // index in Types of each node's type
+
+	// The edges of node i are EdgeTo[EdgeStart[i]:EdgeStart[i+1]],
+	// from the offsets in EdgeOffset[EdgeStart[i]:EdgeStart[i+1]].
+	EdgeStart  []uint64 // len(Addr)+1 entries
+	EdgeTo     []uint32
+	EdgeOffset []uint64
+
+	Roots []BinaryRoot
+}
+
+// A BinaryType is a type in a BinaryGraph.
+type BinaryType struct {
+	Name string
+	Size uint64
+}
+
+// A BinaryRoot is a root in a BinaryGraph.
+type BinaryRoot struct {
+	Kind read.RootKind
+	Name string
+	To   uint32 // node pointed to
+}
+
+// NumNodes returns the number of nodes in g.
+func (g *BinaryGraph) NumNodes() int {
+	return len(g.Addr)
+}
+
+// Edges returns the nodes node i points to.
+func (g *BinaryGraph) Edges(i int) []uint32 {
+	return g.EdgeTo[g.EdgeStart[i]:g.EdgeStart[i+1]]
+}
+
+var errBadGraph = errors.New("not a binary graph file")
+
+// ReadBinary reads an object graph written by WriteBinary.  It reports
+// an error for files of versions newer than BinaryVersion.
+func ReadBinary(r io.Reader) (*BinaryGraph, error) {
+	v := &varintReader{r: bufio.NewReader(r)}
+	magic := make([]byte, len(binaryMagic))
+	if _, err := io.ReadFull(v.r, magic); err != nil || string(magic) != binaryMagic {
+		return nil, errBadGraph
+	}
+	g := &BinaryGraph{Version: int(v.uvarint())}
+	if v.err == nil && g.Version > BinaryVersion {
+		return nil, fmt.Errorf("binary graph version %d is newer than this reader's %d", g.Version, BinaryVersion)
+	}
+	nodes := v.count()
+	edges := v.uvarint()
+	types := v.count()
+	roots := v.count()
+	if v.err != nil {
+		return nil, v.err
+	}
+
+	// Counts come from the file, so grow slices as data arrives rather
+	// than trusting them up front.
+	for i := 0; i < types && v.err == nil; i++ {
+		g.Types = append(g.Types, BinaryType{v.string(), v.uvarint()})
+	}
+	var addr uint64
+	g.EdgeStart = append(g.EdgeStart, 0)
+	for i := 0; i < nodes && v.err == nil; i++ {
+		addr += v.uvarint()
+		g.Addr = append(g.Addr, addr)
+		g.Size = append(g.Size, v.uvarint())
+		t := v.uvarint()
+		if t >= uint64(types) {
+			v.fail()
+		}
+		g.Type = append(g.Type, uint32(t))
+		g.EdgeStart = append(g.EdgeStart, g.EdgeStart[i]+v.uvarint())
+	}
+	if v.err == nil && g.EdgeStart[nodes] != edges {
+		v.fail()
+	}
+	for i := 0; i < nodes && v.err == nil; i++ {
+		for j := g.EdgeStart[i]; j < g.EdgeStart[i+1] && v.err == nil; j++ {
+			g.EdgeTo = append(g.EdgeTo, v.node(int64(i)+v.varint(), nodes))
+			g.EdgeOffset = append(g.EdgeOffset, v.uvarint())
+		}
+	}
+	for i := 0; i < roots && v.err == nil; i++ {
+		k := read.RootKind(v.uvarint())
+		name := v.string()
+		g.Roots = append(g.Roots, BinaryRoot{k, name, v.node(int64(v.uvarint()), nodes)})
+	}
+	if v.err != nil {
+		return nil, v.err
+	}
+	return g, nil
+}
+
+// A varintReader reads the parts of a binary graph, remembering the
+// first error.  After an error, reads return zero values.
+type varintReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (v *varintReader) fail() {
+	if v.err == nil {
+		v.err = errors.New("corrupt binary graph")
+	}
+}
+
+func (v *varintReader) uvarint() uint64 {
+	if v.err != nil {
+		return 0
+	}
+	x, err := binary.ReadUvarint(v.r)
+	if err != nil {
+		v.fail()
+	}
+	return x
+}
+
+func (v *varintReader) varint() int64 {
+	if v.err != nil {
+		return 0
+	}
+	x, err := binary.ReadVarint(v.r)
+	if err != nil {
+		v.fail()
+	}
+	return x
+}
+
+// count reads a count of nodes, types or roots.
+func (v *varintReader) count() int {
+	x := v.uvarint()
+	if x > math.MaxUint32 {
+		v.fail()
+		return 0
+	}
+	return int(x)
+}
+
+// node checks that x is a node of a graph with n nodes.
+func (v *varintReader) node(x int64, n int) uint32 {
+	if x < 0 || x >= int64(n) {
+		v.fail()
+		return 0
+	}
+	return uint32(x)
+}
+
+func (v *varintReader) string() string {
+	n := v.uvarint()
+	if v.err != nil {
+		return ""
+	}
+	if n > 1<<20 {
+		v.fail()
+		return ""
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(v.r, b); err != nil {
+		v.fail()
+	}
+	return string(b)
+}
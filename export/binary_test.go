@@ -0,0 +1,154 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+const testHeap = 0xc000000000
+
+// A testObject is an object of a test dump: its address, contents,
+// and the offsets of the pointers in it.
+type testObject struct {
+	addr uint64
+	data []byte
+	ptrs []uint64
+}
+
+// readTestDump writes a go1.5 heap dump of objs, with a data section
+// holding the pointer words roots, and reads it.
+func readTestDump(t *testing.T, objs []testObject, roots ...uint64) *read.Dump {
+	var b []byte
+	u := func(xs ...uint64) {
+		for _, x := range xs {
+			b = binary.AppendUvarint(b, x)
+		}
+	}
+	blob := func(p []byte) {
+		u(uint64(len(p)))
+		b = append(b, p...)
+	}
+	b = append(b, "go1.5 heap dump\n"...)
+	u(6, 0, 8, testHeap, testHeap+1<<20, '6', 0, 4) // params
+	for _, o := range objs {
+		u(1, o.addr)
+		blob(o.data)
+		for _, p := range o.ptrs {
+			u(1, p)
+		}
+		u(0)
+	}
+	u(12, 0x600000) // data segment
+	blob(words(roots...))
+	for i := range roots {
+		u(1, uint64(8*i))
+	}
+	u(0)
+	u(13, 0x700000) // bss segment
+	blob(words(0))
+	u(0)
+	u(10) // memstats
+	for i := 0; i < 24; i++ {
+		u(1000)
+	}
+	for i := 0; i < 256; i++ {
+		u(0)
+	}
+	u(1) // gc count
+	u(0) // eof
+	name := filepath.Join(t.TempDir(), "test.dump")
+	if err := os.WriteFile(name, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	return read.ReadWithOptions(name, &read.Options{})
+}
+
+// words returns ws as little-endian 8-byte words.
+func words(ws ...uint64) []byte {
+	var b []byte
+	for _, w := range ws {
+		b = binary.LittleEndian.AppendUint64(b, w)
+	}
+	return b
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	d := readTestDump(t, []testObject{
+		{testHeap, words(testHeap+16, 0), []uint64{0}},
+		{testHeap + 16, words(0, 1), nil},
+		{testHeap + 32, words(5, 6), nil},
+		{testHeap + 48, words(7, 8), nil},
+	}, testHeap)
+	var buf bytes.Buffer
+	if err := WriteBinary(&buf, d); err != nil {
+		t.Fatal(err)
+	}
+	g, err := ReadBinary(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g.Version != BinaryVersion {
+		t.Errorf("Version = %d, want %d", g.Version, BinaryVersion)
+	}
+	if len(g.Types) != len(d.FTList) {
+		t.Fatalf("%d types, want %d", len(g.Types), len(d.FTList))
+	}
+	for i, ft := range d.FTList {
+		if g.Types[i] != (BinaryType{ft.Name, ft.Size}) {
+			t.Errorf("Types[%d] = %+v, want {%s %d}", i, g.Types[i], ft.Name, ft.Size)
+		}
+	}
+	if g.NumNodes() != d.NumObjects() || g.NumNodes() != 4 {
+		t.Fatalf("%d nodes, want %d objects and 4", g.NumNodes(), d.NumObjects())
+	}
+	for i := 0; i < g.NumNodes(); i++ {
+		x := read.ObjId(i)
+		if g.Addr[i] != d.Addr(x) || g.Size[i] != d.Size(x) || int(g.Type[i]) != d.Ft(x).Id {
+			t.Errorf("node %d = {%#x %d %d}, want {%#x %d %d}", i, g.Addr[i], g.Size[i], g.Type[i], d.Addr(x), d.Size(x), d.Ft(x).Id)
+		}
+		edges := d.Edges(x)
+		if len(g.Edges(i)) != len(edges) {
+			t.Errorf("node %d has %d edges, want %d", i, len(g.Edges(i)), len(edges))
+			continue
+		}
+		for j, e := range edges {
+			k := g.EdgeStart[i] + uint64(j)
+			if g.EdgeTo[k] != uint32(e.To) || g.EdgeOffset[k] != e.FromOffset {
+				t.Errorf("node %d edge %d = {%d %d}, want {%d %d}", i, j, g.EdgeTo[k], g.EdgeOffset[k], e.To, e.FromOffset)
+			}
+		}
+	}
+	roots := d.Roots()
+	if len(g.Roots) != len(roots) || len(roots) == 0 {
+		t.Fatalf("%d roots, want %d and at least one", len(g.Roots), len(roots))
+	}
+	for i, r := range roots {
+		if g.Roots[i] != (BinaryRoot{r.Kind, r.Name, uint32(r.Edge.To)}) {
+			t.Errorf("Roots[%d] = %+v, want {%v %s %d}", i, g.Roots[i], r.Kind, r.Name, r.Edge.To)
+		}
+	}
+
+	// Every proper prefix of the file is corrupt.
+	for n := 0; n < buf.Len(); n++ {
+		if _, err := ReadBinary(bytes.NewReader(buf.Bytes()[:n])); err == nil {
+			t.Errorf("ReadBinary of the first %d of %d bytes succeeded", n, buf.Len())
+		}
+	}
+}
+
+func TestReadBinaryVersion(t *testing.T) {
+	b := append([]byte(binaryMagic), BinaryVersion+1, 0, 0, 0, 0)
+	if _, err := ReadBinary(bytes.NewReader(b)); err == nil {
+		t.Errorf("ReadBinary of version %d succeeded", BinaryVersion+1)
+	}
+	b = append([]byte("HDGRAPH\x01"), BinaryVersion, 0, 0, 0, 0)
+	if _, err := ReadBinary(bytes.NewReader(b)); err != errBadGraph {
+		t.Errorf("ReadBinary with a bad magic number = %v, want %v", err, errBadGraph)
+	}
+}
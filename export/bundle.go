@@ -0,0 +1,223 @@
+package export
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/randall77/heapdump14/analyze"
+	"github.com/randall77/heapdump14/read"
+)
+
+// BundleOptions controls WriteBundle.
+type BundleOptions struct {
+	// Objects, if any, limits the bundled dump to the objects
+	// reachable from them.  Otherwise it has the whole heap.
+	Objects []read.ObjId
+	// MaxObjects is the most objects to bundle, 0 for no limit.  They
+	// are the first found searching breadth first from Objects, or from
+	// the roots if there are none.
+	MaxObjects int
+	// Executables are read with the redacted dump to compute the
+	// summary and findings, as they were with the original.  They are
+	// not bundled.
+	Executables []read.Executable
+	// Summary computes summary.json from the redacted dump.  nil
+	// means to write a BundleSummary.
+	Summary func(d *read.Dump) interface{}
+}
+
+// A BundleSummary is the default summary.json of a bundle.
+type BundleSummary struct {
+	Arch        string
+	Heap        uint64 // size of the heap's address range
+	Objects     int
+	Bytes       uint64
+	Goroutines  int
+	Features    []string
+	Unavailable []string
+	Warnings    []string
+	Types       []BundleType // the 50 types with the most bytes, largest first
+}
+
+// A BundleType is a type in a BundleSummary.
+type BundleType struct {
+	Name  string
+	Count int
+	Bytes uint64
+}
+
+// WriteBundle writes to w a support bundle for d: a gzipped tar file
+// with everything needed to look into a problem with a heap, without
+// the program's data, for attaching to bug reports.  It holds
+//
+//	heapdump      d, redacted by Dump.WriteRedacted, limited as opts says
+//	summary.json  a summary of the redacted dump
+//	findings.txt  the findings in the redacted dump
+//
+// The summary and findings are computed from the redacted dump, so
+// that no strings or numbers from the heap end up in them.  The dump
+// is written to a temporary file to read it back.  opts may be nil.
+func WriteBundle(w io.Writer, d *read.Dump, opts *BundleOptions) error {
+	if opts == nil {
+		opts = &BundleOptions{}
+	}
+	f, err := os.CreateTemp("", "heapdump-bundle")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := d.WriteRedacted(f, bundleKeep(d, opts)); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	rd := read.ReadWithOptions(f.Name(), &read.Options{
+		Executables:   opts.Executables,
+		SymbolServers: []string{}, // the temporary file has no build ID to look up
+	})
+
+	var summary interface{}
+	if opts.Summary != nil {
+		summary = opts.Summary(rd)
+	} else {
+		summary = bundleSummary(rd)
+	}
+	js, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	var findings bytes.Buffer
+	for _, x := range analyze.RunFindings(rd) {
+		fmt.Fprintf(&findings, "%s\t%s\t%s\n", x.Severity, x.Detector, x.Summary)
+		for _, e := range x.Evidence {
+			fmt.Fprintf(&findings, "\t\t%s\n", e)
+		}
+	}
+
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	now := time.Now()
+	add := func(name string, size int64, r io.Reader) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, ModTime: now}); err != nil {
+			return err
+		}
+		_, err := io.Copy(tw, r)
+		return err
+	}
+	df, err := os.Open(f.Name())
+	if err != nil {
+		return err
+	}
+	defer df.Close()
+	fi, err := df.Stat()
+	if err != nil {
+		return err
+	}
+	if err := add("heapdump", fi.Size(), df); err != nil {
+		return err
+	}
+	if err := add("summary.json", int64(len(js)), bytes.NewReader(js)); err != nil {
+		return err
+	}
+	if err := add("findings.txt", int64(findings.Len()), &findings); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// bundleKeep returns the function choosing the objects of d to
+// bundle, or nil to bundle them all.
+func bundleKeep(d *read.Dump, opts *BundleOptions) func(x read.ObjId) bool {
+	if len(opts.Objects) == 0 && opts.MaxObjects == 0 {
+		return nil
+	}
+	start := opts.Objects
+	if len(start) == 0 {
+		for _, r := range d.Roots() {
+			start = append(start, r.Edge.To)
+		}
+	}
+	keep := d.NewObjSet()
+	n := 0
+	add := func(x read.ObjId) bool {
+		if keep.Has(x) || opts.MaxObjects > 0 && n == opts.MaxObjects {
+			return false
+		}
+		keep.Add(x)
+		n++
+		return true
+	}
+	var q []read.ObjId
+	for _, x := range start {
+		if add(x) {
+			q = append(q, x)
+		}
+	}
+	for len(q) > 0 {
+		x := q[0]
+		q = q[1:]
+		for _, e := range d.Edges(x) {
+			if add(e.To) {
+				q = append(q, e.To)
+			}
+		}
+	}
+	return keep.Has
+}
+
+// bundleSummary returns the default summary of d.
+func bundleSummary(d *read.Dump) *BundleSummary {
+	s := &BundleSummary{
+		Arch:       d.Arch.String(),
+		Heap:       d.HeapEnd - d.HeapStart,
+		Objects:    d.NumObjects(),
+		Goroutines: len(d.Goroutines),
+		Warnings:   d.Warnings(),
+		Types:      []BundleType{},
+	}
+	have, lack := d.Features()
+	for _, f := range have {
+		s.Features = append(s.Features, f.String())
+	}
+	for _, f := range lack {
+		s.Unavailable = append(s.Unavailable, f.String())
+	}
+	types := map[*read.FullType]*BundleType{}
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		s.Bytes += d.Size(x)
+		t := types[d.Ft(x)]
+		if t == nil {
+			t = &BundleType{Name: d.Ft(x).Name}
+			types[d.Ft(x)] = t
+		}
+		t.Count++
+		t.Bytes += d.Size(x)
+	}
+	for _, t := range types {
+		s.Types = append(s.Types, *t)
+	}
+	sort.Slice(s.Types, func(i, j int) bool {
+		a, b := s.Types[i], s.Types[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Name < b.Name
+	})
+	if len(s.Types) > 50 {
+		s.Types = s.Types[:50]
+	}
+	return s
+}
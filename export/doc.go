@@ -0,0 +1,6 @@
+// Package export writes heap dumps in formats understood by other
+// tools.  Writers use only the exported interface of package read.
+// ReadBinary reads back the binary graph format, for programs which
+// analyze very large graphs.  WriteBundle packages a redacted dump with
+// its summary for bug reports.
+package export
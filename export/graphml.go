@@ -0,0 +1,81 @@
+package export
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// GraphMLOptions controls WriteGraphML.
+type GraphMLOptions struct {
+	// MaxEdges, if positive, limits the edges written for each object
+	// to a random sample of this many of its pointers, so that objects
+	// with millions of pointers don't swamp the graph.  The nodes of
+	// sampled objects have their full number of pointers as their
+	// "pointers" attribute.
+	MaxEdges int
+}
+
+// WriteGraphML writes the object graph of d to w in GraphML format,
+// which igraph and networkx can both read.  Nodes are objects, and are
+// weighted by their retained size.  Edges are pointers, and are
+// weighted by the size of the object pointed to.  Multiple pointers
+// from one object to another are written as a single edge.  Roots are
+// not included.  opts may be nil.
+func WriteGraphML(w io.Writer, d *read.Dump, opts *GraphMLOptions) error {
+	if opts == nil {
+		opts = &GraphMLOptions{}
+	}
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "%s", `<?xml version="1.0" encoding="UTF-8"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+<key id="type" for="node" attr.name="type" attr.type="string"/>
+<key id="addr" for="node" attr.name="addr" attr.type="string"/>
+<key id="size" for="node" attr.name="size" attr.type="long"/>
+<key id="weight" for="node" attr.name="weight" attr.type="long"/>
+<key id="pointers" for="node" attr.name="pointers" attr.type="long"/>
+<key id="eweight" for="edge" attr.name="weight" attr.type="long"/>
+<graph id="heap" edgedefault="directed">
+`)
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		fmt.Fprintf(b, `<node id="n%d"><data key="type">`, x)
+		if err := xml.EscapeText(b, []byte(d.Ft(x).Name)); err != nil {
+			return err
+		}
+		fmt.Fprintf(b, `</data><data key="addr">%x</data><data key="size">%d</data><data key="weight">%d</data>`,
+			d.Addr(x), d.Size(x), d.RetainedSize(x))
+		if opts.MaxEdges > 0 {
+			if n := len(d.Edges(x)); n > opts.MaxEdges {
+				fmt.Fprintf(b, `<data key="pointers">%d</data>`, n)
+			}
+		}
+		fmt.Fprintf(b, "</node>\n")
+	}
+	// last[y] is the latest object an edge to y was written from, so
+	// that each pair of objects gets one edge.
+	last := make([]read.ObjId, d.NumObjects())
+	for i := range last {
+		last[i] = read.ObjNil
+	}
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		edges := d.Edges(x)
+		if opts.MaxEdges > 0 {
+			edges, _ = d.SampleEdges(x, opts.MaxEdges, 1)
+		}
+		for _, e := range edges {
+			if last[e.To] == x {
+				continue
+			}
+			last[e.To] = x
+			fmt.Fprintf(b, `<edge source="n%d" target="n%d"><data key="eweight">%d</data></edge>`+"\n",
+				x, e.To, d.Size(e.To))
+		}
+	}
+	fmt.Fprintf(b, "</graph>\n</graphml>\n")
+	return b.Flush()
+}
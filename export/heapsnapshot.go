@@ -0,0 +1,169 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// The node and edge layouts of a V8 heap snapshot.  Each node is
+// snapshotNodeFields numbers in the nodes array, and each edge
+// snapshotEdgeFields numbers in the edges array.
+const snapshotMeta = `{"node_fields":["type","name","id","self_size","edge_count","trace_node_id","detachedness"],` +
+	`"node_types":[["hidden","array","string","object","code","closure","regexp","number","native","synthetic","concatenated string","sliced string","symbol","bigint","object shape"],"string","number","number","number","number","number"],` +
+	`"edge_fields":["type","name_or_index","to_node"],` +
+	`"edge_types":[["context","element","property","internal","hidden","shortcut","weak"],"string_or_number","node"],` +
+	`"trace_function_info_fields":["function_id","name","script_name","script_id","line","column"],` +
+	`"trace_node_fields":["id","function_info_index","count","size","children"],` +
+	`"sample_fields":["timestamp_us","last_assigned_id"],` +
+	`"location_fields":["object_index","script_id","line","column"]}`
+
+const (
+	snapshotNodeFields = 7
+	snapshotEdgeFields = 3
+
+	snapshotObject    = 3 // node types
+	snapshotSynthetic = 9
+
+	snapshotElement  = 1 // edge types
+	snapshotProperty = 2
+)
+
+// A snapshotGroup is a synthetic node under "(GC roots)" holding the
+// roots of one kind, or of one goroutine's stack.
+type snapshotGroup struct {
+	name  string
+	roots []read.Root
+}
+
+// WriteHeapSnapshot writes the object graph of d to w in the JSON
+// .heapsnapshot format of V8, so that the Memory panel of Chrome
+// DevTools can browse it, computing retained sizes and retainer chains
+// itself.  Objects are nodes named by their type.  Their ids follow
+// the synthetic nodes' in read.ObjId order, since addresses aren't
+// unique: a zero-sized object may share one with another.  Pointers are property edges named by their field, or element
+// edges indexed by their offset when the field is unknown.  Roots hang
+// off synthetic nodes under "(GC roots)": one for globals, one for
+// each goroutine's stack and one for other roots.
+func WriteHeapSnapshot(w io.Writer, d *read.Dump) error {
+	s := &snapshotWriter{w: bufio.NewWriter(w), strings: map[string]int{}}
+
+	globals := &snapshotGroup{name: "(Globals)"}
+	other := &snapshotGroup{name: "(Other roots)"}
+	groups := []*snapshotGroup{globals}
+	stacks := map[*read.GoRoutine]*snapshotGroup{}
+	for _, r := range d.Roots() {
+		switch {
+		case r.Kind == read.RootData || r.Kind == read.RootBss:
+			globals.roots = append(globals.roots, r)
+		case r.Goroutine != nil:
+			g := stacks[r.Goroutine]
+			if g == nil {
+				g = &snapshotGroup{name: fmt.Sprintf("goroutine %d", r.Goroutine.Goid)}
+				stacks[r.Goroutine] = g
+				groups = append(groups, g)
+			}
+			g.roots = append(g.roots, r)
+		default:
+			other.roots = append(other.roots, r)
+		}
+	}
+	groups = append(groups, other)
+
+	// Nodes are the root, "(GC roots)", the groups, and then the
+	// objects, in that order.
+	first := 2 + len(groups)
+	objNode := func(x read.ObjId) int { return (first + int(x)) * snapshotNodeFields }
+	edges := 1 + len(groups)
+	for _, g := range groups {
+		edges += len(g.roots)
+	}
+	for i := 0; i < d.NumObjects(); i++ {
+		edges += len(d.Edges(read.ObjId(i)))
+	}
+
+	fmt.Fprintf(s.w, `{"snapshot":{"meta":%s,"node_count":%d,"edge_count":%d,"trace_function_count":0},`+"\n",
+		snapshotMeta, first+d.NumObjects(), edges)
+
+	// Node ids are odd numbers, as V8 gives its objects: first the
+	// synthetic nodes', then the objects'.
+	objID := func(x read.ObjId) uint64 { return uint64(5+2*len(groups)) + 2*uint64(x) }
+	s.w.WriteString(`"nodes":[`)
+	s.node(snapshotSynthetic, "", 1, 0, 1)
+	s.node(snapshotSynthetic, "(GC roots)", 3, 0, len(groups))
+	for i, g := range groups {
+		s.node(snapshotSynthetic, g.name, uint64(5+2*i), 0, len(g.roots))
+	}
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		s.node(snapshotObject, d.Ft(x).Name, objID(x), d.Size(x), len(d.Edges(x)))
+	}
+
+	s.w.WriteString("],\n\"edges\":[")
+	s.sep = ""
+	s.edge(snapshotElement, 1, 1*snapshotNodeFields)
+	for i := range groups {
+		s.edge(snapshotElement, i+1, (2+i)*snapshotNodeFields)
+	}
+	for _, g := range groups {
+		for _, r := range g.roots {
+			s.edge(snapshotProperty, s.str(r.Name), objNode(r.Edge.To))
+		}
+	}
+	for i := 0; i < d.NumObjects(); i++ {
+		for _, e := range d.Edges(read.ObjId(i)) {
+			if e.FieldName == "" {
+				s.edge(snapshotElement, int(e.FromOffset), objNode(e.To))
+			} else {
+				s.edge(snapshotProperty, s.str(e.FieldName), objNode(e.To))
+			}
+		}
+	}
+
+	s.w.WriteString("],\n" + `"trace_function_infos":[],"trace_tree":[],"samples":[],"locations":[],` + "\n" + `"strings":[`)
+	for i, str := range s.list {
+		if i > 0 {
+			s.w.WriteString(",\n")
+		}
+		b, err := json.Marshal(str)
+		if err != nil {
+			return err
+		}
+		s.w.Write(b)
+	}
+	s.w.WriteString("]}\n")
+	return s.w.Flush()
+}
+
+// A snapshotWriter writes the parts of a heap snapshot, collecting the
+// strings they use.
+type snapshotWriter struct {
+	w       *bufio.Writer
+	sep     string // written before the next node or edge
+	strings map[string]int
+	list    []string
+}
+
+// str returns the index of s in the snapshot's strings.
+func (s *snapshotWriter) str(x string) int {
+	i, ok := s.strings[x]
+	if !ok {
+		i = len(s.list)
+		s.strings[x] = i
+		s.list = append(s.list, x)
+	}
+	return i
+}
+
+func (s *snapshotWriter) node(typ int, name string, id, size uint64, edges int) {
+	fmt.Fprintf(s.w, "%s%d,%d,%d,%d,%d,0,0", s.sep, typ, s.str(name), id, size, edges)
+	s.sep = ",\n"
+}
+
+func (s *snapshotWriter) edge(typ, name, to int) {
+	fmt.Fprintf(s.w, "%s%d,%d,%d", s.sep, typ, name, to)
+	s.sep = ",\n"
+}
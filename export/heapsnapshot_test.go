@@ -0,0 +1,55 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestHeapSnapshotIds(t *testing.T) {
+	d := readTestDump(t, []testObject{
+		{testHeap, words(testHeap+16, testHeap+32), []uint64{0, 8}},
+		{testHeap + 16, nil, nil}, // shares its address with the next
+		{testHeap + 16, words(1, 2), nil},
+		{testHeap + 32, nil, nil},
+	}, testHeap)
+	var buf bytes.Buffer
+	if err := WriteHeapSnapshot(&buf, d); err != nil {
+		t.Fatal(err)
+	}
+	var snap struct {
+		Snapshot struct {
+			NodeCount int `json:"node_count"`
+			EdgeCount int `json:"edge_count"`
+		}
+		Nodes   []uint64
+		Edges   []uint64
+		Strings []string
+	}
+	if err := json.Unmarshal(buf.Bytes(), &snap); err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Nodes) != snap.Snapshot.NodeCount*snapshotNodeFields || len(snap.Edges) != snap.Snapshot.EdgeCount*snapshotEdgeFields {
+		t.Fatalf("%d nodes and %d edges, want %d and %d", len(snap.Nodes)/snapshotNodeFields, len(snap.Edges)/snapshotEdgeFields, snap.Snapshot.NodeCount, snap.Snapshot.EdgeCount)
+	}
+	ids := map[uint64]bool{}
+	objects := 0
+	for i := 0; i < len(snap.Nodes); i += snapshotNodeFields {
+		id := snap.Nodes[i+2]
+		if ids[id] {
+			t.Errorf("node %d has id %d, as does an earlier node", i/snapshotNodeFields, id)
+		}
+		ids[id] = true
+		if snap.Nodes[i] == snapshotObject {
+			objects++
+		}
+	}
+	if objects != d.NumObjects() {
+		t.Errorf("%d object nodes, want %d", objects, d.NumObjects())
+	}
+	for i := 0; i < len(snap.Edges); i += snapshotEdgeFields {
+		if to := snap.Edges[i+2]; to%snapshotNodeFields != 0 || to >= uint64(len(snap.Nodes)) {
+			t.Errorf("edge %d to bad node offset %d", i/snapshotEdgeFields, to)
+		}
+	}
+}
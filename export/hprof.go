@@ -0,0 +1,405 @@
+package export
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// HPROF record tags, sub-record tags and basic types.
+const (
+	hprofString          = 0x01
+	hprofLoadClass       = 0x02
+	hprofStackTrace      = 0x05
+	hprofHeapDumpSegment = 0x1c
+	hprofHeapDumpEnd     = 0x2c
+
+	hprofRootUnknown   = 0xff
+	hprofRootJNIGlobal = 0x01
+	hprofRootJavaFrame = 0x03
+	hprofClassDump     = 0x20
+	hprofInstanceDump  = 0x21
+	hprofObjArrayDump  = 0x22
+
+	hprofObject  = 2
+	hprofBoolean = 4
+	hprofFloat   = 6
+	hprofDouble  = 7
+	hprofByte    = 8
+	hprofShort   = 9
+	hprofInt     = 10
+	hprofLong    = 11
+)
+
+// Largest number of fields a class may have, and the size at which a
+// heap dump segment is written out.
+const (
+	hprofMaxFields   = 1<<16 - 1
+	hprofSegmentSize = 1 << 20
+)
+
+// An hprofField is an instance field of a synthesized class: the bytes
+// at off in each object, as an hprof basic type.
+type hprofField struct {
+	name string
+	typ  byte
+	off  uint64
+	size uint64 // in the object; object fields are pointers
+}
+
+// An hprofClass is the class synthesized for a FullType.
+type hprofClass struct {
+	id     uint64
+	name   uint64 // string id
+	fields []hprofField
+	array  bool // objects are written as arrays of their object fields
+}
+
+// WriteHprof writes the object graph of d to w in the HPROF binary
+// format of Java heap dumps, so that Java heap analyzers such as
+// Eclipse MAT can browse it.  Each type becomes a class with no
+// superclass but java.lang.Object, whose instance fields are the
+// type's fields: pointers are object references, holding the address
+// of the start of the object pointed to, and other fields keep their
+// size.  Objects with more fields than a class may have are written as
+// arrays of the objects they point to.  Object ids are addresses;
+// classes get ids past the end of the heap.  Zero-sized objects, which
+// may share their address with another object, are left out, and
+// pointers and roots to them are dropped.  Globals are JNI global
+// roots, stack variables Java frame roots, and other roots unknown
+// roots.
+func WriteHprof(w io.Writer, d *read.Dump) error {
+	h := &hprofWriter{w: bufio.NewWriter(w), d: d, strings: map[string]uint64{}}
+	h.w.WriteString("JAVA PROFILE 1.0.2\x00")
+	h.u4(8) // identifier size
+	h.u8(0) // timestamp
+
+	// An empty stack trace, for the records which want one.
+	h.record(hprofStackTrace, 12)
+	h.u4(1) // serial
+	h.u4(0) // thread
+	h.u4(0) // frames
+
+	next := (d.HeapEnd + 7) &^ 7
+	newClass := func(name string) *hprofClass {
+		next += 8
+		c := &hprofClass{id: next, name: h.str(name)}
+		h.record(hprofLoadClass, 4+8+4+8)
+		h.u4(uint32(len(h.classes) + 1))
+		h.u8(c.id)
+		h.u4(1)
+		h.u8(c.name)
+		h.classes = append(h.classes, c)
+		return c
+	}
+	object := newClass("java.lang.Object")
+	byType := make([]*hprofClass, len(d.FTList))
+	for _, ft := range d.FTList {
+		fields := hprofLayout(d, ft)
+		name := ft.Name
+		if len(fields) > hprofMaxFields {
+			name += "[]"
+		}
+		c := newClass(name)
+		c.fields = fields
+		c.array = len(fields) > hprofMaxFields
+		byType[ft.Id] = c
+	}
+
+	// Class dumps.
+	for _, c := range h.classes {
+		super := object.id
+		if c == object {
+			super = 0
+		}
+		h.segU1(hprofClassDump)
+		h.segID(c.id)
+		h.segU4(1)
+		h.segID(super)
+		for i := 0; i < 5; i++ {
+			h.segID(0) // loader, signers, protection domain, reserved
+		}
+		var fields []hprofField
+		if !c.array {
+			fields = c.fields
+		}
+		h.segU4(uint32(c.size()))
+		h.segU2(0) // constant pool
+		h.segU2(0) // static fields
+		h.segU2(uint16(len(fields)))
+		for _, f := range fields {
+			h.segID(h.str(f.name))
+			h.segU1(f.typ)
+		}
+		h.endSub()
+	}
+
+	// Roots.
+	for _, r := range d.Roots() {
+		if d.Size(r.Edge.To) == 0 {
+			continue
+		}
+		to := d.Addr(r.Edge.To)
+		switch r.Kind {
+		case read.RootData, read.RootBss:
+			h.segU1(hprofRootJNIGlobal)
+			h.segID(to)
+			h.segID(0)
+		case read.RootFrame:
+			h.segU1(hprofRootJavaFrame)
+			h.segID(to)
+			var thread uint32
+			if r.Goroutine != nil {
+				thread = uint32(r.Goroutine.Goid)
+			}
+			h.segU4(thread)
+			h.segU4(uint32(r.Frame.Depth))
+		default:
+			h.segU1(hprofRootUnknown)
+			h.segID(to)
+		}
+		h.endSub()
+	}
+
+	// Objects.
+	targets := map[uint64]uint64{} // offset -> address of object pointed to
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		if d.Size(x) == 0 {
+			continue
+		}
+		c := byType[d.Ft(x).Id]
+		for k := range targets {
+			delete(targets, k)
+		}
+		for _, e := range d.Edges(x) {
+			if d.Size(e.To) > 0 {
+				targets[e.FromOffset] = d.Addr(e.To)
+			}
+		}
+		if c.array {
+			n := 0
+			for _, f := range c.fields {
+				if f.typ == hprofObject {
+					n++
+				}
+			}
+			h.segU1(hprofObjArrayDump)
+			h.segID(d.Addr(x))
+			h.segU4(1)
+			h.segU4(uint32(n))
+			h.segID(c.id)
+			for _, f := range c.fields {
+				if f.typ == hprofObject {
+					h.segID(targets[f.off])
+				}
+			}
+			h.endSub()
+			continue
+		}
+		b := d.Contents(x)
+		h.segU1(hprofInstanceDump)
+		h.segID(d.Addr(x))
+		h.segU4(1)
+		h.segID(c.id)
+		h.segU4(uint32(c.size()))
+		for _, f := range c.fields {
+			if f.typ == hprofObject {
+				h.segID(targets[f.off])
+				continue
+			}
+			var v uint64
+			switch f.size {
+			case 1:
+				v = uint64(b[f.off])
+			case 2:
+				v = uint64(d.Order.Uint16(b[f.off:]))
+			case 4:
+				v = uint64(d.Order.Uint32(b[f.off:]))
+			case 8:
+				v = d.Order.Uint64(b[f.off:])
+			}
+			for k := int(f.size) - 1; k >= 0; k-- {
+				h.segU1(byte(v >> (8 * uint(k))))
+			}
+		}
+		h.endSub()
+	}
+	h.flushSegment()
+	h.record(hprofHeapDumpEnd, 0)
+	if h.err != nil {
+		return h.err
+	}
+	return h.w.Flush()
+}
+
+// size returns the size of an instance of c, as hprof counts it.
+func (c *hprofClass) size() uint64 {
+	if c.array {
+		return 0
+	}
+	var n uint64
+	for _, f := range c.fields {
+		n += hprofSize(f.typ)
+	}
+	return n
+}
+
+// hprofLayout returns the instance fields of the class for ft.
+func hprofLayout(d *read.Dump, ft *read.FullType) []hprofField {
+	w := d.PtrSize
+	word := byte(hprofLong)
+	if w == 4 {
+		word = hprofInt
+	}
+	var r []hprofField
+	if ft.Kind == read.TypeKindConservative {
+		for off := uint64(0); off+w <= ft.Size; off += w {
+			r = append(r, hprofField{fmt.Sprintf("w%d", off/w), hprofObject, off, w})
+		}
+		return r
+	}
+	add := func(name string, typ byte, off, size uint64) {
+		r = append(r, hprofField{name, typ, off, size})
+	}
+	for _, f := range d.TypeFields(ft) {
+		switch f.Kind {
+		case read.FieldKindBool:
+			add(f.Name, hprofBoolean, f.Offset, 1)
+		case read.FieldKindUInt8, read.FieldKindSInt8:
+			add(f.Name, hprofByte, f.Offset, 1)
+		case read.FieldKindUInt16, read.FieldKindSInt16:
+			add(f.Name, hprofShort, f.Offset, 2)
+		case read.FieldKindUInt32, read.FieldKindSInt32, read.FieldKindBytes4:
+			add(f.Name, hprofInt, f.Offset, 4)
+		case read.FieldKindUInt64, read.FieldKindSInt64, read.FieldKindBytes8:
+			add(f.Name, hprofLong, f.Offset, 8)
+		case read.FieldKindFloat32:
+			add(f.Name, hprofFloat, f.Offset, 4)
+		case read.FieldKindFloat64:
+			add(f.Name, hprofDouble, f.Offset, 8)
+		case read.FieldKindComplex64:
+			add(f.Name+".real", hprofFloat, f.Offset, 4)
+			add(f.Name+".imag", hprofFloat, f.Offset+4, 4)
+		case read.FieldKindComplex128:
+			add(f.Name+".real", hprofDouble, f.Offset, 8)
+			add(f.Name+".imag", hprofDouble, f.Offset+8, 8)
+		case read.FieldKindBytes16:
+			add(f.Name, hprofLong, f.Offset, 8)
+			add(f.Name+".hi", hprofLong, f.Offset+8, 8)
+		case read.FieldKindPtr:
+			add(f.Name, hprofObject, f.Offset, w)
+		case read.FieldKindIface:
+			add(f.Name+".tab", word, f.Offset, w)
+			add(f.Name+".data", hprofObject, f.Offset+w, w)
+		case read.FieldKindEface:
+			add(f.Name+".type", word, f.Offset, w)
+			add(f.Name+".data", hprofObject, f.Offset+w, w)
+		case read.FieldKindString:
+			add(f.Name+".ptr", hprofObject, f.Offset, w)
+			add(f.Name+".len", word, f.Offset+w, w)
+		case read.FieldKindSlice:
+			add(f.Name+".ptr", hprofObject, f.Offset, w)
+			add(f.Name+".len", word, f.Offset+w, w)
+			add(f.Name+".cap", word, f.Offset+2*w, w)
+		}
+	}
+	return r
+}
+
+// hprofSize returns the size of a value of basic type typ in an
+// hprof file with 8-byte identifiers.
+func hprofSize(typ byte) uint64 {
+	switch typ {
+	case hprofBoolean, hprofByte:
+		return 1
+	case hprofShort:
+		return 2
+	case hprofFloat, hprofInt:
+		return 4
+	}
+	return 8
+}
+
+type hprofWriter struct {
+	w       *bufio.Writer
+	d       *read.Dump
+	strings map[string]uint64 // string -> id
+	classes []*hprofClass
+
+	seg []byte // the heap dump segment being built
+	err error
+}
+
+func (h *hprofWriter) u4(x uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], x)
+	h.w.Write(b[:])
+}
+
+func (h *hprofWriter) u8(x uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], x)
+	h.w.Write(b[:])
+}
+
+// record writes the header of a top-level record of n bytes.
+func (h *hprofWriter) record(tag byte, n uint64) {
+	if n > 1<<32-1 {
+		h.err = fmt.Errorf("hprof record of %d bytes is too long", n)
+		n = 0
+	}
+	h.w.WriteByte(tag)
+	h.u4(0) // time
+	h.u4(uint32(n))
+}
+
+// str returns the id of the string record for s, writing one if
+// there isn't one yet.
+func (h *hprofWriter) str(s string) uint64 {
+	if id, ok := h.strings[s]; ok {
+		return id
+	}
+	id := uint64(len(h.strings) + 1)
+	h.strings[s] = id
+	h.record(hprofString, 8+uint64(len(s)))
+	h.u8(id)
+	h.w.WriteString(s)
+	return id
+}
+
+func (h *hprofWriter) segU1(x byte) {
+	h.seg = append(h.seg, x)
+}
+
+func (h *hprofWriter) segU2(x uint16) {
+	h.seg = append(h.seg, byte(x>>8), byte(x))
+}
+
+func (h *hprofWriter) segU4(x uint32) {
+	h.seg = append(h.seg, byte(x>>24), byte(x>>16), byte(x>>8), byte(x))
+}
+
+func (h *hprofWriter) segID(x uint64) {
+	h.seg = append(h.seg, byte(x>>56), byte(x>>48), byte(x>>40), byte(x>>32), byte(x>>24), byte(x>>16), byte(x>>8), byte(x))
+}
+
+// endSub ends a heap dump sub-record, writing out the segment if it
+// is full.  Sub-records aren't split across segments.
+func (h *hprofWriter) endSub() {
+	if len(h.seg) >= hprofSegmentSize {
+		h.flushSegment()
+	}
+}
+
+func (h *hprofWriter) flushSegment() {
+	if len(h.seg) == 0 {
+		return
+	}
+	h.record(hprofHeapDumpSegment, uint64(len(h.seg)))
+	h.w.Write(h.seg)
+	h.seg = h.seg[:0]
+}
@@ -0,0 +1,106 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// An hprofDumped is an instance or object array of an HPROF file: its
+// id, and its field values or elements as they are written.
+type hprofDumped struct {
+	id   uint64
+	data []byte
+}
+
+// hprofObjects reads an HPROF file as WriteHprof writes it and returns
+// its instances and object arrays, in order, and the ids its roots
+// point to.
+func hprofObjects(t *testing.T, b []byte) (objs []hprofDumped, roots []uint64) {
+	const header = "JAVA PROFILE 1.0.2\x00"
+	if !bytes.HasPrefix(b, []byte(header)) {
+		t.Fatalf("bad header %q", b[:len(header)])
+	}
+	b = b[len(header)+4+8:] // identifier size, timestamp
+	be := binary.BigEndian
+	for len(b) > 0 {
+		tag, n := b[0], be.Uint32(b[5:])
+		body := b[9 : 9+n]
+		b = b[9+n:]
+		if tag != hprofHeapDumpSegment {
+			continue
+		}
+		for len(body) > 0 {
+			sub := body[0]
+			body = body[1:]
+			id := be.Uint64(body)
+			switch sub {
+			case hprofClassDump:
+				body = body[8+4+8+5*8+4+2+2:]
+				nf := int(be.Uint16(body))
+				body = body[2+nf*9:]
+			case hprofRootJNIGlobal:
+				roots = append(roots, id)
+				body = body[16:]
+			case hprofRootJavaFrame:
+				roots = append(roots, id)
+				body = body[16:]
+			case hprofRootUnknown:
+				roots = append(roots, id)
+				body = body[8:]
+			case hprofInstanceDump:
+				body = body[8+4+8:]
+				n := be.Uint32(body)
+				objs = append(objs, hprofDumped{id, body[4 : 4+n]})
+				body = body[4+n:]
+			case hprofObjArrayDump:
+				body = body[8+4:]
+				n := 8 * be.Uint32(body)
+				objs = append(objs, hprofDumped{id, body[4+8 : 4+8+n]})
+				body = body[4+8+n:]
+			default:
+				t.Fatalf("unexpected sub-record %#x", sub)
+			}
+		}
+	}
+	return objs, roots
+}
+
+func TestHprofZeroSized(t *testing.T) {
+	d := readTestDump(t, []testObject{
+		{testHeap, words(testHeap+16, testHeap+32), []uint64{0, 8}},
+		{testHeap + 16, nil, nil}, // shares its address with the next
+		{testHeap + 16, words(1, 2), nil},
+		{testHeap + 32, nil, nil},
+	}, testHeap, testHeap+32)
+	var buf bytes.Buffer
+	if err := WriteHprof(&buf, d); err != nil {
+		t.Fatal(err)
+	}
+	objs, roots := hprofObjects(t, buf.Bytes())
+
+	// The zero-sized objects are left out, so ids are unique, and the
+	// pointer to one is null.
+	be := func(ws ...uint64) []byte {
+		var b []byte
+		for _, w := range ws {
+			b = binary.BigEndian.AppendUint64(b, w)
+		}
+		return b
+	}
+	want := []hprofDumped{
+		{testHeap, be(testHeap+16, 0)},
+		{testHeap + 16, be(1, 2)},
+	}
+	if len(objs) != len(want) {
+		t.Fatalf("objects %x, want %x", objs, want)
+	}
+	for i := range want {
+		if objs[i].id != want[i].id || !bytes.Equal(objs[i].data, want[i].data) {
+			t.Errorf("object %d = %x, want %x", i, objs[i], want[i])
+		}
+	}
+	if len(roots) != 1 || roots[0] != testHeap {
+		t.Errorf("roots %x, want [%x]", roots, testHeap)
+	}
+}
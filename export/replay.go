@@ -0,0 +1,176 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// WriteReplay writes a Go program to w which rebuilds the shape of
+// part of the heap: the objects reachable from start, up to limit of
+// them.  Each object is replaced by an object of the same size with
+// pointers in the same places, and the pointers between them are
+// restored, but all other data is zero.  The program can be used to
+// run GC and performance experiments against a replica of a
+// production heap.  A limit of 0 means no limit.
+func WriteReplay(w io.Writer, d *read.Dump, start []read.ObjId, limit int) error {
+	if limit < 0 {
+		return fmt.Errorf("negative replay limit %d", limit)
+	}
+	// Pick the objects to replay.
+	index := map[read.ObjId]int{}
+	var objs []read.ObjId
+	add := func(x read.ObjId) {
+		if _, ok := index[x]; !ok && (limit == 0 || len(objs) < limit) {
+			index[x] = len(objs)
+			objs = append(objs, x)
+		}
+	}
+	for _, x := range start {
+		add(x)
+	}
+	// The roots are the start objects added, without duplicates or
+	// those past the limit, which come first in objs.
+	nroots := len(objs)
+	for i := 0; i < len(objs); i++ {
+		for _, e := range d.Edges(objs[i]) {
+			add(e.To)
+		}
+	}
+
+	// Give each type a struct with the same pointer layout.
+	typeIndex := map[*read.FullType]int{}
+	var types []*read.FullType
+	var layouts [][]bool
+	for _, x := range objs {
+		ft := d.Ft(x)
+		if _, ok := typeIndex[ft]; !ok {
+			typeIndex[ft] = len(types)
+			types = append(types, ft)
+			layouts = append(layouts, pointerWords(d, ft))
+		}
+	}
+
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "%s", `// Code generated by hdreplay. DO NOT EDIT.
+
+// This program rebuilds the shape of part of a heap from a heap dump.
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+)
+
+`)
+	for i, ft := range types {
+		fmt.Fprintf(b, "// %s\n", strings.Replace(ft.Name, "\n", " ", -1))
+		fmt.Fprintf(b, "type t%d struct {\n", i)
+		writeLayout(b, layouts[i], ft.Size%d.PtrSize)
+		fmt.Fprintf(b, "}\n\n")
+	}
+
+	fmt.Fprintf(b, "var alloc = []func() unsafe.Pointer{\n")
+	for i := range types {
+		fmt.Fprintf(b, "\tfunc() unsafe.Pointer { return unsafe.Pointer(new(t%d)) },\n", i)
+	}
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// type of each object\nvar objType = []int{")
+	for i, x := range objs {
+		if i%16 == 0 {
+			fmt.Fprintf(b, "\n\t")
+		} else {
+			fmt.Fprintf(b, " ")
+		}
+		fmt.Fprintf(b, "%d,", typeIndex[d.Ft(x)])
+	}
+	fmt.Fprintf(b, "\n}\n\n")
+
+	fmt.Fprintf(b, "// pointers: source object, offset in source, target object, offset in target\nvar edges = [][4]uintptr{\n")
+	for i, x := range objs {
+		layout := layouts[typeIndex[d.Ft(x)]]
+		for _, e := range d.Edges(x) {
+			j, ok := index[e.To]
+			k := e.FromOffset / d.PtrSize
+			if !ok || e.FromOffset%d.PtrSize != 0 || k >= uint64(len(layout)) || !layout[k] {
+				continue
+			}
+			fmt.Fprintf(b, "\t{%d, %d, %d, %d},\n", i, e.FromOffset, j, e.ToOffset)
+		}
+	}
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "%s", `// roots keeps the rebuilt heap alive.
+var roots []unsafe.Pointer
+
+func build() {
+	objs := make([]unsafe.Pointer, len(objType))
+	for i, t := range objType {
+		objs[i] = alloc[t]()
+	}
+	for _, e := range edges {
+		*(*unsafe.Pointer)(unsafe.Pointer(uintptr(objs[e[0]]) + e[1])) = unsafe.Pointer(uintptr(objs[e[2]]) + e[3])
+	}
+`)
+	fmt.Fprintf(b, "\troots = objs[:%d]\n}\n\n", nroots)
+	fmt.Fprintf(b, "%s", `func main() {
+	build()
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	fmt.Printf("%d objects, heap in use %d bytes\n", len(objType), m.HeapAlloc)
+}
+`)
+	return b.Flush()
+}
+
+// pointerWords reports which words of objects of type ft may hold pointers.
+func pointerWords(d *read.Dump, ft *read.FullType) []bool {
+	words := make([]bool, ft.Size/d.PtrSize)
+	if ft.Kind == read.TypeKindConservative {
+		for i := range words {
+			words[i] = true
+		}
+		return words
+	}
+	set := func(off uint64) {
+		if off%d.PtrSize == 0 && off/d.PtrSize < uint64(len(words)) {
+			words[off/d.PtrSize] = true
+		}
+	}
+	for _, f := range ft.Fields {
+		switch f.Kind {
+		case read.FieldKindPtr, read.FieldKindString, read.FieldKindSlice:
+			set(f.Offset)
+		case read.FieldKindIface, read.FieldKindEface:
+			set(f.Offset)
+			set(f.Offset + d.PtrSize)
+		}
+	}
+	return words
+}
+
+// writeLayout writes struct fields for the given words, followed by tail bytes.
+func writeLayout(w io.Writer, words []bool, tail uint64) {
+	for i := 0; i < len(words); {
+		if words[i] {
+			fmt.Fprintf(w, "\tp%d unsafe.Pointer\n", i)
+			i++
+			continue
+		}
+		j := i
+		for j < len(words) && !words[j] {
+			j++
+		}
+		fmt.Fprintf(w, "\ts%d [%d]uintptr\n", i, j-i)
+		i = j
+	}
+	if tail > 0 {
+		fmt.Fprintf(w, "\ttail [%d]byte\n", tail)
+	}
+}
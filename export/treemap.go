@@ -0,0 +1,114 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+// TreemapOptions controls WriteTreemap.
+type TreemapOptions struct {
+	// MinBytes is the smallest retained size for an object to get its
+	// own node.  Smaller children of a node are lumped together into
+	// one.  0 means 1/1000 of the reachable heap.
+	MinBytes uint64
+	// MaxDepth is the depth below which objects are not broken down
+	// further, 0 for no limit.
+	MaxDepth int
+}
+
+// WriteTreemap writes the dominator tree of d to w as nested JSON
+// objects, the hierarchy treemap and flame graph renderers such as
+// d3-hierarchy and d3-flame-graph draw as nested rectangles.  Each
+// node is
+//
+//	{"name": type, "addr": "c208000000", "value": retained, "self": size, "children": [...]}
+//
+// value is the node's retained size, including its children, as
+// d3-flame-graph expects.  For d3.treemap, sum self instead:
+// d3.hierarchy(root).sum(d => d.self).  The root node is the whole
+// reachable heap; its children are the objects dominated only by the
+// roots.  Children are listed largest first.  opts may be nil.
+func WriteTreemap(w io.Writer, d *read.Dump, opts *TreemapOptions) error {
+	if opts == nil {
+		opts = &TreemapOptions{}
+	}
+	n := d.NumObjects()
+	reach := d.Reachable()
+	var total uint64
+	children := map[read.ObjId][]read.ObjId{}
+	for _, x := range reach.Objs() {
+		total += d.Size(x)
+		p := d.Idom(x)
+		if p == read.ObjNil {
+			p = read.ObjId(n) // stands for the whole heap
+		}
+		children[p] = append(children[p], x)
+	}
+	t := &treemapWriter{
+		w:        bufio.NewWriter(w),
+		d:        d,
+		children: children,
+		min:      opts.MinBytes,
+		maxDepth: opts.MaxDepth,
+	}
+	if t.min == 0 {
+		t.min = total / 1000
+	}
+	fmt.Fprintf(t.w, `{"name":"heap","value":%d,"self":0,"children":`, total)
+	t.writeChildren(children[read.ObjId(n)], 1)
+	fmt.Fprintf(t.w, "}\n")
+	return t.w.Flush()
+}
+
+type treemapWriter struct {
+	w        *bufio.Writer
+	d        *read.Dump
+	children map[read.ObjId][]read.ObjId
+	min      uint64
+	maxDepth int
+}
+
+// writeChildren writes the JSON array of nodes for the objects xs,
+// which are at the given depth.
+func (t *treemapWriter) writeChildren(xs []read.ObjId, depth int) {
+	d := t.d
+	sort.SliceStable(xs, func(i, j int) bool { return d.RetainedSize(xs[i]) > d.RetainedSize(xs[j]) })
+	t.w.WriteByte('[')
+	var small uint64
+	nsmall := 0
+	for i, x := range xs {
+		r := d.RetainedSize(x)
+		if r < t.min {
+			small += r
+			nsmall++
+			continue
+		}
+		if i > 0 {
+			t.w.WriteByte(',')
+		}
+		name, _ := json.Marshal(d.Ft(x).Name)
+		self := d.Size(x)
+		kids := t.children[x]
+		if t.maxDepth > 0 && depth >= t.maxDepth {
+			self, kids = r, nil
+		}
+		fmt.Fprintf(t.w, `{"name":%s,"addr":"%x","value":%d,"self":%d`, name, d.Addr(x), r, self)
+		if len(kids) > 0 {
+			t.w.WriteString(`,"children":`)
+			t.writeChildren(kids, depth+1)
+		}
+		t.w.WriteByte('}')
+	}
+	if nsmall > 0 {
+		if nsmall < len(xs) {
+			t.w.WriteByte(',')
+		}
+		fmt.Fprintf(t.w, `{"name":"smaller objects (%d)","value":%d,"self":%d}`, nsmall, small, small)
+	}
+	t.w.WriteByte(']')
+}
@@ -0,0 +1,858 @@
+package expr
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/randall77/heapdump14/analyze"
+	"github.com/randall77/heapdump14/binutil"
+	"github.com/randall77/heapdump14/read"
+)
+
+// Value is the result of evaluating an expression.  It is one of nil,
+// bool, int64, float64, string, []Value, Object, Type, Goroutine, or a
+// Stream.
+type Value interface{}
+
+// An Object is a heap object.  It has attributes id, addr, size, type,
+// and retained.
+type Object struct {
+	d  *read.Dump
+	Id read.ObjId
+}
+
+// A Type is a full type.  It has attributes id, name, and size.
+type Type struct {
+	Ft *read.FullType
+}
+
+// A Goroutine has attributes id, addr, status, waitreason, and frames.
+type Goroutine struct {
+	G *read.GoRoutine
+}
+
+// A Stream is a lazily computed sequence of values, such as all the
+// objects in the heap.  It calls yield for each value in turn,
+// stopping early if yield returns an error.
+type Stream func(yield func(Value) error) error
+
+// scope holds the variables bound by generator expressions.
+type scope struct {
+	name   string
+	v      Value
+	parent *scope
+}
+
+type evaluator struct {
+	d      *read.Dump
+	res    map[string]*regexp.Regexp
+	vars   *scope
+	tg     *binutil.TypeGraph
+	embeds map[string]map[*read.FullType]bool // types embedding each name
+}
+
+// Eval evaluates the expression src against dump d.
+func Eval(d *read.Dump, src string) (Value, error) {
+	n, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+	e := &evaluator{d: d, res: map[string]*regexp.Regexp{}, embeds: map[string]map[*read.FullType]bool{}}
+	return e.eval(n)
+}
+
+func (e *evaluator) eval(n node) (Value, error) {
+	switch n := n.(type) {
+	case *literal:
+		return n.v, nil
+	case *name:
+		for s := e.vars; s != nil; s = s.parent {
+			if s.name == n.name {
+				return s.v, nil
+			}
+		}
+		return e.global(n.name)
+	case *attr:
+		x, err := e.eval(n.x)
+		if err != nil {
+			return nil, err
+		}
+		return e.attr(x, n.name)
+	case *index:
+		x, err := e.eval(n.x)
+		if err != nil {
+			return nil, err
+		}
+		i, err := e.eval(n.i)
+		if err != nil {
+			return nil, err
+		}
+		l, ok := x.([]Value)
+		k, ok2 := i.(int64)
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("can't index %s with %s", typeName(x), typeName(i))
+		}
+		if k < 0 {
+			k += int64(len(l))
+		}
+		if k < 0 || k >= int64(len(l)) {
+			return nil, fmt.Errorf("index %d out of range [0:%d]", k, len(l))
+		}
+		return l[k], nil
+	case *call:
+		f, ok := n.fn.(*name)
+		if !ok {
+			return nil, fmt.Errorf("can only call builtin functions")
+		}
+		b, ok := builtins[f.name]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %s", f.name)
+		}
+		var args []Value
+		for _, a := range n.args {
+			v, err := e.eval(a)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, v)
+		}
+		return b(e, args)
+	case *unary:
+		x, err := e.eval(n.x)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "not":
+			return !truth(x), nil
+		case "-":
+			switch x := x.(type) {
+			case int64:
+				return -x, nil
+			case float64:
+				return -x, nil
+			}
+			return nil, fmt.Errorf("can't negate %s", typeName(x))
+		}
+	case *binary:
+		return e.binary(n)
+	case *listExpr:
+		l := []Value{}
+		for _, x := range n.elems {
+			v, err := e.eval(x)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, v)
+		}
+		return l, nil
+	case *genExpr:
+		iter, err := e.eval(n.iter)
+		if err != nil {
+			return nil, err
+		}
+		vars := e.vars
+		return Stream(func(yield func(Value) error) error {
+			return iterate(iter, func(v Value) error {
+				e.vars = &scope{n.v, v, vars}
+				defer func() { e.vars = vars }()
+				if n.cond != nil {
+					c, err := e.eval(n.cond)
+					if err != nil {
+						return err
+					}
+					if !truth(c) {
+						return nil
+					}
+				}
+				x, err := e.eval(n.elem)
+				if err != nil {
+					return err
+				}
+				return yield(x)
+			})
+		}), nil
+	}
+	return nil, fmt.Errorf("bad expression %T", n)
+}
+
+// global returns the value of a predefined variable.
+func (e *evaluator) global(name string) (Value, error) {
+	d := e.d
+	switch name {
+	case "objects":
+		return Stream(func(yield func(Value) error) error {
+			for i := 0; i < d.NumObjects(); i++ {
+				if err := yield(Object{d, read.ObjId(i)}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}), nil
+	case "types":
+		return Stream(func(yield func(Value) error) error {
+			for _, ft := range d.FTList {
+				if err := yield(Type{ft}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}), nil
+	case "goroutines":
+		return Stream(func(yield func(Value) error) error {
+			for _, g := range d.Goroutines {
+				if err := yield(Goroutine{g}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}), nil
+	case "heapsize":
+		return int64(d.HeapEnd - d.HeapStart), nil
+	case "ptrsize":
+		return int64(d.PtrSize), nil
+	}
+	return nil, fmt.Errorf("undefined: %s", name)
+}
+
+func (e *evaluator) attr(x Value, name string) (Value, error) {
+	switch x := x.(type) {
+	case Object:
+		d := x.d
+		switch name {
+		case "id":
+			return int64(x.Id), nil
+		case "addr":
+			return int64(d.Addr(x.Id)), nil
+		case "size":
+			return int64(d.Size(x.Id)), nil
+		case "type":
+			return d.Ft(x.Id).Name, nil
+		case "retained":
+			return int64(d.RetainedSize(x.Id)), nil
+		}
+	case Type:
+		switch name {
+		case "id":
+			return int64(x.Ft.Id), nil
+		case "name":
+			return x.Ft.Name, nil
+		case "size":
+			return int64(x.Ft.Size), nil
+		}
+	case Goroutine:
+		switch name {
+		case "id":
+			return int64(x.G.Goid), nil
+		case "addr":
+			return int64(x.G.Addr), nil
+		case "status":
+			return int64(x.G.Status), nil
+		case "waitreason":
+			return x.G.WaitReason, nil
+		case "frames":
+			l := []Value{}
+			for f := x.G.Bos; f != nil; f = f.Parent {
+				l = append(l, f.Name)
+			}
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("%s has no attribute %s", typeName(x), name)
+}
+
+func (e *evaluator) binary(n *binary) (Value, error) {
+	x, err := e.eval(n.x)
+	if err != nil {
+		return nil, err
+	}
+	// short circuit
+	switch n.op {
+	case "and":
+		if !truth(x) {
+			return x, nil
+		}
+		return e.eval(n.y)
+	case "or":
+		if truth(x) {
+			return x, nil
+		}
+		return e.eval(n.y)
+	}
+	y, err := e.eval(n.y)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "=~", "!~":
+		s, ok := x.(string)
+		p, ok2 := y.(string)
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("%s needs strings, got %s and %s", n.op, typeName(x), typeName(y))
+		}
+		re := e.res[p]
+		if re == nil {
+			if re, err = regexp.Compile(p); err != nil {
+				return nil, err
+			}
+			e.res[p] = re
+		}
+		return re.MatchString(s) == (n.op == "=~"), nil
+	case "in":
+		found := false
+		err := iterate(y, func(v Value) error {
+			if c, err := compare(x, v); err == nil && c == 0 {
+				found = true
+				return errStop
+			}
+			return nil
+		})
+		if err != nil && err != errStop {
+			if s, ok := y.(string); ok {
+				if t, ok := x.(string); ok {
+					return strings.Contains(s, t), nil
+				}
+			}
+			return nil, err
+		}
+		return found, nil
+	case "==", "!=", "<", "<=", ">", ">=":
+		c, err := compare(x, y)
+		if err != nil {
+			if n.op == "==" {
+				return false, nil
+			}
+			if n.op == "!=" {
+				return true, nil
+			}
+			return nil, err
+		}
+		switch n.op {
+		case "==":
+			return c == 0, nil
+		case "!=":
+			return c != 0, nil
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	}
+	return arith(n.op, x, y)
+}
+
+func arith(op string, x, y Value) (Value, error) {
+	if s, ok := x.(string); ok && op == "+" {
+		if t, ok := y.(string); ok {
+			return s + t, nil
+		}
+	}
+	if a, ok := x.(int64); ok {
+		if b, ok := y.(int64); ok {
+			switch op {
+			case "+":
+				return a + b, nil
+			case "-":
+				return a - b, nil
+			case "*":
+				return a * b, nil
+			case "/", "%":
+				if b == 0 {
+					return nil, fmt.Errorf("division by zero")
+				}
+				if op == "/" {
+					return a / b, nil
+				}
+				return a % b, nil
+			}
+		}
+	}
+	a, ok := toFloat(x)
+	b, ok2 := toFloat(y)
+	if !ok || !ok2 {
+		return nil, fmt.Errorf("can't compute %s %s %s", typeName(x), op, typeName(y))
+	}
+	switch op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		return a / b, nil
+	}
+	return nil, fmt.Errorf("can't compute %s %s %s", typeName(x), op, typeName(y))
+}
+
+func toFloat(v Value) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// compare returns -1, 0, or 1 as x is less than, equal to, or greater than y.
+func compare(x, y Value) (int, error) {
+	if a, ok := x.(int64); ok {
+		if b, ok := y.(int64); ok {
+			switch {
+			case a < b:
+				return -1, nil
+			case a > b:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	if a, ok := toFloat(x); ok {
+		if b, ok := toFloat(y); ok {
+			switch {
+			case a < b:
+				return -1, nil
+			case a > b:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	switch a := x.(type) {
+	case string:
+		if b, ok := y.(string); ok {
+			return strings.Compare(a, b), nil
+		}
+	case bool:
+		if b, ok := y.(bool); ok && a == b {
+			return 0, nil
+		}
+	case nil:
+		if y == nil {
+			return 0, nil
+		}
+	case Object:
+		if b, ok := y.(Object); ok {
+			return compare(int64(a.Id), int64(b.Id))
+		}
+	case Type:
+		if b, ok := y.(Type); ok {
+			return compare(int64(a.Ft.Id), int64(b.Ft.Id))
+		}
+	}
+	return 0, fmt.Errorf("can't compare %s and %s", typeName(x), typeName(y))
+}
+
+func truth(v Value) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case int64:
+		return v != 0
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []Value:
+		return len(v) != 0
+	}
+	return true
+}
+
+var errStop = fmt.Errorf("stop iteration")
+
+// iterate calls f for each element of the list or stream v.
+func iterate(v Value, f func(Value) error) error {
+	switch v := v.(type) {
+	case []Value:
+		for _, x := range v {
+			if err := f(x); err != nil {
+				return err
+			}
+		}
+		return nil
+	case Stream:
+		return v(f)
+	}
+	return fmt.Errorf("can't iterate over %s", typeName(v))
+}
+
+func typeName(v Value) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case float64:
+		return "float"
+	case string:
+		return "string"
+	case []Value:
+		return "list"
+	case Stream:
+		return "stream"
+	case Object:
+		return "object"
+	case Type:
+		return "type"
+	case Goroutine:
+		return "goroutine"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// Format returns a printable representation of v.  Streams are
+// printed in full.
+func Format(v Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return fmt.Sprintf("%q", v)
+	case Object:
+		return fmt.Sprintf("object %x (%s)", v.d.Addr(v.Id), v.d.Ft(v.Id).Name)
+	case Type:
+		return fmt.Sprintf("type %s", v.Ft.Name)
+	case Goroutine:
+		return fmt.Sprintf("goroutine %d", v.G.Goid)
+	case Stream:
+		l, err := toList(v)
+		if err != nil {
+			return "error: " + err.Error()
+		}
+		return Format(l)
+	case []Value:
+		var s []string
+		for _, x := range v {
+			s = append(s, Format(x))
+		}
+		return "[" + strings.Join(s, ", ") + "]"
+	}
+	return fmt.Sprint(v)
+}
+
+// MarshalJSON encodes o as {"Id", "Addr", "Size", "Type"}, with Addr
+// in hex.
+func (o Object) MarshalJSON() ([]byte, error) {
+	d := o.d
+	return json.Marshal(struct {
+		Id   read.ObjId
+		Addr string
+		Size uint64
+		Type string
+	}{o.Id, fmt.Sprintf("%x", d.Addr(o.Id)), d.Size(o.Id), d.Ft(o.Id).Name})
+}
+
+// MarshalJSON encodes t as {"Id", "Name", "Size"}.
+func (t Type) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Id   int
+		Name string
+		Size uint64
+	}{t.Ft.Id, t.Ft.Name, t.Ft.Size})
+}
+
+// MarshalJSON encodes g as {"Id", "Addr", "Status", "WaitReason"},
+// with Addr in hex.
+func (g Goroutine) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Id         uint64
+		Addr       string
+		Status     uint64
+		WaitReason string
+	}{g.G.Goid, fmt.Sprintf("%x", g.G.Addr), g.G.Status, g.G.WaitReason})
+}
+
+// MarshalJSON encodes s as a list of its values.
+func (s Stream) MarshalJSON() ([]byte, error) {
+	l, err := toList(s)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(l)
+}
+
+func toList(v Value) ([]Value, error) {
+	if l, ok := v.([]Value); ok {
+		return l, nil
+	}
+	l := []Value{}
+	err := iterate(v, func(x Value) error {
+		l = append(l, x)
+		return nil
+	})
+	return l, err
+}
+
+type builtin func(e *evaluator, args []Value) (Value, error)
+
+var builtins map[string]builtin
+
+func init() {
+	builtins = map[string]builtin{
+		"len":       builtinLen,
+		"count":     builtinLen,
+		"sum":       builtinSum,
+		"min":       func(e *evaluator, args []Value) (Value, error) { return extreme(args, -1) },
+		"max":       func(e *evaluator, args []Value) (Value, error) { return extreme(args, 1) },
+		"avg":       builtinAvg,
+		"list":      builtinList,
+		"sorted":    builtinSorted,
+		"hist":      builtinHist,
+		"size":      objAttr("size"),
+		"type":      objAttr("type"),
+		"addr":      objAttr("addr"),
+		"retained":  objAttr("retained"),
+		"referrers": builtinReferrers,
+		"pointees":  builtinPointees,
+		"hex":       builtinHex,
+		"embeds":    builtinEmbeds,
+	}
+}
+
+func nargs(name string, args []Value, n int) error {
+	if len(args) != n {
+		return fmt.Errorf("%s takes %d argument(s), got %d", name, n, len(args))
+	}
+	return nil
+}
+
+func builtinLen(e *evaluator, args []Value) (Value, error) {
+	if err := nargs("len", args, 1); err != nil {
+		return nil, err
+	}
+	if s, ok := args[0].(string); ok {
+		return int64(len(s)), nil
+	}
+	var n int64
+	err := iterate(args[0], func(Value) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+func builtinSum(e *evaluator, args []Value) (Value, error) {
+	if err := nargs("sum", args, 1); err != nil {
+		return nil, err
+	}
+	var s Value = int64(0)
+	err := iterate(args[0], func(v Value) error {
+		var err error
+		s, err = arith("+", s, v)
+		return err
+	})
+	return s, err
+}
+
+func builtinAvg(e *evaluator, args []Value) (Value, error) {
+	if err := nargs("avg", args, 1); err != nil {
+		return nil, err
+	}
+	var s float64
+	var n int
+	err := iterate(args[0], func(v Value) error {
+		f, ok := toFloat(v)
+		if !ok {
+			return fmt.Errorf("avg of non-number %s", typeName(v))
+		}
+		s += f
+		n++
+		return nil
+	})
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	return s / float64(n), nil
+}
+
+// extreme returns the min (dir=-1) or max (dir=1) of its arguments,
+// or of the elements of its only argument.  Like avg, it returns nil
+// when there are none.
+func extreme(args []Value, dir int) (Value, error) {
+	var vals Value = args
+	if len(args) == 1 {
+		vals = args[0]
+	}
+	var m Value
+	first := true
+	err := iterate(vals, func(v Value) error {
+		if first {
+			m, first = v, false
+			return nil
+		}
+		c, err := compare(v, m)
+		if err != nil {
+			return err
+		}
+		if c == dir {
+			m = v
+		}
+		return nil
+	})
+	return m, err
+}
+
+func builtinList(e *evaluator, args []Value) (Value, error) {
+	if err := nargs("list", args, 1); err != nil {
+		return nil, err
+	}
+	return toList(args[0])
+}
+
+// sorted(x) sorts in increasing order; sorted(x, -1) in decreasing order.
+func builtinSorted(e *evaluator, args []Value) (Value, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, fmt.Errorf("sorted takes 1 or 2 arguments")
+	}
+	l, err := toList(args[0])
+	if err != nil {
+		return nil, err
+	}
+	l = append([]Value(nil), l...)
+	dir := 1
+	if len(args) == 2 {
+		if c, _ := compare(args[1], int64(0)); c < 0 {
+			dir = -1
+		}
+	}
+	sort.SliceStable(l, func(i, j int) bool {
+		c, _ := compare(l[i], l[j])
+		return c*dir < 0
+	})
+	return l, nil
+}
+
+// hist(x) returns a list of [value, count] pairs, most frequent first.
+// hist(x, n) returns only the first n pairs.
+func builtinHist(e *evaluator, args []Value) (Value, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, fmt.Errorf("hist takes 1 or 2 arguments")
+	}
+	counts := map[string]int64{}
+	keys := map[string]Value{}
+	var order []string
+	err := iterate(args[0], func(v Value) error {
+		k := Format(v)
+		if _, ok := counts[k]; !ok {
+			keys[k] = v
+			order = append(order, k)
+		}
+		counts[k]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(order, func(i, j int) bool { return counts[order[i]] > counts[order[j]] })
+	if len(args) == 2 {
+		n, ok := args[1].(int64)
+		if !ok || n < 0 {
+			return nil, fmt.Errorf("hist limit must be a non-negative int")
+		}
+		if n < int64(len(order)) {
+			order = order[:n]
+		}
+	}
+	l := []Value{}
+	for _, k := range order {
+		l = append(l, []Value{keys[k], counts[k]})
+	}
+	return l, nil
+}
+
+// objAttr returns a builtin which returns the named attribute of its argument.
+func objAttr(name string) builtin {
+	return func(e *evaluator, args []Value) (Value, error) {
+		if err := nargs(name, args, 1); err != nil {
+			return nil, err
+		}
+		return e.attr(args[0], name)
+	}
+}
+
+func builtinReferrers(e *evaluator, args []Value) (Value, error) {
+	if err := nargs("referrers", args, 1); err != nil {
+		return nil, err
+	}
+	o, ok := args[0].(Object)
+	if !ok {
+		return nil, fmt.Errorf("referrers of non-object %s", typeName(args[0]))
+	}
+	l := []Value{}
+	for _, y := range e.d.Referrers(o.Id) {
+		l = append(l, Object{e.d, y})
+	}
+	return l, nil
+}
+
+func builtinPointees(e *evaluator, args []Value) (Value, error) {
+	if err := nargs("pointees", args, 1); err != nil {
+		return nil, err
+	}
+	o, ok := args[0].(Object)
+	if !ok {
+		return nil, fmt.Errorf("pointees of non-object %s", typeName(args[0]))
+	}
+	l := []Value{}
+	for _, x := range e.d.Edges(o.Id) {
+		l = append(l, Object{e.d, x.To})
+	}
+	return l, nil
+}
+
+// embeds(x, name) reports whether the type of object or type x is, or
+// embeds, the type with the given name.
+func builtinEmbeds(e *evaluator, args []Value) (Value, error) {
+	if err := nargs("embeds", args, 2); err != nil {
+		return nil, err
+	}
+	var ft *read.FullType
+	switch x := args[0].(type) {
+	case Object:
+		ft = e.d.Ft(x.Id)
+	case Type:
+		ft = x.Ft
+	default:
+		return nil, fmt.Errorf("embeds of non-object %s", typeName(args[0]))
+	}
+	name, ok := args[1].(string)
+	if !ok {
+		return nil, fmt.Errorf("embeds with non-string type name %s", typeName(args[1]))
+	}
+	m := e.embeds[name]
+	if m == nil {
+		if e.tg == nil {
+			e.tg = analyze.TypeGraph(e.d)
+		}
+		m = map[*read.FullType]bool{}
+		for _, t := range analyze.TypesEmbedding(e.d, e.tg, name) {
+			m[t] = true
+		}
+		e.embeds[name] = m
+	}
+	return m[ft], nil
+}
+
+func builtinHex(e *evaluator, args []Value) (Value, error) {
+	if err := nargs("hex", args, 1); err != nil {
+		return nil, err
+	}
+	n, ok := args[0].(int64)
+	if !ok {
+		return nil, fmt.Errorf("hex of non-int %s", typeName(args[0]))
+	}
+	return fmt.Sprintf("%x", uint64(n)), nil
+}
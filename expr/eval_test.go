@@ -0,0 +1,55 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+)
+
+// These expressions don't look at the dump, so they are evaluated
+// without one.
+func TestEvalBuiltins(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{`hist([1, 2, 2, "a"])`, `[[2, 2], [1, 1], ["a", 1]]`},
+		{`hist([1, 2, 2, 3, 3, 3], 2)`, `[[3, 3], [2, 2]]`},
+		{`hist([1, 2, 2], 0)`, `[]`},
+		{`hist([1, 2, 2], 5)`, `[[2, 2], [1, 1]]`},
+		{`hist([])`, `[]`},
+		{`sorted([3, 1, 2])`, `[1, 2, 3]`},
+		{`sorted([3, 1, 2], -1)`, `[3, 2, 1]`},
+		{`sorted(["b", "a"])`, `["a", "b"]`},
+		{`sorted([])`, `[]`},
+		{`min([3, 1, 2])`, `1`},
+		{`max(3, 1, 2)`, `3`},
+		{`min([])`, `nil`},
+		{`max([])`, `nil`},
+		{`min()`, `nil`},
+		{`avg([])`, `nil`},
+	}
+	for _, tt := range tests {
+		v, err := Eval(nil, tt.src)
+		if err != nil {
+			t.Errorf("Eval(%s): %v", tt.src, err)
+			continue
+		}
+		if got := Format(v); got != tt.want {
+			t.Errorf("Eval(%s) = %s, want %s", tt.src, got, tt.want)
+		}
+	}
+
+	bad := []struct {
+		src, err string
+	}{
+		{`hist([1, 2, 2], -1)`, "non-negative"},
+		{`hist([1, 2, 2], "a")`, "non-negative int"},
+		{`hist([1], 1, 2)`, "1 or 2 arguments"},
+		{`sorted(1)`, "can't iterate"},
+		{`min(["a", 1])`, ""},
+	}
+	for _, tt := range bad {
+		if _, err := Eval(nil, tt.src); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Eval(%s) error = %v, want one containing %q", tt.src, err, tt.err)
+		}
+	}
+}
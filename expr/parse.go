@@ -0,0 +1,409 @@
+// Package expr implements a small expression language for asking
+// questions about a heap dump without writing Go code, e.g.
+//
+//	sum(size(o) for o in objects if o.type =~ "bytes.Buffer")
+//
+// The syntax is a subset of Python expressions: literals, arithmetic,
+// comparisons, and/or/not, attribute access, calls, list displays,
+// and generator expressions.  The =~ and !~ operators match a string
+// against a regular expression.
+//
+// Predefined variables are objects, types, goroutines, heapsize, and
+// ptrsize.  Objects have attributes id, addr, size, type, and retained;
+// types have id, name, and size; goroutines have id, addr, status,
+// waitreason, and frames.
+//
+// Builtin functions are len, count, sum, min, max, avg, list, sorted,
+// hist (value frequencies, most common first), size, type, addr,
+// retained, referrers, pointees, hex, and embeds (whether an object's
+// or type's type is or embeds a named type, e.g. embeds(o, "sync.Mutex")).
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokInt
+	tokFloat
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+// lex splits src into tokens.
+func lex(src string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(src) {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			toks = append(toks, token{tokIdent, src[i:j], i})
+			i = j
+		case unicode.IsDigit(c):
+			j := i
+			kind := tokInt
+			if strings.HasPrefix(src[i:], "0x") || strings.HasPrefix(src[i:], "0X") {
+				j += 2
+				for j < len(src) && strings.IndexByte("0123456789abcdefABCDEF", src[j]) >= 0 {
+					j++
+				}
+			} else {
+				for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.' || src[j] == 'e') {
+					if src[j] == '.' || src[j] == 'e' {
+						kind = tokFloat
+					}
+					j++
+				}
+			}
+			toks = append(toks, token{kind, src[i:j], i})
+			i = j
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && rune(src[j]) != c {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			s := src[i : j+1]
+			if c == '\'' {
+				s = `"` + strings.Replace(s[1:len(s)-1], `"`, `\"`, -1) + `"`
+			}
+			u, err := strconv.Unquote(s)
+			if err != nil {
+				return nil, fmt.Errorf("bad string at %d: %v", i, err)
+			}
+			toks = append(toks, token{tokString, u, i})
+			i = j + 1
+		default:
+			op := ""
+			for _, o := range []string{"==", "!=", "<=", ">=", "=~", "!~", "(", ")", "[", "]", ",", ".", "+", "-", "*", "/", "%", "<", ">"} {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+			toks = append(toks, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	return append(toks, token{tokEOF, "", len(src)}), nil
+}
+
+// node is a parsed expression.
+type node interface{}
+
+type (
+	literal struct{ v Value }
+	name    struct{ name string }
+	attr    struct {
+		x    node
+		name string
+	}
+	call struct {
+		fn   node
+		args []node
+	}
+	index struct{ x, i node }
+	unary struct {
+		op string
+		x  node
+	}
+	binary struct {
+		op   string
+		x, y node
+	}
+	listExpr struct{ elems []node }
+	genExpr  struct {
+		elem node
+		v    string
+		iter node
+		cond node // nil if no condition
+	}
+)
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is reports whether the next token is the operator or keyword s.
+func (p *parser) is(s string) bool {
+	t := p.peek()
+	return (t.kind == tokOp || t.kind == tokIdent) && t.text == s
+}
+
+func (p *parser) expect(s string) error {
+	if !p.is(s) {
+		t := p.peek()
+		return fmt.Errorf("expected %q at %d, found %q", s, t.pos, t.text)
+	}
+	p.next()
+	return nil
+}
+
+// parse parses the expression in src.
+func parse(src string) (node, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	n, err := p.genExpr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	return n, nil
+}
+
+// genExpr parses an expression optionally followed by "for v in iter [if cond]".
+func (p *parser) genExpr() (node, error) {
+	x, err := p.orExpr()
+	if err != nil || !p.is("for") {
+		return x, err
+	}
+	p.next()
+	t := p.next()
+	if t.kind != tokIdent {
+		return nil, fmt.Errorf("expected variable name at %d", t.pos)
+	}
+	if err := p.expect("in"); err != nil {
+		return nil, err
+	}
+	iter, err := p.orExpr()
+	if err != nil {
+		return nil, err
+	}
+	g := &genExpr{elem: x, v: t.text, iter: iter}
+	if p.is("if") {
+		p.next()
+		if g.cond, err = p.orExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+func (p *parser) orExpr() (node, error) {
+	x, err := p.andExpr()
+	for err == nil && p.is("or") {
+		p.next()
+		var y node
+		y, err = p.andExpr()
+		x = &binary{"or", x, y}
+	}
+	return x, err
+}
+
+func (p *parser) andExpr() (node, error) {
+	x, err := p.notExpr()
+	for err == nil && p.is("and") {
+		p.next()
+		var y node
+		y, err = p.notExpr()
+		x = &binary{"and", x, y}
+	}
+	return x, err
+}
+
+func (p *parser) notExpr() (node, error) {
+	if p.is("not") {
+		p.next()
+		x, err := p.notExpr()
+		return &unary{"not", x}, err
+	}
+	return p.cmpExpr()
+}
+
+func (p *parser) cmpExpr() (node, error) {
+	x, err := p.addExpr()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">", "=~", "!~", "in"} {
+		if p.is(op) {
+			p.next()
+			y, err := p.addExpr()
+			return &binary{op, x, y}, err
+		}
+	}
+	return x, nil
+}
+
+func (p *parser) addExpr() (node, error) {
+	x, err := p.mulExpr()
+	for err == nil && (p.is("+") || p.is("-")) {
+		op := p.next().text
+		var y node
+		y, err = p.mulExpr()
+		x = &binary{op, x, y}
+	}
+	return x, err
+}
+
+func (p *parser) mulExpr() (node, error) {
+	x, err := p.unaryExpr()
+	for err == nil && (p.is("*") || p.is("/") || p.is("%")) {
+		op := p.next().text
+		var y node
+		y, err = p.unaryExpr()
+		x = &binary{op, x, y}
+	}
+	return x, err
+}
+
+func (p *parser) unaryExpr() (node, error) {
+	if p.is("-") {
+		p.next()
+		x, err := p.unaryExpr()
+		return &unary{"-", x}, err
+	}
+	return p.postfixExpr()
+}
+
+func (p *parser) postfixExpr() (node, error) {
+	x, err := p.primary()
+	for err == nil {
+		switch {
+		case p.is("."):
+			p.next()
+			t := p.next()
+			if t.kind != tokIdent {
+				return nil, fmt.Errorf("expected attribute name at %d", t.pos)
+			}
+			x = &attr{x, t.text}
+		case p.is("("):
+			p.next()
+			var args []node
+			for !p.is(")") {
+				a, err := p.genExpr()
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, a)
+				if !p.is(")") {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+			}
+			p.next()
+			x = &call{x, args}
+		case p.is("["):
+			p.next()
+			i, err := p.orExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &index{x, i}
+		default:
+			return x, nil
+		}
+	}
+	return x, err
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokInt:
+		v, err := strconv.ParseInt(t.text, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q at %d", t.text, t.pos)
+		}
+		return &literal{v}, nil
+	case tokFloat:
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q at %d", t.text, t.pos)
+		}
+		return &literal{v}, nil
+	case tokString:
+		return &literal{t.text}, nil
+	case tokIdent:
+		switch t.text {
+		case "True", "true":
+			return &literal{true}, nil
+		case "False", "false":
+			return &literal{false}, nil
+		case "None", "nil":
+			return &literal{nil}, nil
+		}
+		return &name{t.text}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			x, err := p.genExpr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			l := &listExpr{}
+			for !p.is("]") {
+				x, err := p.genExpr()
+				if err != nil {
+					return nil, err
+				}
+				if g, ok := x.(*genExpr); ok && len(l.elems) == 0 && p.is("]") {
+					// list comprehension
+					p.next()
+					return &call{&name{"list"}, []node{g}}, nil
+				}
+				l.elems = append(l.elems, x)
+				if !p.is("]") {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+			}
+			p.next()
+			return l, nil
+		}
+	}
+	if t.kind == tokEOF {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
@@ -0,0 +1,201 @@
+// Package format renders the quantities which appear in heap dump
+// reports: byte counts, object counts and fractions of the heap.  All
+// the reports share it so that a single flag switches every one of
+// them between human-readable and machine-readable output, and another
+// switches them to JSON documents for dashboards and CI checks.
+package format
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A Unit is the unit in which byte counts are written.
+type Unit int
+
+const (
+	Auto Unit = iota // the largest unit which leaves at least 1 of it
+	B
+	KiB
+	MiB
+	GiB
+)
+
+var unitNames = [...]string{
+	Auto: "auto",
+	B:    "B",
+	KiB:  "KiB",
+	MiB:  "MiB",
+	GiB:  "GiB",
+}
+
+func (u Unit) String() string {
+	if u < 0 || int(u) >= len(unitNames) {
+		return fmt.Sprintf("Unit(%d)", int(u))
+	}
+	return unitNames[u]
+}
+
+// ParseUnit returns the Unit with the given name, as returned by
+// Unit.String.  Case is ignored.
+func ParseUnit(s string) (Unit, error) {
+	for u, n := range unitNames {
+		if strings.EqualFold(n, s) {
+			return Unit(u), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown unit %q", s)
+}
+
+// Set implements flag.Value.
+func (u *Unit) Set(s string) error {
+	v, err := ParseUnit(s)
+	if err != nil {
+		return err
+	}
+	*u = v
+	return nil
+}
+
+// A Formatter writes report values.  The zero Formatter is
+// machine-readable: plain integers, and percentages without a sign.
+type Formatter struct {
+	// Human selects output meant to be read by people: byte counts
+	// in Unit, counts with thousands separators, and percentages
+	// with a % sign.
+	Human bool
+	// Unit is the unit for byte counts in human-readable output.
+	// Machine-readable output is always in bytes.
+	Unit Unit
+	// Precision is the number of digits after the decimal point for
+	// fractional values.  Negative means 1 for human-readable output
+	// and 4 for machine-readable output.
+	Precision int
+	// Total is the size against which Percent measures, usually the
+	// size of the heap.
+	Total uint64
+}
+
+// Human is the Formatter most reports use by default.
+var Human = Formatter{Human: true, Precision: -1}
+
+// Machine is the Formatter for output to be read by other programs.
+var Machine = Formatter{Precision: -1}
+
+func (f Formatter) precision() int {
+	if f.Precision >= 0 {
+		return f.Precision
+	}
+	if f.Human {
+		return 1
+	}
+	return 4
+}
+
+// Bytes formats a byte count.
+func (f Formatter) Bytes(n uint64) string {
+	if !f.Human {
+		return strconv.FormatUint(n, 10)
+	}
+	u := f.Unit
+	if u == Auto {
+		u = B
+		for u < GiB && n>>(10*uint(u-B)) >= 1024 {
+			u++
+		}
+	}
+	if u == B {
+		return f.Count(n) + " B"
+	}
+	v := float64(n) / float64(uint64(1)<<(10*uint(u-B)))
+	return strconv.FormatFloat(v, 'f', f.precision(), 64) + " " + u.String()
+}
+
+// Count formats a count of objects or other things.
+func (f Formatter) Count(n uint64) string {
+	s := strconv.FormatUint(n, 10)
+	if !f.Human {
+		return s
+	}
+	var b strings.Builder
+	for i, c := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Percent formats n as a percentage of f.Total.  It returns the
+// empty string if f.Total is zero.
+func (f Formatter) Percent(n uint64) string {
+	if f.Total == 0 {
+		return ""
+	}
+	s := strconv.FormatFloat(100*float64(n)/float64(f.Total), 'f', f.precision(), 64)
+	if f.Human {
+		s += "%"
+	}
+	return s
+}
+
+// BytesFloat formats an estimated, and so fractional, byte count.
+func (f Formatter) BytesFloat(v float64) string {
+	if v < 0 {
+		return "-" + f.Bytes(uint64(-v+0.5))
+	}
+	return f.Bytes(uint64(v + 0.5))
+}
+
+// Flags registers the -raw, -unit and -precision flags in the
+// command-line flag set and returns the Formatter they configure.
+// The Formatter is human-readable unless -raw is given.
+func Flags() *Formatter {
+	f := Human
+	flag.Var(rawFlag{&f}, "raw", "write sizes and counts as plain numbers, for other programs to read")
+	flag.Var(&f.Unit, "unit", "unit for sizes: auto, B, KiB, MiB or GiB")
+	flag.IntVar(&f.Precision, "precision", -1, "digits after the decimal point in sizes and percentages (-1 for the default)")
+	return &f
+}
+
+// JSONFlag registers the -json flag, with which a report is written as
+// a JSON document instead of as text.  Quantities in JSON reports are
+// plain numbers, in bytes, whatever the other flags say.
+func JSONFlag() *bool {
+	return flag.Bool("json", false, "write the report as JSON, for dashboards and other programs")
+}
+
+// WriteJSON writes v to w as an indented JSON document.  The reports
+// use it for their JSON output, so that all of them look alike.
+func WriteJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+// rawFlag is the flag.Value for -raw, which clears Human.
+type rawFlag struct{ f *Formatter }
+
+func (r rawFlag) IsBoolFlag() bool { return true }
+
+func (r rawFlag) String() string {
+	if r.f == nil {
+		return "false"
+	}
+	return strconv.FormatBool(!r.f.Human)
+}
+
+func (r rawFlag) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	r.f.Human = !v
+	return nil
+}
@@ -0,0 +1,94 @@
+// hdbudget checks a heap dump against a budget of live bytes, in all
+// and by type and package, and lists the limits it exceeds.  It exits
+// with status 1 if any are, so that a CI job or canary can fail on a
+// dump which holds more than it should.  See analyze.ParseHeapBudget
+// for the budget file's format.
+//
+// With -json, it writes the violations as a list of
+// analyze.BudgetViolation.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/randall77/heapdump14/analyze"
+	"github.com/randall77/heapdump14/format"
+	"github.com/randall77/heapdump14/read"
+)
+
+var (
+	fmtr   = format.Flags()
+	asJSON = format.JSONFlag()
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr,
+		"usage: hdbudget [flags] budget heapdump [executable [plugin@loadaddr ...]]\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+	}
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, err := analyze.ParseHeapBudget(data)
+	if err != nil {
+		log.Fatalf("%s: %v", args[0], err)
+	}
+	var opts read.Options
+	for _, a := range args[2:] {
+		e, err := read.ParseExecutable(a)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Executables = append(opts.Executables, e)
+	}
+	d := read.ReadWithOptions(args[1], &opts)
+
+	vs := b.Check(d)
+	if *asJSON {
+		if vs == nil {
+			vs = []analyze.BudgetViolation{}
+		}
+		if err := format.WriteJSON(os.Stdout, vs); err != nil {
+			log.Fatal(err)
+		}
+	} else if len(vs) == 0 {
+		fmt.Printf("within budget: %d limits checked\n", limits(b))
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "over budget\tlimit\tlive\tobjects\tover\n")
+		for _, v := range vs {
+			what := v.Scope.String()
+			if v.Name != "" {
+				what += " " + v.Name
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", what, fmtr.Bytes(v.Limit), fmtr.Bytes(v.Bytes), fmtr.Count(uint64(v.Objects)), fmtr.Bytes(v.Over()))
+		}
+		w.Flush()
+	}
+	if len(vs) > 0 {
+		os.Exit(1)
+	}
+}
+
+// limits counts the limits b sets.
+func limits(b *analyze.HeapBudget) int {
+	n := len(b.Types) + len(b.Packages)
+	if b.Total != 0 {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,100 @@
+// hdexpr evaluates expressions against a heap dump.  See package expr
+// for the expression syntax.
+//
+// With -json, it writes a Result for each expression.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/randall77/heapdump14/expr"
+	"github.com/randall77/heapdump14/format"
+	"github.com/randall77/heapdump14/read"
+)
+
+var (
+	exprFlag = flag.String("e", "", "expression to evaluate; if empty, expressions are read from stdin, one per line")
+	asJSON   = format.JSONFlag()
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr,
+		"usage: hdexpr [-e expr] [-json] heapdump [executable [plugin@loadaddr ...]]\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 1 {
+		usage()
+	}
+	var opts read.Options
+	for _, a := range args[1:] {
+		e, err := read.ParseExecutable(a)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Executables = append(opts.Executables, e)
+	}
+	d := read.ReadWithOptions(args[0], &opts)
+
+	if *exprFlag != "" {
+		if !eval(d, *exprFlag) {
+			os.Exit(1)
+		}
+		return
+	}
+	s := bufio.NewScanner(os.Stdin)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		eval(d, line)
+	}
+	if err := s.Err(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Result is what hdexpr -json writes for each expression.  Objects,
+// types and goroutines in Value are encoded as by their MarshalJSON
+// methods, and streams as lists.
+type Result struct {
+	Expr  string
+	Value expr.Value
+	Error string `json:",omitempty"`
+}
+
+// eval evaluates and prints a single expression.  It reports whether
+// evaluation succeeded.
+func eval(d *read.Dump, src string) bool {
+	v, err := expr.Eval(d, src)
+	if *asJSON {
+		r := Result{Expr: src, Value: v}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		if err := format.WriteJSON(os.Stdout, r); err != nil {
+			// e.g. a stream failing part way
+			r = Result{Expr: src, Error: err.Error()}
+			format.WriteJSON(os.Stdout, r)
+			return false
+		}
+		return r.Error == ""
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", src, err)
+		return false
+	}
+	fmt.Println(expr.Format(v))
+	return true
+}
@@ -0,0 +1,124 @@
+// hdgraph exports the object graph of a heap dump for analysis with
+// general graph tools, or its dominator tree for drawing as a treemap,
+// or ranks objects by their centrality in it.
+//
+// The graph is written as GraphML by default.  With -format hprof, it
+// is written as a Java HPROF heap profile instead, for Java heap
+// analyzers like Eclipse MAT and VisualVM; see export.WriteHprof.  With
+// -format heapsnapshot, it is written as a V8 heap snapshot, which the
+// Memory panel of Chrome DevTools can load; see export.WriteHeapSnapshot.
+// With -format binary, it is written in the compact binary graph format
+// of export.WriteBinary, for programs analyzing very large graphs.
+//
+// With -json, the -rank list is written as a list of Ranked.  The
+// -treemap output is JSON already.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/randall77/heapdump14/analyze"
+	"github.com/randall77/heapdump14/export"
+	"github.com/randall77/heapdump14/format"
+	"github.com/randall77/heapdump14/read"
+)
+
+var (
+	rankFlag = flag.Int("rank", 0, "instead of exporting the graph, list the `n` objects with the highest PageRank")
+	treemap  = flag.Bool("treemap", false, "instead of exporting the graph, export the dominator tree as treemap JSON")
+	minBytes = flag.Uint64("treemap.min", 0, "smallest retained size of a treemap node; 0 means 1/1000 of the heap")
+	damping  = flag.Float64("damping", 0.85, "PageRank damping factor")
+	outFmt   = flag.String("format", "graphml", "format to export the graph in: graphml, hprof, heapsnapshot or binary")
+	maxEdges = flag.Int("maxedges", 0, "write a random sample of at most `n` pointers from each object; 0 means all")
+	fmtr     = format.Flags()
+	asJSON   = format.JSONFlag()
+	symdir   = flag.String("symdir", "", "directories, separated as in $PATH, to find executables in by build ID when none are given")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr,
+		"usage: hdgraph [flags] heapdump [executable [plugin@loadaddr ...]] > heap.graphml\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 1 {
+		usage()
+	}
+	opts := read.Options{SymbolDirs: filepath.SplitList(*symdir)}
+	for _, a := range args[1:] {
+		e, err := read.ParseExecutable(a)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Executables = append(opts.Executables, e)
+	}
+	d := read.ReadWithOptions(args[0], &opts)
+	d.ComputeDominators()
+
+	if *treemap {
+		if err := export.WriteTreemap(os.Stdout, d, &export.TreemapOptions{MinBytes: *minBytes}); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *rankFlag == 0 {
+		if *asJSON {
+			log.Fatal("-json applies to -rank and -treemap; use -format to choose how the graph is written")
+		}
+		var err error
+		switch *outFmt {
+		case "graphml":
+			err = export.WriteGraphML(os.Stdout, d, &export.GraphMLOptions{MaxEdges: *maxEdges})
+		case "hprof":
+			err = export.WriteHprof(os.Stdout, d)
+		case "heapsnapshot":
+			err = export.WriteHeapSnapshot(os.Stdout, d)
+		case "binary":
+			err = export.WriteBinary(os.Stdout, d)
+		default:
+			log.Fatalf("unknown format %q; want graphml, hprof, heapsnapshot or binary", *outFmt)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	rank := analyze.PageRank(d, *damping, 100)
+	names := analyze.NewNamer(d)
+	if *asJSON {
+		res := []Ranked{}
+		for _, x := range analyze.TopRanked(rank, *rankFlag) {
+			res = append(res, Ranked{rank[x], fmt.Sprintf("%x", d.Addr(x)), names.Name(x), d.Ft(x).Name, d.Size(x), d.RetainedSize(x)})
+		}
+		if err := format.WriteJSON(os.Stdout, res); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "rank\tobject\tname\ttype\tsize\tretained\n")
+	for _, x := range analyze.TopRanked(rank, *rankFlag) {
+		fmt.Fprintf(w, "%.6f\t%x\t%s\t%s\t%s\t%s\n", rank[x], d.Addr(x), names.Name(x), d.Ft(x).Name, fmtr.Bytes(d.Size(x)), fmtr.Bytes(d.RetainedSize(x)))
+	}
+	w.Flush()
+}
+
+// Ranked is an element of the -rank -json output.  Sizes are in bytes.
+type Ranked struct {
+	Rank     float64
+	Addr     string // hex
+	Name     string // a path naming the object, as analyze.Namer gives
+	Type     string
+	Size     uint64
+	Retained uint64
+}
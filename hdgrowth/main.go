@@ -0,0 +1,187 @@
+// hdgrowth reads a series of heap dumps of one process and reports
+// which goroutine creation sites hold the fastest-growing memory.
+// With -types, it instead compares the first and last dumps type by
+// type, matching types renamed or reshaped between builds of the
+// program; see analyze.DiffTypes.  With -objects, it matches the
+// objects of the first and last dumps, and totals those added, removed,
+// grown and shrunk by type and allocation site; see analyze.DiffHeaps.
+//
+// With -json, it writes the sites as a list of analyze.SiteGrowth,
+// with -types the types as a list of analyze.TypeChange, and with
+// -objects an analyze.HeapDiff.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/randall77/heapdump14/analyze"
+	"github.com/randall77/heapdump14/format"
+	"github.com/randall77/heapdump14/read"
+)
+
+var (
+	execs   = flag.String("exe", "", "comma-separated executables, as executable[,plugin@loadaddr ...], shared by all the dumps")
+	top     = flag.Int("n", 10, "number of sites or types to show")
+	types   = flag.Bool("types", false, "compare the first and last dumps by type, instead of by goroutine site")
+	objects = flag.Bool("objects", false, "compare the objects of the first and last dumps, instead of goroutine sites")
+	fmtr    = format.Flags()
+	asJSON  = format.JSONFlag()
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr,
+		"usage: hdgrowth [flags] heapdump heapdump...\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+	}
+	var opts read.Options
+	for _, a := range strings.Split(*execs, ",") {
+		if a == "" {
+			continue
+		}
+		e, err := read.ParseExecutable(a)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Executables = append(opts.Executables, e)
+	}
+	var dumps []*read.Dump
+	for _, a := range args {
+		dumps = append(dumps, read.ReadWithOptions(a, &opts))
+	}
+
+	if *types {
+		diffTypes(dumps[0], dumps[len(dumps)-1])
+		return
+	}
+	if *objects {
+		diffObjects(dumps[0], dumps[len(dumps)-1])
+		return
+	}
+
+	sites := analyze.GoroutineGrowth(dumps)
+	if *top >= 0 && len(sites) > *top {
+		sites = sites[:*top]
+	}
+	if *asJSON {
+		if sites == nil {
+			sites = []analyze.SiteGrowth{}
+		}
+		if err := format.WriteJSON(os.Stdout, sites); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "site\tgrowth/dump\tgoroutines\tbytes\n")
+	for _, s := range sites {
+		var gs, bs []string
+		for j := range dumps {
+			gs = append(gs, fmtr.Count(uint64(s.Goroutines[j])))
+			bs = append(bs, fmtr.Bytes(s.Bytes[j]))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Site, fmtr.BytesFloat(s.Growth), strings.Join(gs, " "), strings.Join(bs, " "))
+		for j, a := range s.AllocSites {
+			if j == 3 {
+				break
+			}
+			fmt.Fprintf(w, "  allocated at %s\t\t\t\n", a)
+		}
+	}
+	w.Flush()
+}
+
+// diffTypes writes the -types report comparing a with b.
+func diffTypes(a, b *read.Dump) {
+	changes := analyze.DiffTypes(a, b)
+	if *top >= 0 && len(changes) > *top {
+		changes = changes[:*top]
+	}
+	if *asJSON {
+		if changes == nil {
+			changes = []analyze.TypeChange{}
+		}
+		if err := format.WriteJSON(os.Stdout, changes); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "type\tmatch\tcount\tbytes\n")
+	for _, c := range changes {
+		match := c.Match.String()
+		if c.OldName != "" {
+			match = fmt.Sprintf("%s from %s (%.0f%% alike)", match, c.OldName, 100*c.Similarity)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s -> %s\t%s -> %s\n", c.Name, match,
+			fmtr.Count(uint64(c.CountA)), fmtr.Count(uint64(c.CountB)), fmtr.Bytes(c.BytesA), fmtr.Bytes(c.BytesB))
+	}
+	w.Flush()
+}
+
+// diffObjects writes the -objects report comparing a with b.
+func diffObjects(a, b *read.Dump) {
+	diff := analyze.DiffHeaps(a, b)
+	if *top >= 0 && len(diff.Types) > *top {
+		diff.Types = diff.Types[:*top]
+	}
+	if *top >= 0 && len(diff.Sites) > *top {
+		diff.Sites = diff.Sites[:*top]
+	}
+	if *asJSON {
+		if diff.Types == nil {
+			diff.Types = []analyze.HeapDelta{}
+		}
+		if diff.Sites == nil {
+			diff.Sites = []analyze.HeapDelta{}
+		}
+		if err := format.WriteJSON(os.Stdout, diff); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	fmt.Printf("matched %s objects: %s by path from a root, %s by address, %s by contents\n",
+		fmtr.Count(uint64(diff.ByPath+diff.ByAddr+diff.ByContent)),
+		fmtr.Count(uint64(diff.ByPath)), fmtr.Count(uint64(diff.ByAddr)), fmtr.Count(uint64(diff.ByContent)))
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "\ntype\tnet\tadded\tremoved\tgrown\tshrunk\n")
+	for _, d := range diff.Types {
+		writeDelta(w, d.Key, d)
+	}
+	writeDelta(w, "total", diff.Total)
+	if len(diff.Sites) > 0 {
+		fmt.Fprintf(w, "\nallocation site (sampled objects)\tnet\tadded\tremoved\tgrown\tshrunk\n")
+		for _, d := range diff.Sites {
+			writeDelta(w, d.Key, d)
+		}
+	}
+	w.Flush()
+}
+
+// writeDelta writes a row of the -objects report.
+func writeDelta(w io.Writer, what string, d analyze.HeapDelta) {
+	net := fmtr.Bytes(uint64(d.Net()))
+	if d.Net() < 0 {
+		net = "-" + fmtr.Bytes(uint64(-d.Net()))
+	}
+	fmt.Fprintf(w, "%s\t%s\t%s (%s)\t%s (%s)\t%s (%s)\t%s (%s)\n", what, net,
+		fmtr.Count(uint64(d.Added)), fmtr.Bytes(d.AddedBytes),
+		fmtr.Count(uint64(d.Removed)), fmtr.Bytes(d.RemovedBytes),
+		fmtr.Count(uint64(d.Grown)), fmtr.Bytes(d.GrownBytes),
+		fmtr.Count(uint64(d.Shrunk)), fmtr.Bytes(d.ShrunkBytes))
+}
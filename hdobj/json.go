@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/randall77/heapdump14/analyze"
+	"github.com/randall77/heapdump14/read"
+)
+
+// Object is what hdobj -json writes.  Sizes are in bytes and addresses
+// are hex strings.
+type Object struct {
+	Addr string
+	Id   read.ObjId
+	Type string
+	Size uint64
+
+	Fields    []Field
+	Referrers []Referrer // objects pointing to the object
+	Roots     []Root     // roots pointing to the object
+
+	Name  string // the object's name along its first path, if reachable
+	Depth int    // distance from the nearest root; -1 if unreachable
+	Paths []Path // shortest paths from roots, as -paths asks
+
+	// Without -budget, Dominator is the object's immediate dominator,
+	// nil if it is dominated by the roots alone or is unreachable, and
+	// Retained and PreciseRetained are its retained size, the latter
+	// not counting conservative pointers.
+	Dominator       *Ref   `json:",omitempty"`
+	Retained        uint64 `json:",omitempty"`
+	PreciseRetained uint64 `json:",omitempty"`
+	// With -budget, Estimate is the estimated retained size instead.
+	Estimate *analyze.Estimate `json:",omitempty"`
+}
+
+// A Field is a field of the object; padding is left out.
+type Field struct {
+	Name   string
+	Offset uint64
+	Type   string
+	Value  string
+}
+
+// A Ref identifies another object.
+type Ref struct {
+	Addr     string
+	Id       read.ObjId
+	Type     string
+	Retained uint64 `json:",omitempty"` // only for Dominator
+}
+
+// A Referrer is a pointer to the object from another object.
+type Referrer struct {
+	Addr   string
+	Field  string // name of the field holding the pointer, if known
+	Offset uint64 // offset of the pointer in the referrer
+}
+
+// A Root is a root of the object graph.
+type Root struct {
+	Kind   string
+	Name   string
+	Source string // where the root is, as read.Root.Source gives
+}
+
+// A Path leads from a root to the object.
+type Path struct {
+	Root  Root
+	Steps []Step // ending with the object itself
+}
+
+// A Step is an object on a path, and the field of the previous
+// object pointing to it.
+type Step struct {
+	Ref
+	Field string
+}
+
+// describe computes the Object for x.  paths are the paths from roots
+// to x to report.
+func describe(d *read.Dump, x read.ObjId, paths []read.Path) *Object {
+	ref := func(y read.ObjId) Ref {
+		return Ref{Addr: fmt.Sprintf("%x", d.Addr(y)), Id: y, Type: d.Ft(y).Name}
+	}
+	o := &Object{
+		Addr:      fmt.Sprintf("%x", d.Addr(x)),
+		Id:        x,
+		Type:      d.Ft(x).Name,
+		Size:      d.Size(x),
+		Fields:    []Field{},
+		Referrers: []Referrer{},
+		Roots:     []Root{},
+		Depth:     d.Depth(x),
+		Paths:     []Path{},
+	}
+	for _, v := range d.Describe(x) {
+		if !v.Pad {
+			o.Fields = append(o.Fields, Field{v.Name, v.Offset, v.Type, v.String()})
+		}
+	}
+	for _, y := range d.Referrers(x) {
+		for _, e := range d.Edges(y) {
+			if e.To == x {
+				o.Referrers = append(o.Referrers, Referrer{fmt.Sprintf("%x", d.Addr(y)), e.FieldName, e.FromOffset})
+			}
+		}
+	}
+	for _, r := range d.RootReferrers(x) {
+		o.Roots = append(o.Roots, Root{r.Kind.String(), r.Name, r.Source()})
+	}
+	if len(paths) > 0 {
+		o.Name = analyze.PathName(d, paths[0])
+	}
+	for _, p := range paths {
+		q := Path{Root: Root{p.Root.Kind.String(), p.Root.Name, p.Root.Source()}}
+		for i, y := range p.Objs {
+			e := p.Root.Edge
+			if i > 0 {
+				e = p.Edges[i-1]
+			}
+			q.Steps = append(q.Steps, Step{ref(y), e.FieldName})
+		}
+		o.Paths = append(o.Paths, q)
+	}
+
+	if *budget != 0 {
+		if d.Depth(x) < 0 {
+			o.Estimate = &analyze.Estimate{Exact: true}
+		} else {
+			e := analyze.EstimateRetained(d, x, analyze.Budget{Time: *budget})
+			o.Estimate = &e
+		}
+		return o
+	}
+	if y := d.Idom(x); y != read.ObjNil {
+		r := ref(y)
+		r.Retained = d.RetainedSize(y)
+		o.Dominator = &r
+	}
+	o.Retained = d.RetainedSize(x)
+	o.PreciseRetained = d.PreciseRetainedSize(x)
+	return o
+}
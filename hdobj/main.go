@@ -0,0 +1,227 @@
+// hdobj prints everything needed for a first look at a single heap
+// object: its fields, its referrers, a shortest path keeping it alive,
+// and its immediate dominator.  -paths shows more paths, and -depth
+// limits how long they may be.
+//
+// With -json, it writes the same information as an Object.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/randall77/heapdump14/analyze"
+	"github.com/randall77/heapdump14/format"
+	"github.com/randall77/heapdump14/read"
+)
+
+var (
+	byId    = flag.Bool("id", false, "interpret the object argument as an object id instead of an address")
+	npaths  = flag.Int("paths", 1, "number of paths from roots to show")
+	exclude = flag.String("exclude", "", "comma-separated root kinds (data,bss,frame,other,qfinal) paths may not start at")
+	prefer  = flag.String("prefer", "", "comma-separated root kinds to show paths from first")
+	depth   = flag.Int("depth", 0, "if positive, the most pointers a path from a root may follow")
+	budget  = flag.Duration("budget", 0, "if nonzero, estimate the retained size within this time instead of computing dominators")
+	fmtr    = format.Flags()
+	asJSON  = format.JSONFlag()
+	symdir  = flag.String("symdir", "", "directories, separated as in $PATH, to find executables in by build ID when none are given")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr,
+		"usage: hdobj [flags] heapdump [executable [plugin@loadaddr ...]] object\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+	}
+	if *npaths < 1 {
+		log.Fatal("-paths must be at least 1")
+	}
+	dump := args[0]
+	target := args[len(args)-1]
+	opts := read.Options{SymbolDirs: filepath.SplitList(*symdir)}
+	for _, a := range args[1 : len(args)-1] {
+		e, err := read.ParseExecutable(a)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Executables = append(opts.Executables, e)
+	}
+
+	d := read.ReadWithOptions(dump, &opts)
+	x := findObject(d, target)
+	popts := &read.PathOptions{Exclude: rootKinds(*exclude), Prefer: rootKinds(*prefer), MaxDepth: *depth}
+	paths := d.KShortestPaths(x, *npaths, popts)
+
+	if *asJSON {
+		if err := format.WriteJSON(os.Stdout, describe(d, x, paths)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "%s: %s, %s\n", objName(d, x), d.Ft(x).Name, fmtr.Bytes(d.Size(x)))
+
+	fmt.Fprintf(w, "\nFields\n")
+	for _, v := range d.Describe(x) {
+		if v.Pad {
+			fmt.Fprintf(w, "  (%s)\t\t\n", v.Name)
+			continue
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", v.Name, v.Type, v)
+	}
+	w.Flush()
+
+	fmt.Printf("\nReferrers\n")
+	for _, y := range d.Referrers(x) {
+		for _, e := range d.Edges(y) {
+			if e.To == x {
+				fmt.Printf("  %s\n", edgeSource(d, y, e))
+			}
+		}
+	}
+	for _, r := range d.RootReferrers(x) {
+		fmt.Printf("  %s\n", r.Source())
+	}
+
+	if len(paths) > 0 {
+		fmt.Printf("\nName\n  %s\n", analyze.PathName(d, paths[0]))
+	}
+
+	if k := d.Depth(x); k >= 0 {
+		fmt.Printf("\nDepth\n  %d\n", k)
+	}
+
+	fmt.Printf("\nPaths from roots\n")
+	for j, p := range paths {
+		if j > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("  %s\n", p.Root.Source())
+		for i, y := range p.Objs {
+			if i > 0 {
+				fmt.Printf("  -> %s\n", edgeSource(d, p.Objs[i-1], p.Edges[i-1]))
+			}
+			fmt.Printf("  %s: %s\n", objName(d, y), d.Ft(y).Name)
+		}
+	}
+	if len(paths) == 0 {
+		fmt.Printf("  %s\n", noPaths(d, x, popts))
+	}
+
+	if *budget != 0 {
+		if d.Depth(x) < 0 {
+			fmt.Printf("\nRetains nothing (unreachable)\n")
+			return
+		}
+		e := analyze.EstimateRetained(d, x, analyze.Budget{Time: *budget})
+		if e.Exact {
+			fmt.Printf("\nRetains %s\n", fmtr.BytesFloat(e.Value))
+		} else {
+			fmt.Printf("\nRetains about %s (%s to %s, from %s samples)\n",
+				fmtr.BytesFloat(e.Value), fmtr.BytesFloat(e.Low), fmtr.BytesFloat(e.High), fmtr.Count(uint64(e.Samples)))
+		}
+		return
+	}
+
+	fmt.Printf("\nDominator\n")
+	if y := d.Idom(x); y != read.ObjNil {
+		fmt.Printf("  %s: %s, retains %s\n", objName(d, y), d.Ft(y).Name, fmtr.Bytes(d.RetainedSize(y)))
+	} else if _, ok := d.PathToRoot(x); ok {
+		fmt.Printf("  roots\n")
+	} else {
+		fmt.Printf("  none (unreachable)\n")
+	}
+	fmt.Printf("  object itself retains %s", fmtr.Bytes(d.RetainedSize(x)))
+	if p := d.PreciseRetainedSize(x); p != d.RetainedSize(x) {
+		fmt.Printf(" (%s excluding conservative pointers)", fmtr.Bytes(p))
+	}
+	fmt.Println()
+}
+
+// findObject returns the object described by the command line argument s.
+func findObject(d *read.Dump, s string) read.ObjId {
+	if *byId {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if id >= uint64(d.NumObjects()) {
+			log.Fatalf("no object with id %d", id)
+		}
+		return read.ObjId(id)
+	}
+	addr, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+	if err != nil {
+		log.Fatal(err)
+	}
+	x := d.FindObj(addr)
+	if x == read.ObjNil {
+		log.Fatalf("no object at address %x", addr)
+	}
+	return x
+}
+
+// rootKinds parses a comma-separated list of root kinds.
+func rootKinds(s string) []read.RootKind {
+	var r []read.RootKind
+	for _, f := range strings.Split(s, ",") {
+		if f == "" {
+			continue
+		}
+		k, err := read.ParseRootKind(f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		r = append(r, k)
+	}
+	return r
+}
+
+func objName(d *read.Dump, x read.ObjId) string {
+	return fmt.Sprintf("object %x (id %d)", d.Addr(x), x)
+}
+
+// edgeSource returns a string representing the source of an Edge.
+func edgeSource(d *read.Dump, x read.ObjId, e read.Edge) string {
+	s := fmt.Sprintf("object %x", d.Addr(x))
+	if e.FieldName != "" {
+		s = fmt.Sprintf("%s.%s", s, e.FieldName)
+	} else if e.FromOffset != 0 {
+		s = fmt.Sprintf("%s+%d", s, e.FromOffset)
+	}
+	return s
+}
+
+// noPaths says why KShortestPaths found no path from the roots to x
+// under popts.  Whether x is reachable at all does not depend on popts:
+// its paths may all be too long, or start at excluded roots.
+func noPaths(d *read.Dump, x read.ObjId, popts *read.PathOptions) string {
+	k := d.Depth(x)
+	switch {
+	case k < 0:
+		return "unreachable"
+	case popts.MaxDepth > 0 && k > popts.MaxDepth:
+		return fmt.Sprintf("none within %d pointers", popts.MaxDepth)
+	case len(popts.Exclude) > 0 && popts.MaxDepth > 0:
+		return fmt.Sprintf("no path avoiding the excluded roots within %d pointers", popts.MaxDepth)
+	case len(popts.Exclude) > 0:
+		return "no path avoiding the excluded roots"
+	default:
+		return "none found within the search limit"
+	}
+}
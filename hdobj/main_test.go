@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/randall77/heapdump14/read"
+)
+
+const heap = 0xc000000000
+
+// readTestDump writes a small go1.5 heap dump and reads it.  It has
+// four 16-byte objects.  A data root points to the first, which points
+// to the second, which points to the third; the fourth is garbage.
+func readTestDump(t *testing.T) *read.Dump {
+	var b []byte
+	u := func(xs ...uint64) {
+		for _, x := range xs {
+			b = binary.AppendUvarint(b, x)
+		}
+	}
+	words := func(ws ...uint64) {
+		u(uint64(8 * len(ws)))
+		for _, w := range ws {
+			b = binary.LittleEndian.AppendUint64(b, w)
+		}
+	}
+	b = append(b, "go1.5 heap dump\n"...)
+	u(6, 0, 8, heap, heap+1<<20, '6', 0, 4) // params
+	obj := func(addr uint64, ptrs bool, ws ...uint64) {
+		u(1, addr)
+		words(ws...)
+		if ptrs {
+			u(1, 0) // a pointer at offset 0
+		}
+		u(0)
+	}
+	obj(heap, true, heap+16, 0)
+	obj(heap+16, true, heap+32, 0)
+	obj(heap+32, false, 0, 1)
+	obj(heap+48, false, 0, 2)
+	u(12, 0x600000) // data segment
+	words(heap)
+	u(1, 0, 0)
+	u(13, 0x700000) // bss segment
+	words(0)
+	u(0)
+	u(10) // memstats
+	for i := 0; i < 24; i++ {
+		u(1000)
+	}
+	for i := 0; i < 256; i++ {
+		u(0)
+	}
+	u(1) // gc count
+	u(0) // eof
+	name := filepath.Join(t.TempDir(), "test.dump")
+	if err := os.WriteFile(name, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	return read.ReadWithOptions(name, &read.Options{})
+}
+
+func TestNoPaths(t *testing.T) {
+	d := readTestDump(t)
+	k, err := read.ParseRootKind("data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []read.RootKind{k}
+	tests := []struct {
+		addr  uint64
+		popts read.PathOptions
+		want  string
+	}{
+		{heap + 48, read.PathOptions{}, "unreachable"},
+		{heap + 48, read.PathOptions{MaxDepth: 1}, "unreachable"},
+		{heap + 16, read.PathOptions{Exclude: data}, "no path avoiding the excluded roots"},
+		{heap + 32, read.PathOptions{Exclude: data, MaxDepth: 3}, "no path avoiding the excluded roots within 3 pointers"},
+		{heap + 32, read.PathOptions{MaxDepth: 1}, "none within 1 pointers"},
+		{heap + 32, read.PathOptions{Exclude: data, MaxDepth: 1}, "none within 1 pointers"},
+	}
+	for _, tt := range tests {
+		x := d.FindObj(tt.addr)
+		if paths := d.KShortestPaths(x, 1, &tt.popts); len(paths) != 0 {
+			t.Errorf("object %x with %+v has paths %v", tt.addr, tt.popts, paths)
+			continue
+		}
+		if got := noPaths(d, x, &tt.popts); got != tt.want {
+			t.Errorf("noPaths(%x, %+v) = %q, want %q", tt.addr, tt.popts, got, tt.want)
+		}
+	}
+	// The object reached only through the excluded roots is live.
+	if x := d.FindObj(heap + 32); d.Depth(x) != 2 || len(d.KShortestPaths(x, 1, nil)) != 1 {
+		t.Errorf("object %x has depth %d, want 2 and a path", heap+32, d.Depth(x))
+	}
+}
@@ -0,0 +1,61 @@
+// hdreplay writes a Go program which rebuilds the shape of the part of
+// a heap reachable from the given object, for GC and performance
+// experiments against a replica of a real heap.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/randall77/heapdump14/export"
+	"github.com/randall77/heapdump14/read"
+)
+
+var (
+	limit = flag.Int("limit", 100000, "maximum number of objects to replay; 0 means no limit")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr,
+		"usage: hdreplay [flags] heapdump [executable [plugin@loadaddr ...]] address > replay.go\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+	}
+	if *limit < 0 {
+		log.Fatal("-limit must not be negative")
+	}
+	var opts read.Options
+	for _, a := range args[1 : len(args)-1] {
+		e, err := read.ParseExecutable(a)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Executables = append(opts.Executables, e)
+	}
+	d := read.ReadWithOptions(args[0], &opts)
+
+	s := args[len(args)-1]
+	addr, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+	if err != nil {
+		log.Fatal(err)
+	}
+	x := d.FindObj(addr)
+	if x == read.ObjNil {
+		log.Fatalf("no object at address %x", addr)
+	}
+	if err := export.WriteReplay(os.Stdout, d, []read.ObjId{x}, *limit); err != nil {
+		log.Fatal(err)
+	}
+}
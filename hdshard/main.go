@@ -0,0 +1,119 @@
+// hdshard analyzes a heap dump in shards, one window of addresses at a
+// time, so that a dump too large for one machine can be divided among
+// several.  Each shard is written as the JSON of an analyze.ShardResult:
+//
+//	hdshard -window lo-hi heapdump [binary] > shard1.json
+//
+// -merge combines shards, and logs how many pointers between windows
+// are still to be followed:
+//
+//	hdshard -merge shard*.json > merged.json
+//
+// While any are, each window with pointers pending is analyzed again
+// against the merged result, and the extensions merged in:
+//
+//	hdshard -window lo-hi -extend merged.json heapdump [binary] > ext1.json
+//	hdshard -merge merged.json ext*.json > merged2.json
+//
+// Once no pointers are pending, the merged result's reachability counts
+// are final.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/randall77/heapdump14/analyze"
+	"github.com/randall77/heapdump14/format"
+	"github.com/randall77/heapdump14/read"
+)
+
+var (
+	window = flag.String("window", "", "analyze the objects starting in this address range, given as lo-hi in hex")
+	extend = flag.String("extend", "", "follow the pointers into the window still pending in this merged result")
+	merge  = flag.Bool("merge", false, "merge the shard results named on the command line")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr,
+		"usage: hdshard -window lo-hi [-extend merged.json] heapdump [executable [plugin@loadaddr ...]]\n"+
+			"       hdshard -merge shard.json ...\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if *merge {
+		if len(args) < 1 || *window != "" || *extend != "" {
+			usage()
+		}
+		mergeFiles(args)
+		return
+	}
+	if len(args) < 1 || *window == "" {
+		usage()
+	}
+	w, err := read.ParseAddrRange(*window)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := read.Options{Window: w}
+	for _, a := range args[1:] {
+		e, err := read.ParseExecutable(a)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Executables = append(opts.Executables, e)
+	}
+	d := read.ReadWithOptions(args[0], &opts)
+
+	var r *analyze.ShardResult
+	if *extend == "" {
+		r = analyze.AnalyzeShard(d)
+	} else {
+		m := readResult(*extend)
+		r = analyze.ExtendShard(d, m.Pending(d.Window()), m.ReachedIn(d.Window()))
+	}
+	if err := format.WriteJSON(os.Stdout, r); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// mergeFiles writes the merge of the shard results in files.
+func mergeFiles(files []string) {
+	var rs []*analyze.ShardResult
+	for _, f := range files {
+		rs = append(rs, readResult(f))
+	}
+	m, err := analyze.Merge(rs...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if m.Converged() {
+		log.Printf("%d objects in %d windows, %d unreachable", m.Objects, len(m.Windows), m.Unreachable())
+	} else {
+		log.Printf("%d objects in %d windows, %d pointers between windows pending", m.Objects, len(m.Windows), len(m.Frontier))
+	}
+	if err := format.WriteJSON(os.Stdout, m); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func readResult(file string) *analyze.ShardResult {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	var r analyze.ShardResult
+	if err := json.NewDecoder(f).Decode(&r); err != nil {
+		log.Fatalf("%s: %v", file, err)
+	}
+	return &r
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/randall77/heapdump14/analyze"
+	"github.com/randall77/heapdump14/read"
+)
+
+// Summary is what hdsummary -json writes.  Sizes are in bytes and
+// addresses are hex strings.
+type Summary struct {
+	Arch string // GOARCH of the dumped process, or "unknown"
+	// Compat describes the runtime which wrote the dump, and how it
+	// differs from what the tools decode.
+	Compat      *read.Compat
+	Features    []string // features of the dump available, as read.Feature names
+	Unavailable []string // features not available
+	// RuntimeChecks compare the dump's records with the runtime's
+	// globals, if the executable was given.
+	RuntimeChecks []read.RuntimeCheck `json:",omitempty"`
+	// Truncation says how much of the dump there was, if it was cut
+	// short.
+	Truncation *read.Truncation `json:",omitempty"`
+
+	Heap     uint64            // size of the heap's address range
+	Window   string            `json:",omitempty"` // with -window, the range summarized, as lo-hi
+	Objects  int               // number of objects
+	Bytes    uint64            // total size of the objects
+	Memstats *runtime.MemStats // nil if the dump has none
+
+	// Compaction compares the pages the objects are on with the pages
+	// they would need if packed together.
+	Compaction    *analyze.Compaction
+	Fragmentation float64 // fraction of the pages compaction would free
+
+	Findings   []Finding // most severe first
+	Graph      Graph
+	Garbage    Garbage
+	Buffers    []Buffer               // kinds of large byte buffers, if any
+	Strings    *analyze.PrefixSummary `json:",omitempty"` // live strings by prefix, the -n largest clusters; nil without types
+	Packages   *Packages              `json:",omitempty"` // nil if no type's package is known
+	Sizes      []analyze.SizeBin      // objects by size, smallest first
+	Types      []Type                 // the -n types with the most bytes, largest first
+	Anonymous  []analyze.TypeBlame    // the -n largest types without type information, by their top three referrers and allocation sites
+	Largest    []Object               // the -n objects retaining the most, largest first
+	Goroutines []Goroutine
+	MemProf    *MemProf `json:",omitempty"` // with -memprofrate
+}
+
+// Packages classifies the edges by whether they stay within a
+// package; see analyze.PackageCoupling.
+type Packages struct {
+	Internal, External, Unknown int
+	Refs                        []analyze.PackageRef // the -n pairs of packages retaining the most
+}
+
+// MemProf reconciles the memory profile with the live objects; see
+// analyze.Accounting.
+type MemProf struct {
+	Orphans, Dangling int
+	Strict            bool
+	Unsampled         int
+	UnsampledBytes    uint64
+	Discrepancies     int
+	Sites             []MemProfSite // the -n sites with problems, then the largest
+	// SiteOwners and Owners cross the allocation sites of the sampled
+	// objects with the objects retaining them, the -n sites and
+	// owners with the most sampled bytes; see analyze.AllocOwners.
+	SiteOwners []SiteOwners
+	Owners     []Owner
+}
+
+// SiteOwners lists the objects retaining an allocation site's sampled
+// objects, the -n with the most bytes.
+type SiteOwners struct {
+	Site    string
+	Objects int
+	Bytes   uint64
+	Owners  []Share
+}
+
+// An Owner lists the allocation sites of the sampled objects an object
+// retains, the -n with the most bytes.
+type Owner struct {
+	Owner   Object
+	Objects int
+	Bytes   uint64
+	Sites   []Share
+}
+
+// A Share is the sampled objects from one site retained by one owner.
+type Share struct {
+	Site    string  `json:",omitempty"` // in an Owner's list
+	Owner   *Object `json:",omitempty"` // in a site's list
+	Objects int
+	Bytes   uint64
+}
+
+// A MemProfSite is an allocation site of the memory profile; see
+// analyze.SiteAccount.
+type MemProfSite struct {
+	Site         string
+	Stack        []read.MemProfFrame
+	InUse        int64
+	Live         int
+	Bytes        uint64
+	Estimate     float64
+	UnderSampled bool
+	Problems     []string
+}
+
+// A Finding is a problem found by one of the detectors of
+// analyze.RunFindings.
+type Finding struct {
+	Severity string // info, warning or critical
+	Detector string
+	Summary  string
+	Bytes    uint64   // memory involved, if the finding is about memory
+	Objects  []string // addresses of example objects
+	Evidence []string
+}
+
+// Graph summarizes the shape of the object graph; see
+// analyze.GraphMetrics.
+type Graph struct {
+	Edges, Roots     int
+	MeanInDegree     float64
+	MaxInDegree      int
+	MeanOutDegree    float64
+	MaxOutDegree     int
+	MeanDepth        float64
+	MaxDepth         int
+	Unreachable      int
+	Components       int
+	InDegree         []int // power-of-two histograms; see analyze.Histogram
+	OutDegree, Depth []int
+}
+
+// Garbage counts the objects no root reaches; see
+// analyze.FindGarbage.
+type Garbage struct {
+	Objects int
+	Bytes   uint64
+	Types   []GarbageType // the -n types with the most bytes, largest first
+}
+
+// A GarbageType counts the unreachable objects of one type.
+type GarbageType struct {
+	Type    string
+	Objects int
+	Bytes   uint64
+	Largest string // address of the largest of them
+}
+
+// A Buffer counts the large byte buffers of one kind.
+type Buffer struct {
+	Kind    string
+	Objects int
+	Bytes   uint64
+}
+
+// A Type counts the objects of one type.
+type Type struct {
+	Name     string
+	Count    uint64
+	Bytes    uint64
+	Retained uint64 // see analyze.RetainedByType
+}
+
+// An Object is one of the objects retaining the most memory.
+type Object struct {
+	Addr     string
+	Name     string // a path naming the object, as analyze.Namer gives
+	Type     string
+	Retained uint64
+}
+
+// A Goroutine describes one goroutine.
+type Goroutine struct {
+	Goid   uint64
+	Status string
+	Gopc   string   // pc of the go statement which created it
+	Stack  []string // function names, innermost first
+}
+
+// summarize computes the Summary of d.
+func summarize(d *read.Dump) *Summary {
+	s := &Summary{
+		Heap:       d.HeapEnd - d.HeapStart,
+		Objects:    d.NumObjects(),
+		Memstats:   d.Memstats,
+		Findings:   []Finding{},
+		Buffers:    []Buffer{},
+		Types:      []Type{},
+		Largest:    []Object{},
+		Goroutines: []Goroutine{},
+	}
+	if d.Window() != d.HeapRange() {
+		s.Window = d.Window().String()
+	}
+	s.Arch = d.Arch.String()
+	s.Compat = d.Compat()
+	if *class && !analyze.SizeClassesCover(d) {
+		s.Compat.Issues = append(s.Compat.Issues[:len(s.Compat.Issues):len(s.Compat.Issues)], "size classes are those of go1.4 and go1.5, not necessarily of "+s.Compat.Format)
+	}
+	have, lack := d.Features()
+	for _, f := range have {
+		s.Features = append(s.Features, f.String())
+	}
+	for _, f := range lack {
+		s.Unavailable = append(s.Unavailable, f.String())
+	}
+	s.RuntimeChecks = d.RuntimeChecks()
+	s.Truncation = d.Truncation()
+	for i := 0; i < d.NumObjects(); i++ {
+		s.Bytes += d.Size(read.ObjId(i))
+	}
+
+	s.Compaction = analyze.SimulateCompaction(d, false)
+	s.Fragmentation = s.Compaction.Fragmentation()
+
+	for _, f := range analyze.RunFindings(d) {
+		e := Finding{f.Severity.String(), f.Detector, f.Summary, f.Bytes, []string{}, f.Evidence}
+		for _, x := range f.Objects {
+			e.Objects = append(e.Objects, fmt.Sprintf("%x", d.Addr(x)))
+		}
+		if e.Evidence == nil {
+			e.Evidence = []string{}
+		}
+		s.Findings = append(s.Findings, e)
+	}
+
+	g := analyze.Metrics(d)
+	s.Graph = Graph{
+		Edges:         g.Edges,
+		Roots:         g.Roots,
+		MeanInDegree:  g.InDegree.Mean(),
+		MaxInDegree:   g.InDegree.Max,
+		MeanOutDegree: g.OutDegree.Mean(),
+		MaxOutDegree:  g.OutDegree.Max,
+		MeanDepth:     g.Depth.Mean(),
+		MaxDepth:      g.Depth.Max,
+		Unreachable:   g.Unreachable,
+		Components:    g.Components,
+		InDegree:      g.InDegree.Buckets,
+		OutDegree:     g.OutDegree.Buckets,
+		Depth:         g.Depth.Buckets,
+	}
+
+	gb := analyze.FindGarbage(d)
+	s.Garbage = Garbage{gb.Objects, gb.Bytes, []GarbageType{}}
+	for i, t := range gb.Types {
+		if i == *top {
+			break
+		}
+		s.Garbage.Types = append(s.Garbage.Types, GarbageType{t.Type, t.Objects, t.Bytes, fmt.Sprintf("%x", d.Addr(t.Largest))})
+	}
+
+	for _, b := range analyze.ClassifyBuffers(d, nil) {
+		if b.Objects > 0 {
+			s.Buffers = append(s.Buffers, Buffer{b.Kind.String(), b.Objects, b.Bytes})
+		}
+	}
+	if ps := stringPrefixes(d); ps.Strings > 0 {
+		s.Strings = ps
+	}
+	if pc := analyze.PackageEdges(d); pc.Edges[analyze.EdgeInternal]+pc.Edges[analyze.EdgeExternal] > 0 {
+		refs := pc.Refs
+		if len(refs) > *top {
+			refs = refs[:*top]
+		}
+		s.Packages = &Packages{pc.Edges[analyze.EdgeInternal], pc.Edges[analyze.EdgeExternal], pc.Edges[analyze.EdgeUnknown], refs}
+	}
+	s.Sizes = sizes(d)
+	if s.Sizes == nil {
+		s.Sizes = []analyze.SizeBin{}
+	}
+	for _, e := range typeSizes(d) {
+		s.Types = append(s.Types, Type{e.ft.Name, e.count, e.bytes, e.retained})
+	}
+	s.Anonymous = blameAnonymous(d)
+	if s.Anonymous == nil {
+		s.Anonymous = []analyze.TypeBlame{}
+	}
+	names := analyze.NewNamer(d)
+	for _, x := range largest(d) {
+		s.Largest = append(s.Largest, Object{fmt.Sprintf("%x", d.Addr(x)), names.Name(x), d.Ft(x).Name, d.RetainedSize(x)})
+	}
+	for _, gr := range d.Goroutines {
+		e := Goroutine{Goid: gr.Goid, Status: status(gr), Gopc: fmt.Sprintf("%#x", gr.Gopc)}
+		for f := gr.Bos; f != nil; f = f.Parent {
+			e.Stack = append(e.Stack, f.Name)
+		}
+		s.Goroutines = append(s.Goroutines, e)
+	}
+	if *mprate != 0 {
+		a := analyze.ReconcileMemProf(d, &analyze.AccountingOptions{Rate: *mprate})
+		m := &MemProf{a.Orphans, a.Dangling, a.Strict, a.Unsampled, a.UnsampledBytes, a.Discrepancies, []MemProfSite{}, []SiteOwners{}, []Owner{}}
+		for i, e := range a.Sites {
+			if i == *top {
+				break
+			}
+			p := e.Problems
+			if p == nil {
+				p = []string{}
+			}
+			m.Sites = append(m.Sites, MemProfSite{e.Site, e.Entry.Stack(), e.InUse, e.Live, e.Bytes, e.Estimate, e.UnderSampled, p})
+		}
+		o := analyze.FindAllocOwners(d)
+		object := func(c analyze.OwnerShare) Object {
+			return Object{fmt.Sprintf("%x", d.Addr(c.Owner)), c.Name, c.Type, d.RetainedSize(c.Owner)}
+		}
+		for i, e := range o.Sites {
+			if i == *top {
+				break
+			}
+			so := SiteOwners{e.Site, e.Objects, e.Bytes, []Share{}}
+			for j, c := range e.Owners {
+				if j == *top {
+					break
+				}
+				obj := object(c)
+				so.Owners = append(so.Owners, Share{Owner: &obj, Objects: c.Objects, Bytes: c.Bytes})
+			}
+			m.SiteOwners = append(m.SiteOwners, so)
+		}
+		for i, e := range o.Owners {
+			if i == *top {
+				break
+			}
+			ow := Owner{object(e.Sites[0]), e.Objects, e.Bytes, []Share{}}
+			for j, c := range e.Sites {
+				if j == *top {
+					break
+				}
+				ow.Sites = append(ow.Sites, Share{Site: c.Site, Objects: c.Objects, Bytes: c.Bytes})
+			}
+			m.Owners = append(m.Owners, ow)
+		}
+		s.MemProf = m
+	}
+	return s
+}
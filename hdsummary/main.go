@@ -0,0 +1,458 @@
+// hdsummary prints a plain-text summary of a heap dump: problems found
+// by the detectors of analyze.RunFindings, memory statistics and how
+// much compacting the heap would save, the shape of the object graph,
+// what holds large byte buffers, references between packages, object
+// sizes, the largest types and objects, and the goroutines.  With
+// -memprofrate, it also reconciles the memory profile with the live
+// objects and lists which objects retain each site's allocations, and
+// with -window, it summarizes only part of the heap.  It works without
+// the executable, so it can run in automated pipelines which only have
+// the dump; it says which features were unavailable.
+//
+// With -json, it writes the same information as a Summary.  With
+// -bundle, it writes a support bundle instead (see export.WriteBundle):
+// the dump with its data redacted, and the Summary and findings of the
+// redacted dump, for attaching to bug reports.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/randall77/heapdump14/analyze"
+	"github.com/randall77/heapdump14/export"
+	"github.com/randall77/heapdump14/format"
+	"github.com/randall77/heapdump14/read"
+)
+
+var (
+	top    = flag.Int("n", 20, "number of types and objects to list")
+	class  = flag.Bool("sizeclasses", false, "count objects by runtime size class instead of power-of-two size")
+	mprate = flag.Int("memprofrate", 0, "reconcile the memory profile with the live objects, for a process with this runtime.MemProfRate (1 for strict accounting)")
+	fmtr   = format.Flags()
+	asJSON = format.JSONFlag()
+	symdir = flag.String("symdir", "", "directories, separated as in $PATH, to find executables in by build ID when none are given")
+	window = flag.String("window", "", "summarize only the objects starting in this address range, given as lo-hi in hex")
+	bundle = flag.String("bundle", "", "write a support bundle, with the dump's data redacted, to this .tar.gz file")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr,
+		"usage: hdsummary [flags] heapdump [executable [plugin@loadaddr ...]]\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 1 {
+		usage()
+	}
+	opts := read.Options{SymbolDirs: filepath.SplitList(*symdir)}
+	if *window != "" {
+		r, err := read.ParseAddrRange(*window)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Window = r
+	}
+	for _, a := range args[1:] {
+		e, err := read.ParseExecutable(a)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Executables = append(opts.Executables, e)
+	}
+	d := read.ReadWithOptions(args[0], &opts)
+	d.ComputeDominators()
+
+	if *bundle != "" {
+		f, err := os.Create(*bundle)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = export.WriteBundle(f, d, &export.BundleOptions{
+			Executables: opts.Executables,
+			Summary: func(d *read.Dump) interface{} {
+				d.ComputeDominators()
+				return summarize(d)
+			},
+		})
+		if err == nil {
+			err = f.Close()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *asJSON {
+		if err := format.WriteJSON(os.Stdout, summarize(d)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var total uint64
+	for i := 0; i < d.NumObjects(); i++ {
+		total += d.Size(read.ObjId(i))
+	}
+	fmtr.Total = total
+
+	compat := d.Compat()
+	fmt.Printf("arch: %v, %d-byte pointers\n", d.Arch, d.PtrSize)
+	fmt.Printf("written by: %s runtime, %d cpus", compat.Format, compat.Ncpu)
+	if len(compat.Experiments) > 0 {
+		fmt.Printf(", GOEXPERIMENT=%s", strings.Join(compat.Experiments, ","))
+	}
+	fmt.Println()
+	for _, i := range compat.Issues {
+		fmt.Printf("  compat: %s\n", i)
+	}
+	if *class && !analyze.SizeClassesCover(d) {
+		fmt.Printf("  compat: size classes are those of go1.4 and go1.5, not necessarily of %s\n", compat.Format)
+	}
+	have, lack := d.Features()
+	fmt.Printf("features: %v\n", have)
+	if len(lack) > 0 {
+		fmt.Printf("unavailable: %v\n", lack)
+	}
+	if t := d.Truncation(); t != nil {
+		fmt.Printf("truncated: after %s of the dump; objects cover %s of the heap's %s\n",
+			fmtr.Bytes(uint64(t.Offset)), fmtr.Bytes(t.HeapCovered), fmtr.Bytes(t.HeapSize))
+		if len(t.Missing) > 0 {
+			fmt.Printf("  missing: %s records\n", strings.Join(t.Missing, ", "))
+		}
+	}
+	if cs := d.RuntimeChecks(); len(cs) > 0 {
+		agree := 0
+		for _, c := range cs {
+			if c.OK {
+				agree++
+			}
+		}
+		fmt.Printf("runtime globals: %d of %d checks agree\n", agree, len(cs))
+		for _, c := range cs {
+			if !c.OK {
+				fmt.Printf("  %v\n", c)
+			}
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	if fs := analyze.RunFindings(d); len(fs) > 0 {
+		fmt.Fprintf(w, "\nfindings\n")
+		for _, f := range fs {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", f.Severity, f.Detector, f.Summary)
+			for _, e := range f.Evidence {
+				fmt.Fprintf(w, "\t\t  %s\n", e)
+			}
+		}
+		w.Flush()
+	}
+	fmt.Fprintf(w, "\nheap\t%s\n", fmtr.Bytes(d.HeapEnd-d.HeapStart))
+	if d.Window() != d.HeapRange() {
+		fmt.Fprintf(w, "window\t%v, %s\n", d.Window(), fmtr.Bytes(d.Window().Hi-d.Window().Lo))
+	}
+	fmt.Fprintf(w, "objects\t%s in %s\n", fmtr.Bytes(total), fmtr.Count(uint64(d.NumObjects())))
+	if m := d.Memstats; m != nil {
+		fmt.Fprintf(w, "alloc\t%s\n", fmtr.Bytes(m.Alloc))
+		fmt.Fprintf(w, "sys\t%s\n", fmtr.Bytes(m.Sys))
+		fmt.Fprintf(w, "next gc\t%s\n", fmtr.Bytes(m.NextGC))
+		fmt.Fprintf(w, "gcs\t%s\n", fmtr.Count(uint64(m.NumGC)))
+	}
+	c := analyze.SimulateCompaction(d, false)
+	fmt.Fprintf(w, "pages\t%s in %s pages\n", fmtr.Bytes(c.Pages*analyze.PageSize), fmtr.Count(c.Pages))
+	fmt.Fprintf(w, "compacted\t%s in %s pages, %.1f%% fragmentation\n", fmtr.Bytes(c.Compacted), fmtr.Count(c.CompactedPages), 100*c.Fragmentation())
+	w.Flush()
+
+	g := analyze.Metrics(d)
+	fmt.Fprintf(w, "\nedges\t%s from %s roots\n", fmtr.Count(uint64(g.Edges)), fmtr.Count(uint64(g.Roots)))
+	fmt.Fprintf(w, "in-degree\tmean %.1f, max %d\n", g.InDegree.Mean(), g.InDegree.Max)
+	fmt.Fprintf(w, "out-degree\tmean %.1f, max %d\n", g.OutDegree.Mean(), g.OutDegree.Max)
+	fmt.Fprintf(w, "depth\tmean %.1f, max %d\n", g.Depth.Mean(), g.Depth.Max)
+	fmt.Fprintf(w, "unreachable\t%s\n", fmtr.Count(uint64(g.Unreachable)))
+	fmt.Fprintf(w, "components\t%s\n", fmtr.Count(uint64(g.Components)))
+	w.Flush()
+
+	if gb := analyze.FindGarbage(d); gb.Objects > 0 {
+		fmt.Fprintf(w, "\ngarbage\tcount\tbytes\theap\tlargest\n")
+		for i, t := range gb.Types {
+			if i == *top {
+				break
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%x\n", t.Type, fmtr.Count(uint64(t.Objects)), fmtr.Bytes(t.Bytes), fmtr.Percent(t.Bytes), d.Addr(t.Largest))
+		}
+		fmt.Fprintf(w, "total\t%s\t%s\t%s\t\n", fmtr.Count(uint64(gb.Objects)), fmtr.Bytes(gb.Bytes), fmtr.Percent(gb.Bytes))
+		w.Flush()
+	}
+
+	header := "\nbuffers\tcount\tbytes\theap\n"
+	for _, b := range analyze.ClassifyBuffers(d, nil) {
+		if b.Objects > 0 {
+			fmt.Fprint(w, header)
+			header = ""
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", b.Kind, fmtr.Count(uint64(b.Objects)), fmtr.Bytes(b.Bytes), fmtr.Percent(b.Bytes))
+		}
+	}
+	w.Flush()
+
+	if ps := stringPrefixes(d); len(ps.Clusters) > 0 {
+		fmt.Fprintf(w, "\nstring prefix\tstrings\tbytes\theap\texample\n")
+		for _, c := range ps.Clusters {
+			fmt.Fprintf(w, "%q\t%s\t%s\t%s\t%q\n", c.Prefix, fmtr.Count(uint64(c.Strings)), fmtr.Bytes(c.Bytes), fmtr.Percent(c.Bytes), c.Example)
+		}
+		fmt.Fprintf(w, "unclustered\t%s\t%s\t%s\t\n", fmtr.Count(uint64(ps.Unclustered)), fmtr.Bytes(ps.UnclusteredBytes), fmtr.Percent(ps.UnclusteredBytes))
+		w.Flush()
+	}
+
+	if pc := analyze.PackageEdges(d); pc.Edges[analyze.EdgeInternal]+pc.Edges[analyze.EdgeExternal] > 0 {
+		fmt.Fprintf(w, "\npackage edges\t%s internal, %s external, %s unknown\n",
+			fmtr.Count(uint64(pc.Edges[analyze.EdgeInternal])), fmtr.Count(uint64(pc.Edges[analyze.EdgeExternal])), fmtr.Count(uint64(pc.Edges[analyze.EdgeUnknown])))
+		w.Flush()
+		if len(pc.Refs) > 0 {
+			fmt.Fprintf(w, "\nfrom\tto\tedges\tbytes\tretained\n")
+			for i, r := range pc.Refs {
+				if i == *top {
+					break
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.From, r.To, fmtr.Count(uint64(r.Edges)), fmtr.Bytes(r.Bytes), fmtr.Bytes(r.Retained))
+			}
+			w.Flush()
+		}
+	}
+
+	fmt.Fprintf(w, "\nsizes\tcount\tbytes\theap\n")
+	for _, b := range sizes(d) {
+		if b.Count > 0 {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", sizeRange(b), fmtr.Count(uint64(b.Count)), fmtr.Bytes(b.Bytes), fmtr.Percent(b.Bytes))
+		}
+	}
+	w.Flush()
+
+	byType := typeSizes(d)
+	fmt.Fprintf(w, "\ntype\tcount\tbytes\theap\tretained\n")
+	for _, e := range byType {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.ft.Name, fmtr.Count(e.count), fmtr.Bytes(e.bytes), fmtr.Percent(e.bytes), fmtr.Bytes(e.retained))
+	}
+	w.Flush()
+
+	if bs := blameAnonymous(d); len(bs) > 0 {
+		fmt.Fprintf(w, "\nanonymous type\tcount\tbytes\theap\n")
+		for _, b := range bs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", b.Type, fmtr.Count(uint64(b.Objects)), fmtr.Bytes(b.Bytes), fmtr.Percent(b.Bytes))
+			for _, r := range b.Referrers {
+				fmt.Fprintf(w, "  from %s\t%s\t%s\t\n", r.Name, fmtr.Count(uint64(r.Objects)), fmtr.Bytes(r.Bytes))
+			}
+			for _, s := range b.Sites {
+				fmt.Fprintf(w, "  allocated at %s\t%s\t%s\t\n", s.Name, fmtr.Count(uint64(s.Objects)), fmtr.Bytes(s.Bytes))
+			}
+		}
+		w.Flush()
+	}
+
+	// Objects by retained size.
+	names := analyze.NewNamer(d)
+	fmt.Fprintf(w, "\nobject\tname\ttype\tretained\theap\n")
+	for _, x := range largest(d) {
+		r := d.RetainedSize(x)
+		fmt.Fprintf(w, "%x\t%s\t%s\t%s\t%s\n", d.Addr(x), names.Name(x), d.Ft(x).Name, fmtr.Bytes(r), fmtr.Percent(r))
+	}
+	w.Flush()
+
+	if *mprate != 0 {
+		a := analyze.ReconcileMemProf(d, &analyze.AccountingOptions{Rate: *mprate})
+		fmt.Fprintf(w, "\nmemprof\t%s sites, %s with discrepancies\n", fmtr.Count(uint64(len(a.Sites))), fmtr.Count(uint64(a.Discrepancies)))
+		fmt.Fprintf(w, "samples\t%s without a profile entry, %s not at a live object\n", fmtr.Count(uint64(a.Orphans)), fmtr.Count(uint64(a.Dangling)))
+		if a.Strict {
+			fmt.Fprintf(w, "unsampled\t%s in %s\n", fmtr.Bytes(a.UnsampledBytes), fmtr.Count(uint64(a.Unsampled)))
+		}
+		w.Flush()
+		fmt.Fprintf(w, "\nsite\tin use\tlive\testimate\tproblems\n")
+		for i, s := range a.Sites {
+			if i == *top {
+				break
+			}
+			est := fmtr.BytesFloat(s.Estimate)
+			if s.UnderSampled {
+				est += " (few samples)"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", s.Site, s.InUse, fmtr.Count(uint64(s.Live)), est, strings.Join(s.Problems, "; "))
+		}
+		w.Flush()
+
+		printAllocOwners(w, d)
+	}
+
+	fmt.Fprintf(w, "\ngoroutine\tstatus\tcreated at\tstack\n")
+	for _, g := range d.Goroutines {
+		stack := ""
+		if g.Bos != nil {
+			stack = g.Bos.Name
+			if g.Bos.Parent != nil {
+				stack += " ..."
+			}
+		}
+		fmt.Fprintf(w, "%d\t%s\t%#x\t%s\n", g.Goid, status(g), g.Gopc, stack)
+	}
+	w.Flush()
+}
+
+// printAllocOwners prints which objects keep the sampled allocations of
+// each site alive, and where the sampled objects each retains were
+// allocated.
+func printAllocOwners(w *tabwriter.Writer, d *read.Dump) {
+	o := analyze.FindAllocOwners(d)
+	if len(o.Sites) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\nsite\tretained by\tsampled\tbytes\n")
+	for i, s := range o.Sites {
+		if i == *top {
+			break
+		}
+		for j, c := range s.Owners {
+			if j == 3 {
+				fmt.Fprintf(w, "\t... %d more\t\t\n", len(s.Owners)-j)
+				break
+			}
+			site := s.Site
+			if j > 0 {
+				site = ""
+			}
+			fmt.Fprintf(w, "%s\t%x %s\t%s\t%s\n", site, d.Addr(c.Owner), c.Name, fmtr.Count(uint64(c.Objects)), fmtr.Bytes(c.Bytes))
+		}
+	}
+	w.Flush()
+	fmt.Fprintf(w, "\nowner\ttype\tretained\tallocated at\tsampled\tbytes\n")
+	for i, x := range o.Owners {
+		if i == *top {
+			break
+		}
+		for j, c := range x.Sites {
+			if j == 3 {
+				fmt.Fprintf(w, "\t\t\t... %d more\t\t\n", len(x.Sites)-j)
+				break
+			}
+			if j == 0 {
+				fmt.Fprintf(w, "%x %s\t%s\t%s", d.Addr(x.Owner), x.Name, x.Type, fmtr.Bytes(x.Retained))
+			} else {
+				fmt.Fprintf(w, "\t\t")
+			}
+			fmt.Fprintf(w, "\t%s\t%s\t%s\n", c.Site, fmtr.Count(uint64(c.Objects)), fmtr.Bytes(c.Bytes))
+		}
+	}
+	if o.Unreachable > 0 {
+		fmt.Fprintf(w, "unreachable\t\t\t\t%s\t%s\n", fmtr.Count(uint64(o.Unreachable)), fmtr.Bytes(o.UnreachableBytes))
+	}
+	w.Flush()
+}
+
+// stringPrefixes clusters the live strings by prefix, keeping the -n
+// clusters with the most bytes.
+func stringPrefixes(d *read.Dump) *analyze.PrefixSummary {
+	ps := analyze.StringPrefixes(d, analyze.PrefixOptions{})
+	if len(ps.Clusters) > *top {
+		ps.Clusters = ps.Clusters[:*top]
+	}
+	return ps
+}
+
+// blameAnonymous describes the -n largest anonymous types by their
+// top three referrers and allocation sites.
+func blameAnonymous(d *read.Dump) []analyze.TypeBlame {
+	if *top <= 0 {
+		return nil
+	}
+	return analyze.BlameAnonymous(d, &analyze.BlameOptions{Types: *top, Shares: 3})
+}
+
+// sizes counts the objects by size, as -sizeclasses asks.
+func sizes(d *read.Dump) []analyze.SizeBin {
+	if *class {
+		return analyze.SizeClassSizes(d, nil)
+	}
+	return analyze.PowerOfTwoSizes(d, nil)
+}
+
+// A typeSize is the number and total size of the objects of a type,
+// and the bytes they retain; see analyze.RetainedByType.
+type typeSize struct {
+	ft       *read.FullType
+	count    uint64
+	bytes    uint64
+	retained uint64
+}
+
+// typeSizes returns the -n types with the most bytes, largest first.
+func typeSizes(d *read.Dump) []typeSize {
+	byType := make([]typeSize, len(d.FTList))
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		e := &byType[d.Ft(x).Id]
+		e.ft = d.Ft(x)
+		e.count++
+		e.bytes += d.Size(x)
+	}
+	for _, r := range analyze.RetainedByType(d) {
+		byType[r.Type.Id].retained = r.Retained
+	}
+	sort.SliceStable(byType, func(i, j int) bool { return byType[i].bytes > byType[j].bytes })
+	for i, e := range byType {
+		if i == *top || e.count == 0 {
+			return byType[:i]
+		}
+	}
+	return byType
+}
+
+// largest returns the -n objects with the largest retained sizes,
+// largest first.
+func largest(d *read.Dump) []read.ObjId {
+	objs := make([]read.ObjId, d.NumObjects())
+	for i := range objs {
+		objs[i] = read.ObjId(i)
+	}
+	sort.SliceStable(objs, func(i, j int) bool { return d.RetainedSize(objs[i]) > d.RetainedSize(objs[j]) })
+	if *top >= 0 && len(objs) > *top {
+		objs = objs[:*top]
+	}
+	return objs
+}
+
+// sizeRange describes the sizes counted in bin b.
+func sizeRange(b analyze.SizeBin) string {
+	switch {
+	case b.Min == b.Max:
+		return fmt.Sprint(b.Min)
+	case b.Max == ^uint64(0):
+		return fmt.Sprintf("%d+", b.Min)
+	}
+	return fmt.Sprintf("%d-%d", b.Min, b.Max)
+}
+
+// status describes the state of goroutine g.
+func status(g *read.GoRoutine) string {
+	switch g.Status {
+	case 0:
+		return "idle"
+	case 1:
+		return "runnable"
+	case 3:
+		return "syscall"
+	case 4:
+		return g.WaitReason
+	case 5:
+		return "dead"
+	}
+	return fmt.Sprintf("status %d", g.Status)
+}
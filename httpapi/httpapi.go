@@ -0,0 +1,459 @@
+// Package httpapi serves a read-only JSON API for exploring a heap
+// dump, so that existing servers, like internal admin servers, can
+// mount dump exploration endpoints without running hview.
+//
+//	http.Handle("/heap/", http.StripPrefix("/heap", httpapi.Handler(d)))
+//
+// The endpoints, all GET, are:
+//
+//	/summary                   heap size, object count, features and warnings
+//	/types                     types, largest total size first
+//	/objects?type=ID&limit=N   objects of a type
+//	/object?id=ID or ?addr=HEX an object's fields, referrers, retained size and depth
+//	/path?id=ID                a shortest path from a root to an object
+//	/goroutines                goroutines and their stacks
+//	/search?q=REGEXP&limit=N   objects containing matching text
+//	/find?q=WORDS&limit=N      types, fields, functions and strings with the words
+//
+// Errors are reported as {"error": "..."} with a 4xx status.
+//
+// /summary and /types are computed once, and sent with their
+// analyze.Handle, like "types@gen1", as the ETag, so clients can
+// revalidate them with If-None-Match.
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/randall77/heapdump14/analyze"
+	"github.com/randall77/heapdump14/binutil"
+	"github.com/randall77/heapdump14/read"
+)
+
+// Handler returns a handler serving the API for d.  It computes the
+// indexes the API needs up front.  A Dump is not safe for concurrent
+// use, so requests are served one at a time.
+func Handler(d *read.Dump) http.Handler {
+	d.ComputeReferrers()
+	d.ComputeDominators()
+	d.ComputeDepths()
+	s := &server{d: d, mux: http.NewServeMux(), results: analyze.NewResultCache(d)}
+	s.handle("/summary", s.summary)
+	s.handle("/types", s.types)
+	s.handle("/objects", s.objects)
+	s.handle("/object", s.object)
+	s.handle("/path", s.path)
+	s.handle("/goroutines", s.goroutines)
+	s.handle("/search", s.search)
+	s.handle("/find", s.find)
+	return s.mux
+}
+
+type server struct {
+	mu      sync.Mutex
+	d       *read.Dump
+	mux     *http.ServeMux
+	index   *analyze.SearchIndex // built by the first /find
+	results *analyze.ResultCache
+}
+
+// A handlerFunc computes the result of a request, to be written as
+// JSON, or returns an error.
+type handlerFunc func(r *http.Request) (interface{}, error)
+
+// A requestError is an error with an HTTP status.
+type requestError struct {
+	status int
+	msg    string
+}
+
+func (e *requestError) Error() string { return e.msg }
+
+func badRequest(format string, args ...interface{}) error {
+	return &requestError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &requestError{http.StatusNotFound, fmt.Sprintf(format, args...)}
+}
+
+func (s *server) handle(path string, h handlerFunc) {
+	s.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "read-only API"})
+			return
+		}
+		s.mu.Lock()
+		v, err := h(r)
+		s.mu.Unlock()
+		if err != nil {
+			status := http.StatusInternalServerError
+			if e, ok := err.(*requestError); ok {
+				status = e.status
+			}
+			writeJSON(w, status, map[string]string{"error": err.Error()})
+			return
+		}
+		if c, ok := v.(cached); ok {
+			etag := strconv.Quote(c.h.String())
+			w.Header().Set("ETag", etag)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			v = c.v
+		}
+		writeJSON(w, http.StatusOK, v)
+	})
+}
+
+// A cached is a result from s.results, which handle sends with its
+// handle as the ETag.
+type cached struct {
+	h analyze.Handle
+	v interface{}
+}
+
+// get returns the result named name from s.results, computing it with
+// compute if need be.
+func (s *server) get(name string, compute func(d *read.Dump) interface{}) cached {
+	h, v := s.results.Get(name, nil, compute)
+	return cached{h, v}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// intParam returns the integer query parameter name, or def if it is
+// missing.
+func intParam(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, badRequest("bad %s %q", name, v)
+	}
+	return n, nil
+}
+
+// objParam returns the object named by the id or addr query parameter.
+func (s *server) objParam(r *http.Request) (read.ObjId, error) {
+	q := r.URL.Query()
+	if v := q.Get("addr"); v != "" {
+		a, err := strconv.ParseUint(v, 16, 64)
+		if err != nil {
+			return 0, badRequest("bad addr %q", v)
+		}
+		x := s.d.FindObj(a)
+		if x == read.ObjNil {
+			return 0, notFound("no object at %x", a)
+		}
+		return x, nil
+	}
+	v := q.Get("id")
+	if v == "" {
+		return 0, badRequest("id or addr parameter missing")
+	}
+	id, err := strconv.Atoi(v)
+	if err != nil || id < 0 {
+		return 0, badRequest("bad id %q", v)
+	}
+	if id >= s.d.NumObjects() {
+		return 0, notFound("no object %d", id)
+	}
+	return read.ObjId(id), nil
+}
+
+// An ObjRef identifies an object in results.
+type ObjRef struct {
+	Id   read.ObjId
+	Addr string // hex
+	Type string
+	Size uint64
+}
+
+func (s *server) ref(x read.ObjId) ObjRef {
+	return ObjRef{x, fmt.Sprintf("%x", s.d.Addr(x)), s.d.Ft(x).Name, s.d.Size(x)}
+}
+
+// A RootRef describes a root in results.
+type RootRef struct {
+	Kind   string
+	Name   string
+	Source string // where the root is, as read.Root.Source gives
+}
+
+// Summary is the result of /summary.
+type Summary struct {
+	HeapStart, HeapEnd string // hex
+	Objects            int
+	Bytes              uint64
+	Features           []string
+	Unavailable        []string
+	Warnings           []string
+}
+
+func (s *server) summary(r *http.Request) (interface{}, error) {
+	return s.get("summary", func(d *read.Dump) interface{} {
+		m := &Summary{
+			HeapStart: fmt.Sprintf("%x", d.HeapStart),
+			HeapEnd:   fmt.Sprintf("%x", d.HeapEnd),
+			Objects:   d.NumObjects(),
+			Warnings:  d.Warnings(),
+		}
+		for i := 0; i < d.NumObjects(); i++ {
+			m.Bytes += d.Size(read.ObjId(i))
+		}
+		have, lack := d.Features()
+		for _, f := range have {
+			m.Features = append(m.Features, f.String())
+		}
+		for _, f := range lack {
+			m.Unavailable = append(m.Unavailable, f.String())
+		}
+		return m
+	}), nil
+}
+
+// TypeEntry is an element of the result of /types.
+type TypeEntry struct {
+	Id    int
+	Name  string
+	Count int
+	Bytes uint64
+}
+
+func (s *server) types(r *http.Request) (interface{}, error) {
+	return s.get("types", func(d *read.Dump) interface{} {
+		t := make([]TypeEntry, len(d.FTList))
+		for i := 0; i < d.NumObjects(); i++ {
+			x := read.ObjId(i)
+			e := &t[d.Ft(x).Id]
+			e.Count++
+			e.Bytes += d.Size(x)
+		}
+		res := []TypeEntry{}
+		for i, e := range t {
+			if e.Count > 0 {
+				e.Id = i
+				e.Name = d.FTList[i].Name
+				res = append(res, e)
+			}
+		}
+		sort.SliceStable(res, func(i, j int) bool { return res[i].Bytes > res[j].Bytes })
+		return res
+	}), nil
+}
+
+func (s *server) objects(r *http.Request) (interface{}, error) {
+	d := s.d
+	v := r.URL.Query().Get("type")
+	id, err := strconv.Atoi(v)
+	if err != nil || id < 0 || id >= len(d.FTList) {
+		return nil, badRequest("bad type %q", v)
+	}
+	limit, err := intParam(r, "limit", 1000)
+	if err != nil {
+		return nil, err
+	}
+	ft := d.FTList[id]
+	res := []ObjRef{}
+	for i := 0; i < d.NumObjects() && len(res) < limit; i++ {
+		if x := read.ObjId(i); d.Ft(x) == ft {
+			res = append(res, s.ref(x))
+		}
+	}
+	return res, nil
+}
+
+// Object is the result of /object.
+type Object struct {
+	ObjRef
+	Fields    []FieldEntry
+	Referrers []ObjRef
+	Roots     []RootRef // roots pointing to the object
+	Retained  uint64
+	Depth     int // -1 if unreachable
+}
+
+// A FieldEntry is a field of an object.
+type FieldEntry struct {
+	Name   string
+	Offset uint64
+	Type   string
+	Value  string
+	To     *ObjRef `json:",omitempty"` // object the field points to
+}
+
+func (s *server) object(r *http.Request) (interface{}, error) {
+	d := s.d
+	x, err := s.objParam(r)
+	if err != nil {
+		return nil, err
+	}
+	o := &Object{
+		ObjRef:    s.ref(x),
+		Fields:    []FieldEntry{},
+		Referrers: []ObjRef{},
+		Roots:     []RootRef{},
+		Retained:  d.RetainedSize(x),
+		Depth:     d.Depth(x),
+	}
+	for _, v := range d.Describe(x) {
+		if v.Pad {
+			continue
+		}
+		f := FieldEntry{Name: v.Name, Offset: v.Offset, Type: v.Type, Value: v.String()}
+		if v.Edge != nil {
+			t := s.ref(v.Edge.To)
+			f.To = &t
+		}
+		o.Fields = append(o.Fields, f)
+	}
+	for _, y := range d.Referrers(x) {
+		o.Referrers = append(o.Referrers, s.ref(y))
+	}
+	for _, rt := range d.RootReferrers(x) {
+		o.Roots = append(o.Roots, RootRef{rt.Kind.String(), rt.Name, rt.Source()})
+	}
+	return o, nil
+}
+
+// PathResult is the result of /path.
+type PathResult struct {
+	Root RootRef
+	Objs []PathStep
+}
+
+// A PathStep is an object on a path, and the field of the previous
+// object (or of the root) pointing to it.
+type PathStep struct {
+	ObjRef
+	Field string
+}
+
+func (s *server) path(r *http.Request) (interface{}, error) {
+	d := s.d
+	x, err := s.objParam(r)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := d.PathToRoot(x)
+	if !ok {
+		return nil, notFound("object %d is unreachable", x)
+	}
+	res := &PathResult{Root: RootRef{p.Root.Kind.String(), p.Root.Name, p.Root.Source()}}
+	for i, y := range p.Objs {
+		e := p.Root.Edge
+		if i > 0 {
+			e = p.Edges[i-1]
+		}
+		res.Objs = append(res.Objs, PathStep{s.ref(y), e.FieldName})
+	}
+	return res, nil
+}
+
+// Goroutine is an element of the result of /goroutines.
+type Goroutine struct {
+	Goid       uint64
+	Addr       string // hex
+	Status     uint64
+	WaitReason string
+	Frames     []binutil.SourceFrame // innermost first
+}
+
+func (s *server) goroutines(r *http.Request) (interface{}, error) {
+	res := []Goroutine{}
+	for _, g := range s.d.Goroutines {
+		e := Goroutine{
+			Goid:       g.Goid,
+			Addr:       fmt.Sprintf("%x", g.Addr),
+			Status:     g.Status,
+			WaitReason: g.WaitReason,
+			Frames:     []binutil.SourceFrame{},
+		}
+		for f := g.Bos; f != nil; f = f.Parent {
+			e.Frames = append(e.Frames, f.SourceFrames()...)
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
+
+// SearchEntry is an element of the result of /search.
+type SearchEntry struct {
+	Obj      ObjRef
+	Offset   uint64
+	Encoding string
+	Preview  string
+}
+
+func (s *server) search(r *http.Request) (interface{}, error) {
+	limit, err := intParam(r, "limit", 100)
+	if err != nil {
+		return nil, err
+	}
+	m, err := analyze.Search(s.d, r.URL.Query().Get("q"), &analyze.SearchOptions{Limit: limit})
+	if err != nil {
+		return nil, badRequest("%v", err)
+	}
+	res := []SearchEntry{}
+	for _, x := range m {
+		res = append(res, SearchEntry{s.ref(x.Obj), x.Offset, x.Encoding.String(), x.Preview})
+	}
+	return res, nil
+}
+
+// FindEntry is an element of the result of /find.
+type FindEntry struct {
+	Kind  string // type, field, function or string
+	Text  string
+	Type  *TypeEntry `json:",omitempty"` // the type, or the type with the field or string
+	Field string     `json:",omitempty"` // the field holding the string
+	Obj   *ObjRef    `json:",omitempty"` // the first object holding the string
+	// Goroutines lists the goids of the goroutines running the
+	// function.
+	Goroutines []uint64 `json:",omitempty"`
+	Count      int
+}
+
+func (s *server) find(r *http.Request) (interface{}, error) {
+	limit, err := intParam(r, "limit", 100)
+	if err != nil {
+		return nil, err
+	}
+	if s.index == nil {
+		s.index = analyze.NewSearchIndex(s.d, &analyze.SearchIndexOptions{Strings: true})
+	}
+	res := []FindEntry{}
+	for _, h := range s.index.Search(r.URL.Query().Get("q"), limit) {
+		e := FindEntry{Kind: h.Kind.String(), Text: h.Text, Field: h.Field, Count: h.Count}
+		if h.Type != nil {
+			e.Type = &TypeEntry{Id: h.Type.Id, Name: h.Type.Name}
+			if h.Kind != analyze.HitString {
+				e.Type.Count, e.Type.Bytes = h.Count, h.Bytes
+			}
+		}
+		if h.Obj != read.ObjNil {
+			o := s.ref(h.Obj)
+			e.Obj = &o
+		}
+		for _, g := range h.Goroutines {
+			e.Goroutines = append(e.Goroutines, g.Goid)
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
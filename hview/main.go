@@ -1,18 +1,25 @@
 package main
 
 import (
+	"expvar"
 	"flag"
 	"fmt"
 	"html"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"runtime"
 	"runtime/debug"
 	"sort"
 	"strconv"
+	"strings"
 	"text/template"
 
+	"github.com/randall77/heapdump14/analyze"
+	"github.com/randall77/heapdump14/binutil"
+	"github.com/randall77/heapdump14/format"
+	"github.com/randall77/heapdump14/httpapi"
 	"github.com/randall77/heapdump14/read"
 )
 
@@ -22,9 +29,25 @@ const (
 )
 
 var (
-	httpAddr = flag.String("http", defaultAddr, "HTTP service address")
+	httpAddr     = flag.String("http", defaultAddr, "HTTP service address")
+	ifacePolicy  = flag.String("iface", "skip", "handling of interfaces with unknown types: skip, fail, or conservative")
+	conservative = flag.Bool("conservative", false, "scan objects without dwarf types and frames without pointer maps conservatively")
+	cacheMB      = flag.Int64("cache", 0, "megabytes of the dump file to cache in memory")
+	edgeMB       = flag.Int64("edgemem", 0, "megabytes to spend keeping pointers from stacks and globals, or -1 for none (0 means no limit)")
+	fmtr         = format.Flags()
+	indexProfile = flag.Bool("indexprofile", false, "profile lookups in the object index, and list its slowest buckets once the heap is analyzed")
+	symdir       = flag.String("symdir", "", "directories, separated as in $PATH, to find executables in by build ID when none are given")
+	indexDir     = flag.String("indexdir", "", "directory to keep the reverse edge and dominator indexes in across restarts")
 )
 
+// templateFuncs lets templates write sizes and counts as the
+// formatting flags ask.
+var templateFuncs = template.FuncMap{
+	"bytes":   func(n uint64) string { return fmtr.Bytes(n) },
+	"count":   func(n int) string { return fmtr.Count(uint64(n)) },
+	"percent": func(n uint64) string { return fmtr.Percent(n) },
+}
+
 // d is the loaded heap dump.
 var d *read.Dump
 
@@ -58,18 +81,6 @@ func edgeSource(x read.ObjId, e read.Edge) string {
 	return s
 }
 
-// the first d.PtrSize bytes of b contain a pointer.  Return html
-// to represent that pointer.
-func nonheapPtr(b []byte) string {
-	p := readPtr(b)
-	if p == 0 {
-		return "nil"
-	} else {
-		// TODO: look up symbol in executable
-		return fmt.Sprintf("outsideheap_%x", p)
-	}
-}
-
 // display field
 type Field struct {
 	Name  string
@@ -77,165 +88,37 @@ type Field struct {
 	Value string
 }
 
-// rawBytes generates an html string representing the given raw bytes
-func rawBytes(b []byte) string {
-	v := ""
-	s := ""
-	for _, c := range b {
-		v += fmt.Sprintf("%.2x ", c)
-		if c <= 32 || c >= 127 {
-			c = 46
-		}
-		s += fmt.Sprintf("%c", c)
-	}
-	return v + " | " + html.EscapeString(s)
-}
-
 // getFields uses the data in b to fill in the values for the given field list.
 // edges is a list of known connecting out edges.
 func getFields(b []byte, fields []read.Field, edges []read.Edge) []Field {
 	var r []Field
-	off := uint64(0)
-	for _, f := range fields {
-		if f.Offset < off {
-			log.Fatal("out of order fields")
+	for _, v := range d.DescribeFields(b, fields, edges) {
+		if v.Pad {
+			r = append(r, Field{fmt.Sprintf("<font color=LightGray>%s</font>", v.Name), "", ""})
+			continue
 		}
-		if f.Offset > off {
-			r = append(r, Field{fmt.Sprintf("<font color=LightGray>pad %d</font>", f.Offset-off), "", ""})
-			off = f.Offset
+		value := html.EscapeString(v.Value)
+		if v.Edge != nil {
+			value = edgeLink(*v.Edge)
 		}
-		var value string
-		var typ string
-		switch f.Kind {
-		case read.FieldKindBool:
-			if b[off] == 0 {
-				value = "false"
-			} else {
-				value = "true"
-			}
-			typ = "bool"
-			off++
-		case read.FieldKindUInt8:
-			value = fmt.Sprintf("%d", b[off])
-			typ = "uint8"
-			off++
-		case read.FieldKindSInt8:
-			value = fmt.Sprintf("%d", int8(b[off]))
-			typ = "int8"
-			off++
-		case read.FieldKindUInt16:
-			value = fmt.Sprintf("%d", d.Order.Uint16(b[off:]))
-			typ = "uint16"
-			off += 2
-		case read.FieldKindSInt16:
-			value = fmt.Sprintf("%d", int16(d.Order.Uint16(b[off:])))
-			typ = "int16"
-			off += 2
-		case read.FieldKindUInt32:
-			value = fmt.Sprintf("%d", d.Order.Uint32(b[off:]))
-			typ = "uint32"
-			off += 4
-		case read.FieldKindSInt32:
-			value = fmt.Sprintf("%d", int32(d.Order.Uint32(b[off:])))
-			typ = "int32"
-			off += 4
-		case read.FieldKindUInt64:
-			value = fmt.Sprintf("%d", d.Order.Uint64(b[off:]))
-			typ = "uint64"
-			off += 8
-		case read.FieldKindSInt64:
-			value = fmt.Sprintf("%d", int64(d.Order.Uint64(b[off:])))
-			typ = "int64"
-			off += 8
-		case read.FieldKindBytes4:
-			value = rawBytes(b[off : off+4])
-			typ = "raw bytes"
-			off += 4
-		case read.FieldKindBytes8:
-			value = rawBytes(b[off : off+8])
-			typ = "raw bytes"
-			off += 8
-		case read.FieldKindBytes16:
-			value = rawBytes(b[off : off+16])
-			typ = "raw bytes"
-			off += 16
-		case read.FieldKindPtr:
-			typ = "*" + f.BaseType
-			// TODO: get ptr base type somehow?  Also for slices,chans.
-			if len(edges) > 0 && edges[0].FromOffset == off {
-				value = edgeLink(edges[0])
-				edges = edges[1:]
-			} else {
-				value = nonheapPtr(b[off:])
-			}
-			off += d.PtrSize
-		case read.FieldKindIface:
-			// TODO: the itab part?
-			typ = "interface{...}" + f.BaseType
-			if len(edges) > 0 && edges[0].FromOffset == off+d.PtrSize {
-				value = edgeLink(edges[0])
-				edges = edges[1:]
-			} else {
-				// TODO: use itab to decide whether this is a
-				// pointer or a scalar.
-				value = nonheapPtr(b[off+d.PtrSize:])
-			}
-			off += 2 * d.PtrSize
-		case read.FieldKindEface:
-			// TODO: the type part
-			typ = "interface{}"
-			if len(edges) > 0 && edges[0].FromOffset == off+d.PtrSize {
-				value = edgeLink(edges[0])
-				edges = edges[1:]
-			} else {
-				// TODO: use type to decide whether this is a
-				// pointer or a scalar.
-				value = nonheapPtr(b[off+d.PtrSize:])
-			}
-			off += 2 * d.PtrSize
-		case read.FieldKindString:
-			typ = "string"
-			if len(edges) > 0 && edges[0].FromOffset == off {
-				value = edgeLink(edges[0])
-				edges = edges[1:]
-			} else {
-				value = nonheapPtr(b[off:])
-			}
-			value = fmt.Sprintf("%s/%d", value, readPtr(b[off+d.PtrSize:]))
-			off += 2 * d.PtrSize
-		case read.FieldKindSlice:
-			typ = "[]" + f.BaseType
-			if len(edges) > 0 && edges[0].FromOffset == off {
-				value = edgeLink(edges[0])
-				edges = edges[1:]
-			} else {
-				value = nonheapPtr(b[off:])
-			}
-			value = fmt.Sprintf("%s/%d/%d", value, readPtr(b[off+d.PtrSize:]), readPtr(b[off+2*d.PtrSize:]))
-			off += 3 * d.PtrSize
-		case read.FieldKindBytesElided:
-			typ = "raw bytes"
-			value = fmt.Sprintf("... %d elided bytes ...", uint64(len(b))-off)
-			off = uint64(len(b))
-		}
-		r = append(r, Field{f.Name, typ, value})
-	}
-	if uint64(len(b)) > off {
-		r = append(r, Field{fmt.Sprintf("<font color=LightGray>sizeclass pad %d</font>", uint64(len(b))-off), "", ""})
+		r = append(r, Field{v.Name, v.Type, value + v.Suffix})
 	}
 	return r
 }
 
 type objInfo struct {
 	Addr      uint64
+	Name      string // logical name, from the fields leading to the object
 	Typ       string
 	Size      uint64
 	Fields    []Field
 	Referrers []string
 	Dominates uint64
+	Precise   uint64 // bytes dominated using only precise edges
+	Depth     int    // distance from the roots, -1 if unreachable
 }
 
-var objTemplate = template.Must(template.New("obj").Parse(`
+var objTemplate = template.Must(template.New("obj").Funcs(templateFuncs).Parse(`
 <html>
 <head>
 <style>
@@ -253,7 +136,8 @@ border:1px solid grey;
 <body>
 <tt>
 <h2>Object {{printf "%x" .Addr}} : {{.Typ}}</h2>
-<h3>{{.Size}} bytes</h3>
+<h3>{{bytes .Size}}</h3>
+{{if .Name}}<h3>{{.Name}}</h3>{{end}}
 <table>
 <tr>
 <td>Field</td>
@@ -273,8 +157,11 @@ border:1px solid grey;
 {{.}}
 <br>
 {{end}}
+<h3>Depth</h3>
+{{if lt .Depth 0}}unreachable{{else}}{{.Depth}} pointers from the nearest root{{end}}
 <h3>Heap dominated by this object</h3>
-{{.Dominates}} bytes
+{{bytes .Dominates}} ({{percent .Dominates}} of live heap)
+{{if ne .Dominates .Precise}}({{bytes .Precise}} excluding conservative pointers){{end}}
 </tt>
 </body>
 </html>
@@ -293,13 +180,13 @@ func objHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if int(id) >= d.NumObjects() {
+	if id >= uint64(d.NumObjects()) {
 		http.Error(w, "object not found", 405)
 		return
 	}
 	x := read.ObjId(id)
 
-	fld := getFields(d.Contents(x), d.Ft(x).Fields, d.Edges(x))
+	fld := getFields(d.Contents(x), d.TypeFields(d.Ft(x)), d.Edges(x))
 	if len(fld) > maxFields {
 		msg := fmt.Sprintf("<font color=Red>elided for display: %d fields</font>", len(fld)-(maxFields-1))
 		fld = fld[:maxFields-1]
@@ -315,11 +202,14 @@ func objHandler(w http.ResponseWriter, r *http.Request) {
 
 	info := objInfo{
 		d.Addr(x),
+		html.EscapeString(names.Name(x)),
 		typeLink(d.Ft(x)),
 		d.Size(x),
 		fld,
 		ref,
-		domsize[x],
+		d.RetainedSize(x),
+		d.PreciseRetainedSize(x),
+		d.Depth(x),
 	}
 	if err := objTemplate.Execute(w, info); err != nil {
 		log.Print(err)
@@ -331,12 +221,16 @@ type objEntry struct {
 	Addr uint64
 }
 type typeInfo struct {
+	Id        int
 	Name      string
 	Size      uint64
+	Sizes     []analyze.SizeBin // sizes of instances, if they vary
+	Retained  uint64            // bytes freed if every instance were
 	Instances []string
+	Continue  string
 }
 
-var typeTemplate = template.Must(template.New("type").Parse(`
+var typeTemplate = template.Must(template.New("type").Funcs(templateFuncs).Parse(`
 <html>
 <head>
 <title>Type {{.Name}}</title>
@@ -345,12 +239,25 @@ var typeTemplate = template.Must(template.New("type").Parse(`
 <tt>
 <h2>{{.Name}}</h2>
 <h3>Size {{.Size}}</h3>
+<h3>Retained by all instances {{bytes .Retained}}</h3>
+{{if .Sizes}}
+<h3>Instance sizes</h3>
+<table>
+<tr><td>Sizes</td><td>Count</td><td>Bytes</td></tr>
+{{range .Sizes}}
+<tr><td>{{.Min}}-{{.Max}}</td><td>{{count .Count}}</td><td>{{bytes .Bytes}}</td></tr>
+{{end}}
+</table>
+{{end}}
 <h3>Instances</h3>
 <table>
 {{range .Instances}}
 <tr><td>{{.}}</td></tr>
 {{end}}
 </table>
+{{if .Continue}}
+<a href="type?id={{.Id}}&continue={{.Continue}}">more...</a>
+{{end}}
 </tt>
 </body>
 </html>
@@ -375,12 +282,23 @@ func typeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ft := d.FTList[id]
+	page, err := d.ListObjects(read.ObjectQuery{Type: ft, Limit: maxFields - 1}, q.Get("continue"))
+	if err != nil {
+		http.Error(w, err.Error(), 405)
+		return
+	}
 	var info typeInfo
+	info.Id = ft.Id
 	info.Name = ft.Name
 	info.Size = ft.Size
-	for _, x := range byType[ft.Id].objects {
+	if s := analyze.PowerOfTwoSizes(d, ft); len(s) > 1 {
+		info.Sizes = s
+	}
+	info.Retained = analyze.TypeUniqueRetained(d, ft)
+	for _, x := range page.Objs {
 		info.Instances = append(info.Instances, objLink(x))
 	}
+	info.Continue = page.Continue
 	if err := typeTemplate.Execute(w, info); err != nil {
 		log.Print(err)
 	}
@@ -392,7 +310,7 @@ type hentry struct {
 	Bytes uint64
 }
 
-var histoTemplate = template.Must(template.New("histo").Parse(`
+var histoTemplate = template.Must(template.New("histo").Funcs(templateFuncs).Parse(`
 <html>
 <head>
 <style>
@@ -413,16 +331,19 @@ border:1px solid grey;
 <col align="left">
 <col align="right">
 <col align="right">
+<col align="right">
 <tr>
 <td>Type</td>
 <td align="right">Count</td>
 <td align="right">Bytes</td>
+<td align="right">Heap</td>
 </tr>
 {{range .}}
 <tr>
 <td>{{.Name}}</td>
-<td align="right">{{.Count}}</td>
-<td align="right">{{.Bytes}}</td>
+<td align="right">{{count .Count}}</td>
+<td align="right">{{bytes .Bytes}}</td>
+<td align="right">{{percent .Bytes}}</td>
 </tr>
 {{end}}
 </table>
@@ -432,36 +353,311 @@ border:1px solid grey;
 `))
 
 func histoHandler(w http.ResponseWriter, r *http.Request) {
+	collapse := r.URL.Query().Get("collapse") != ""
+	_, s := results.Get("histogram", collapse, func(d *read.Dump) interface{} {
+		return histogram(collapse)
+	})
+	if err := histoTemplate.Execute(w, s); err != nil {
+		log.Print(err)
+	}
+}
+
+// histogram returns the types sorted by total size, with
+// instantiations of the same generic type together if collapse is set.
+func histogram(collapse bool) []hentry {
 	// build sorted list of types
 	var s []hentry
-	for id, b := range byType {
-		if b.bytes == 0 {
-			// This can happen for raw types that were superceded by dwarf types
-			continue
+	if collapse {
+		// Group instantiations of the same generic type together.
+		groups := map[string]*hentry{}
+		for id, b := range byType {
+			if len(b.objects) == 0 {
+				continue
+			}
+			name := analyze.CollapseInstantiations(d.FTList[id].Name)
+			h := groups[name]
+			if h == nil {
+				h = &hentry{Name: html.EscapeString(name)}
+				groups[name] = h
+			}
+			h.Count += len(b.objects)
+			h.Bytes += b.bytes
+		}
+		for _, h := range groups {
+			s = append(s, *h)
+		}
+	} else {
+		for id, b := range byType {
+			if len(b.objects) == 0 {
+				// This can happen for raw types that were superceded by dwarf types
+				continue
+			}
+			ft := d.FTList[id]
+			s = append(s, hentry{typeLink(ft), len(b.objects), b.bytes})
 		}
-		ft := d.FTList[id]
-		s = append(s, hentry{typeLink(ft), len(b.objects), b.bytes})
 	}
 	sort.Sort(ByBytes(s))
+	return s
+}
 
-	if err := histoTemplate.Execute(w, s); err != nil {
+type ByBytes []hentry
+
+func (a ByBytes) Len() int      { return len(a) }
+func (a ByBytes) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByBytes) Less(i, j int) bool {
+	if a[i].Bytes != a[j].Bytes {
+		return a[i].Bytes > a[j].Bytes
+	}
+	return a[i].Name < a[j].Name
+}
+
+type structEntry struct {
+	Kind   string
+	Type   string
+	Fields string
+	Head   string
+	Length int
+	Bytes  uint64
+	Owner  string
+}
+
+var structTemplate = template.Must(template.New("structures").Funcs(templateFuncs).Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Lists and trees</title>
+</head>
+<body>
+<tt>
+<table>
+<tr>
+<td>Kind</td>
+<td>Type</td>
+<td>Linked by</td>
+<td>Head</td>
+<td align="right">Length</td>
+<td align="right">Bytes</td>
+<td>Hanging off</td>
+</tr>
+{{range .}}
+<tr>
+<td>{{.Kind}}</td>
+<td>{{.Type}}</td>
+<td>{{.Fields}}</td>
+<td>{{.Head}}</td>
+<td align="right">{{count .Length}}</td>
+<td align="right">{{bytes .Bytes}}</td>
+<td>{{.Owner}}</td>
+</tr>
+{{end}}
+</table>
+</tt>
+</body>
+</html>
+`))
+
+func structHandler(w http.ResponseWriter, r *http.Request) {
+	opts := &analyze.StructureOptions{Limit: 100}
+	_, v := results.Get("structures", opts, func(d *read.Dump) interface{} {
+		return analyze.FindStructures(d, opts)
+	})
+	var s []structEntry
+	for _, x := range v.([]analyze.Structure) {
+		s = append(s, structEntry{
+			x.Kind.String(),
+			typeLink(x.Type),
+			html.EscapeString(strings.Join(x.Fields, ", ")),
+			objLink(x.Head),
+			x.Length,
+			x.Bytes,
+			html.EscapeString(x.Owner),
+		})
+	}
+	if err := structTemplate.Execute(w, s); err != nil {
 		log.Print(err)
 	}
 }
 
-type ByBytes []hentry
+type searchEntry struct {
+	Obj      string
+	Offset   uint64
+	Encoding string
+	Preview  string
+}
+
+type searchInfo struct {
+	Query   string
+	Matches []searchEntry
+	More    bool
+}
+
+var searchTemplate = template.Must(template.New("search").Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Search</title>
+</head>
+<body>
+<tt>
+<h2>Objects containing {{.Query}}</h2>
+<table>
+<tr>
+<td>Object</td>
+<td align="right">Offset</td>
+<td>Encoding</td>
+<td>Text</td>
+</tr>
+{{range .Matches}}
+<tr>
+<td>{{.Obj}}</td>
+<td align="right">{{.Offset}}</td>
+<td>{{.Encoding}}</td>
+<td>{{.Preview}}</td>
+</tr>
+{{end}}
+</table>
+{{if .More}}<font color=Red>only the first matches are shown</font>{{end}}
+</tt>
+</body>
+</html>
+`))
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := &analyze.SearchOptions{
+		IgnoreCase: q.Get("i") != "",
+		AllObjects: q.Get("all") != "",
+		Limit:      maxFields,
+	}
+	m, err := analyze.Search(d, q.Get("q"), opts)
+	if err != nil {
+		http.Error(w, err.Error(), 405)
+		return
+	}
+	i := searchInfo{Query: html.EscapeString(q.Get("q"))}
+	if len(m) == maxFields {
+		m = m[:maxFields-1]
+		i.More = true
+	}
+	for _, x := range m {
+		i.Matches = append(i.Matches, searchEntry{objLink(x.Obj), x.Offset, x.Encoding.String(), html.EscapeString(x.Preview)})
+	}
+	if err := searchTemplate.Execute(w, i); err != nil {
+		log.Print(err)
+	}
+}
+
+type findEntry struct {
+	Kind  string
+	Text  string // html
+	Where string // html
+	Count int
+}
+
+type findInfo struct {
+	Query string
+	Hits  []findEntry
+	More  bool
+}
+
+var findTemplate = template.Must(template.New("find").Funcs(templateFuncs).Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Find</title>
+</head>
+<body>
+<tt>
+<h2>Names and strings matching {{.Query}}</h2>
+<table>
+<tr>
+<td>Kind</td>
+<td>Name</td>
+<td>Where</td>
+<td align="right">Count</td>
+</tr>
+{{range .Hits}}
+<tr>
+<td>{{.Kind}}</td>
+<td>{{.Text}}</td>
+<td>{{.Where}}</td>
+<td align="right">{{count .Count}}</td>
+</tr>
+{{end}}
+</table>
+{{if .More}}<font color=Red>only the first matches are shown</font>{{end}}
+</tt>
+</body>
+</html>
+`))
 
-func (a ByBytes) Len() int           { return len(a) }
-func (a ByBytes) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a ByBytes) Less(i, j int) bool { return a[i].Bytes > a[j].Bytes }
+func findHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	hits := index.Search(q, maxFields)
+	i := findInfo{Query: html.EscapeString(q)}
+	if len(hits) == maxFields {
+		hits = hits[:maxFields-1]
+		i.More = true
+	}
+	for _, h := range hits {
+		e := findEntry{Kind: h.Kind.String(), Text: html.EscapeString(h.Text), Count: h.Count}
+		switch h.Kind {
+		case analyze.HitType:
+			e.Text = typeLink(h.Type)
+		case analyze.HitField:
+			e.Where = typeLink(h.Type)
+		case analyze.HitFunction:
+			// Link the first goroutine running it; the count says how
+			// many more there are.
+			g := h.Goroutines[0]
+			e.Where = fmt.Sprintf("<a href=go?id=%x>goroutine %x</a>", g.Addr, g.Addr)
+		case analyze.HitString:
+			e.Text = html.EscapeString(strconv.Quote(h.Text))
+			e.Where = fmt.Sprintf("%s field %s", objLink(h.Obj), html.EscapeString(h.Field))
+		}
+		i.Hits = append(i.Hits, e)
+	}
+	if err := findTemplate.Execute(w, i); err != nil {
+		log.Print(err)
+	}
+}
 
 type mainInfo struct {
 	HeapSize   uint64
 	HeapUsed   uint64
 	NumObjects int
+	Warnings   []string
+	Compat     *read.Compat
 }
 
-var mainTemplate = template.Must(template.New("histo").Parse(`
+var mainTemplate = template.Must(template.New("histo").Funcs(templateFuncs).Parse(`
 <html>
 <head>
 <title>Heap dump viewer</title>
@@ -471,23 +667,45 @@ var mainTemplate = template.Must(template.New("histo").Parse(`
 
 <h2>Heap dump viewer</h2>
 <br>
-Heap size: {{.HeapSize}} bytes
+Written by: {{.Compat.Format}} on {{.Compat.Arch}}, {{.Compat.PtrSize}}-byte pointers, {{.Compat.Ncpu}} cpus{{if .Compat.Experiments}}, GOEXPERIMENT={{range $i, $e := .Compat.Experiments}}{{if $i}},{{end}}{{$e}}{{end}}{{end}}
+<br>
+Heap size: {{bytes .HeapSize}}
 <br>
-Heap live: {{.HeapUsed}} bytes
+Heap live: {{bytes .HeapUsed}}
 <br>
-Heap objects: {{.NumObjects}}
+Heap objects: {{count .NumObjects}}
 <br>
 <a href="histo">Type Histogram</a>
+<a href="histo?collapse=1">Type Histogram, generic instantiations combined</a>
 <a href="globals">Globals</a>
 <a href="goroutines">Goroutines</a>
 <a href="others">Miscellaneous Roots</a>
+<a href="structures">Lists and Trees</a>
+<form action="find">
+Find types, fields, functions and strings: <input type="text" name="q">
+</form>
+<form action="search">
+Search object contents: <input type="text" name="q">
+<input type="checkbox" name="i" value="1">ignore case
+<input type="checkbox" name="all" value="1">include objects with pointers
+</form>
+{{if .Warnings}}
+<h3>Warnings</h3>
+{{range .Warnings}}
+<font color=Red>{{.}}</font>
+<br>
+{{end}}
+{{end}}
 </tt>
 </body>
 </html>
 `))
 
 func mainHandler(w http.ResponseWriter, r *http.Request) {
-	i := mainInfo{d.HeapEnd - d.HeapStart, d.Memstats.Alloc, d.NumObjects()}
+	i := mainInfo{d.HeapEnd - d.HeapStart, 0, d.NumObjects(), d.Warnings(), d.Compat()}
+	if d.Memstats != nil { // truncated dumps may have none
+		i.HeapUsed = d.Memstats.Alloc
+	}
 	if err := mainTemplate.Execute(w, i); err != nil {
 		log.Print(err)
 	}
@@ -533,7 +751,7 @@ border:1px solid grey;
 func globalsHandler(w http.ResponseWriter, r *http.Request) {
 	var f []Field
 	for _, x := range []*read.Data{d.Data, d.Bss} {
-		f = append(f, getFields(x.Data, x.Fields, x.Edges)...)
+		f = append(f, getFields(x.Data, x.Fields, x.EdgeList())...)
 	}
 	if err := globalsTemplate.Execute(w, f); err != nil {
 		log.Print(err)
@@ -654,7 +872,7 @@ func goListHandler(w http.ResponseWriter, r *http.Request) {
 		i = append(i, goListInfo{name, state})
 	}
 	// sort by state
-	sort.Sort(ByState(i))
+	sort.Stable(ByState(i))
 	if err := goListTemplate.Execute(w, i); err != nil {
 		log.Print(err)
 	}
@@ -666,14 +884,28 @@ func (a ByState) Len() int           { return len(a) }
 func (a ByState) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByState) Less(i, j int) bool { return a[i].State < a[j].State }
 
+// sourcePos returns the source position of frame s, for display.
+func sourcePos(s binutil.SourceFrame) string {
+	if s.File == "" {
+		return ""
+	}
+	return html.EscapeString(fmt.Sprintf("%s:%d", s.File, s.Line))
+}
+
 type goInfo struct {
 	Addr   uint64
 	Obj    read.ObjId
 	State  string
 	Frames []string
+	Defers []string
+	Panics []string
+	// Freed is the memory only the goroutine keeps alive, in
+	// FreedCount objects.
+	Freed      uint64
+	FreedCount int
 }
 
-var goTemplate = template.Must(template.New("go").Parse(`
+var goTemplate = template.Must(template.New("go").Funcs(templateFuncs).Parse(`
 <html>
 <head>
 <style>
@@ -692,11 +924,26 @@ border:1px solid grey;
 <tt>
 <h2>Goroutine <a href=obj?id={{.Obj}}>{{printf "%x" .Addr}}</a></h2>
 <h3>{{.State}}</h3>
+Exiting would free {{bytes .Freed}} in {{count .FreedCount}} objects.
 <h3>Stack</h3>
 {{range .Frames}}
 {{.}}
 <br>
 {{end}}
+{{if .Defers}}
+<h3>Deferred calls</h3>
+{{range .Defers}}
+{{.}}
+<br>
+{{end}}
+{{end}}
+{{if .Panics}}
+<h3>Panics</h3>
+{{range .Panics}}
+{{.}}
+<br>
+{{end}}
+{{end}}
 </tt>
 </body>
 </html>
@@ -748,7 +995,28 @@ func goHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for f := g.Bos; f != nil; f = f.Parent {
-		i.Frames = append(i.Frames, fmt.Sprintf("<a href=frame?id=%x&depth=%d>%s</a>", f.Addr, f.Depth, f.Name))
+		src := f.SourceFrames()
+		for _, s := range src[:len(src)-1] {
+			i.Frames = append(i.Frames, fmt.Sprintf("%s (inlined) %s", html.EscapeString(s.Func), sourcePos(s)))
+		}
+		i.Frames = append(i.Frames, fmt.Sprintf("<a href=frame?id=%x&depth=%d>%s</a> %s", f.Addr, f.Depth, f.Name, sourcePos(src[len(src)-1])))
+	}
+	for _, x := range g.Defers {
+		name := x.Func
+		if name == "" {
+			name = fmt.Sprintf("func at %x", x.Code)
+		}
+		i.Defers = append(i.Defers, fmt.Sprintf("%s, deferred at pc %x", html.EscapeString(name), x.Pc))
+	}
+	if set, n, ok := analyze.ReachableFromGoroutine(d, g.Goid); ok {
+		i.Freed, i.FreedCount = n, set.Len()
+	}
+	for _, p := range g.Panics {
+		s := html.EscapeString(p.Value())
+		if p.Defer != nil && p.Defer.Func != "" {
+			s += ", running " + html.EscapeString(p.Defer.Func)
+		}
+		i.Panics = append(i.Panics, s)
 	}
 
 	if err := goTemplate.Execute(w, i); err != nil {
@@ -842,10 +1110,15 @@ func frameHandler(w http.ResponseWriter, r *http.Request) {
 	i.Addr = f.Addr
 	i.Name = f.Name
 	i.Depth = f.Depth
-	i.Goroutine = fmt.Sprintf("<a href=go?id=%x>goroutine %x</a>", f.Goroutine.Addr, f.Goroutine.Addr)
+	if f.Goroutine != nil {
+		i.Goroutine = fmt.Sprintf("<a href=go?id=%x>goroutine %x</a>", f.Goroutine.Addr, f.Goroutine.Addr)
+	} else {
+		// An orphan frame, whose stack no goroutine in the dump owns.
+		i.Goroutine = "no goroutine"
+	}
 
 	// variables
-	i.Vars = getFields(f.Data, f.Fields, f.Edges)
+	i.Vars = getFields(f.Data, f.Fields, f.EdgeList())
 
 	if err := frameTemplate.Execute(w, i); err != nil {
 		log.Print(err)
@@ -866,7 +1139,7 @@ func heapdumpHandler(w http.ResponseWriter, r *http.Request) {
 
 func usage() {
 	fmt.Fprintf(os.Stderr,
-		"usage: hview heapdump [executable]\n")
+		"usage: hview heapdump [executable [plugin@loadaddr ...]]\n")
 	flag.PrintDefaults()
 	os.Exit(2)
 }
@@ -875,25 +1148,49 @@ func main() {
 	flag.Usage = usage
 	flag.Parse()
 
-	var dump, exec string
 	args := flag.Args()
-	switch len(args) {
-	case 1:
-		dump = args[0]
-		exec = ""
-	case 2:
-		dump = args[0]
-		exec = args[1]
-	default:
+	if len(args) < 1 {
 		usage()
 		return
 	}
+	dump := args[0]
+	opts := read.Options{Conservative: *conservative, CacheSize: *cacheMB << 20, MaxEdgeMemory: *edgeMB << 20, ProfileIndex: *indexProfile}
+	opts.SymbolDirs = filepath.SplitList(*symdir)
+	var err error
+	if *indexDir != "" {
+		if opts.IndexStore, err = read.NewFileIndexStore(*indexDir); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if opts.IfacePolicy, err = read.ParseIfacePolicy(*ifacePolicy); err != nil {
+		log.Fatal(err)
+	}
+	for _, a := range args[1:] {
+		e, err := read.ParseExecutable(a)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Executables = append(opts.Executables, e)
+	}
+
+	// Analyses report their times and allocations at /debug/vars.
+	analyze.OnRun(analyze.ExpvarRuns(expvar.NewMap("analyses")))
 
 	fmt.Println("Loading...")
-	d = read.Read(dump, exec)
+	d = read.ReadWithOptions(dump, &opts)
+	fmt.Println(d.Stats())
 
 	fmt.Println("Analyzing...")
 	prepare()
+	if *indexProfile {
+		s := d.Stats()
+		fmt.Printf("index: %d lookups, %.2f objects examined each, %d misses\n",
+			s.IndexLookups, float64(s.IndexProbes)/float64(s.IndexLookups), s.IndexMisses)
+		fmt.Printf("slowest %d-byte buckets:\n", s.IndexBucketSize)
+		for _, b := range d.WorstBuckets(10) {
+			fmt.Printf("  %x  %d objects, %d lookups, %d examined\n", b.Addr, b.Objects, b.Lookups, b.Probes)
+		}
+	}
 
 	fmt.Println("Ready.  Point your browser to localhost" + *httpAddr)
 	http.HandleFunc("/", mainHandler)
@@ -905,38 +1202,27 @@ func main() {
 	http.HandleFunc("/go", goHandler)
 	http.HandleFunc("/frame", frameHandler)
 	http.HandleFunc("/others", othersHandler)
+	http.HandleFunc("/structures", structHandler)
+	http.HandleFunc("/search", searchHandler)
+	http.HandleFunc("/find", findHandler)
 	http.HandleFunc("/heapdump", heapdumpHandler)
+	http.Handle("/api/", http.StripPrefix("/api", httpapi.Handler(d)))
 	if err := http.ListenAndServe(*httpAddr, nil); err != nil {
 		log.Fatal(err)
 	}
 }
 
-// Map from object ID to list of objects that refer to that object.
-// It is split in two parts for efficiency.  If an object x has <= 1
-// inbound edge, we store it in ref1[x].  Otherwise, it is stored in ref2[x].
-// Since most objects have only one incoming reference,
-// ref2 ends up small.
-var ref1 []read.ObjId
-var ref2 map[read.ObjId][]read.ObjId
-
 func getReferrers(x read.ObjId) []string {
 	var r []string
-	if y := ref1[x]; y != read.ObjNil {
+	for _, y := range d.Referrers(x) {
 		for _, e := range d.Edges(y) {
 			if e.To == x {
 				r = append(r, edgeSource(y, e))
 			}
 		}
-		for _, y := range ref2[x] {
-			for _, e := range d.Edges(y) {
-				if e.To == x {
-					r = append(r, edgeSource(y, e))
-				}
-			}
-		}
 	}
 	for _, s := range []*read.Data{d.Data, d.Bss} {
-		for _, e := range s.Edges {
+		for _, e := range s.EdgeList() {
 			if e.To != x {
 				continue
 			}
@@ -944,7 +1230,7 @@ func getReferrers(x read.ObjId) []string {
 		}
 	}
 	for _, f := range d.Frames {
-		for _, e := range f.Edges {
+		for _, e := range f.EdgeList() {
 			if e.To == x {
 				r = append(r, fmt.Sprintf("<a href=frame?id=%x&depth=%d>%s</a>.%s", f.Addr, f.Depth, f.Name, e.FieldName))
 			}
@@ -968,6 +1254,15 @@ type bucket struct {
 // histogram by full type id
 var byType []bucket
 
+// names gives objects names which don't depend on their addresses.
+var names *analyze.Namer
+
+// index finds types, fields, functions and strings by name.
+var index *analyze.SearchIndex
+
+// results holds the pages' analyses, so each is computed only once.
+var results *analyze.ResultCache
+
 func prepare() {
 	// group objects by type
 	fmt.Println("Grouping by type...")
@@ -981,169 +1276,23 @@ func prepare() {
 		byType[tid] = b
 	}
 
-	// compute referrers
 	fmt.Println("Computing referrers...")
-	ref1 = make([]read.ObjId, d.NumObjects())
-	for i := 0; i < d.NumObjects(); i++ {
-		ref1[i] = read.ObjNil
-	}
-	ref2 = map[read.ObjId][]read.ObjId{}
-	for i := 0; i < d.NumObjects(); i++ {
-		x := read.ObjId(i)
-		//fmt.Printf("object %d %x %d %s %s\n", i, d.Addr(x), d.Size(x), d.Ft(x).GCSig, d.Ft(x).Name)
-		//printbytes(d.Contents(x))
-		for _, e := range d.Edges(x) {
-			r := ref1[e.To]
-			if r == read.ObjNil {
-				ref1[e.To] = x
-			} else if x != r {
-				s := ref2[e.To]
-				if len(s) == 0 || x != s[len(s)-1] {
-					ref2[e.To] = append(s, x)
-				}
-			}
-		}
-	}
-
-	dom()
-}
-
-// map from object ID to the size of the heap that is dominated by that object.
-var domsize []uint64
+	d.ComputeReferrers()
 
-func dom() {
 	fmt.Println("Computing dominators...")
-	n := d.NumObjects()
+	d.ComputeDominators()
 
-	// make list of roots
-	// TODO: have loader compute this?
-	roots := map[read.ObjId]struct{}{}
-	for _, s := range []*read.Data{d.Data, d.Bss} {
-		for _, e := range s.Edges {
-			roots[e.To] = struct{}{}
-		}
-	}
-	for _, f := range d.Frames {
-		for _, e := range f.Edges {
-			roots[e.To] = struct{}{}
-		}
-	}
-	for _, x := range d.Otherroots {
-		for _, e := range x.Edges {
-			roots[e.To] = struct{}{}
-		}
-	}
+	fmt.Println("Naming objects...")
+	names = analyze.NewNamer(d)
 
-	// compute postorder traversal
-	// object states:
-	// 0 - not seen yet
-	// 1 - seen, added to queue, not yet expanded children
-	// 2 - seen, already expanded children
-	// 3 - added to postorder
-	postorder := make([]read.ObjId, 0, n)
-	postnum := make([]int, n+1)
-	state := make([]byte, n)
-	var q []read.ObjId // stack of work to do, holds state 1 and 2 objects
-	for x := range roots {
-		if state[x] != 0 {
-			if state[x] != 3 {
-				log.Fatal("bad state found")
-			}
-			continue
-		}
-		state[x] = 1
-		q = q[:0]
-		q = append(q, x)
-		for len(q) > 0 {
-			y := q[len(q)-1]
-			if state[y] == 2 {
-				state[y] = 3
-				q = q[:len(q)-1]
-				postnum[y] = len(postorder)
-				postorder = append(postorder, y)
-			} else {
-				if state[y] != 1 {
-					log.Fatal("bad state")
-				}
-				state[y] = 2
-				for _, e := range d.Edges(y) {
-					z := e.To
-					if state[z] == 0 {
-						state[z] = 1
-						q = append(q, z)
-					}
-				}
-			}
-		}
-	}
-	postnum[n] = n // virtual start node
-
-	// compute immediate dominators
-	// http://www.hipersoft.rice.edu/grads/publications/dom14.pdf
-	idom := make([]read.ObjId, n+1)
-	for i := 0; i < n; i++ {
-		idom[i] = read.ObjNil
-	}
-	idom[n] = read.ObjId(n)
-	for r := range roots {
-		idom[r] = read.ObjId(n)
-	}
-	var redges []read.ObjId
-	change := true
-	for change {
-		change = false
-		for i := len(postorder) - 1; i >= 0; i-- {
-			x := postorder[i]
-			// get list of incoming edges
-			redges = redges[:0]
-			if ref1[x] != read.ObjNil {
-				redges = append(redges, ref1[x])
-				redges = append(redges, ref2[x]...)
-			}
-			a := read.ObjNil
-			for _, b := range redges {
-				if idom[b] == read.ObjNil {
-					continue
-				}
-				if a == read.ObjNil {
-					a = b
-					continue
-				}
-				for a != b {
-					if postnum[a] < postnum[b] {
-						a = idom[a]
-					} else {
-						b = idom[b]
-					}
-				}
-			}
-			if _, ok := roots[x]; ok {
-				a = read.ObjId(n)
-			}
-			if a != idom[x] {
-				idom[x] = a
-				change = true
-			}
-		}
-	}
+	results = analyze.NewResultCache(d)
 
-	domsize = make([]uint64, n+1)
-	for _, x := range postorder {
-		domsize[x] += d.Size(x)
-		domsize[idom[x]] += domsize[x]
-	}
-	// Note: unreachable objects will have domsize of 0.
-}
+	fmt.Println("Indexing names and strings...")
+	index = analyze.NewSearchIndex(d, &analyze.SearchIndexOptions{Strings: true})
 
-func readPtr(b []byte) uint64 {
-	switch d.PtrSize {
-	case 4:
-		return uint64(d.Order.Uint32(b))
-	case 8:
-		return d.Order.Uint64(b)
-	default:
-		log.Fatal("unsupported PtrSize=%d", d.PtrSize)
-		return 0
+	// Percentages are of the live heap.
+	for _, b := range byType {
+		fmtr.Total += b.bytes
 	}
 }
 
@@ -0,0 +1,64 @@
+package read
+
+import "fmt"
+
+// readPtrAt returns the pointer at offset off of data, checking that it
+// lies within data.
+func (d *Dump) readPtrAt(data []byte, off uint64) (uint64, error) {
+	if off > uint64(len(data)) || uint64(len(data))-off < d.PtrSize {
+		return 0, fmt.Errorf("pointer at offset %d out of range [0,%d)", off, len(data))
+	}
+	return readPtr(d, data[off:]), nil
+}
+
+// ReadPtrAt returns the pointer stored at offset off in the frame.
+func (f *StackFrame) ReadPtrAt(off uint64) (uint64, error) {
+	p, err := f.d.readPtrAt(f.Data, off)
+	if err != nil {
+		return 0, fmt.Errorf("frame %s: %v", f.Name, err)
+	}
+	return p, nil
+}
+
+// ReadPtrAt returns the pointer stored at offset off from the start
+// of the section.
+func (x *Data) ReadPtrAt(off uint64) (uint64, error) {
+	p, err := x.d.readPtrAt(x.Data, off)
+	if err != nil {
+		return 0, fmt.Errorf("section at %x: %v", x.Addr, err)
+	}
+	return p, nil
+}
+
+// readMem returns the n bytes at address addr of the dumped process,
+// if they are all in one heap object or in the data or bss section.
+func (d *Dump) readMem(addr, n uint64) ([]byte, bool) {
+	if x := d.FindObj(addr); x != ObjNil {
+		off := addr - d.Addr(x)
+		if d.Size(x)-off < n {
+			return nil, false
+		}
+		return d.Contents(x)[off : off+n], true
+	}
+	for _, s := range []*Data{d.Data, d.Bss} {
+		if s != nil && addr >= s.Addr && addr-s.Addr <= uint64(len(s.Data)) && uint64(len(s.Data))-(addr-s.Addr) >= n {
+			return s.Data[addr-s.Addr : addr-s.Addr+n], true
+		}
+	}
+	return nil, false
+}
+
+// readString returns the contents of the string whose header is at
+// address addr.
+func (d *Dump) readString(addr uint64) (string, bool) {
+	h, ok := d.readMem(addr, 2*d.PtrSize)
+	if !ok {
+		return "", false
+	}
+	p, n := d.StringHeader(h)
+	b, ok := d.readMem(p, n)
+	if !ok {
+		return "", false
+	}
+	return string(b), true
+}
@@ -0,0 +1,125 @@
+package read
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// An Arch is the architecture of the dumped process, named as GOARCH
+// names it.
+type Arch int
+
+const (
+	ArchUnknown Arch = iota
+	Arch386
+	ArchAMD64
+	ArchAMD64p32
+	ArchARM
+	ArchARM64
+	ArchPPC64
+	ArchPPC64LE
+	ArchMIPS
+	ArchMIPSLE
+	ArchMIPS64
+	ArchMIPS64LE
+	ArchS390X
+	ArchRISCV64
+	ArchLoong64
+	ArchWasm
+
+	numArchs
+)
+
+type archInfo struct {
+	name     string
+	char     byte // the toolchain's letter for the architecture, if it had one
+	ptrSize  uint64
+	maxAlign uint64
+	order    binary.ByteOrder
+	// minFrame is the size of the fixed part at the bottom of every
+	// frame, where machines with a link register save it: a callee's
+	// arguments start this far above the caller's stack pointer.
+	minFrame uint64
+}
+
+var archs = [...]archInfo{
+	ArchUnknown:  {"unknown", 0, 0, 0, nil, 0},
+	Arch386:      {"386", '8', 4, 4, binary.LittleEndian, 0},
+	ArchAMD64:    {"amd64", '6', 8, 8, binary.LittleEndian, 0},
+	ArchAMD64p32: {"amd64p32", '6', 4, 8, binary.LittleEndian, 0},
+	ArchARM:      {"arm", '5', 4, 4, binary.LittleEndian, 4},
+	ArchARM64:    {"arm64", '7', 8, 8, binary.LittleEndian, 8},
+	ArchPPC64:    {"ppc64", '9', 8, 8, binary.BigEndian, 32},
+	ArchPPC64LE:  {"ppc64le", '9', 8, 8, binary.LittleEndian, 32},
+	ArchMIPS:     {"mips", 0, 4, 4, binary.BigEndian, 4},
+	ArchMIPSLE:   {"mipsle", 0, 4, 4, binary.LittleEndian, 4},
+	ArchMIPS64:   {"mips64", 0, 8, 8, binary.BigEndian, 8},
+	ArchMIPS64LE: {"mips64le", 0, 8, 8, binary.LittleEndian, 8},
+	ArchS390X:    {"s390x", 0, 8, 8, binary.BigEndian, 8},
+	ArchRISCV64:  {"riscv64", 0, 8, 8, binary.LittleEndian, 8},
+	ArchLoong64:  {"loong64", 0, 8, 8, binary.LittleEndian, 8},
+	ArchWasm:     {"wasm", 0, 8, 8, binary.LittleEndian, 0},
+}
+
+func (a Arch) String() string {
+	if a < 0 || a >= numArchs {
+		return fmt.Sprintf("Arch(%d)", int(a))
+	}
+	return archs[a].name
+}
+
+// MarshalText encodes a as its name, so that JSON gives the GOARCH.
+func (a Arch) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// PtrSize returns the size of a pointer on a, or 0 if a is unknown.
+func (a Arch) PtrSize() uint64 {
+	if a < 0 || a >= numArchs {
+		return 0
+	}
+	return archs[a].ptrSize
+}
+
+// MaxAlign returns the largest alignment of any type on a, or 0 if a
+// is unknown.  It differs from PtrSize only on amd64p32.
+func (a Arch) MaxAlign() uint64 {
+	if a < 0 || a >= numArchs {
+		return 0
+	}
+	return archs[a].maxAlign
+}
+
+// MinFrameSize returns the size of the fixed part at the bottom of a
+// frame on a: 0 on machines which push return addresses, like amd64,
+// and the space for the saved link register on the others.  It is 32
+// on ppc64, as from Go 1.6.
+func (a Arch) MinFrameSize() uint64 {
+	if a < 0 || a >= numArchs {
+		return 0
+	}
+	return archs[a].minFrame
+}
+
+// ParseArch returns the Arch with the given GOARCH name.
+func ParseArch(s string) (Arch, error) {
+	for a := Arch(1); a < numArchs; a++ {
+		if archs[a].name == s {
+			return a, nil
+		}
+	}
+	return ArchUnknown, fmt.Errorf("unknown architecture %q", s)
+}
+
+// archFromChar returns the Arch a dump's params record describes by
+// the toolchain letter c, which alone can't tell amd64 from amd64p32
+// or ppc64 from ppc64le: the pointer size and byte order decide.
+func archFromChar(c byte, p Params) Arch {
+	for a := Arch(1); a < numArchs; a++ {
+		i := archs[a]
+		if i.char == c && i.ptrSize == p.PtrSize && i.order == p.Order {
+			return a
+		}
+	}
+	return ArchUnknown
+}
@@ -0,0 +1,81 @@
+package read
+
+import (
+	"container/list"
+	"io"
+)
+
+// Size of the blocks cached by a blockCache.
+const cacheBlockSize = 64 << 10
+
+// A blockCache is an io.ReaderAt which keeps recently read blocks of
+// an underlying io.ReaderAt in memory, discarding the least recently
+// used block when full.  Graph traversals read the same objects over
+// and over, which is slow if the dump lives on a spinning disk or
+// network filesystem.
+type blockCache struct {
+	r         io.ReaderAt
+	maxBlocks int
+	blocks    map[int64]*list.Element // block number -> element in lru
+	lru       list.List               // of *cacheBlock, most recently used first
+
+	hits, misses uint64
+}
+
+type cacheBlock struct {
+	n    int64 // block number
+	data []byte
+	err  error // error reading past the end of data
+}
+
+// newBlockCache returns a cache of r holding at most size bytes.
+func newBlockCache(r io.ReaderAt, size int64) *blockCache {
+	n := int(size / cacheBlockSize)
+	if n < 1 {
+		n = 1
+	}
+	return &blockCache{r: r, maxBlocks: n, blocks: map[int64]*list.Element{}}
+}
+
+func (c *blockCache) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		b := c.block((off + int64(n)) / cacheBlockSize)
+		i := (off + int64(n)) % cacheBlockSize
+		if i >= int64(len(b.data)) {
+			return n, b.err
+		}
+		n += copy(p[n:], b.data[i:])
+	}
+	return n, nil
+}
+
+// block returns block number n, reading it if necessary.
+func (c *blockCache) block(n int64) *cacheBlock {
+	if e := c.blocks[n]; e != nil {
+		c.hits++
+		c.lru.MoveToFront(e)
+		return e.Value.(*cacheBlock)
+	}
+	c.misses++
+	var b *cacheBlock
+	if c.lru.Len() >= c.maxBlocks {
+		// Reuse the least recently used block.
+		e := c.lru.Back()
+		b = e.Value.(*cacheBlock)
+		delete(c.blocks, b.n)
+		c.lru.Remove(e)
+	} else {
+		b = &cacheBlock{data: make([]byte, cacheBlockSize)}
+	}
+	b.n = n
+	b.data = b.data[:cacheBlockSize]
+	k, err := c.r.ReadAt(b.data, n*cacheBlockSize)
+	b.data = b.data[:k]
+	b.err = err
+	if err == nil || k == cacheBlockSize {
+		b.err = nil
+	}
+	c.blocks[n] = c.lru.PushFront(b)
+	return b
+}
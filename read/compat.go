@@ -0,0 +1,109 @@
+package read
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Compat describes the toolchain and runtime which wrote a dump, as
+// far as the dump says, and the ways they differ from what this
+// package knows how to decode.  Each of its Issues is also a warning
+// of the dump.
+type Compat struct {
+	Format      string   // the dump format, from its header, e.g. "go1.4"
+	Arch        Arch     // ArchUnknown if the params record names none we know
+	Char        byte     // the architecture letter the params record gives
+	PtrSize     uint64   // bytes
+	BigEndian   bool     // byte order
+	HeapStart   uint64   // start of the heap's address range
+	HeapEnd     uint64   // end of the heap's address range
+	Ncpu        uint64   // the process's GOMAXPROCS limit, as the runtime's ncpu
+	Experiments []string // the GOEXPERIMENTs the runtime was built with
+	Issues      []string // why the dump may be misdecoded, if it may be
+}
+
+// Experiments which don't change the layout of the heap, the dump or
+// the runtime structures this package decodes.
+var layoutNeutralExperiments = map[string]bool{
+	"fieldtrack":        true,
+	"framepointer":      true,
+	"preemptibleloops":  true,
+	"staticlockranking": true,
+	"boringcrypto":      true,
+	"loopvar":           true,
+	"rangefunc":         true,
+	"regabi":            true,
+	"regabiwrappers":    true,
+	"regabiargs":        true,
+	"coverageredesign":  true,
+	"cgocheck2":         true,
+}
+
+// Experiments known to change layouts this package decodes, and how.
+var layoutExperiments = map[string]string{
+	"allocheaders": "objects carry a header with their type, so object contents and pointer maps may be misread",
+	"arenas":       "user arenas hold objects the dump may describe incompletely",
+	"swisstable":   "maps are Swiss tables, which map key decoding doesn't understand",
+	"greenteagc":   "the garbage collector's span layout differs from the one assumed",
+}
+
+// Compat describes the dump's toolchain and runtime.
+func (d *Dump) Compat() *Compat {
+	return &Compat{
+		Format:      d.format.String(),
+		Arch:        d.Arch,
+		Char:        d.TheChar,
+		PtrSize:     d.PtrSize,
+		BigEndian:   d.Order == binary.BigEndian,
+		HeapStart:   d.HeapStart,
+		HeapEnd:     d.HeapEnd,
+		Ncpu:        d.Ncpu,
+		Experiments: experiments(d.Experiment),
+		Issues:      d.compatIssues,
+	}
+}
+
+// experiments splits the runtime's experiment string, which lists the
+// experiments separated by commas, and perhaps prefixed by "X:" as
+// the go command's version strings do.
+func experiments(s string) []string {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "X:")
+	return strings.FieldsFunc(s, func(c rune) bool { return c == ',' || c == ' ' })
+}
+
+// checkCompat warns about the ways the process which wrote d, as its
+// params record describes it, differs from what the package decodes,
+// and records them for Compat.
+func checkCompat(d *Dump) {
+	issue := func(format string, args ...interface{}) {
+		s := fmt.Sprintf(format, args...)
+		d.compatIssues = append(d.compatIssues, s)
+		d.warnf("%s", s)
+	}
+	if d.Arch == ArchUnknown {
+		order := "little"
+		if d.Order == binary.BigEndian {
+			order = "big"
+		}
+		arch := fmt.Sprintf("%q", d.TheChar)
+		if formats[d.format].goarch {
+			arch = d.goarch
+		}
+		issue("unknown architecture %s with %d-byte pointers, %s-endian; alignments are assumed to be the pointer size", arch, d.PtrSize, order)
+	}
+	for _, e := range experiments(d.Experiment) {
+		name := strings.TrimPrefix(e, "no")
+		if layoutNeutralExperiments[name] {
+			continue
+		}
+		if why, ok := layoutExperiments[name]; ok {
+			if name != e {
+				continue // disabled
+			}
+			issue("dump was written with GOEXPERIMENT=%s: %s", e, why)
+			continue
+		}
+		issue("dump was written with GOEXPERIMENT=%s, which may change layouts this tool assumes", e)
+	}
+}
@@ -0,0 +1,49 @@
+package read
+
+import "math"
+
+// ComputeDepths computes the depth of every object, which Depth
+// reports.  Depth computes them on demand; call this to control when
+// the cost is paid.
+func (d *Dump) ComputeDepths() {
+	if d.depths != nil {
+		return
+	}
+	depth := make([]int32, d.NumObjects())
+	for i := range depth {
+		depth[i] = -1
+	}
+	var q []ObjId
+	for _, r := range d.Roots() {
+		if depth[r.Edge.To] < 0 {
+			depth[r.Edge.To] = 0
+			q = append(q, r.Edge.To)
+		}
+	}
+	// Breadth-first, so each object is first reached by a shortest path.
+	for len(q) > 0 {
+		x := q[0]
+		q = q[1:]
+		for _, e := range d.Edges(x) {
+			if depth[e.To] < 0 {
+				depth[e.To] = depth[x] + 1
+				if depth[x] == math.MaxInt32 {
+					depth[e.To] = math.MaxInt32
+				}
+				q = append(q, e.To)
+			}
+		}
+	}
+	d.depths = depth
+}
+
+// Depth returns the length of the shortest path from a root to x, in
+// edges between objects: objects a root points to have depth 0.  It
+// returns -1 if x is unreachable.  Depths are stored in 32 bits, so
+// depths of 2^31-1 and more are reported as 2^31-1.  Old, deep
+// structures and freshly allocated, shallow ones tend to fall at
+// different depths, and PathToRoot's paths are of this length.
+func (d *Dump) Depth(x ObjId) int {
+	d.ComputeDepths()
+	return int(d.depths[x])
+}
@@ -0,0 +1,156 @@
+package read
+
+import (
+	"fmt"
+	"log"
+)
+
+// A FieldValue is the decoded contents of one field of an object,
+// stack frame, or global data section.
+type FieldValue struct {
+	Name   string
+	Offset uint64
+	Type   string // e.g. "uint32", "*main.T", "raw bytes"
+	// Value is the decoded value.  For pointer-like fields, it is
+	// the text of the pointer part only.
+	Value string
+	// Suffix follows the pointer of a string or slice, giving its
+	// length (and capacity), e.g. "/5/8".
+	Suffix string
+	// Edge is the edge into the heap this field contains, if any.
+	Edge *Edge
+	// Raw holds the bytes of a raw byte field, in memory order.
+	Raw []byte
+	// Pad is set if this entry describes padding, not a field.
+	Pad bool
+}
+
+func (v FieldValue) String() string {
+	return v.Value + v.Suffix
+}
+
+// Describe decodes the fields of object x.
+func (d *Dump) Describe(x ObjId) []FieldValue {
+	return d.DescribeFields(d.Contents(x), d.TypeFields(d.Ft(x)), d.Edges(x))
+}
+
+// DescribeFields uses the data in b to fill in the values for the given field list.
+// edges is a list of known connecting out edges.
+func (d *Dump) DescribeFields(b []byte, fields []Field, edges []Edge) []FieldValue {
+	var r []FieldValue
+	off := uint64(0)
+	// ptrField decodes a pointer at b[p:] for a field at off.
+	ptrField := func(f Field, typ string, p uint64) FieldValue {
+		v := FieldValue{Name: f.Name, Offset: off, Type: typ}
+		if len(edges) > 0 && edges[0].FromOffset == p {
+			e := edges[0]
+			v.Edge = &e
+			v.Value = fmt.Sprintf("object %x", d.Addr(e.To))
+			if e.ToOffset != 0 {
+				v.Value = fmt.Sprintf("%s+%d", v.Value, e.ToOffset)
+			}
+			edges = edges[1:]
+		} else {
+			v.Value = d.nonheapPtr(b[p:])
+		}
+		return v
+	}
+	for _, f := range fields {
+		if f.Offset < off {
+			log.Fatal("out of order fields")
+		}
+		if f.Offset > off {
+			r = append(r, FieldValue{Name: fmt.Sprintf("pad %d", f.Offset-off), Offset: off, Pad: true})
+			off = f.Offset
+		}
+		var v FieldValue
+		switch f.Kind {
+		case FieldKindBool:
+			v = FieldValue{Name: f.Name, Offset: off, Type: "bool", Value: "false"}
+			if b[off] != 0 {
+				v.Value = "true"
+			}
+			off++
+		case FieldKindUInt8:
+			v = FieldValue{Name: f.Name, Offset: off, Type: "uint8", Value: fmt.Sprintf("%d", b[off])}
+			off++
+		case FieldKindSInt8:
+			v = FieldValue{Name: f.Name, Offset: off, Type: "int8", Value: fmt.Sprintf("%d", int8(b[off]))}
+			off++
+		case FieldKindUInt16:
+			v = FieldValue{Name: f.Name, Offset: off, Type: "uint16", Value: fmt.Sprintf("%d", d.Order.Uint16(b[off:]))}
+			off += 2
+		case FieldKindSInt16:
+			v = FieldValue{Name: f.Name, Offset: off, Type: "int16", Value: fmt.Sprintf("%d", int16(d.Order.Uint16(b[off:])))}
+			off += 2
+		case FieldKindUInt32:
+			v = FieldValue{Name: f.Name, Offset: off, Type: "uint32", Value: fmt.Sprintf("%d", d.Order.Uint32(b[off:]))}
+			off += 4
+		case FieldKindSInt32:
+			v = FieldValue{Name: f.Name, Offset: off, Type: "int32", Value: fmt.Sprintf("%d", int32(d.Order.Uint32(b[off:])))}
+			off += 4
+		case FieldKindUInt64:
+			v = FieldValue{Name: f.Name, Offset: off, Type: "uint64", Value: fmt.Sprintf("%d", d.Order.Uint64(b[off:]))}
+			off += 8
+		case FieldKindSInt64:
+			v = FieldValue{Name: f.Name, Offset: off, Type: "int64", Value: fmt.Sprintf("%d", int64(d.Order.Uint64(b[off:])))}
+			off += 8
+		case FieldKindBytes4, FieldKindBytes8, FieldKindBytes16:
+			n := uint64(RawWidth(f.Kind))
+			raw := append([]byte(nil), b[off:off+n]...)
+			v = FieldValue{Name: f.Name, Offset: off, Type: "raw bytes", Value: rawBytes(raw), Raw: raw}
+			if n <= 8 {
+				// As a word, it might be a count, a flag set or an
+				// address outside the heap.
+				v.Value += fmt.Sprintf(" = %#x", d.RawWords(raw)[0])
+			}
+			off += n
+		case FieldKindPtr:
+			v = ptrField(f, "*"+f.BaseType, off)
+			off += d.PtrSize
+		case FieldKindIface:
+			// TODO: the itab part?
+			v = ptrField(f, "interface{...}"+f.BaseType, off+d.PtrSize)
+			off += 2 * d.PtrSize
+		case FieldKindEface:
+			// TODO: the type part
+			v = ptrField(f, "interface{}", off+d.PtrSize)
+			off += 2 * d.PtrSize
+		case FieldKindString:
+			v = ptrField(f, "string", off)
+			_, n := d.StringHeader(b[off:])
+			v.Suffix = fmt.Sprintf("/%d", n)
+			off += 2 * d.PtrSize
+		case FieldKindSlice:
+			v = ptrField(f, "[]"+f.BaseType, off)
+			_, n, c := d.SliceHeader(b[off:])
+			v.Suffix = fmt.Sprintf("/%d/%d", n, c)
+			off += 3 * d.PtrSize
+		case FieldKindBytesElided:
+			v = FieldValue{Name: f.Name, Offset: off, Type: "raw bytes", Value: fmt.Sprintf("... %d elided bytes ...", uint64(len(b))-off)}
+			off = uint64(len(b))
+		}
+		r = append(r, v)
+	}
+	if uint64(len(b)) > off {
+		r = append(r, FieldValue{Name: fmt.Sprintf("sizeclass pad %d", uint64(len(b))-off), Offset: off, Pad: true})
+	}
+	return r
+}
+
+// the first d.PtrSize bytes of b contain a pointer.  Return text
+// to represent that pointer.
+func (d *Dump) nonheapPtr(b []byte) string {
+	p := readPtr(d, b)
+	if p == 0 {
+		return "nil"
+	}
+	// TODO: look up symbol in executable
+	return fmt.Sprintf("outsideheap_%x", p)
+}
+
+// rawBytes generates a string representing the given raw bytes,
+// in hex followed by the printable characters.
+func rawBytes(b []byte) string {
+	return HexBytes(b) + "  | " + PrintableBytes(b)
+}
@@ -0,0 +1,182 @@
+package read
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/randall77/heapdump14/binutil"
+)
+
+// A DumpMeta describes the process which wrote a dump, as recorded in
+// the dump's companion metadata file.  The file is named after the
+// dump with ".meta" appended, and has one "key value" pair per line:
+//
+//	exe /usr/local/bin/server
+//	buildid 4f2a...
+//	plugin /opt/plugins/auth.so@0x7f3a00000000
+//
+// Lines starting with # are comments.  The process writing the dump
+// can write the file at the same time, so tools need not be told
+// where the executable is.
+type DumpMeta struct {
+	Exe     string       // path of the main executable
+	BuildID string       // build ID of the main executable
+	Plugins []Executable // shared objects loaded by the process
+}
+
+// ReadDumpMeta reads the metadata file for the dump in dumpname.  It
+// returns nil if there isn't one.
+func ReadDumpMeta(dumpname string) (*DumpMeta, error) {
+	f, err := os.Open(dumpname + ".meta")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m := &DumpMeta{}
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		kv := strings.SplitN(line, " ", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%s.meta:%d: want key and value", dumpname, n)
+		}
+		v := strings.TrimSpace(kv[1])
+		switch kv[0] {
+		case "exe":
+			m.Exe = v
+		case "buildid":
+			m.BuildID = v
+		case "plugin":
+			e, err := ParseExecutable(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s.meta:%d: %v", dumpname, n, err)
+			}
+			m.Plugins = append(m.Plugins, e)
+		default:
+			// Unknown keys are for other tools.
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// FindExecutables tries to find the executables of the process which
+// wrote the dump in dumpname, so they needn't be given by hand.  It
+// uses the dump's metadata file: the executable it names, if that
+// exists and has the recorded build ID, or else a binary with the
+// recorded build ID in one of symbolDirs, or else the binary with that
+// build ID from one of symbolServers.  A symbol directory may hold
+// binaries named by build ID, either directly or in the
+// .build-id/xx/rest layout used by debuggers; other binaries in it are
+// checked for a matching build ID too.  Symbol servers are
+// debuginfod-style HTTP servers; see binutil.FetchByBuildID.
+//
+// It returns nil if no metadata file exists.
+func FindExecutables(dumpname string, symbolDirs, symbolServers []string) ([]Executable, error) {
+	m, err := ReadDumpMeta(dumpname)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	exe := ""
+	if m.Exe != "" && matchesBuildID(m.Exe, m.BuildID) {
+		exe = m.Exe
+	}
+	if exe == "" && m.BuildID != "" {
+		exe = findByBuildID(symbolDirs, m.BuildID)
+	}
+	if exe == "" && m.BuildID != "" {
+		exe = fetchByBuildID(symbolServers, m.BuildID)
+	}
+	if exe == "" {
+		return nil, fmt.Errorf("can't find executable %q with build ID %q for %s", m.Exe, m.BuildID, dumpname)
+	}
+	return append([]Executable{{Path: exe}}, m.Plugins...), nil
+}
+
+// matchesBuildID reports whether path exists and, if id is not empty,
+// has build ID id.
+func matchesBuildID(path, id string) bool {
+	if _, err := os.Stat(path); err != nil {
+		return false
+	}
+	if id == "" {
+		return true
+	}
+	got, err := binutil.BuildID(path)
+	return err == nil && got == id
+}
+
+// findByBuildID returns the path of a binary with build ID id in one
+// of dirs, or "".
+func findByBuildID(dirs []string, id string) string {
+	for _, dir := range dirs {
+		var cands []string
+		if len(id) > 2 {
+			cands = append(cands,
+				filepath.Join(dir, ".build-id", id[:2], id[2:]+".debug"),
+				filepath.Join(dir, ".build-id", id[:2], id[2:]))
+		}
+		cands = append(cands, filepath.Join(dir, id))
+		for _, c := range cands {
+			if matchesBuildID(c, id) {
+				return c
+			}
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if !f.Mode().IsRegular() {
+				continue
+			}
+			p := filepath.Join(dir, f.Name())
+			if got, err := binutil.BuildID(p); err == nil && got == id {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// fetchByBuildID downloads the binary with build ID id from the first
+// of servers which has it, and returns its path, or "".
+func fetchByBuildID(servers []string, id string) string {
+	if len(servers) == 0 {
+		return ""
+	}
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		log.Print(err)
+		return ""
+	}
+	cache = filepath.Join(cache, "heapdump", "buildid")
+	for _, s := range servers {
+		p, err := binutil.FetchByBuildID(s, id, cache)
+		if err == nil {
+			return p
+		}
+		log.Print(err)
+	}
+	return ""
+}
+
+// DefaultSymbolServers returns the symbol servers listed in the
+// DEBUGINFOD_URLS environment variable, as used by other debugging
+// tools.
+func DefaultSymbolServers() []string {
+	return strings.Fields(os.Getenv("DEBUGINFOD_URLS"))
+}
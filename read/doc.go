@@ -0,0 +1,19 @@
+// Package read parses Go heap dumps and builds the object graph.
+//
+// Read and ReadWithOptions load a Dump from a dump of Go 1.4 through
+// 1.7.  Heap objects are identified by ObjId; Contents, Edges, Ft,
+// Addr and Size describe an object, and FindObj maps an address to
+// one.  Roots, Reachable, Referrers, PathToRoot, Depth, Idom and
+// RetainedSize answer questions about the graph from indexes cached
+// on the Dump, which an IndexStore can keep across runs.  These, and
+// the exported fields of Dump and its record types, are the stable
+// interface of the package.
+//
+// Dump.Platform gives the word size, byte order and alignment of the
+// dumped process.  Code decoding object contents should use its
+// methods, so that it works for 32-bit dumps too.
+//
+// Package binutil reads type information from the executables.
+// Reports which walk the graph afresh on each call belong in package
+// analyze, and writers for other tools' formats in package export.
+package read
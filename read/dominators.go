@@ -0,0 +1,157 @@
+package read
+
+// Dominators is the dominator tree of the heap.  An object y
+// dominates x if every path from a root to x goes through y, so that
+// x would become unreachable if y did; the immediate dominator of x is
+// the dominator closest to it.  The roots form a virtual node at the
+// top of the tree, whose children, given by Top, are the objects
+// dominated only by the roots.  Unreachable objects are not in the
+// tree.
+type Dominators struct {
+	t   *domTree
+	top ObjId // index of the virtual node, NumObjects()
+
+	// children of each object and of the virtual node, in ObjId
+	// order: those of x are children[start[x]:start[x+1]]
+	start    []int
+	children []ObjId
+
+	// preorder interval of each object's subtree, so that x
+	// dominates y iff enter[x] <= enter[y] < exit[x]; enter is -1 for
+	// unreachable objects
+	enter, exit []int32
+}
+
+// Dominators returns the dominator tree of the heap, computing it if
+// needed.  Idom and RetainedSize answer questions about single
+// objects from the same tree; Dominators also gives the objects each
+// one dominates.
+func (d *Dump) Dominators() *Dominators {
+	if d.dominators != nil {
+		return d.dominators
+	}
+	t := d.domTree(false)
+	n := d.NumObjects()
+	s := &Dominators{t: t, top: ObjId(n)}
+
+	// Count, then place, the children of each node.
+	s.start = make([]int, n+2)
+	for x := 0; x < n; x++ {
+		if p := t.idom[x]; p != ObjNil {
+			s.start[p+1]++
+		}
+	}
+	for i := 1; i < len(s.start); i++ {
+		s.start[i] += s.start[i-1]
+	}
+	s.children = make([]ObjId, s.start[n+1])
+	next := append([]int(nil), s.start...)
+	for x := 0; x < n; x++ {
+		if p := t.idom[x]; p != ObjNil {
+			s.children[next[p]] = ObjId(x)
+			next[p]++
+		}
+	}
+
+	// Number the tree in preorder, without recursion: the tree may
+	// be as deep as the heap is big.
+	s.enter = make([]int32, n+1)
+	s.exit = make([]int32, n+1)
+	for i := range s.enter {
+		s.enter[i] = -1
+	}
+	num := int32(0)
+	type frame struct {
+		x    ObjId
+		next int // index in children of the next child to visit
+	}
+	stack := []frame{{s.top, s.start[s.top]}}
+	s.enter[s.top] = num
+	num++
+	for len(stack) > 0 {
+		f := &stack[len(stack)-1]
+		if f.next == s.start[f.x+1] {
+			s.exit[f.x] = num
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		c := s.children[f.next]
+		f.next++
+		s.enter[c] = num
+		num++
+		stack = append(stack, frame{c, s.start[c]})
+	}
+	d.dominators = s
+	return s
+}
+
+// Idom returns the immediate dominator of x, or ObjNil if x is
+// dominated only by the roots or is unreachable.
+func (s *Dominators) Idom(x ObjId) ObjId {
+	y := s.t.idom[x]
+	if y == s.top {
+		return ObjNil
+	}
+	return y
+}
+
+// Top returns the objects dominated only by the roots, the tops of the
+// tree, in increasing ObjId order.
+func (s *Dominators) Top() []ObjId {
+	return s.children[s.start[s.top]:s.start[s.top+1]]
+}
+
+// Children returns the objects x immediately dominates, in increasing
+// ObjId order.  The slice is shared, and must not be modified.
+func (s *Dominators) Children(x ObjId) []ObjId {
+	return s.children[s.start[x]:s.start[x+1]]
+}
+
+// Dominates reports whether x dominates y, in constant time.  Every
+// reachable object dominates itself; unreachable objects dominate, and
+// are dominated by, nothing.
+func (s *Dominators) Dominates(x, y ObjId) bool {
+	if s.enter[x] < 0 || s.enter[y] < 0 {
+		return false
+	}
+	return s.enter[x] <= s.enter[y] && s.enter[y] < s.exit[x]
+}
+
+// Reachable reports whether x is reachable from the roots, and so in
+// the tree.
+func (s *Dominators) Reachable(x ObjId) bool {
+	return s.enter[x] >= 0
+}
+
+// Dominated calls f for each object x dominates, x first and then the
+// rest of its subtree in preorder, stopping if f returns false.
+func (s *Dominators) Dominated(x ObjId, f func(y ObjId) bool) {
+	if !s.Reachable(x) {
+		return
+	}
+	stack := []ObjId{x}
+	for len(stack) > 0 {
+		y := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !f(y) {
+			return
+		}
+		c := s.Children(y)
+		for i := len(c) - 1; i >= 0; i-- {
+			stack = append(stack, c[i])
+		}
+	}
+}
+
+// RetainedSize returns the bytes of the objects x dominates, as
+// Dump.RetainedSize does.
+func (s *Dominators) RetainedSize(x ObjId) uint64 {
+	return s.t.retained[x]
+}
+
+// RetainedSizes returns the retained size of every object, indexed by
+// ObjId, as RetainedSize gives them.  The slice is shared, and must
+// not be modified.
+func (d *Dump) RetainedSizes() []uint64 {
+	return d.domTree(false).retained[:d.NumObjects()]
+}
@@ -0,0 +1,60 @@
+package read
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/randall77/heapdump14/binutil"
+)
+
+// An Executable is a binary that contributed code and data to the
+// dumped process.
+type Executable struct {
+	Path string
+	// Base is the address at which the binary was loaded.  It is 0 for
+	// the main executable and for anything else linked at a fixed address.
+	Base uint64
+}
+
+// ParseExecutable parses an executable specification of the form
+// path[@base], where base is the load address of the binary, in any
+// base accepted by strconv.ParseUint (e.g. plugin.so@0x7f0000000000).
+func ParseExecutable(s string) (Executable, error) {
+	i := strings.LastIndex(s, "@")
+	if i < 0 {
+		return Executable{Path: s}, nil
+	}
+	base, err := strconv.ParseUint(s[i+1:], 0, 64)
+	if err != nil {
+		return Executable{}, fmt.Errorf("bad load address in %q: %v", s, err)
+	}
+	return Executable{Path: s[:i], Base: base}, nil
+}
+
+// loadExecs reads the dwarf information from each of the given
+// executables, through cache if it is not nil.  For executables
+// without dwarf info, it reads their function tables instead.  It may
+// run concurrently with the rest of loading, so it must not touch the
+// Dump; it returns an error for the caller to report instead.
+func loadExecs(p Params, execs []Executable, rw []binutil.NameRewrite, cache *binutil.Cache) ([]*binutil.Binary, []*binutil.FuncTable, error) {
+	open := binutil.OpenWithOptions
+	if cache != nil {
+		open = cache.Open
+	}
+	var bins []*binutil.Binary
+	var tabs []*binutil.FuncTable
+	for _, e := range execs {
+		b, err := open(e.Path, e.Base, p.Platform, &binutil.OpenOptions{NameRewrites: rw})
+		if err != nil {
+			t, terr := binutil.OpenFuncTable(e.Path, e.Base, p.Platform)
+			if terr != nil {
+				return nil, nil, fmt.Errorf("%v; %v", err, terr)
+			}
+			tabs = append(tabs, t)
+			continue
+		}
+		bins = append(bins, b)
+	}
+	return bins, tabs, nil
+}
@@ -0,0 +1,79 @@
+package read
+
+import "fmt"
+
+// A Feature is a kind of information a Dump may or may not have.
+// Everything in the package works on a dump read without executables,
+// which is what automated pipelines often have: objects are typed by
+// gc signature only (e.g. "16_P"), fields and globals are named by
+// position, and stacks are named by the function names in the dump.
+// Histograms, the object graph, dominators, goroutines and memstats
+// are all available.  Features say what more there is, so that tools
+// can report what they had to leave out instead of failing.
+type Feature int
+
+const (
+	// FeatureDwarfTypes means objects have Go types from the
+	// executables' dwarf info.
+	FeatureDwarfTypes Feature = iota
+	// FeatureFieldNames means object fields, globals and stack
+	// variables have their source names.
+	FeatureFieldNames
+	// FeatureFuncNames means deferred calls are named.  Stack frames
+	// are always named.
+	FeatureFuncNames
+	// FeatureMemProf means the dump has memory profile records, and
+	// AllocSamples says where sampled objects were allocated.
+	FeatureMemProf
+	// FeatureMemStats means the dump has the runtime's memory
+	// statistics.
+	FeatureMemStats
+	// FeatureComplete means the dump was written to the end, rather
+	// than truncated; see Dump.Truncation.
+	FeatureComplete
+
+	numFeatures
+)
+
+var featureNames = [...]string{
+	FeatureDwarfTypes: "dwarf-types",
+	FeatureFieldNames: "field-names",
+	FeatureFuncNames:  "func-names",
+	FeatureMemProf:    "memprof",
+	FeatureMemStats:   "memstats",
+	FeatureComplete:   "complete",
+}
+
+func (f Feature) String() string {
+	if f < 0 || f >= numFeatures {
+		return fmt.Sprintf("Feature(%d)", int(f))
+	}
+	return featureNames[f]
+}
+
+// Has reports whether d has feature f.
+func (d *Dump) Has(f Feature) bool {
+	switch f {
+	case FeatureDwarfTypes, FeatureFieldNames, FeatureFuncNames:
+		return d.hasDwarf
+	case FeatureMemProf:
+		return len(d.MemProf) > 0
+	case FeatureMemStats:
+		return d.Memstats != nil
+	case FeatureComplete:
+		return d.truncation == nil
+	}
+	return false
+}
+
+// Features returns the features d has, and those it lacks.
+func (d *Dump) Features() (have, lack []Feature) {
+	for f := Feature(0); f < numFeatures; f++ {
+		if d.Has(f) {
+			have = append(have, f)
+		} else {
+			lack = append(lack, f)
+		}
+	}
+	return have, lack
+}
@@ -0,0 +1,58 @@
+package read
+
+import "fmt"
+
+// A Format is a version of the heap dump format, named by the Go
+// release whose runtime wrote it, as the dump's header line does.
+// Records which are laid out the same in every version are decoded by
+// the same code; formatInfo says where the versions diverge.
+type Format int
+
+const (
+	FormatGo14 Format = iota
+	FormatGo15
+	FormatGo16
+	FormatGo17
+
+	numFormats
+)
+
+type formatInfo struct {
+	name string // as in the header, e.g. "go1.4"
+	// goarch is set if the params record names the architecture by
+	// its GOARCH string, rather than by the toolchain's letter for it,
+	// which Go 1.7 dropped.
+	goarch bool
+}
+
+var formats = [...]formatInfo{
+	FormatGo14: {"go1.4", false},
+	FormatGo15: {"go1.5", false},
+	FormatGo16: {"go1.6", false},
+	FormatGo17: {"go1.7", true},
+}
+
+func (f Format) String() string {
+	if f < 0 || f >= numFormats {
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+	return formats[f].name
+}
+
+// parseHeader returns the format of a dump with the given header line.
+func parseHeader(hdr string) (Format, error) {
+	for f := Format(0); f < numFormats; f++ {
+		if hdr == formats[f].name+" heap dump" {
+			return f, nil
+		}
+	}
+	if len(hdr) > 40 {
+		hdr = hdr[:40] + "..."
+	}
+	return 0, fmt.Errorf("not a go1.4 to go1.7 heap dump file: header %q", hdr)
+}
+
+// Format returns the version of the dump's format.
+func (d *Dump) Format() Format {
+	return d.format
+}
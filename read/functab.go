@@ -0,0 +1,71 @@
+package read
+
+import (
+	"fmt"
+
+	"github.com/randall77/heapdump14/binutil"
+)
+
+// nameWithFuncTables names the frame slots of the functions of
+// executables without dwarf info, which nameFallback numbered, from
+// the stack maps of their function tables.  A slot among a function's
+// locals is named local.N, for the Nth word of its locals counting up
+// from the lowest, and a slot among the arguments of the function it
+// called outarg.N, for the Nth word of those.  The names stay the same
+// at every pc of a function, as the maps' sizes do, so slots of the
+// same function in different goroutines can be compared.  Slots in
+// neither area keep their numbers.
+func nameWithFuncTables(d *Dump, tabs []*binutil.FuncTable) {
+	// Go 1.4 kept the stack map index in the second pc-value table,
+	// and gave each word two bits.
+	index, bits := 0, 1
+	if d.format == FormatGo14 {
+		index, bits = 1, 2
+	}
+	lookup := func(f *StackFrame) (binutil.FuncFrame, bool) {
+		for _, t := range tabs {
+			if fr, ok := t.Frame(f.lookupPC(), index); ok {
+				return fr, true
+			}
+		}
+		return binutil.FuncFrame{}, false
+	}
+	ptr := d.PtrSize
+	minFrame := d.Arch.MinFrameSize()
+	for _, g := range d.Goroutines {
+		var callee binutil.FuncFrame
+		haveCallee := false
+		for r := g.Bos; r != nil; r = r.Parent {
+			fr, ok := lookup(r)
+			if ok {
+				// The locals end at the top of the frame, below the
+				// return address on machines which push one.
+				top := uint64(len(r.Data))
+				if minFrame == 0 && top >= ptr {
+					top -= ptr
+				}
+				locals := uint64(fr.Locals.N/bits) * ptr
+				if locals > top {
+					locals = top
+				}
+				var args uint64
+				if haveCallee {
+					args = uint64(callee.Args.N/bits) * ptr
+					if callee.Args.N == 0 && callee.ArgSize > 0 {
+						args = uint64(callee.ArgSize)
+					}
+				}
+				for i := range r.Fields {
+					off := r.Fields[i].Offset
+					switch {
+					case off >= minFrame && off < minFrame+args:
+						r.Fields[i].Name = fmt.Sprintf("outarg.%d", (off-minFrame)/ptr)
+					case off >= top-locals && off < top:
+						r.Fields[i].Name = fmt.Sprintf("local.%d", (off-(top-locals))/ptr)
+					}
+				}
+			}
+			callee, haveCallee = fr, ok
+		}
+	}
+}
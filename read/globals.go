@@ -0,0 +1,126 @@
+package read
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/randall77/heapdump14/binutil"
+)
+
+// A Global is a global variable in the data or bss section, as the
+// dwarf info of the executables describes it.
+type Global struct {
+	Name string
+	Addr uint64
+	Size uint64
+	Type string // name of its type
+
+	typ binutil.Type
+}
+
+// ReadAt reads len(p) bytes of the section's contents starting off
+// bytes into the section.  Like any io.ReaderAt, it returns io.EOF
+// with the bytes there are if the section ends first.
+func (x *Data) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("%s section: negative offset %d", x.name, off)
+	}
+	if off >= int64(len(x.Data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, x.Data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Globals returns the global variables in the section, in address
+// order.  It is empty unless the dump was read with dwarf info.
+func (x *Data) Globals() []Global {
+	return x.globals
+}
+
+// Global returns the global variable named name, like "main.config",
+// if it is in the section.
+func (x *Data) Global(name string) (Global, bool) {
+	for _, g := range x.globals {
+		if g.Name == name {
+			return g, true
+		}
+	}
+	return Global{}, false
+}
+
+// Slice returns the contents of the global variable named name, all
+// Size bytes of it, for decoding global state such as configuration
+// structs and registries.  The result aliases the section's data.
+func (x *Data) Slice(name string) ([]byte, error) {
+	g, ok := x.Global(name)
+	if !ok {
+		if len(x.globals) == 0 {
+			return nil, fmt.Errorf("%s section: no global %s: no dwarf info for globals", x.name, name)
+		}
+		return nil, fmt.Errorf("%s section: no global %s", x.name, name)
+	}
+	off := g.Addr - x.Addr
+	if g.Size > uint64(len(x.Data))-off {
+		return nil, fmt.Errorf("%s section: global %s (%d bytes at %x) extends past the section's end %x", x.name, name, g.Size, g.Addr, x.Addr+uint64(len(x.Data)))
+	}
+	return x.Data[off : off+g.Size : off+g.Size], nil
+}
+
+// GlobalBytes returns the contents of the global variable named name
+// and the section, data or bss, holding it.
+func (d *Dump) GlobalBytes(name string) ([]byte, *Data, error) {
+	for _, x := range []*Data{d.Data, d.Bss} {
+		if x == nil {
+			continue
+		}
+		if _, ok := x.Global(name); ok {
+			b, err := x.Slice(name)
+			return b, x, err
+		}
+	}
+	return nil, nil, fmt.Errorf("no global %s in the data or bss section", name)
+}
+
+// globalField returns the bytes of field of the global variable named
+// name, where field is a member name as dwarf types flatten them, like
+// "arena_start" or "spans.len", or "" for the whole variable.
+func (d *Dump) globalField(name, field string) ([]byte, bool) {
+	b, x, err := d.GlobalBytes(name)
+	if err != nil {
+		return nil, false
+	}
+	if field == "" {
+		return b, true
+	}
+	g, _ := x.Global(name)
+	for _, m := range g.typ.Members() {
+		if m.Name == field && m.Offset+m.Type.Size() <= uint64(len(b)) {
+			return b[m.Offset : m.Offset+m.Type.Size()], true
+		}
+	}
+	return nil, false
+}
+
+// addGlobal records g in whichever section holds its address.
+func (d *Dump) addGlobal(g Global) {
+	for _, x := range []*Data{d.Data, d.Bss} {
+		if x != nil && g.Addr >= x.Addr && g.Addr < x.Addr+uint64(len(x.Data)) {
+			x.globals = append(x.globals, g)
+			return
+		}
+	}
+}
+
+// sortGlobals puts the globals of each section in address order.
+func (d *Dump) sortGlobals() {
+	for _, x := range []*Data{d.Data, d.Bss} {
+		if x != nil {
+			sort.SliceStable(x.globals, func(i, j int) bool { return x.globals[i].Addr < x.globals[j].Addr })
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package read
+
+import "fmt"
+
+// A GoroutineEdge is a pointer into the heap held by a goroutine.
+type GoroutineEdge struct {
+	Edge
+	// Via says where the pointer is: "frame" for a local variable or
+	// argument, "ctxt" for the closure context, "defer" for a
+	// deferred call's record or closure, and "panic" for a panic's
+	// record or value.
+	Via string
+	// Frame is the frame holding a "frame" edge.
+	Frame *StackFrame
+}
+
+// Edges returns the pointers into the heap from g: those from its
+// stack frames, innermost first, then from its closure
+// context, its deferred calls and its panics.  Together they are what
+// g keeps alive directly.  FieldName names the variable or record
+// field holding each pointer.
+func (g *GoRoutine) Edges() []GoroutineEdge {
+	d := g.d
+	var r []GoroutineEdge
+	for f := g.Bos; f != nil; f = f.Parent {
+		for _, e := range f.EdgeList() {
+			e.FieldName = joinNames(f.Name, e.FieldName)
+			r = append(r, GoroutineEdge{e, "frame", f})
+		}
+	}
+	// ptr adds an edge for p, if it points into the heap.
+	ptr := func(via, name string, p uint64) {
+		if x := d.FindObj(p); x != ObjNil {
+			r = append(r, GoroutineEdge{Edge{To: x, ToOffset: p - d.Addr(x), FieldName: name}, via, nil})
+		}
+	}
+	ptr("ctxt", "ctxt", g.ctxtaddr)
+	for _, f := range g.Defers {
+		ptr("defer", "defer", f.Addr)
+		ptr("defer", "defer.fn", f.Fn)
+	}
+	for _, p := range g.Panics {
+		ptr("panic", "panic", p.Addr)
+		ptr("panic", "panic.arg", p.Data)
+	}
+	return r
+}
+
+// Value describes the value p is panicking with: its type, and for
+// strings and errors made by errors.New and the runtime, the text.
+// The text of a string constant is in the executable's read-only data,
+// which isn't dumped, so only its address is given.
+func (p *Panic) Value() string {
+	d := p.d
+	if p.ValueType == nil {
+		return fmt.Sprintf("value of type %x at %x", p.Type, p.Data)
+	}
+	name := p.ValueType.Name
+	var s string
+	var ok bool
+	switch name {
+	case "string", "runtime.errorString":
+		// The data word points to the string header.
+		s, ok = d.readString(p.Data)
+	case "*errors.errorString":
+		// The data word is the pointer, to a struct{ s string }.
+		s, ok = d.readString(p.Data)
+	default:
+		return fmt.Sprintf("%s at %x", name, p.Data)
+	}
+	if !ok {
+		return fmt.Sprintf("%s at %x (text not in dump)", name, p.Data)
+	}
+	return fmt.Sprintf("%s %q", name, s)
+}
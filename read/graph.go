@@ -0,0 +1,596 @@
+package read
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A RootKind describes where a root pointer lives.
+type RootKind int
+
+const (
+	RootData   RootKind = iota // global variable in the data segment
+	RootBss                    // global variable in the bss segment
+	RootFrame                  // local variable or argument in a stack frame
+	RootOther                  // other runtime root (finalizer specials, ...)
+	RootQFinal                 // finalizer queued to run
+	numRootKinds
+)
+
+var rootKindNames = [...]string{
+	RootData:   "data",
+	RootBss:    "bss",
+	RootFrame:  "frame",
+	RootOther:  "other",
+	RootQFinal: "qfinal",
+}
+
+func (k RootKind) String() string {
+	if k < 0 || k >= numRootKinds {
+		return fmt.Sprintf("RootKind(%d)", int(k))
+	}
+	return rootKindNames[k]
+}
+
+// A Root is a pointer into the heap from outside the heap.  Besides
+// its kind and name, it records the record it came from, so reports
+// can say precisely where a root is without searching for it.
+type Root struct {
+	Kind  RootKind
+	Name  string      // global variable, frame variable, or root description
+	Frame *StackFrame // frame containing the pointer, for RootFrame roots
+	Edge  Edge        // edge from the root to its target object
+
+	Goroutine *GoRoutine  // goroutine whose stack holds Frame, for RootFrame roots
+	Section   *Data       // d.Data or d.Bss, for RootData and RootBss roots
+	Other     *OtherRoot  // for RootOther roots
+	QFinal    *QFinalizer // for RootQFinal roots
+}
+
+// Source describes where r is: its kind and name, and for frame roots
+// the goroutine, and for queued finalizers the object being finalized.
+func (r Root) Source() string {
+	switch {
+	case r.Goroutine != nil:
+		return fmt.Sprintf("%s %s in goroutine %d", r.Kind, r.Name, r.Goroutine.Goid)
+	case r.QFinal != nil:
+		return fmt.Sprintf("%s %s, finalizing object %x", r.Kind, r.Name, r.QFinal.obj)
+	}
+	return fmt.Sprintf("%s %s", r.Kind, r.Name)
+}
+
+// Roots returns a list of all the root pointers into the heap.  The
+// list is built on the first call and shared after, unless the dump
+// was read with a MaxEdgeMemory too small to keep every frame's and
+// section's edges: then it isn't kept either, and each call finds the
+// pointers of those frames and sections again, in time proportional
+// to the size of the stacks and globals.  Callers wanting the roots
+// more than once should call Roots once and keep the result.
+func (d *Dump) Roots() []Root {
+	if d.roots != nil {
+		return d.roots
+	}
+	roots := []Root{}
+	for _, e := range d.Data.EdgeList() {
+		roots = append(roots, Root{Kind: RootData, Name: e.FieldName, Edge: e, Section: d.Data})
+	}
+	for _, e := range d.Bss.EdgeList() {
+		roots = append(roots, Root{Kind: RootBss, Name: e.FieldName, Edge: e, Section: d.Bss})
+	}
+	for _, f := range d.Frames {
+		for _, e := range f.EdgeList() {
+			roots = append(roots, Root{Kind: RootFrame, Name: joinNames(f.Name, e.FieldName), Frame: f, Edge: e, Goroutine: f.Goroutine})
+		}
+	}
+	for _, r := range d.Otherroots {
+		for _, e := range r.Edges {
+			roots = append(roots, Root{Kind: RootOther, Name: r.Description, Edge: e, Other: r})
+		}
+	}
+	for _, f := range d.QFinal {
+		for _, e := range f.Edges {
+			roots = append(roots, Root{Kind: RootQFinal, Name: "finalizer queue", Edge: e, QFinal: f})
+		}
+	}
+	if !d.streamedEdges {
+		// When edges are being streamed to save memory, don't keep
+		// a copy of them all here.
+		d.roots = roots
+	}
+	return roots
+}
+
+// RootReferrers returns the roots which point to object x, in the
+// order Roots lists them.  The first call builds an index of the roots
+// of every object; if Roots isn't kept, there is no index, and each
+// call takes as long as Roots does.  To find the roots of many
+// objects, index the result of one call to Roots instead.
+func (d *Dump) RootReferrers(x ObjId) []Root {
+	roots := d.Roots()
+	var r []Root
+	if d.roots == nil {
+		// The roots aren't kept, so neither is an index of them.
+		for _, s := range roots {
+			if s.Edge.To == x {
+				r = append(r, s)
+			}
+		}
+		return r
+	}
+	if d.rootRefs == nil {
+		d.rootRefs = map[ObjId][]int32{}
+		for i, s := range roots {
+			d.rootRefs[s.Edge.To] = append(d.rootRefs[s.Edge.To], int32(i))
+		}
+	}
+	for _, i := range d.rootRefs[x] {
+		r = append(r, roots[i])
+	}
+	return r
+}
+
+// A refIndex maps each object to the list of objects that refer to it.
+// It is split in two parts for efficiency.  If an object x has <= 1
+// inbound edge, we store it in ref1[x].  Otherwise, it is stored in ref2[x].
+// Since most objects have only one incoming reference,
+// ref2 ends up small.
+type refIndex struct {
+	ref1 []ObjId
+	ref2 map[ObjId][]ObjId
+}
+
+// buildRefIndex builds a reverse edge index.  If precise is set,
+// conservative edges are left out.
+func (d *Dump) buildRefIndex(precise bool) *refIndex {
+	n := d.NumObjects()
+	r := &refIndex{make([]ObjId, n), map[ObjId][]ObjId{}}
+	for i := 0; i < n; i++ {
+		r.ref1[i] = ObjNil
+	}
+	for i := 0; i < n; i++ {
+		x := ObjId(i)
+		for _, e := range d.Edges(x) {
+			if precise && e.Conservative {
+				continue
+			}
+			y := r.ref1[e.To]
+			if y == ObjNil {
+				r.ref1[e.To] = x
+			} else if x != y {
+				s := r.ref2[e.To]
+				if len(s) == 0 || x != s[len(s)-1] {
+					r.ref2[e.To] = append(s, x)
+				}
+			}
+		}
+	}
+	return r
+}
+
+// referrers returns the referrers of x, appended to buf.
+func (r *refIndex) referrers(buf []ObjId, x ObjId) []ObjId {
+	if y := r.ref1[x]; y != ObjNil {
+		buf = append(buf, y)
+		buf = append(buf, r.ref2[x]...)
+	}
+	return buf
+}
+
+// mayBeConservative reports whether the dump might have conservative edges.
+func (d *Dump) mayBeConservative() bool {
+	return d.conservative || d.ifacePolicy == IfaceConservative
+}
+
+// refIndex returns the (possibly precise) reverse edge index,
+// loading it from the index store or building it if needed.
+func (d *Dump) refIndex(precise bool) *refIndex {
+	if precise && d.mayBeConservative() {
+		if d.preciseRefs == nil {
+			d.preciseRefs = d.loadRefIndex(preciseRefsIndex)
+		}
+		if d.preciseRefs == nil {
+			d.preciseRefs = d.buildRefIndex(true)
+			d.storeRefIndex(preciseRefsIndex, d.preciseRefs)
+		}
+		return d.preciseRefs
+	}
+	if d.refs == nil {
+		d.refs = d.loadRefIndex(refsIndex)
+	}
+	if d.refs == nil {
+		d.refs = d.buildRefIndex(false)
+		d.storeRefIndex(refsIndex, d.refs)
+	}
+	return d.refs
+}
+
+// ComputeReferrers builds the reverse edge indexes used by Referrers
+// and RootReferrers.  They build them on demand; call this to control
+// when the cost is paid.
+func (d *Dump) ComputeReferrers() {
+	d.refIndex(false)
+	if !d.streamedEdges {
+		d.RootReferrers(ObjNil)
+	}
+}
+
+// Referrers returns the list of heap objects which have an edge to x,
+// in increasing ObjId order.  Each referrer appears once, even if it
+// has several edges to x.  The first call builds an index of the
+// referrers of every object, in time proportional to the number of
+// edges, so that each call after takes time proportional to the
+// referrers it returns.
+func (d *Dump) Referrers(x ObjId) []ObjId {
+	return d.refIndex(false).referrers(nil, x)
+}
+
+// HasReferrers reports whether the index Referrers uses is built, so
+// that Referrers takes time proportional only to what it returns.
+func (d *Dump) HasReferrers() bool {
+	return d.refs != nil
+}
+
+// edgesTo returns the edges from x to y.
+func (d *Dump) edgesTo(x, y ObjId) []Edge {
+	var r []Edge
+	for _, e := range d.Edges(x) {
+		if e.To == y {
+			r = append(r, e)
+		}
+	}
+	return r
+}
+
+// A Path is a chain of pointers leading from a root to an object.
+type Path struct {
+	Root  Root    // Root.Edge points to Objs[0]
+	Objs  []ObjId // objects along the path, ending with the target object
+	Edges []Edge  // Edges[i] leads from Objs[i] to Objs[i+1]
+}
+
+// PathToRoot returns a shortest path from any root to x.
+// It returns false if x is not reachable.
+func (d *Dump) PathToRoot(x ObjId) (Path, bool) {
+	roots := d.Roots()
+	rootOf := map[ObjId]int{}
+	for i, r := range roots {
+		if _, ok := rootOf[r.Edge.To]; !ok {
+			rootOf[r.Edge.To] = i
+		}
+	}
+	// Breadth-first search backwards from x.  next[y] is the
+	// object after y on the path from y to x.
+	next := map[ObjId]ObjId{x: ObjNil}
+	q := []ObjId{x}
+	for len(q) > 0 {
+		y := q[0]
+		q = q[1:]
+		if i, ok := rootOf[y]; ok {
+			return d.makePath(roots[i], y, next), true
+		}
+		for _, z := range d.Referrers(y) {
+			if _, ok := next[z]; !ok {
+				next[z] = y
+				q = append(q, z)
+			}
+		}
+	}
+	return Path{}, false
+}
+
+// makePath builds the path starting at root r and object y, and
+// following next until it runs out.
+func (d *Dump) makePath(r Root, y ObjId, next map[ObjId]ObjId) Path {
+	p := Path{Root: r}
+	for ; y != ObjNil; y = next[y] {
+		if len(p.Objs) > 0 {
+			p.Edges = append(p.Edges, d.edgesTo(p.Objs[len(p.Objs)-1], y)[0])
+		}
+		p.Objs = append(p.Objs, y)
+	}
+	return p
+}
+
+// A domTree is the dominator tree of the heap, indexed by ObjId.
+// Index NumObjects() is a virtual node representing all the roots.
+type domTree struct {
+	idom     []ObjId
+	retained []uint64 // bytes dominated by each object
+}
+
+// buildDomTree computes the dominator tree.  If precise is set,
+// conservative edges are left out.
+func (d *Dump) buildDomTree(precise bool) *domTree {
+	refs := d.refIndex(precise)
+	n := d.NumObjects()
+	seen := make([]bool, n)
+	var roots []ObjId
+	for _, r := range d.Roots() {
+		if precise && r.Edge.Conservative || seen[r.Edge.To] {
+			continue
+		}
+		seen[r.Edge.To] = true
+		roots = append(roots, r.Edge.To)
+	}
+	sort.Sort(byObjId(roots))
+
+	// compute postorder traversal
+	// object states:
+	// 0 - not seen yet
+	// 1 - seen, added to queue, not yet expanded children
+	// 2 - seen, already expanded children
+	// 3 - added to postorder
+	postorder := make([]ObjId, 0, n)
+	postnum := make([]int, n+1)
+	state := make([]byte, n)
+	var q []ObjId // stack of work to do, holds state 1 and 2 objects
+	for _, x := range roots {
+		if state[x] != 0 {
+			continue
+		}
+		state[x] = 1
+		q = q[:0]
+		q = append(q, x)
+		for len(q) > 0 {
+			y := q[len(q)-1]
+			if state[y] == 2 {
+				state[y] = 3
+				q = q[:len(q)-1]
+				postnum[y] = len(postorder)
+				postorder = append(postorder, y)
+			} else {
+				state[y] = 2
+				for _, e := range d.Edges(y) {
+					if precise && e.Conservative {
+						continue
+					}
+					z := e.To
+					if state[z] == 0 {
+						state[z] = 1
+						q = append(q, z)
+					}
+				}
+			}
+		}
+	}
+	postnum[n] = n // virtual start node
+
+	// compute immediate dominators
+	// http://www.hipersoft.rice.edu/grads/publications/dom14.pdf
+	idom := make([]ObjId, n+1)
+	for i := 0; i < n; i++ {
+		idom[i] = ObjNil
+	}
+	idom[n] = ObjId(n)
+	for _, r := range roots {
+		idom[r] = ObjId(n)
+	}
+	var redges []ObjId
+	change := true
+	for change {
+		change = false
+		for i := len(postorder) - 1; i >= 0; i-- {
+			x := postorder[i]
+			if seen[x] {
+				continue // roots are dominated by the virtual start node
+			}
+			// get list of incoming edges
+			redges = refs.referrers(redges[:0], x)
+			a := ObjNil
+			for _, b := range redges {
+				if idom[b] == ObjNil {
+					continue
+				}
+				if a == ObjNil {
+					a = b
+					continue
+				}
+				for a != b {
+					if postnum[a] < postnum[b] {
+						a = idom[a]
+					} else {
+						b = idom[b]
+					}
+				}
+			}
+			if a != idom[x] {
+				idom[x] = a
+				change = true
+			}
+		}
+	}
+
+	retained := make([]uint64, n+1)
+	for _, x := range postorder {
+		retained[x] += d.Size(x)
+		retained[idom[x]] += retained[x]
+	}
+	return &domTree{idom, retained}
+}
+
+// domTree returns the (possibly precise) dominator tree, loading it
+// from the index store or building it if needed.
+func (d *Dump) domTree(precise bool) *domTree {
+	if precise && d.mayBeConservative() {
+		if d.preciseDom == nil {
+			d.preciseDom = d.loadDomTree(preciseDomIndex)
+		}
+		if d.preciseDom == nil {
+			d.preciseDom = d.buildDomTree(true)
+			d.storeDomTree(preciseDomIndex, d.preciseDom)
+		}
+		return d.preciseDom
+	}
+	if d.dom == nil {
+		d.dom = d.loadDomTree(domIndex)
+	}
+	if d.dom == nil {
+		d.dom = d.buildDomTree(false)
+		d.storeDomTree(domIndex, d.dom)
+	}
+	return d.dom
+}
+
+// ComputeDominators computes the dominator tree of the heap, which
+// Idom and RetainedSize report.  They compute it on demand; call this
+// to control when the cost is paid.
+func (d *Dump) ComputeDominators() {
+	d.domTree(false)
+}
+
+// Idom returns the immediate dominator of x.  It returns ObjNil if x
+// is dominated only by the roots, or if x is unreachable.
+func (d *Dump) Idom(x ObjId) ObjId {
+	y := d.domTree(false).idom[x]
+	if y == ObjId(d.NumObjects()) {
+		return ObjNil
+	}
+	return y
+}
+
+// RetainedSize returns the number of bytes of heap dominated by x,
+// that is, the bytes which would be freed if x became unreachable.
+// Unreachable objects retain nothing, and neither do zero-sized
+// objects, which can't hold pointers.
+func (d *Dump) RetainedSize(x ObjId) uint64 {
+	return d.domTree(false).retained[x]
+}
+
+// PreciseRetainedSize is like RetainedSize, but ignores conservative
+// edges.  Comparing it with RetainedSize shows how much of a retained
+// size depends on words which may not really be pointers.
+func (d *Dump) PreciseRetainedSize(x ObjId) uint64 {
+	return d.domTree(true).retained[x]
+}
+
+type byObjId []ObjId
+
+func (a byObjId) Len() int           { return len(a) }
+func (a byObjId) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byObjId) Less(i, j int) bool { return a[i] < a[j] }
+
+// ParseRootKind returns the RootKind with the given name, as
+// returned by RootKind.String.
+func ParseRootKind(s string) (RootKind, error) {
+	for k, n := range rootKindNames {
+		if n == s {
+			return RootKind(k), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown root kind %q", s)
+}
+
+// PathOptions controls which paths KShortestPaths finds.
+type PathOptions struct {
+	// Exclude lists kinds of roots that paths may not start at.
+	Exclude []RootKind
+	// Prefer lists kinds of roots whose paths are returned ahead of
+	// all other paths, even shorter ones.
+	Prefer []RootKind
+	// MaxDepth, if positive, is the most pointers between objects a
+	// path may follow, not counting the root's.  Longer paths are not
+	// looked for, which bounds the search in a deep heap.
+	MaxDepth int
+}
+
+// Maximum number of partial paths KShortestPaths will examine.
+const maxPathSearch = 1 << 20
+
+// pathState is a partial path, built backwards from the target.
+type pathState struct {
+	obj   ObjId
+	next  *pathState // next object toward the target; nil at the target
+	depth int        // number of edges from obj to the target
+}
+
+// contains reports whether x is on the partial path s.
+func (s *pathState) contains(x ObjId) bool {
+	for ; s != nil; s = s.next {
+		if s.obj == x {
+			return true
+		}
+	}
+	return false
+}
+
+// KShortestPaths returns up to k of the shortest acyclic paths from
+// roots to x, shortest first (but see PathOptions.Prefer).  Paths
+// from different roots to the same object count as different paths.
+// opts may be nil.
+func (d *Dump) KShortestPaths(x ObjId, k int, opts *PathOptions) []Path {
+	if opts == nil {
+		opts = &PathOptions{}
+	}
+	excluded := map[RootKind]bool{}
+	for _, r := range opts.Exclude {
+		excluded[r] = true
+	}
+	preferred := map[RootKind]bool{}
+	for _, r := range opts.Prefer {
+		preferred[r] = true
+	}
+	roots := d.Roots()
+	rootsOf := map[ObjId][]int{}
+	for i, r := range roots {
+		if !excluded[r.Kind] {
+			rootsOf[r.Edge.To] = append(rootsOf[r.Edge.To], i)
+		}
+	}
+
+	// Breadth-first search backwards from x over partial paths.  Like
+	// the standard k shortest walks algorithm, each object is expanded
+	// at most k times.  When some root kinds are preferred, we keep
+	// going until we've found k paths from preferred roots.
+	var best, other []Path
+	expanded := map[ObjId]int{}
+	q := []*pathState{{x, nil, 0}}
+	for n := 0; len(q) > 0 && n < maxPathSearch; n++ {
+		s := q[0]
+		q = q[1:]
+		for _, i := range rootsOf[s.obj] {
+			p := d.statePath(roots[i], s)
+			if len(preferred) == 0 || preferred[p.Root.Kind] {
+				best = append(best, p)
+			} else if len(other) < k {
+				other = append(other, p)
+			}
+		}
+		if len(best) >= k {
+			break
+		}
+		if expanded[s.obj] >= k || opts.MaxDepth > 0 && s.depth >= opts.MaxDepth {
+			continue
+		}
+		expanded[s.obj]++
+		for _, y := range d.Referrers(s.obj) {
+			if !s.contains(y) {
+				q = append(q, &pathState{y, s, s.depth + 1})
+			}
+		}
+	}
+	best = append(best, other...)
+	if len(best) > k {
+		best = best[:k]
+	}
+	return best
+}
+
+// AllPathsToRoot returns the acyclic paths from roots to x which
+// follow at most maxDepth pointers between objects, shortest first,
+// up to maxPaths of them: the ways x is kept alive, where PathToRoot
+// gives just one.  maxDepth may be 0 for no limit.
+func (d *Dump) AllPathsToRoot(x ObjId, maxDepth, maxPaths int) []Path {
+	return d.KShortestPaths(x, maxPaths, &PathOptions{MaxDepth: maxDepth})
+}
+
+// statePath converts a partial path which starts at root r into a Path.
+func (d *Dump) statePath(r Root, s *pathState) Path {
+	p := Path{Root: r}
+	for ; s != nil; s = s.next {
+		if len(p.Objs) > 0 {
+			p.Edges = append(p.Edges, d.edgesTo(p.Objs[len(p.Objs)-1], s.obj)[0])
+		}
+		p.Objs = append(p.Objs, s.obj)
+	}
+	return p
+}
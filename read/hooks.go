@@ -0,0 +1,81 @@
+package read
+
+import "log"
+
+// A RecordHook is called as each record of a dump is parsed, before
+// the records are linked together.  kind is the name of the record's
+// kind, as used in Stats.Records, and rec is the parsed record:
+//
+//	object      ObjectRecord
+//	otherroot   *OtherRoot
+//	type        *Type
+//	goroutine   *GoRoutine
+//	stackframe  *StackFrame
+//	params      Params
+//	finalizer   *Finalizer
+//	qfinal      *QFinalizer
+//	data, bss   *Data
+//	itab        *Itab
+//	osthread    *OSThread
+//	memstats    *runtime.MemStats
+//	defer       *Defer
+//	panic       *Panic
+//	memprof     *MemProfEntry
+//	allocsample *AllocSample
+//
+// Pointer records are the ones the Dump keeps, so a hook must not
+// modify them.  Fields filled in by linking, like GoRoutine.Bos and
+// StackFrame.Edges, are not set yet.
+type RecordHook func(kind string, rec interface{})
+
+// An ObjectRecord is the record of a heap object passed to hooks.
+// Type is the object's type as far as the garbage collector knows;
+// it is refined later if the dump is read with dwarf information.
+type ObjectRecord struct {
+	Addr uint64
+	Type *FullType
+}
+
+// OnRecord registers h to be called for each record of the given kind
+// (see RecordHook) while the dump is parsed.  Hooks can gather
+// information the Dump doesn't keep, or stream records elsewhere,
+// without another pass over the file.  Hooks for the same kind are
+// called in the order they were registered.
+func (o *Options) OnRecord(kind string, h RecordHook) {
+	found := false
+	for _, n := range tagNames {
+		if n == kind && kind != "eof" {
+			found = true
+		}
+	}
+	if !found {
+		log.Fatalf("OnRecord: unknown record kind %q", kind)
+	}
+	if o.recordHooks == nil {
+		o.recordHooks = map[string][]RecordHook{}
+	}
+	o.recordHooks[kind] = append(o.recordHooks[kind], h)
+}
+
+// hooks returns the registered hooks indexed by record tag.
+func (o *Options) hooks() []RecordHook {
+	if len(o.recordHooks) == 0 {
+		return nil
+	}
+	r := make([]RecordHook, len(tagNames))
+	for tag, n := range tagNames {
+		hs := o.recordHooks[n]
+		switch len(hs) {
+		case 0:
+		case 1:
+			r[tag] = hs[0]
+		default:
+			r[tag] = func(kind string, rec interface{}) {
+				for _, h := range hs {
+					h(kind, rec)
+				}
+			}
+		}
+	}
+	return r
+}
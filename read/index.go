@@ -0,0 +1,61 @@
+package read
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// BucketStats describes a bucket of the index FindObj uses, which
+// divides the heap into Stats.IndexBucketSize-byte buckets.  A lookup
+// examines the objects from the lowest-addressed one overlapping the
+// address's bucket up to the address, so buckets which many small
+// objects, or one large object before many small ones, overlap are
+// slow.
+type BucketStats struct {
+	Addr    uint64 // first address of the bucket
+	Objects int    // objects a lookup in the bucket may examine
+	// Lookups and Probes count the FindObj calls for addresses in the
+	// bucket and the objects they examined.  They are zero unless
+	// Options.ProfileIndex was set.
+	Lookups, Probes uint64
+}
+
+// WorstBuckets returns the n buckets of the FindObj index where
+// lookups have examined the most objects, or, without
+// Options.ProfileIndex, where they may examine the most, worst first.
+func (d *Dump) WorstBuckets(n int) []BucketStats {
+	worse := func(a, b BucketStats) bool {
+		if a.Probes != b.Probes {
+			return a.Probes > b.Probes
+		}
+		return a.Objects > b.Objects
+	}
+	// r holds the worst buckets so far, worst first.  Heaps have
+	// millions of buckets, so keep only n.
+	var r []BucketStats
+	for b := range d.idx {
+		start := d.idxStart + uint64(b)*bucketSize
+		s := BucketStats{Addr: start}
+		for i := d.idx[b]; int(i) < len(d.objects) && d.objects[i].Addr < start+bucketSize; i++ {
+			s.Objects++
+		}
+		if d.bucketLookups != nil {
+			s.Lookups = atomic.LoadUint64(&d.bucketLookups[b])
+			s.Probes = atomic.LoadUint64(&d.bucketProbes[b])
+		}
+		if s.Objects == 0 && s.Lookups == 0 || n <= 0 {
+			continue
+		}
+		if len(r) == n {
+			if !worse(s, r[n-1]) {
+				continue
+			}
+			r = r[:n-1]
+		}
+		i := sort.Search(len(r), func(i int) bool { return worse(s, r[i]) })
+		r = append(r, BucketStats{})
+		copy(r[i+1:], r[i:])
+		r[i] = s
+	}
+	return r
+}
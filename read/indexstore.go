@@ -0,0 +1,329 @@
+package read
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// An IndexStore keeps the reverse edge and dominator indexes of a dump
+// on disk, so that a server restarted on the same dump loads them
+// instead of spending hours building them again.  Each index is a set
+// of records keyed by ObjId, the shape embedded key-value stores like
+// bolt and pebble hold: a bolt adapter would keep each index in a
+// bucket, keyed by the big-endian key, and write it in one
+// transaction.  FileIndexStore is an implementation needing nothing
+// but a directory.
+type IndexStore interface {
+	// Load calls f for each record of the named index, in increasing
+	// key order, and stops at the first error f returns.  A missing
+	// index has no records.
+	Load(index string, f func(key uint64, value []byte) error) error
+	// Store replaces the named index with the records put calls
+	// write, which it calls in increasing key order.  A failed Store
+	// must leave no partial index behind.
+	Store(index string, write func(put func(key uint64, value []byte) error) error) error
+}
+
+// The indexes a Dump keeps in an IndexStore.  Each is stored with a
+// fingerprint of the dump, the first record, under key fingerprintKey,
+// so that indexes of another dump, or of the same dump read with other
+// options, are rebuilt rather than used.  The record of object x has
+// key x+1.
+const (
+	refsIndex        = "referrers"
+	preciseRefsIndex = "referrers-precise"
+	domIndex         = "dominators"
+	preciseDomIndex  = "dominators-precise"
+
+	fingerprintKey = 0
+)
+
+// errStale stops the loading of an index whose fingerprint isn't d's.
+var errStale = errors.New("stale index")
+
+// fingerprint returns a summary of everything about d the indexes
+// depend on: its objects, their types and contents, its roots, and the
+// options affecting its edges.
+func (d *Dump) fingerprint() []byte {
+	if d.indexFingerprint != nil {
+		return d.indexFingerprint
+	}
+	h := fnv.New64a()
+	var buf [binary.MaxVarintLen64]byte
+	num := func(v uint64) {
+		h.Write(buf[:binary.PutUvarint(buf[:], v)])
+	}
+	str := func(s string) {
+		num(uint64(len(s)))
+		io.WriteString(h, s)
+	}
+	for i := range d.objects {
+		x := ObjId(i)
+		num(d.Addr(x))
+		num(d.Size(x))
+		str(d.Ft(x).Name)
+		h.Write(d.Contents(x))
+	}
+	for _, r := range d.Roots() {
+		num(uint64(r.Kind))
+		str(r.Name)
+		num(uint64(r.Edge.To))
+		num(r.Edge.FromOffset)
+		num(r.Edge.ToOffset)
+	}
+	d.indexFingerprint = []byte(fmt.Sprintf("v2 bytes=%d objects=%d hash=%x dwarf=%v conservative=%v iface=%v window=%v",
+		d.stats.Bytes, len(d.objects), h.Sum64(), d.hasDwarf, d.conservative, d.ifacePolicy, d.window))
+	return d.indexFingerprint
+}
+
+// loadIndex loads the named index from d's store, calling f with the
+// ObjId of each record but the fingerprint.  It reports whether the
+// index was there, for this dump.  Records are decoded only once the
+// fingerprint, which comes first, matches.
+func (d *Dump) loadIndex(name string, f func(x uint64, value []byte) error) bool {
+	if d.indexStore == nil {
+		return false
+	}
+	want := string(d.fingerprint())
+	found := false
+	err := d.indexStore.Load(name, func(key uint64, value []byte) error {
+		if !found {
+			if key != fingerprintKey || string(value) != want {
+				return errStale
+			}
+			found = true
+			return nil
+		}
+		return f(key-1, value)
+	})
+	if err == errStale {
+		return false
+	}
+	if err != nil {
+		d.warnf("loading %s index: %v", name, err)
+		return false
+	}
+	return found
+}
+
+// storeIndex stores the named index in d's store, with records
+// writing its records, by ObjId.
+func (d *Dump) storeIndex(name string, records func(put func(x uint64, value []byte) error) error) {
+	if d.indexStore == nil {
+		return
+	}
+	err := d.indexStore.Store(name, func(put func(key uint64, value []byte) error) error {
+		if err := put(fingerprintKey, d.fingerprint()); err != nil {
+			return err
+		}
+		return records(func(x uint64, value []byte) error {
+			return put(x+1, value)
+		})
+	})
+	if err != nil {
+		d.warnf("storing %s index: %v", name, err)
+	}
+}
+
+// loadRefIndex loads a reverse edge index from d's store.  Each
+// record is an object's referrers, as uvarints.
+func (d *Dump) loadRefIndex(name string) *refIndex {
+	n := d.NumObjects()
+	r := &refIndex{make([]ObjId, n), map[ObjId][]ObjId{}}
+	for i := range r.ref1 {
+		r.ref1[i] = ObjNil
+	}
+	ok := d.loadIndex(name, func(key uint64, value []byte) error {
+		if key >= uint64(n) {
+			return fmt.Errorf("object %d out of range", key)
+		}
+		for len(value) > 0 {
+			y, k := binary.Uvarint(value)
+			if k <= 0 || y >= uint64(n) {
+				return fmt.Errorf("bad referrers of object %d", key)
+			}
+			value = value[k:]
+			if r.ref1[key] == ObjNil {
+				r.ref1[key] = ObjId(y)
+			} else {
+				r.ref2[ObjId(key)] = append(r.ref2[ObjId(key)], ObjId(y))
+			}
+		}
+		return nil
+	})
+	if !ok {
+		return nil
+	}
+	return r
+}
+
+// storeRefIndex stores r in d's store.
+func (d *Dump) storeRefIndex(name string, r *refIndex) {
+	d.storeIndex(name, func(put func(key uint64, value []byte) error) error {
+		var buf []byte
+		var ids []ObjId
+		for i := range r.ref1 {
+			ids = r.referrers(ids[:0], ObjId(i))
+			if len(ids) == 0 {
+				continue
+			}
+			buf = buf[:0]
+			for _, y := range ids {
+				buf = binary.AppendUvarint(buf, uint64(y))
+			}
+			if err := put(uint64(i), buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// loadDomTree loads a dominator tree from d's store.  Each record is
+// an object's immediate dominator plus one, so that ObjNil is 0, and
+// its retained size, as uvarints.
+func (d *Dump) loadDomTree(name string) *domTree {
+	n := d.NumObjects()
+	t := &domTree{make([]ObjId, n+1), make([]uint64, n+1)}
+	for i := range t.idom {
+		t.idom[i] = ObjNil
+	}
+	seen := 0
+	ok := d.loadIndex(name, func(key uint64, value []byte) error {
+		idom, k := binary.Uvarint(value)
+		retained, k2 := binary.Uvarint(value[max0(k):])
+		if key > uint64(n) || k <= 0 || k2 <= 0 || idom > uint64(n)+1 {
+			return fmt.Errorf("bad dominator record for object %d", key)
+		}
+		t.idom[key] = ObjId(idom) - 1
+		t.retained[key] = retained
+		seen++
+		return nil
+	})
+	if !ok || seen != n+1 {
+		return nil
+	}
+	return t
+}
+
+// max0 returns k, or 0 if k is negative.
+func max0(k int) int {
+	if k < 0 {
+		return 0
+	}
+	return k
+}
+
+// storeDomTree stores t in d's store.
+func (d *Dump) storeDomTree(name string, t *domTree) {
+	d.storeIndex(name, func(put func(key uint64, value []byte) error) error {
+		var buf []byte
+		for i := range t.idom {
+			buf = binary.AppendUvarint(buf[:0], uint64(t.idom[i]+1))
+			buf = binary.AppendUvarint(buf, t.retained[i])
+			if err := put(uint64(i), buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// A FileIndexStore is an IndexStore keeping each index in a file of a
+// directory.  Stores write a new file and rename it into place, so an
+// index is never left half written.
+type FileIndexStore struct {
+	Dir string
+}
+
+// NewFileIndexStore returns a store keeping indexes in dir, which it
+// creates if need be.
+func NewFileIndexStore(dir string) (*FileIndexStore, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	return &FileIndexStore{dir}, nil
+}
+
+// Load implements IndexStore.  Records are a uvarint key, a uvarint
+// length, and the value, in the order Store wrote them.
+func (s *FileIndexStore) Load(index string, f func(key uint64, value []byte) error) error {
+	file, err := os.Open(filepath.Join(s.Dir, index))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	var value []byte
+	for {
+		key, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", file.Name(), err)
+		}
+		n, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", file.Name(), err)
+		}
+		if n > 1<<30 {
+			return fmt.Errorf("%s: record of %d bytes", file.Name(), n)
+		}
+		if uint64(cap(value)) < n {
+			value = make([]byte, n)
+		}
+		value = value[:n]
+		if _, err := io.ReadFull(r, value); err != nil {
+			return fmt.Errorf("%s: %v", file.Name(), err)
+		}
+		if err := f(key, value); err == errStale {
+			return err
+		} else if err != nil {
+			return fmt.Errorf("%s: %v", file.Name(), err)
+		}
+	}
+}
+
+// Store implements IndexStore.
+func (s *FileIndexStore) Store(index string, write func(put func(key uint64, value []byte) error) error) error {
+	file, err := os.CreateTemp(s.Dir, index+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name()) // fails once renamed
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	var buf [2 * binary.MaxVarintLen64]byte
+	err = write(func(key uint64, value []byte) error {
+		k := binary.PutUvarint(buf[:], key)
+		k += binary.PutUvarint(buf[k:], uint64(len(value)))
+		if _, err := w.Write(buf[:k]); err != nil {
+			return err
+		}
+		_, err := w.Write(value)
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filepath.Join(s.Dir, index))
+}
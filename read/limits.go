@@ -0,0 +1,19 @@
+package read
+
+import "log"
+
+// maxInt is the largest int.  It bounds the length of any slice, so
+// it limits the size of an object, string or section, and the number
+// of objects, that a dump can have and still be read on this host.
+// On 64-bit hosts the limits are far beyond any real dump; on 32-bit
+// hosts a dump from a large 64-bit process may exceed them, and is
+// rejected with an error instead of overflowing.
+const maxInt = int(^uint(0) >> 1)
+
+// checkLen exits with an error if n, the length in bytes or elements
+// of what, is too large to hold in a slice on this host.
+func checkLen(what string, n uint64) {
+	if n > uint64(maxInt) {
+		log.Fatalf("%s has length %d, more than this host can hold in memory (%d)", what, n, maxInt)
+	}
+}
@@ -0,0 +1,121 @@
+package read
+
+import (
+	"fmt"
+
+	"github.com/randall77/heapdump14/binutil"
+)
+
+// Options controls how a heap dump is read.
+type Options struct {
+	// Parts, if not empty, lists the files of a dump split into
+	// parts, in order.  They are read as if concatenated, in place of
+	// the file ReadWithOptions is given.
+	Parts []string
+
+	// Executables lists the binaries whose dwarf information
+	// describes the process that wrote the dump.  The main executable
+	// comes first, followed by any plugins or shared objects it had
+	// loaded.  If empty, objects are typed only by their gc signatures.
+	Executables []Executable
+
+	// SymbolDirs lists directories, and SymbolServers debuginfod-style
+	// HTTP servers, to look for executables in by build ID when
+	// Executables is empty.  See FindExecutables.  If SymbolServers is
+	// nil, the servers are taken from $DEBUGINFOD_URLS.
+	SymbolDirs    []string
+	SymbolServers []string
+
+	// NameRewrites maps type names in the executables' dwarf info to
+	// the runtime's names for them.  If nil, binutil.DefaultNameRewrites
+	// is used.  See binutil.NameRewritesFor for presets.
+	NameRewrites []binutil.NameRewrite
+
+	// Binaries, if not nil, is where executables are opened, so that
+	// dumps of the same program read with the same Cache, even
+	// concurrently, share their dwarf information.
+	Binaries *binutil.Cache
+
+	// IfacePolicy says what to do with interface values whose type or
+	// itab isn't described in the dump.
+	IfacePolicy IfacePolicy
+
+	// Conservative requests that objects without dwarf type information,
+	// and stack frames without pointer maps, be scanned conservatively:
+	// every pointer-aligned word which points into an object is treated
+	// as a pointer.  This overestimates, rather than underestimates,
+	// what is reachable when type information is incomplete.
+	Conservative bool
+
+	// CacheSize is the number of bytes of the dump file to keep in
+	// memory when reading object contents.  If zero, every read goes
+	// to the file.
+	CacheSize int64
+
+	// MaxEdgeMemory, if positive, limits the memory used to keep the
+	// pointers from stack frames and global data into the heap to
+	// about this many bytes.  The pointers of frames and sections
+	// which don't fit are found again each time they are needed, which
+	// is slower; see StackFrame.EdgeList.  If negative, none are kept.
+	MaxEdgeMemory int64
+
+	// Window, if not zero, restricts loading to the objects starting
+	// in this range of addresses, such as one arena, to investigate
+	// part of a heap or to shard the analysis of a huge dump across
+	// machines.  Pointers to objects outside the window are treated as
+	// pointing to no object, and record hooks don't see those objects.
+	// Every object starts in exactly one of a set of windows which
+	// partition the heap, as AddrRange.Split's do.
+	Window AddrRange
+
+	// ProfileIndex counts FindObj lookups, in all for Stats and for
+	// each bucket of the index objects are found with, for
+	// Dump.WorstBuckets to report.  It costs two words of memory per
+	// bucket, and atomic updates on every lookup.
+	ProfileIndex bool
+
+	// IndexStore, if not nil, keeps the reverse edge and dominator
+	// indexes, which take hours to build for the largest dumps, across
+	// runs.  They are loaded from it if it has them for this dump, and
+	// stored in it once built.
+	IndexStore IndexStore
+
+	// recordHooks holds the hooks registered with OnRecord, by record
+	// kind name.
+	recordHooks map[string][]RecordHook
+}
+
+// An IfacePolicy says how to handle interface values whose dynamic
+// type can't be determined, and so whose data word may or may not be
+// a pointer.
+type IfacePolicy int
+
+const (
+	IfaceSkip         IfacePolicy = iota // ignore the data word, with a warning
+	IfaceFail                            // stop with a fatal error
+	IfaceConservative                    // treat the data word as a possible pointer, with a warning
+)
+
+var ifacePolicyNames = [...]string{
+	IfaceSkip:         "skip",
+	IfaceFail:         "fail",
+	IfaceConservative: "conservative",
+}
+
+func (p IfacePolicy) String() string {
+	if p < 0 || int(p) >= len(ifacePolicyNames) {
+		return fmt.Sprintf("IfacePolicy(%d)", int(p))
+	}
+	return ifacePolicyNames[p]
+}
+
+// ParseIfacePolicy returns the IfacePolicy with the given name, as
+// returned by IfacePolicy.String.
+func ParseIfacePolicy(s string) (IfacePolicy, error) {
+	for p, n := range ifacePolicyNames {
+		if n == s {
+			return IfacePolicy(p), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown interface policy %q", s)
+}
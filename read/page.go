@@ -0,0 +1,132 @@
+package read
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Default number of results returned by the List calls.
+const defaultPageSize = 100
+
+// An ObjectPage is a bounded part of a listing of objects.  If
+// Continue is not empty, there are more results, which are obtained
+// by passing Continue back to the same call with the same arguments.
+type ObjectPage struct {
+	Objs     []ObjId
+	Continue string
+}
+
+// An ObjectQuery selects which objects ListObjects returns.
+type ObjectQuery struct {
+	Type    *FullType // only objects of this type, if not nil
+	MinSize uint64    // only objects at least this big
+	Limit   int       // maximum number of objects per page; 0 means 100
+}
+
+// ListObjects returns the page of objects matching q which follows
+// the position described by token.  An empty token starts at the
+// beginning.  Objects are returned in increasing address order.
+func (d *Dump) ListObjects(q ObjectQuery, token string) (ObjectPage, error) {
+	start, err := decodeToken("objects", token)
+	if err != nil {
+		return ObjectPage{}, err
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	var p ObjectPage
+	for i := start; i < d.NumObjects(); i++ {
+		x := ObjId(i)
+		if q.Type != nil && d.Ft(x) != q.Type || d.Size(x) < q.MinSize {
+			continue
+		}
+		if len(p.Objs) == limit {
+			p.Continue = encodeToken("objects", i)
+			break
+		}
+		p.Objs = append(p.Objs, x)
+	}
+	return p, nil
+}
+
+// ListReferrers returns a page of the referrers of x, following the
+// position described by token.  limit is the maximum number of
+// referrers to return; 0 means 100.
+func (d *Dump) ListReferrers(x ObjId, limit int, token string) (ObjectPage, error) {
+	start, err := decodeToken(fmt.Sprintf("referrers%d", x), token)
+	if err != nil {
+		return ObjectPage{}, err
+	}
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	r := d.Referrers(x)
+	if start > len(r) {
+		return ObjectPage{}, fmt.Errorf("continue token out of range")
+	}
+	var p ObjectPage
+	if len(r)-start > limit {
+		p.Objs = r[start : start+limit]
+		p.Continue = encodeToken(fmt.Sprintf("referrers%d", x), start+limit)
+	} else {
+		p.Objs = r[start:]
+	}
+	return p, nil
+}
+
+// An ObjectInfo is what a listing typically shows of an object.
+type ObjectInfo struct {
+	Id   ObjId
+	Addr uint64
+	Size uint64
+	Type *FullType // nil if Id is not a valid object
+}
+
+// ObjectsInfo returns the address, size and type of each of ids, in
+// one slice, for callers like UIs which list many objects at once.
+// Ids which aren't valid objects, such as ObjNil, get an ObjectInfo
+// with only Id set.
+func (d *Dump) ObjectsInfo(ids []ObjId) []ObjectInfo {
+	r := make([]ObjectInfo, len(ids))
+	for i, x := range ids {
+		r[i].Id = x
+		if x < 0 || int(x) >= len(d.objects) {
+			continue
+		}
+		o := &d.objects[x]
+		r[i].Addr = o.Addr
+		r[i].Size = o.Ft.Size
+		r[i].Type = o.Ft
+	}
+	return r
+}
+
+// encodeToken makes an opaque continuation token for position i of
+// the listing named kind.
+func encodeToken(kind string, i int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(kind + ":" + strconv.Itoa(i)))
+}
+
+// decodeToken returns the position in the listing named kind described
+// by token.  The empty token is position 0.
+func decodeToken(kind, token string) (int, error) {
+	if token == "" {
+		return 0, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, fmt.Errorf("bad continue token: %v", err)
+	}
+	s := string(b)
+	if !strings.HasPrefix(s, kind+":") {
+		return 0, fmt.Errorf("continue token is not for this listing")
+	}
+	i, err := strconv.Atoi(s[len(kind)+1:])
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("bad continue token")
+	}
+	return i, nil
+}
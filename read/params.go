@@ -0,0 +1,75 @@
+package read
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/randall77/heapdump14/binutil"
+)
+
+// Params describes the process which wrote the dump.  Decisions
+// about the layout of runtime data structures should be made from
+// these values, and in particular from the Platform.
+type Params struct {
+	binutil.Platform
+	HeapStart  uint64
+	HeapEnd    uint64
+	Arch       Arch // ArchUnknown if the params record names none we know
+	Experiment string
+	Ncpu       uint64
+
+	// TheChar is the architecture as the dump gives it, in the
+	// toolchain's letters: '6' for amd64, '8' for 386, ...
+	//
+	// Deprecated: use Arch.
+	TheChar byte
+
+	// the GOARCH the params record gives, in formats which give it
+	goarch string
+}
+
+// readParams reads a params record of a dump in format f and checks
+// that its values are usable.
+func readParams(r Reader, f Format) (Params, error) {
+	var p Params
+	var order binary.ByteOrder = binary.LittleEndian
+	if readUint64(r) != 0 {
+		order = binary.BigEndian
+	}
+	platform, err := binutil.NewPlatform(order, readUint64(r))
+	if err != nil {
+		return Params{}, fmt.Errorf("bad params record: %v", err)
+	}
+	p.Platform = platform
+	p.HeapStart = readUint64(r)
+	p.HeapEnd = readUint64(r)
+	var goarch string
+	if formats[f].goarch {
+		goarch = readString(r)
+	} else {
+		p.TheChar = byte(readUint64(r))
+	}
+	p.Experiment = readString(r)
+	p.Ncpu = readUint64(r)
+
+	if p.HeapStart > p.HeapEnd {
+		return Params{}, fmt.Errorf("bad heap range [%x,%x) in params record", p.HeapStart, p.HeapEnd)
+	}
+	if formats[f].goarch {
+		// A GOARCH the pointer size or byte order contradicts is
+		// not to be trusted.
+		p.Arch, _ = ParseArch(goarch)
+		if p.Arch.PtrSize() != p.PtrSize || archs[p.Arch].order != p.Order {
+			p.Arch = ArchUnknown
+		}
+		p.TheChar = archs[p.Arch].char
+		p.goarch = goarch
+	} else {
+		p.Arch = archFromChar(p.TheChar, p)
+	}
+	if p.Arch != ArchUnknown {
+		// amd64p32 has 4-byte pointers but 8-byte aligned int64s.
+		p.MaxAlign = p.Arch.MaxAlign()
+	}
+	return p, nil
+}
@@ -0,0 +1,48 @@
+package read
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestReadParamsArch(t *testing.T) {
+	tests := []struct {
+		bigEndian bool
+		ptrSize   uint64
+		goarch    string
+		want      Arch
+	}{
+		{false, 8, "amd64", ArchAMD64},
+		{true, 8, "ppc64", ArchPPC64},
+		{false, 8, "ppc64le", ArchPPC64LE},
+		{false, 4, "amd64p32", ArchAMD64p32},
+		{true, 8, "amd64", ArchUnknown},  // wrong byte order
+		{false, 8, "ppc64", ArchUnknown}, // wrong byte order
+		{false, 4, "amd64", ArchUnknown}, // wrong pointer size
+		{false, 8, "vax", ArchUnknown},   // unknown GOARCH
+	}
+	for _, tt := range tests {
+		var b []byte
+		big := uint64(0)
+		if tt.bigEndian {
+			big = 1
+		}
+		for _, x := range []uint64{big, tt.ptrSize, 0x1000, 0x2000} {
+			b = binary.AppendUvarint(b, x)
+		}
+		for _, s := range []string{tt.goarch, ""} { // GOARCH, experiments
+			b = binary.AppendUvarint(b, uint64(len(s)))
+			b = append(b, s...)
+		}
+		b = binary.AppendUvarint(b, 4) // ncpu
+		p, err := readParams(bytes.NewReader(b), FormatGo17)
+		if err != nil {
+			t.Errorf("readParams(%s, %d-byte pointers, big-endian %v): %v", tt.goarch, tt.ptrSize, tt.bigEndian, err)
+			continue
+		}
+		if p.Arch != tt.want {
+			t.Errorf("readParams(%s, %d-byte pointers, big-endian %v) gives arch %v, want %v", tt.goarch, tt.ptrSize, tt.bigEndian, p.Arch, tt.want)
+		}
+	}
+}
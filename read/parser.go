@@ -2,20 +2,21 @@ package read
 
 import (
 	"bufio"
-	"debug/dwarf"
-	"debug/elf"
-	"debug/macho"
-	"debug/pe"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
-	"os"
 	"regexp"
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/randall77/heapdump14/binutil"
 )
 
 type FieldKind int
@@ -72,18 +73,6 @@ const (
 	tagMemProf     = 16
 	tagAllocSample = 17
 
-	// DWARF constants
-	dw_op_call_frame_cfa = 156
-	dw_op_consts         = 17
-	dw_op_plus           = 34
-	dw_op_plus_uconst    = 35
-	dw_op_addr           = 3
-	dw_ate_boolean       = 2
-	dw_ate_complex_float = 3 // complex64/complex128
-	dw_ate_float         = 4 // float32/float64
-	dw_ate_signed        = 5 // int8/int16/int32/int64/int
-	dw_ate_unsigned      = 7 // uint8/uint16/uint32/uint64/uint/uintptr
-
 	// Size of buckets for FindObj.  Bigger buckets use less memory
 	// but make FindObj take longer.  512 byte buckets use about 1.5%
 	// of the total heap size and require us to look at at most
@@ -92,13 +81,7 @@ const (
 )
 
 type Dump struct {
-	Order        binary.ByteOrder
-	PtrSize      uint64 // in bytes
-	HeapStart    uint64
-	HeapEnd      uint64
-	TheChar      byte
-	Experiment   string
-	Ncpu         uint64
+	Params
 	Types        []*Type
 	objects      []object
 	Frames       []*StackFrame
@@ -115,8 +98,9 @@ type Dump struct {
 	MemProf      []*MemProfEntry
 	AllocSamples []*AllocSample
 
-	// handle to dump file
-	r io.ReaderAt
+	// handle to dump file, and the cache in front of it, if any
+	r     io.ReaderAt
+	cache *blockCache
 
 	buf []byte // temporary space for Contents calls
 
@@ -130,6 +114,7 @@ type Dump struct {
 
 	// map from itab address to the type address that itab address represents.
 	ItabMap map[uint64]uint64
+	itabs   []*Itab // in increasing address order, once linked
 
 	// Data structure for fast lookup of objects.  Divides the heap
 	// into chunks of bucketSize bytes.  For each bucket, we keep
@@ -137,6 +122,79 @@ type Dump struct {
 	// bytes in that bucket.
 	bucketSize uint64
 	idx        []ObjId
+
+	// FindObj counters, for Stats, and for each bucket of idx, kept
+	// only with Options.ProfileIndex.  They are updated atomically, since
+	// FindObj is otherwise read-only and may be called concurrently.
+	findLookups, findProbes, findMisses uint64
+	profileIndex                        bool
+	bucketLookups, bucketProbes         []uint64
+
+	// whether types and names come from executables' dwarf info
+	hasDwarf bool
+
+	// the addresses objects were loaded from (see Options.Window),
+	// and the addresses the index covers
+	window           AddrRange
+	idxStart, idxEnd uint64
+
+	// checks of the records against the runtime's globals
+	runtimeChecks []RuntimeCheck
+
+	// the dump's format, and the ways the process which wrote it
+	// differs from what the package decodes (see Compat)
+	format       Format
+	compatIssues []string
+
+	// how the dump was truncated, or nil if it is complete
+	truncation *Truncation
+
+	// whether to scan untyped objects and frames conservatively
+	conservative bool
+	wordNames    []string // names of words in untyped objects, by index
+
+	// how to handle interfaces with unknown types, and the number of
+	// times each unknown type or itab address has been encountered
+	ifacePolicy IfacePolicy
+	unresolved  map[uint64]uint64
+
+	// problems found while reading the dump
+	warnings   []string
+	warnCounts map[string]int // number of warnings of each limited kind
+
+	// statistics about loading the dump
+	stats        Stats
+	recordCounts [len(tagNames)]uint64
+
+	// all root pointers into the heap, computed lazily, and the
+	// indexes in roots of those pointing to each object
+	roots    []Root
+	rootRefs map[ObjId][]int32
+
+	// bytes left for keeping the edges of frames and data sections,
+	// or -1 for no limit, and whether any edges were left to be
+	// computed on demand
+	edgeMemory    int64
+	streamedEdges bool
+
+	// reverse edge and dominator indexes, computed lazily.  The
+	// precise versions ignore conservative edges.
+	refs, preciseRefs *refIndex
+	dom, preciseDom   *domTree
+	dominators        *Dominators // the exported view of dom
+	depths            []int32     // see Depth
+	reachable         ObjSet      // see Reachable
+
+	// the executables with dwarf info, and the logical frames of
+	// each pc looked up in them so far; see StackFrame.SourceFrames
+	bins       []*binutil.Binary
+	sourceMu   sync.Mutex
+	sourceByPC map[uint64][]binutil.SourceFrame
+
+	// where the reverse edge and dominator indexes are kept across
+	// runs, if anywhere, and the fingerprint they are kept under
+	indexStore       IndexStore
+	indexFingerprint []byte
 }
 
 type Type struct {
@@ -148,13 +206,51 @@ type Type struct {
 	Addr uint64
 }
 
+// An Itab is the record of an itab: the method table an interface
+// value with methods points to, which determines the concrete type of
+// the value.
+type Itab struct {
+	Addr     uint64 // address of the itab
+	TypeAddr uint64 // address of the concrete type's descriptor, 0 if not a pointer type
+	Type     *Type  // the concrete type, nil if not in the dump; set by linking
+}
+
+// Name returns the name of the itab's concrete type, or "" if the
+// type is unknown.
+func (t *Itab) Name() string {
+	if t.Type == nil {
+		return ""
+	}
+	return t.Type.Name
+}
+
+// Itabs returns the itabs recorded in the dump, in increasing address
+// order.  Tools analyzing interface usage can use them to see which
+// concrete types were stored in interfaces.
+func (d *Dump) Itabs() []*Itab {
+	return d.itabs
+}
+
+type byItabAddr []*Itab
+
+func (a byItabAddr) Len() int           { return len(a) }
+func (a byItabAddr) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byItabAddr) Less(i, j int) bool { return a[i].Addr < a[j].Addr }
+
 type FullType struct {
-	Id     int
-	Size   uint64
-	GCSig  string
-	Name   string
+	Id    int
+	Size  uint64
+	GCSig string
+	Name  string
+	// Fields lists the fields of the type.  Without dwarf information
+	// it has only the fields which may hold pointers; use
+	// Dump.TypeFields to get them all.
 	Fields []Field
-	Type   dwarfType
+	Type   binutil.Type
+	// Kind is TypeKindConservative if objects of this type are scanned
+	// conservatively, that is, if every pointer-aligned word is treated
+	// as a possible pointer.  Otherwise it is TypeKindObject.
+	Kind TypeKind
 }
 
 // An edge is a directed connection between two objects.  The source
@@ -167,6 +263,11 @@ type Edge struct {
 
 	// name of field in the source object, if known
 	FieldName string
+
+	// Conservative is set if the edge comes from a word which might not
+	// really be a pointer: one found by conservative scanning, or the
+	// data word of an interface whose type is unknown.
+	Conservative bool
 }
 
 // object represents an object in the heap.
@@ -214,68 +315,99 @@ func (d *Dump) Ft(x ObjId) *FullType {
 }
 
 // FindObj returns the object id containing the address addr, or -1 if no object contains addr.
+// If the dump contains overlapping objects, FindObj returns the lowest-addressed
+// object containing addr, with ties broken by the order of the objects in the dump.
+// Zero-sized objects contain no addresses, but FindObj returns one for a pointer
+// to exactly its address if no other object contains that address.
 func (d *Dump) FindObj(addr uint64) ObjId {
-	if addr < d.HeapStart || addr >= d.HeapEnd { // quick exit.  Includes nil.
+	if addr < d.idxStart || addr >= d.idxEnd { // quick exit.  Includes nil.
+		if d.profileIndex {
+			atomic.AddUint64(&d.findLookups, 1)
+			atomic.AddUint64(&d.findMisses, 1)
+		}
 		return ObjNil
 	}
+	b := (addr - d.idxStart) / bucketSize
+	x, probes := d.searchBucket(addr, b)
+	if d.profileIndex {
+		atomic.AddUint64(&d.findLookups, 1)
+		atomic.AddUint64(&d.findProbes, probes)
+		atomic.AddUint64(&d.bucketLookups[b], 1)
+		atomic.AddUint64(&d.bucketProbes[b], probes)
+		if x == ObjNil {
+			atomic.AddUint64(&d.findMisses, 1)
+		}
+	}
+	return x
+}
+
+// searchBucket finds the object containing addr, which is in bucket b
+// of the index, and returns it and the number of objects examined.
+func (d *Dump) searchBucket(addr, b uint64) (ObjId, uint64) {
+	// Zero-sized objects contain no bytes.  A pointer to one is only
+	// attributed to it if no other object contains the address.
+	zero := ObjNil
+	var probes uint64
 	// linear search among all the objects that map to the same bucketSize-byte bucket.
-	for i := d.idx[(addr-d.HeapStart)/bucketSize]; i < ObjId(len(d.objects)); i++ {
+	for i := d.idx[b]; i < ObjId(len(d.objects)); i++ {
+		probes++
 		x := &d.objects[i]
 		if addr < x.Addr {
-			return ObjNil
+			break
 		}
 		if addr < x.Addr+x.Ft.Size {
-			return ObjId(i)
+			return ObjId(i), probes
+		}
+		if x.Ft.Size == 0 && addr == x.Addr && zero == ObjNil {
+			zero = ObjId(i)
 		}
 	}
-	return ObjNil
+	return zero, probes
 }
 
 func (d *Dump) Edges(i ObjId) []Edge {
 	x := &d.objects[i]
 	e := d.edges[:0]
 	b := d.Contents(i)
+	if x.Ft.Kind == TypeKindConservative {
+		e = d.appendConservative(e, b)
+		d.edges = e
+		return e
+	}
 	for _, f := range x.Ft.Fields {
 		//fmt.Printf("field %d %s %d\n", f.Kind, f.Name, f.Offset)
+		if (f.Kind == FieldKindEface || f.Kind == FieldKindIface) && f.Offset+2*d.PtrSize > uint64(len(b)) {
+			continue // doesn't fit; resolveIfaces skipped it too
+		}
 		switch f.Kind {
 		case FieldKindPtr:
 			p := readPtr(d, b[f.Offset:])
 			y := d.FindObj(p)
 			if y != ObjNil {
-				e = append(e, Edge{y, f.Offset, p - d.objects[y].Addr, f.Name})
+				e = append(e, Edge{y, f.Offset, p - d.objects[y].Addr, f.Name, false})
 			}
 		case FieldKindEface:
 			taddr := readPtr(d, b[f.Offset:])
-			if taddr != 0 {
-				t := d.TypeMap[taddr]
-				if t == nil {
-					log.Fatalf("Edges: can't find eface type %x", taddr)
-				}
-				if t.interfaceptr {
-					p := readPtr(d, b[f.Offset+d.PtrSize:])
-					y := d.FindObj(p)
-					if y != ObjNil {
-						e = append(e, Edge{y, f.Offset + d.PtrSize, p - d.objects[y].Addr, f.Name})
-					}
+			if taddr == 0 {
+				continue
+			}
+			if ptr, cons := d.efaceHasPtr(taddr); ptr {
+				p := readPtr(d, b[f.Offset+d.PtrSize:])
+				y := d.FindObj(p)
+				if y != ObjNil {
+					e = append(e, Edge{y, f.Offset + d.PtrSize, p - d.objects[y].Addr, f.Name, cons})
 				}
 			}
 		case FieldKindIface:
 			itabaddr := readPtr(d, b[f.Offset:])
-			if itabaddr != 0 {
-				taddr := d.ItabMap[itabaddr]
-				if taddr == 0 {
-					log.Fatalf("Edges: can't find itab %x", itabaddr)
-				}
-				t := d.TypeMap[taddr]
-				if t == nil {
-					log.Fatalf("Edges: can't find iface type %x", taddr)
-				}
-				if t.interfaceptr {
-					p := readPtr(d, b[f.Offset+d.PtrSize:])
-					y := d.FindObj(p)
-					if y != ObjNil {
-						e = append(e, Edge{y, f.Offset + d.PtrSize, p - d.objects[y].Addr, f.Name})
-					}
+			if itabaddr == 0 {
+				continue
+			}
+			if ptr, cons := d.ifaceHasPtr(itabaddr); ptr {
+				p := readPtr(d, b[f.Offset+d.PtrSize:])
+				y := d.FindObj(p)
+				if y != ObjNil {
+					e = append(e, Edge{y, f.Offset + d.PtrSize, p - d.objects[y].Addr, f.Name, cons})
 				}
 			}
 		default:
@@ -302,6 +434,11 @@ type Finalizer struct {
 	ot   uint64 // type of object
 }
 
+// Obj returns the address of the object the finalizer is for.
+func (f *Finalizer) Obj() uint64 {
+	return f.obj
+}
+
 // Finalizer that's ready to run
 type QFinalizer struct {
 	obj   uint64
@@ -312,23 +449,43 @@ type QFinalizer struct {
 	Edges []Edge
 }
 
+// Obj returns the address of the object the finalizer is for.
+func (f *QFinalizer) Obj() uint64 {
+	return f.obj
+}
+
+// A Defer is a deferred call which hasn't run yet.
 type Defer struct {
-	addr uint64
+	Addr uint64
+	Argp uint64 // stack pointer of the frame which deferred the call
+	Pc   uint64 // pc of the defer statement
+	Fn   uint64 // closure to call
+	Code uint64 // entry point of the function to call
+	// Func is the name of the function to call, or "" if it isn't
+	// known.  It is found from stack frames with the same entry
+	// point, or from the executables' dwarf info.
+	Func string
+
 	gp   uint64
-	argp uint64
-	pc   uint64
-	fn   uint64
-	code uint64
 	link uint64
 }
 
+// A Panic is a panic in progress.
 type Panic struct {
-	addr uint64
+	Addr uint64
+	Type uint64 // type descriptor of the panic value
+	Data uint64 // data word of the panic value
+	// Defer is the deferred call running when the panic happened, if
+	// the dump has a record of it.
+	Defer *Defer
+	// ValueType is the type of the panic value, or nil if the dump has
+	// no record of it.
+	ValueType *Type
+
 	gp   uint64
-	typ  uint64
-	data uint64
 	defr uint64
 	link uint64
+	d    *Dump
 }
 
 type MemProfFrame struct {
@@ -338,23 +495,61 @@ type MemProfFrame struct {
 }
 
 type MemProfEntry struct {
-	addr   uint64
+	key    uint64
 	size   uint64
 	stack  []MemProfFrame
 	allocs uint64
 	frees  uint64
 }
 
+// Stack returns the call stack of the allocation site, innermost
+// frame first.
+func (e *MemProfEntry) Stack() []MemProfFrame {
+	return e.stack
+}
+
+// Key returns the key identifying the entry in the dump, the address
+// of the runtime's profiling bucket.  It can be used to join the entry
+// with profiles captured separately from the same process.
+func (e *MemProfEntry) Key() uint64 {
+	return e.key
+}
+
+// Size returns the size of the allocations made at the site, as
+// requested, before rounding up to a size class.
+func (e *MemProfEntry) Size() uint64 {
+	return e.size
+}
+
+// Allocs and Frees return the number of sampled allocations at the
+// site, and how many of them have been freed, as of the last garbage
+// collection.
+func (e *MemProfEntry) Allocs() uint64 {
+	return e.allocs
+}
+
+func (e *MemProfEntry) Frees() uint64 {
+	return e.frees
+}
+
 type AllocSample struct {
-	Addr uint64        // address of object
-	Prof *MemProfEntry // record of allocation site
+	Addr    uint64        // address of object
+	ProfKey uint64        // key of the allocation site's memprof entry
+	Prof    *MemProfEntry // record of allocation site, nil if missing
 }
 
 type Data struct {
 	Addr   uint64
 	Data   []byte
 	Fields []Field
-	Edges  []Edge
+	// Edges are the pointers from the section into the heap.  They
+	// may be left out to save memory; see EdgeList.
+	Edges []Edge
+
+	name     string   // "data" or "bss"
+	streamed bool     // Edges were left out
+	globals  []Global // from the dwarf info, in address order
+	d        *Dump
 }
 
 type OSThread struct {
@@ -374,7 +569,7 @@ type Field struct {
 
 type GoRoutine struct {
 	Bos  *StackFrame // frame at the top of the stack (i.e. currently running)
-	Ctxt ObjId
+	Ctxt ObjId       // closure context of the goroutine's function, or ObjNil
 
 	Addr         uint64
 	bosaddr      uint64
@@ -389,12 +584,19 @@ type GoRoutine struct {
 	maddr        uint64
 	deferaddr    uint64
 	panicaddr    uint64
+
+	// Defers are the goroutine's pending deferred calls, and Panics
+	// its panics in progress, most recent first.
+	Defers []*Defer
+	Panics []*Panic
+
+	d *Dump
 }
 
 type StackFrame struct {
 	Name      string
 	Parent    *StackFrame
-	Goroutine *GoRoutine
+	Goroutine *GoRoutine // nil for a frame no goroutine's stack reaches
 	Depth     uint64
 	Data      []byte
 	Edges     []Edge
@@ -404,6 +606,23 @@ type StackFrame struct {
 	entry     uint64
 	pc        uint64
 	Fields    []Field
+
+	streamed bool // Edges were left out to save memory; see EdgeList
+	d        *Dump
+}
+
+// SourceFrames returns the logical frames of f, innermost first.  When
+// calls were inlined into f's function, there is one for each inlined
+// call as well as one for the function itself, so stacks read like
+// those the runtime prints.  Without dwarf information, or if the
+// executables don't describe f's pc, there is just the one frame,
+// named but without a position.  The frames of each pc are found the
+// first time they are asked for.
+func (f *StackFrame) SourceFrames() []binutil.SourceFrame {
+	if s := f.d.sourceFrames(f.lookupPC()); s != nil {
+		return s
+	}
+	return []binutil.SourceFrame{{Func: f.Name}}
 }
 
 // both an io.Reader and an io.ByteReader
@@ -415,16 +634,26 @@ type Reader interface {
 func readUint64(r Reader) uint64 {
 	x, err := binary.ReadUvarint(r)
 	if err != nil {
-		log.Fatal(err)
+		readFailed(err)
 	}
 	return x
 }
 
+// readFailed handles an error reading a record: the end of the dump
+// means it was truncated, and anything else is fatal.
+func readFailed(err error) {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		panic(errTruncated{})
+	}
+	log.Fatal(err)
+}
+
 func readNBytes(r Reader, n uint64) []byte {
+	checkLen("string or byte field", n)
 	s := make([]byte, n)
 	_, err := io.ReadFull(r, s)
 	if err != nil {
-		log.Fatal(err)
+		readFailed(err)
 	}
 	return s
 }
@@ -441,7 +670,7 @@ func readString(r Reader) string {
 func readBool(r Reader) bool {
 	b, err := r.ReadByte()
 	if err != nil {
-		log.Fatal(err)
+		readFailed(err)
 	}
 	return b != 0
 }
@@ -498,44 +727,86 @@ type tkey struct {
 
 func (d *Dump) makeFullType(size uint64, gcmap string) *FullType {
 	name := fmt.Sprintf("%d_%s", size, gcmap)
-	ft := &FullType{len(d.FTList), size, gcmap, name, nil, nil}
+	ft := &FullType{len(d.FTList), size, gcmap, name, nil, nil, TypeKindObject}
 	d.FTList = append(d.FTList, ft)
 	return ft
 }
 
 // Reads heap dump into memory.
-func rawRead(filename string) *Dump {
-	file, err := os.Open(filename)
+// If onParams is not nil, it is called as soon as the params record has
+// been read, so that work which depends only on it can start early.
+// A dump which ends before its eof record is loaded up to its last
+// complete record, and marked partial.
+func rawRead(parts []string, window AddrRange, onParams func(Params), hooks []RecordHook) (dump *Dump) {
+	file, err := OpenParts(parts)
 	if err != nil {
 		log.Fatal(err)
 	}
-	r := &myReader{r: bufio.NewReader(file)}
+	r := &myReader{r: bufio.NewReader(io.NewSectionReader(file, 0, file.Size()))}
 
 	// check for header
 	hdr, prefix, err := r.ReadLine()
 	if err != nil {
 		log.Fatal(err)
 	}
-	if prefix || (string(hdr) != "go1.4 heap dump" && string(hdr) != "go1.5 heap dump" && string(hdr) != "go1.6 heap dump") {
-		log.Fatal("not a go1.[456] heap dump file")
+	if prefix {
+		log.Fatal("not a heap dump file: no header line")
+	}
+	format, err := parseHeader(string(hdr))
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	var d Dump
 	d.r = file
+	d.format = format
 	d.ItabMap = map[uint64]uint64{}
 	d.TypeMap = map[uint64]*Type{}
 	ftmap := map[tkey]*FullType{} // full type dedup
 	memprof := map[uint64]*MemProfEntry{}
 	var sig []byte // buffer for reading a garbage collection signature
+
+	// where the record being read starts, and its kind, in case the
+	// dump is truncated in the middle of it
+	recStart := r.Count()
+	recKind := -1
+	var heapEnd uint64 // end of the highest object read
+	defer func() {
+		if e := recover(); e != nil {
+			if _, ok := e.(errTruncated); !ok {
+				panic(e)
+			}
+			if d.PtrSize == 0 { // set by a complete params record
+				log.Fatal("dump is truncated before the end of its params record")
+			}
+			if r.Count() == recStart {
+				recKind = -1
+			}
+			finishTruncated(&d, recStart, recKind, heapEnd)
+			linkAllocSamples(&d, memprof)
+			dump = &d
+		}
+	}()
 	for {
+		recStart, recKind = r.Count(), -1
 		kind := readUint64(r)
+		recKind = int(kind)
+		if kind < uint64(len(d.recordCounts)) {
+			d.recordCounts[kind]++
+		}
+		var rec interface{} // the record, for hooks
 		switch kind {
 		case tagObject:
 			obj := object{}
 			obj.Addr = readUint64(r)
 			size := readUint64(r)
+			if size > uint64(maxInt) {
+				checkLen(fmt.Sprintf("object %x", obj.Addr), size)
+			}
 			obj.offset = r.Count()
-			r.Skip(int64(size))
+			if err := r.Skip(int64(size)); err != nil {
+				readFailed(err)
+			}
 
 			// build a "signature" for the object.  This is its type
 			// as far as the garbage collector is concerned.
@@ -570,6 +841,13 @@ func rawRead(filename string) *Dump {
 					break gcloop
 				}
 			}
+			if obj.Addr+size > heapEnd {
+				heapEnd = obj.Addr + size
+			}
+			if !window.Contains(obj.Addr) {
+				d.stats.ObjectsOutsideWindow++
+				continue
+			}
 			gcsig := string(sig)
 			k := tkey{size, gcsig}
 			ft := ftmap[k]
@@ -578,14 +856,22 @@ func rawRead(filename string) *Dump {
 				ftmap[k] = ft
 			}
 			obj.Ft = ft
+			if len(d.objects) == maxInt {
+				log.Fatalf("more than %d objects, more than this host can index", maxInt)
+			}
 			d.objects = append(d.objects, obj)
+			rec = ObjectRecord{obj.Addr, ft}
 		case tagEOF:
+			d.stats.Bytes = r.Count()
+			addMissingSections(&d)
+			linkAllocSamples(&d, memprof)
 			return &d
 		case tagOtherRoot:
 			t := &OtherRoot{}
 			t.Description = readString(r)
 			t.toaddr = readUint64(r)
 			d.Otherroots = append(d.Otherroots, t)
+			rec = t
 		case tagType:
 			typ := &Type{}
 			typ.Addr = readUint64(r)
@@ -598,9 +884,10 @@ func rawRead(filename string) *Dump {
 				d.TypeMap[typ.Addr] = typ
 				d.Types = append(d.Types, typ)
 			}
+			rec = typ
 			//fmt.Printf("type %x\n", typ.Addr)
 		case tagGoRoutine:
-			g := &GoRoutine{}
+			g := &GoRoutine{Ctxt: ObjNil, d: &d}
 			g.Addr = readUint64(r)
 			g.bosaddr = readUint64(r)
 			g.Goid = readUint64(r)
@@ -615,8 +902,9 @@ func rawRead(filename string) *Dump {
 			g.deferaddr = readUint64(r)
 			g.panicaddr = readUint64(r)
 			d.Goroutines = append(d.Goroutines, g)
+			rec = g
 		case tagStackFrame:
-			t := &StackFrame{}
+			t := &StackFrame{d: &d}
 			t.Addr = readUint64(r)
 			t.Depth = readUint64(r)
 			t.childaddr = readUint64(r)
@@ -650,18 +938,18 @@ func rawRead(filename string) *Dump {
 				t.Fields = nil
 			}
 			d.Frames = append(d.Frames, t)
+			rec = t
 		case tagParams:
-			if readUint64(r) == 0 {
-				d.Order = binary.LittleEndian
-			} else {
-				d.Order = binary.BigEndian
+			p, err := readParams(r, d.format)
+			if err != nil {
+				log.Fatal(err)
 			}
-			d.PtrSize = readUint64(r)
-			d.HeapStart = readUint64(r)
-			d.HeapEnd = readUint64(r)
-			d.TheChar = byte(readUint64(r))
-			d.Experiment = readString(r)
-			d.Ncpu = readUint64(r)
+			d.Params = p
+			checkCompat(&d)
+			if onParams != nil {
+				onParams(d.Params)
+			}
+			rec = d.Params
 		case tagFinalizer:
 			t := &Finalizer{}
 			t.obj = readUint64(r)
@@ -670,6 +958,7 @@ func rawRead(filename string) *Dump {
 			t.fint = readUint64(r)
 			t.ot = readUint64(r)
 			d.Finalizers = append(d.Finalizers, t)
+			rec = t
 		case tagQFinal:
 			t := &QFinalizer{}
 			t.obj = readUint64(r)
@@ -678,28 +967,37 @@ func rawRead(filename string) *Dump {
 			t.fint = readUint64(r)
 			t.ot = readUint64(r)
 			d.QFinal = append(d.QFinal, t)
+			rec = t
 		case tagData:
-			t := &Data{}
+			t := &Data{name: "data", d: &d}
 			t.Addr = readUint64(r)
 			t.Data = readBytes(r)
 			t.Fields = readFields(r)
 			d.Data = t
+			rec = t
 		case tagBss:
-			t := &Data{}
+			t := &Data{name: "bss", d: &d}
 			t.Addr = readUint64(r)
 			t.Data = readBytes(r)
 			t.Fields = readFields(r)
 			d.Bss = t
+			rec = t
 		case tagItab:
 			addr := readUint64(r)
 			typaddr := readUint64(r)
-			d.ItabMap[addr] = typaddr
+			t := &Itab{Addr: addr, TypeAddr: typaddr}
+			if _, ok := d.ItabMap[addr]; !ok {
+				d.ItabMap[addr] = typaddr
+				d.itabs = append(d.itabs, t)
+			}
+			rec = t
 		case tagOSThread:
 			t := &OSThread{}
 			t.addr = readUint64(r)
 			t.id = readUint64(r)
 			t.procid = readUint64(r)
 			d.Osthreads = append(d.Osthreads, t)
+			rec = t
 		case tagMemStats:
 			t := &runtime.MemStats{}
 			t.Alloc = readUint64(r)
@@ -731,28 +1029,31 @@ func rawRead(filename string) *Dump {
 			}
 			t.NumGC = uint32(readUint64(r))
 			d.Memstats = t
+			rec = t
 		case tagDefer:
 			t := &Defer{}
-			t.addr = readUint64(r)
+			t.Addr = readUint64(r)
 			t.gp = readUint64(r)
-			t.argp = readUint64(r)
-			t.pc = readUint64(r)
-			t.fn = readUint64(r)
-			t.code = readUint64(r)
+			t.Argp = readUint64(r)
+			t.Pc = readUint64(r)
+			t.Fn = readUint64(r)
+			t.Code = readUint64(r)
 			t.link = readUint64(r)
 			d.Defers = append(d.Defers, t)
+			rec = t
 		case tagPanic:
-			t := &Panic{}
-			t.addr = readUint64(r)
+			t := &Panic{d: &d}
+			t.Addr = readUint64(r)
 			t.gp = readUint64(r)
-			t.typ = readUint64(r)
-			t.data = readUint64(r)
+			t.Type = readUint64(r)
+			t.Data = readUint64(r)
 			t.defr = readUint64(r)
 			t.link = readUint64(r)
 			d.Panics = append(d.Panics, t)
+			rec = t
 		case tagMemProf:
 			t := &MemProfEntry{}
-			key := readUint64(r)
+			t.key = readUint64(r)
 			t.size = readUint64(r)
 			nstk := readUint64(r)
 			for i := uint64(0); i < nstk; i++ {
@@ -765,71 +1066,26 @@ func rawRead(filename string) *Dump {
 			t.allocs = readUint64(r)
 			t.frees = readUint64(r)
 			d.MemProf = append(d.MemProf, t)
-			memprof[key] = t
+			memprof[t.key] = t
+			rec = t
 		case tagAllocSample:
 			t := &AllocSample{}
 			t.Addr = readUint64(r)
-			t.Prof = memprof[readUint64(r)]
+			t.ProfKey = readUint64(r)
+			t.Prof = memprof[t.ProfKey]
 			d.AllocSamples = append(d.AllocSamples, t)
+			rec = t
 		default:
 			log.Fatal("unknown record kind ", kind)
 		}
+		if kind < uint64(len(hooks)) && hooks[kind] != nil {
+			hooks[kind](tagNames[kind], rec)
+		}
 	}
 	// TODO: any easy way to truncate the objects array?  We could
 	// reclaim the fraction that append() added but we didn't need.
 }
 
-func getDwarf(execname string) *dwarf.Data {
-	e, err := elf.Open(execname)
-	if err == nil {
-		defer e.Close()
-		d, err := e.DWARF()
-		if err == nil {
-			return d
-		}
-	}
-	m, err := macho.Open(execname)
-	if err == nil {
-		defer m.Close()
-		d, err := m.DWARF()
-		if err == nil {
-			return d
-		}
-	}
-	p, err := pe.Open(execname)
-	if err == nil {
-		defer p.Close()
-		d, err := p.DWARF()
-		if err == nil {
-			return d
-		}
-	}
-	log.Fatal("can't get dwarf info from executable", err)
-	return nil
-}
-
-func readUleb(b []byte) ([]byte, uint64) {
-	r := uint64(0)
-	s := uint(0)
-	for {
-		x := b[0]
-		b = b[1:]
-		r |= uint64(x&127) << s
-		if x&128 == 0 {
-			break
-		}
-		s += 7
-
-	}
-	return b, r
-}
-func readSleb(b []byte) ([]byte, int64) {
-	c, v := readUleb(b)
-	// sign extend
-	k := (len(b) - len(c)) * 7
-	return c, int64(v) << uint(64-k) >> uint(64-k)
-}
-
 func joinNames(a, b string) string {
 	if a == "" {
 		return b
@@ -840,274 +1096,15 @@ func joinNames(a, b string) string {
 	return fmt.Sprintf("%s.%s", a, b)
 }
 
-type dwarfType interface {
-	// Name returns the name of this type
-	Name() string
-	// Size returns the size of this type in bytes
-	Size() uint64
-	// Fields returns a list of fields within the object, in increasing offset order.
-	Fields() []Field
-	// dwarfFields returns a list of fields within the type.
-	// The list is "flattened", so only base & ptr types remain. (TODO: and func, for now)
-	// We call this dynamically instead of building it for each type
-	// when the type is constructed, so we avoid constructing this list for
-	// crazy types that are never instantiated, e.g. [1000000000]byte.
-	dwarfFields() []dwarfTypeMember
-}
-type dwarfTypeImpl struct {
-	name    string
-	size    uint64
-	fields  []Field
-	dFields []dwarfTypeMember
-}
-type dwarfBaseType struct {
-	dwarfTypeImpl
-	encoding int64
-}
-type dwarfTypedef struct {
-	dwarfTypeImpl
-	type_ dwarfType
-}
-type dwarfStructType struct {
-	dwarfTypeImpl
-	members []dwarfTypeMember
-	isSlice bool
-}
-type dwarfTypeMember struct {
-	offset uint64
-	name   string
-	type_  dwarfType
-}
-type dwarfPtrType struct {
-	dwarfTypeImpl
-	elem dwarfType
-}
-type dwarfArrayType struct {
-	dwarfTypeImpl
-	elem dwarfType
-}
-type dwarfFuncType struct {
-	dwarfTypeImpl
-}
-type dwarfIfaceType struct {
-	dwarfTypeImpl
-}
-type dwarfEfaceType struct {
-	dwarfTypeImpl
-}
-
-func (t *dwarfTypeImpl) Name() string {
-	return t.name
-}
-func (t *dwarfTypeImpl) Size() uint64 {
-	return t.size
-}
-func (t *dwarfBaseType) Fields() []Field {
-	if t.fields != nil {
-		return t.fields
-	}
-	switch {
-	case t.encoding == dw_ate_boolean:
-		t.fields = append(t.fields, Field{FieldKindBool, 0, "", ""})
-	case t.encoding == dw_ate_signed && t.size == 1:
-		t.fields = append(t.fields, Field{FieldKindSInt8, 0, "", ""})
-	case t.encoding == dw_ate_unsigned && t.size == 1:
-		t.fields = append(t.fields, Field{FieldKindUInt8, 0, "", ""})
-	case t.encoding == dw_ate_signed && t.size == 2:
-		t.fields = append(t.fields, Field{FieldKindSInt16, 0, "", ""})
-	case t.encoding == dw_ate_unsigned && t.size == 2:
-		t.fields = append(t.fields, Field{FieldKindUInt16, 0, "", ""})
-	case t.encoding == dw_ate_signed && t.size == 4:
-		t.fields = append(t.fields, Field{FieldKindSInt32, 0, "", ""})
-	case t.encoding == dw_ate_unsigned && t.size == 4:
-		t.fields = append(t.fields, Field{FieldKindUInt32, 0, "", ""})
-	case t.encoding == dw_ate_signed && t.size == 8:
-		t.fields = append(t.fields, Field{FieldKindSInt64, 0, "", ""})
-	case t.encoding == dw_ate_unsigned && t.size == 8:
-		t.fields = append(t.fields, Field{FieldKindUInt64, 0, "", ""})
-	case t.encoding == dw_ate_float && t.size == 4:
-		t.fields = append(t.fields, Field{FieldKindFloat32, 0, "", ""})
-	case t.encoding == dw_ate_float && t.size == 8:
-		t.fields = append(t.fields, Field{FieldKindFloat64, 0, "", ""})
-	case t.encoding == dw_ate_complex_float && t.size == 8:
-		t.fields = append(t.fields, Field{FieldKindComplex64, 0, "", ""})
-	case t.encoding == dw_ate_complex_float && t.size == 16:
-		t.fields = append(t.fields, Field{FieldKindComplex128, 0, "", ""})
-	default:
-		log.Fatalf("unknown encoding type encoding=%d size=%d", t.encoding, t.size)
-	}
-	return t.fields
-}
-func (t *dwarfBaseType) dwarfFields() []dwarfTypeMember {
-	if t.dFields != nil {
-		return t.dFields
-	}
-	t.dFields = append(t.dFields, dwarfTypeMember{0, "", t})
-	return t.dFields
-}
-
-func (t *dwarfTypedef) Fields() []Field {
-	return t.type_.Fields()
-}
-func (t *dwarfTypedef) dwarfFields() []dwarfTypeMember {
-	return t.type_.dwarfFields()
-}
-func (t *dwarfTypedef) Size() uint64 {
-	return t.type_.Size()
-}
-
-var unkBase = "unkBase"
-
-func (t *dwarfPtrType) Fields() []Field {
-	if t.fields == nil {
-		if t.Name()[0] == '*' {
-			t.fields = append(t.fields, Field{FieldKindPtr, 0, "", t.Name()[1:]})
-		} else {
-			t.fields = append(t.fields, Field{FieldKindPtr, 0, "", unkBase})
-		}
-	}
-	return t.fields
-}
-func (t *dwarfPtrType) dwarfFields() []dwarfTypeMember {
-	if t.dFields == nil {
-		t.dFields = append(t.dFields, dwarfTypeMember{0, "", t})
-	}
-	return t.dFields
-}
-
-// We treat a func as a *uintptr.  (It is actually a pointer to a closure, which is
-// in turn a pointer to code.)
-// TODO: how do we deduce types of closure parameters???  We could look at the code
-// pointer and figure it out somehow.
-// TODO: parameterize size by d.PtrSize.
-var dwarfCodePtr dwarfType = &dwarfBaseType{dwarfTypeImpl{"<codeptr>", 8, nil, nil}, dw_ate_unsigned}
-var dwarfFunc dwarfType = &dwarfPtrType{dwarfTypeImpl{"*<closure>", 8, nil, nil}, dwarfCodePtr}
-
-func (t *dwarfFuncType) Fields() []Field {
-	if t.fields == nil {
-		t.fields = append(t.fields, Field{FieldKindPtr, 0, "", unkBase})
-	}
-	return t.fields
-}
-
-func (t *dwarfFuncType) dwarfFields() []dwarfTypeMember {
-	if t.dFields == nil {
-		t.dFields = append(t.dFields, dwarfTypeMember{0, "", dwarfFunc})
-	}
-	return t.dFields
-}
-
-func (t *dwarfStructType) Fields() []Field {
-	if t.fields != nil {
-		return t.fields
-	}
-	// Iterate over members, flatten fields.
-	// Don't look inside strings, interfaces, slices.
-	switch {
-	case t.name == "string":
-
-		t.fields = append(t.fields, Field{FieldKindPtr, 0, "", ""}, Field{FieldKindUInt64, 0, "", ""}) // TODO: uint32 for 32-bit?
-	case t.name == "runtime.iface":
-		t.fields = append(t.fields, Field{FieldKindPtr, 0, "", unkBase}, Field{FieldKindPtr, 0, "", unkBase}) // TODO: different offsets?
-	case t.name == "runtime.eface":
-		t.fields = append(t.fields, Field{FieldKindEface, 0, "", ""}, Field{FieldKindEface, 0, "", ""})
-	default:
-		for _, m := range t.members {
-			for _, f := range m.type_.Fields() {
-				t.fields = append(t.fields, Field{f.Kind, m.offset + f.Offset, joinNames(m.name, f.Name), f.BaseType})
-			}
-		}
-	}
-	return t.fields
-}
-
-func (t *dwarfStructType) dwarfFields() []dwarfTypeMember {
-	if t.dFields != nil {
-		return t.dFields
-	}
-	// Iterate over members, flatten fields.
-	for _, m := range t.members {
-		for _, f := range m.type_.dwarfFields() {
-			t.dFields = append(t.dFields, dwarfTypeMember{m.offset + f.offset, joinNames(m.name, f.name), f.type_})
-		}
-	}
-	return t.dFields
-}
-
-func (t *dwarfArrayType) Fields() []Field {
-	if t.fields != nil {
-		return t.fields
-	}
-	s := t.elem.Size()
-	if s == 0 {
-		return t.fields
-	}
-	n := t.Size() / s
-	fields := t.elem.Fields()
-	for i := uint64(0); i < n; i++ {
-		for _, f := range fields {
-			t.fields = append(t.fields, Field{f.Kind, i*s + f.Offset, joinNames(fmt.Sprintf("%d", i), f.Name), f.BaseType})
-		}
-	}
-	return t.fields
-}
-
-func (t *dwarfArrayType) dwarfFields() []dwarfTypeMember {
-	if t.dFields != nil {
-		return t.dFields
-	}
-	s := t.elem.Size()
-	if s == 0 {
-		return t.dFields
-	}
-	n := t.Size() / s
-	fields := t.elem.dwarfFields()
-	for i := uint64(0); i < n; i++ {
-		name := fmt.Sprintf("[%d]", i)
-		for _, f := range fields {
-			t.dFields = append(t.dFields, dwarfTypeMember{i*s + f.offset, joinNames(name, f.name), f.type_})
-		}
-	}
-	return t.dFields
-}
-
-func (t *dwarfIfaceType) Fields() []Field {
-	if t.fields == nil {
-		t.fields = append(t.fields, Field{FieldKindIface, 0, "", ""})
-	}
-	return t.fields
-}
-
-func (t *dwarfIfaceType) dwarfFields() []dwarfTypeMember {
-	if t.dFields == nil {
-		t.dFields = append(t.dFields, dwarfTypeMember{0, "", t})
-	}
-	return t.dFields
-}
-
-func (t *dwarfEfaceType) Fields() []Field {
-	if t.fields == nil {
-		t.fields = append(t.fields, Field{FieldKindEface, 0, "", ""})
-	}
-	return t.fields
-}
-
-func (t *dwarfEfaceType) dwarfFields() []dwarfTypeMember {
-	if t.dFields == nil {
-		t.dFields = append(t.dFields, dwarfTypeMember{0, "", t})
-	}
-	return t.dFields
-}
-
 // baseType returns a string representing the base type of this dwarf type,
 // or "" if the base type makes no sense.
-func baseType(t dwarfType) string {
+func baseType(t binutil.Type) string {
 	switch t := t.(type) {
-	case *dwarfPtrType:
-		if t.elem == nil {
+	case *binutil.PtrType:
+		if t.Elem == nil {
 			return "&lt;unknown&gt;"
 		} else {
-			return t.elem.Name()
+			return t.Elem.Name()
 		}
 		// TODO: iface, func?
 	default:
@@ -1115,355 +1112,301 @@ func baseType(t dwarfType) string {
 	}
 }
 
-// Some type names in the dwarf info don't match the corresponding
-// type names in the binary.  We'll use the rewrites here to map
-// between the two.
-// TODO: just map names for now.  Do this conversion in the dwarf dumper?
-type adjTypeName struct {
-	matcher   *regexp.Regexp
-	formatter string
-}
-
-var adjTypeNames = []adjTypeName{
-	{regexp.MustCompile(`hash<(.*),(.*)>`), "map.hdr[%s]%s"},
-	{regexp.MustCompile(`bucket<(.*),(.*)>`), "map.bucket[%s]%s"},
-	// TODO: hchan<>?
+// allGlobalRoots returns the global variables of all the given binaries.
+func allGlobalRoots(bins []*binutil.Binary) []binutil.Member {
+	var roots []binutil.Member
+	for _, b := range bins {
+		roots = append(roots, b.Globals()...)
+	}
+	return roots
 }
 
-// fix up dwarf names to match internal names
-func fixName(s string) string {
-	for _, a := range adjTypeNames {
-		for {
-			k := a.matcher.FindStringSubmatchIndex(s)
-			if k == nil {
-				break
+// allFrameLayouts merges the frame layouts of all the given binaries.
+// If a function name appears in more than one binary, the earliest
+// binary in the list wins.
+func allFrameLayouts(bins []*binutil.Binary) map[string]binutil.FrameLayout {
+	m := map[string]binutil.FrameLayout{}
+	for _, b := range bins {
+		for name, l := range b.FrameLayouts() {
+			if _, ok := m[name]; !ok {
+				m[name] = l
 			}
-			var i []interface{}
-			for j := 2; j < len(k); j += 2 {
-				i = append(i, s[k[j]:k[j+1]])
-			}
-			s = s[:k[0]] + fmt.Sprintf(a.formatter, i...) + s[k[1]:]
 		}
 	}
-	return s
+	return m
 }
 
-// load a map of all of the dwarf types
-func dwarfTypeMap(d *Dump, w *dwarf.Data) map[dwarf.Offset]dwarfType {
-	t := make(map[dwarf.Offset]dwarfType)
+// stack frames may be zero-sized, so we add call depth
+// to the key to ensure uniqueness.
+type frameKey struct {
+	sp    uint64
+	depth uint64
+}
 
-	// pass 1: make a dwarfType for all of the types in the file
-	r := w.Reader()
-	for {
-		e, err := r.Next()
-		if err != nil {
-			log.Fatal(err)
-		}
-		if e == nil {
-			break
-		}
-		if e.Val(dwarf.AttrName) == nil {
-			// Dwarf info from non-go sources might be missing a name
-			continue
-		}
-		name := fixName(e.Val(dwarf.AttrName).(string))
-		switch e.Tag {
-		case dwarf.TagBaseType:
-			x := new(dwarfBaseType)
-			x.name = name
-			x.size = uint64(e.Val(dwarf.AttrByteSize).(int64))
-			x.encoding = e.Val(dwarf.AttrEncoding).(int64)
-			t[e.Offset] = x
-		case dwarf.TagPointerType:
-			x := new(dwarfPtrType)
-			x.name = name
-			x.size = d.PtrSize
-			t[e.Offset] = x
-		case dwarf.TagStructType:
-			if name == "runtime.iface" {
-				x := new(dwarfIfaceType)
-				x.name = name
-				x.size = 2 * d.PtrSize
-				t[e.Offset] = x
-				continue
-			}
-			if name == "runtime.eface" {
-				x := new(dwarfEfaceType)
-				x.name = name
-				x.size = 2 * d.PtrSize
-				t[e.Offset] = x
-				continue
-			}
-			x := new(dwarfStructType)
-			x.name = name
-			x.size = uint64(e.Val(dwarf.AttrByteSize).(int64))
-			if len(x.name) >= 2 && x.name[:2] == "[]" {
-				// TODO: check array/len/cap
-				x.isSlice = true
-			}
-			t[e.Offset] = x
-		case dwarf.TagArrayType:
-			x := new(dwarfArrayType)
-			x.name = name
-			x.size = uint64(e.Val(dwarf.AttrByteSize).(int64))
-			t[e.Offset] = x
-		case dwarf.TagTypedef:
-			x := new(dwarfTypedef)
-			x.name = name
-			t[e.Offset] = x
-		case dwarf.TagSubroutineType:
-			x := new(dwarfFuncType)
-			x.name = name
-			x.size = d.PtrSize
-			t[e.Offset] = x
-		}
-	}
-
-	// pass 2: fill in / link up the types
-	r = w.Reader()
-	var currentStruct *dwarfStructType
-	for {
-		e, err := r.Next()
-		if err != nil {
-			log.Fatal(err)
-		}
-		if e == nil {
-			break
-		}
-		switch e.Tag {
-		case dwarf.TagTypedef:
-			t[e.Offset].(*dwarfTypedef).type_ = t[e.Val(dwarf.AttrType).(dwarf.Offset)]
-			if t[e.Offset].(*dwarfTypedef).type_ == nil {
-				log.Fatalf("can't find referent for %s %d\n", t[e.Offset].(*dwarfTypedef).name, e.Val(dwarf.AttrType).(dwarf.Offset))
-			}
-		case dwarf.TagPointerType:
-			i := e.Val(dwarf.AttrType)
-			if i != nil {
-				t[e.Offset].(*dwarfPtrType).elem = t[i.(dwarf.Offset)]
-			} else {
-				// The only nil cases are unsafe.Pointer and reflect.iword
-				if t[e.Offset].Name() != "unsafe.Pointer" &&
-					t[e.Offset].Name() != "crypto/x509._Ctype_CFTypeRef" {
-					log.Fatalf("pointer without base pointer %s", t[e.Offset].Name())
-				}
-			}
-		case dwarf.TagArrayType:
-			t[e.Offset].(*dwarfArrayType).elem = t[e.Val(dwarf.AttrType).(dwarf.Offset)]
-		case dwarf.TagStructType:
-			name := e.Val(dwarf.AttrName).(string)
-			switch name {
-			case "runtime.iface":
-				currentStruct = nil
-			case "runtime.eface":
-				currentStruct = nil
-			default:
-				currentStruct = t[e.Offset].(*dwarfStructType)
-			}
-		case dwarf.TagMember:
-			if currentStruct == nil {
-				continue
-			}
-			name := e.Val(dwarf.AttrName).(string)
-			type_ := t[e.Val(dwarf.AttrType).(dwarf.Offset)]
-			loc := e.Val(dwarf.AttrDataMemberLoc).([]uint8)
-			var offset uint64
-			if len(loc) == 0 {
-				offset = 0
-			} else if loc[0] == dw_op_plus_uconst {
-				loc, offset = readUleb(loc[1:])
-			} else if len(loc) >= 2 && loc[0] == dw_op_consts && loc[len(loc)-1] == dw_op_plus {
-				loc, offset = readUleb(loc[1 : len(loc)-1])
-				if len(loc) != 0 {
-					break
-				}
-			} else {
-				log.Fatalf("bad dwarf location spec %#v", loc)
-			}
-			currentStruct.members = append(currentStruct.members, dwarfTypeMember{offset, name, type_})
-		}
+// keepEdges reports whether there is memory to keep the edges e,
+// and if so takes it.
+func (d *Dump) keepEdges(e []Edge) bool {
+	if d.edgeMemory < 0 {
+		return true
+	}
+	n := int64(cap(e)) * int64(unsafe.Sizeof(Edge{}))
+	if n > d.edgeMemory {
+		d.streamedEdges = true
+		return false
 	}
-	return t
+	d.edgeMemory -= n
+	return true
 }
 
-// globalRoots extracts a list of global variables.  The offsets are addresses.
-func globalRoots(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) []dwarfTypeMember {
-	var roots []dwarfTypeMember
-	r := w.Reader()
-	for {
-		e, err := r.Next()
-		if err != nil {
-			log.Fatal(err)
-		}
-		if e == nil {
-			break
-		}
-		if e.Tag != dwarf.TagVariable {
-			continue
-		}
-		name := e.Val(dwarf.AttrName).(string)
-		typ := t[e.Val(dwarf.AttrType).(dwarf.Offset)]
-		locexpr := e.Val(dwarf.AttrLocation).([]uint8)
-		if len(locexpr) == 0 || locexpr[0] != dw_op_addr {
+// linkAllocSamples links alloc samples to memprof entries which
+// followed them in the dump, and warns about samples whose entry is
+// missing.
+func linkAllocSamples(d *Dump, memprof map[uint64]*MemProfEntry) {
+	for _, a := range d.AllocSamples {
+		if a.Prof != nil {
 			continue
 		}
-		loc := readPtr(d, locexpr[1:])
-		if typ == nil {
-			// lots of non-Go global symbols hit here (rodata, type..gc,
-			// static function closures, ...)
-			//fmt.Printf("nontyped global %s %d\n", name, loc)
-			continue
+		a.Prof = memprof[a.ProfKey]
+		if a.Prof == nil {
+			d.warnLimitedf("memprof", "alloc sample for object %x has no memprof entry %x", a.Addr, a.ProfKey)
 		}
-		roots = append(roots, dwarfTypeMember{loc, name, typ})
 	}
-	return roots
 }
 
-type frameLayout struct {
-	// offset is distance down from FP
-	locals []dwarfTypeMember
-	// offset is distance up from first arg slot
-	args []dwarfTypeMember
+// edges appends the edges from f into the heap to buf.  If warn is
+// set, problems with f's fields are reported.
+func (f *StackFrame) edges(buf []Edge, warn bool) []Edge {
+	d := f.d
+	if d.conservative && len(f.Fields) == 0 {
+		return d.appendConservative(buf, f.Data)
+	}
+	where := ""
+	if warn {
+		where = "frame " + f.Name
+	}
+	return d.appendFields(buf, f.Data, f.Fields, where)
 }
 
-// frameLayouts returns a map from function names to frameLayouts describing that function's stack frame.
-func frameLayouts(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) map[string]frameLayout {
-	m := map[string]frameLayout{}
-	var locals []dwarfTypeMember
-	var args []dwarfTypeMember
-	r := w.Reader()
-	var funcname string
-	for {
-		e, err := r.Next()
-		if err != nil {
-			log.Fatal(err)
-		}
-		if e == nil {
-			break
-		}
-		switch e.Tag {
-		case dwarf.TagSubprogram:
-			if funcname != "" {
-				m[funcname] = frameLayout{locals, args}
-				locals = nil
-				args = nil
-			}
-			funcname = e.Val(dwarf.AttrName).(string)
-		case dwarf.TagVariable:
-			name := e.Val(dwarf.AttrName).(string)
-			typ := t[e.Val(dwarf.AttrType).(dwarf.Offset)]
-			loc := e.Val(dwarf.AttrLocation).([]uint8)
-			if len(loc) == 0 || loc[0] != dw_op_call_frame_cfa {
-				continue
-			}
-			var offset int64
-			if len(loc) == 1 {
-				offset = 0
-			} else if len(loc) >= 3 && loc[1] == dw_op_consts && loc[len(loc)-1] == dw_op_plus {
-				loc, offset = readSleb(loc[2 : len(loc)-1])
-				if len(loc) != 0 {
-					continue
-				}
-			}
-			locals = append(locals, dwarfTypeMember{uint64(-offset), name, typ})
-		case dwarf.TagFormalParameter:
-			if e.Val(dwarf.AttrName) == nil {
-				continue
-			}
-			name := e.Val(dwarf.AttrName).(string)
-			typ := t[e.Val(dwarf.AttrType).(dwarf.Offset)]
-			loc := e.Val(dwarf.AttrLocation).([]uint8)
-			if len(loc) == 0 || loc[0] != dw_op_call_frame_cfa {
-				continue
-			}
-			var offset int64
-			if len(loc) == 1 {
-				offset = 0
-			} else if len(loc) >= 3 && loc[1] == dw_op_consts && loc[len(loc)-1] == dw_op_plus {
-				loc, offset = readSleb(loc[2 : len(loc)-1])
-				if len(loc) != 0 {
-					continue
-				}
-			}
-			args = append(args, dwarfTypeMember{uint64(offset), name, typ})
-		}
+// EdgeList returns the edges from f into the heap.  It is f.Edges,
+// unless the dump was read with a MaxEdgeMemory too small to keep
+// them, in which case they are computed on each call.
+func (f *StackFrame) EdgeList() []Edge {
+	if !f.streamed {
+		return f.Edges
 	}
-	if funcname != "" {
-		m[funcname] = frameLayout{locals, args}
+	return f.edges(nil, false)
+}
+
+// edges appends the edges from s into the heap to buf.  If warn is
+// set, problems with s's fields are reported.
+func (s *Data) edges(buf []Edge, warn bool) []Edge {
+	where := ""
+	if warn {
+		where = s.name
 	}
-	return m
+	return s.d.appendFields(buf, s.Data, s.Fields, where)
 }
 
-// stack frames may be zero-sized, so we add call depth
-// to the key to ensure uniqueness.
-type frameKey struct {
-	sp    uint64
-	depth uint64
+// EdgeList returns the edges from s into the heap.  It is s.Edges,
+// unless the dump was read with a MaxEdgeMemory too small to keep
+// them, in which case they are computed on each call.
+func (s *Data) EdgeList() []Edge {
+	if !s.streamed {
+		return s.Edges
+	}
+	return s.edges(nil, false)
 }
 
 // appendEdge might add an edge to edges.  Returns new edges.
 //   Requires data[off:] be a pointer
 //   Adds an edge if that pointer points to a valid object.
-func (d *Dump) appendEdge(edges []Edge, data []byte, off uint64, f Field) []Edge {
+func (d *Dump) appendEdge(edges []Edge, data []byte, off uint64, f Field, conservative bool) []Edge {
 	p := readPtr(d, data[off:])
 	q := d.FindObj(p)
 	if q != ObjNil {
-		edges = append(edges, Edge{q, off, p - d.objects[q].Addr, f.Name})
+		edges = append(edges, Edge{q, off, p - d.objects[q].Addr, f.Name, conservative})
 	}
 	return edges
 }
 
-func (d *Dump) appendFields(edges []Edge, data []byte, fields []Field) []Edge {
+// appendFields adds the edges found in the given fields of data.
+// where describes data for warnings about fields which don't fit in
+// it.  If where is empty, there are no warnings.
+func (d *Dump) appendFields(edges []Edge, data []byte, fields []Field, where string) []Edge {
 	//fmt.Println("appending fields")
 	for _, f := range fields {
 		//fmt.Printf("field %d %d %s %s\n", f.Kind, f.Offset, f.Name, f.BaseType)
 		off := f.Offset
-		if off >= uint64(len(data)) {
-			// TODO: what the heck is this?
+		n := d.PtrSize
+		if f.Kind == FieldKindEface || f.Kind == FieldKindIface {
+			n = 2 * d.PtrSize
+		}
+		if off+n > uint64(len(data)) {
+			if where == "" {
+				continue // already warned about
+			}
+			d.warnLimitedf("field", "%s: field %s at offset %d doesn't fit in %d bytes of data", where, f.Name, off, len(data))
 			continue
 		}
 		switch f.Kind {
 		case FieldKindPtr:
-			edges = d.appendEdge(edges, data, off, f)
+			edges = d.appendEdge(edges, data, off, f, false)
 		case FieldKindString:
-			edges = d.appendEdge(edges, data, off, f)
+			edges = d.appendEdge(edges, data, off, f, false)
 		case FieldKindSlice:
-			edges = d.appendEdge(edges, data, off, f)
+			edges = d.appendEdge(edges, data, off, f, false)
 		case FieldKindEface:
-			edges = d.appendEdge(edges, data, off, f)
+			edges = d.appendEdge(edges, data, off, f, false)
 			taddr := readPtr(d, data[off:])
 			if taddr == 0 {
 				continue // nil eface
 			}
-			t := d.TypeMap[taddr]
-			if t == nil {
-				log.Fatalf("can't find eface type %x", taddr)
-			}
-			if t.interfaceptr {
-				edges = d.appendEdge(edges, data, off+d.PtrSize, f)
+			if ptr, cons := d.efaceHasPtr(taddr); ptr {
+				edges = d.appendEdge(edges, data, off+d.PtrSize, f, cons)
 			}
 		case FieldKindIface:
 			itab := readPtr(d, data[off:])
 			if itab == 0 {
 				continue // nil iface
 			}
-			taddr, ok := d.ItabMap[itab]
-			if !ok {
-				log.Fatalf("can't find itab %x", itab)
+			if ptr, cons := d.ifaceHasPtr(itab); ptr {
+				edges = d.appendEdge(edges, data, off+d.PtrSize, f, cons)
 			}
-			if taddr == 0 {
-				// this type has a non-pointer data field
+		}
+	}
+	return edges
+}
+
+// appendConservative adds an edge for every pointer-aligned word
+// of data which points to an object.
+func (d *Dump) appendConservative(edges []Edge, data []byte) []Edge {
+	for off := uint64(0); off+d.PtrSize <= uint64(len(data)); off += d.PtrSize {
+		p := readPtr(d, data[off:])
+		q := d.FindObj(p)
+		if q != ObjNil {
+			edges = append(edges, Edge{q, off, p - d.objects[q].Addr, d.wordName(off / d.PtrSize), true})
+		}
+	}
+	return edges
+}
+
+// wordName returns the name of the i'th word of an untyped object.
+func (d *Dump) wordName(i uint64) string {
+	for uint64(len(d.wordNames)) <= i {
+		d.wordNames = append(d.wordNames, fmt.Sprintf("%d", len(d.wordNames)))
+	}
+	return d.wordNames[i]
+}
+
+// markConservative arranges for objects without dwarf types to be
+// scanned conservatively.
+func markConservative(d *Dump) {
+	for _, ft := range d.FTList {
+		if ft.Type == nil {
+			ft.Kind = TypeKindConservative
+		}
+	}
+}
+
+// efaceHasPtr reports whether an eface whose type word is taddr
+// holds a pointer in its data word, and whether that is a guess.
+func (d *Dump) efaceHasPtr(taddr uint64) (ptr, conservative bool) {
+	t := d.TypeMap[taddr]
+	if t == nil {
+		return d.unresolvedIface()
+	}
+	return t.interfaceptr, false
+}
+
+// ifaceHasPtr reports whether an iface whose itab word is itab
+// holds a pointer in its data word, and whether that is a guess.
+func (d *Dump) ifaceHasPtr(itab uint64) (ptr, conservative bool) {
+	taddr, ok := d.ItabMap[itab]
+	if !ok {
+		return d.unresolvedIface()
+	}
+	if taddr == 0 {
+		// this type has a non-pointer data field
+		return false, false
+	}
+	t := d.TypeMap[taddr]
+	if t == nil {
+		return d.unresolvedIface()
+	}
+	return t.interfaceptr, false
+}
+
+// unresolvedIface says how to treat an interface value whose type
+// can't be found, as the dump's IfacePolicy directs: whether its data
+// word should be treated as a pointer, which is always a guess.
+// resolveIfaces has already counted and reported the value.
+func (d *Dump) unresolvedIface() (ptr, conservative bool) {
+	ptr = d.ifacePolicy == IfaceConservative
+	return ptr, ptr
+}
+
+// resolveIfaces looks up the type of every interface value in the
+// heap, stack frames and globals, and counts, with a warning, those
+// whose type or itab the dump doesn't describe.  Doing it once at load
+// leaves Edges nothing to record.  With IfaceFail, an unresolved value
+// is fatal.
+func resolveIfaces(d *Dump) {
+	unresolved := func(what string, addr uint64) {
+		if d.ifacePolicy == IfaceFail {
+			log.Fatalf("can't find %s %x", what, addr)
+		}
+		if d.unresolved == nil {
+			d.unresolved = map[uint64]uint64{}
+		}
+		if d.unresolved[addr] == 0 {
+			d.warnLimitedf("iface", "can't find %s %x", what, addr)
+		}
+		d.unresolved[addr]++
+	}
+	check := func(data []byte, fields []Field) {
+		for _, f := range fields {
+			if f.Kind != FieldKindEface && f.Kind != FieldKindIface || f.Offset+2*d.PtrSize > uint64(len(data)) {
 				continue
 			}
-			t := d.TypeMap[taddr]
-			if t == nil {
-				log.Fatalf("can't find type for itab %x", taddr)
+			w := readPtr(d, data[f.Offset:])
+			switch {
+			case w == 0:
+				// nil interface
+			case f.Kind == FieldKindEface:
+				if d.TypeMap[w] == nil {
+					unresolved("eface type", w)
+				}
+			default:
+				if taddr, ok := d.ItabMap[w]; !ok {
+					unresolved("itab", w)
+				} else if taddr != 0 && d.TypeMap[taddr] == nil {
+					unresolved("type for itab", taddr)
+				}
 			}
-			if t.interfaceptr {
-				edges = d.appendEdge(edges, data, off+d.PtrSize, f)
+		}
+	}
+
+	// Read the contents only of objects whose type has interfaces.
+	hasIface := make([]bool, len(d.FTList))
+	for _, ft := range d.FTList {
+		for _, f := range ft.Fields {
+			if f.Kind == FieldKindEface || f.Kind == FieldKindIface {
+				hasIface[ft.Id] = ft.Kind != TypeKindConservative
+				break
 			}
 		}
 	}
-	return edges
+	for i := range d.objects {
+		if hasIface[d.objects[i].Ft.Id] {
+			check(d.Contents(ObjId(i)), d.objects[i].Ft.Fields)
+		}
+	}
+	for _, f := range d.Frames {
+		check(f.Data, f.Fields)
+	}
+	for _, s := range []*Data{d.Data, d.Bss} {
+		check(s.Data, s.Fields)
+	}
 }
 
 // Matches a package path, e.g. code.google.com/p/go.tools/go/types.Var
@@ -1482,30 +1425,33 @@ func typeFromPath(s string) string {
 type propagateContext struct {
 	d *Dump
 
-	type2dwarf map[uint64]dwarfType
-	itab2dwarf map[uint64]dwarfType
+	type2dwarf map[uint64]binutil.Type
+	itab2dwarf map[uint64]binutil.Type
 
 	// map from heap address to type at that address
-	htypes map[uint64]dwarfType
+	htypes map[uint64]binutil.Type
 
 	// queue of objects yet to be "scanned"
 	addrq []uint64
 }
 
-func typePropagate(d *Dump, execname string) {
+func typePropagate(d *Dump, bins []*binutil.Binary) {
 	fmt.Println("inferring types...")
 	// TODO: special case the unsafe.Pointer in reflect.Value.  We can compute
 	// the type of the thing it points to in this case.
-	w := getDwarf(execname)
-	t := dwarfTypeMap(d, w)
 
 	var pc propagateContext
 	pc.d = d
 
-	// map from type name to dwarf type
-	name2dwarf := map[string]dwarfType{}
-	for _, typ := range t {
-		name2dwarf[typ.Name()] = typ
+	// map from type name to dwarf type.  Types in the main executable
+	// take precedence over same-named types in plugins.
+	// Within a binary, the first of several same-named types wins.
+	name2dwarf := map[string]binutil.Type{}
+	for i := len(bins) - 1; i >= 0; i-- {
+		types := bins[i].SortedTypes()
+		for j := len(types) - 1; j >= 0; j-- {
+			name2dwarf[types[j].Name()] = types[j]
+		}
 	}
 
 	// Some runtime type names have just package names instead of package paths, e.g.
@@ -1513,12 +1459,22 @@ func typePropagate(d *Dump, execname string) {
 	// runtime names map to the long dwarf names.
 	// TODO: matching types by name is very error prone.  There's got to be a better way.
 	// For now, if there is a unique mapping from runtime type to dwarf type, use it.
-	short2long := map[string][]dwarfType{}
-	for n, dt := range name2dwarf {
-		n = pathRegexp.ReplaceAllStringFunc(n, typeFromPath)
-		short2long[n] = append(short2long[n], dt)
-	}
-	for n, a := range short2long {
+	var names, shorts []string
+	for n := range name2dwarf {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	short2long := map[string][]binutil.Type{}
+	for _, n := range names {
+		short := pathRegexp.ReplaceAllStringFunc(n, typeFromPath)
+		if short2long[short] == nil {
+			shorts = append(shorts, short)
+		}
+		short2long[short] = append(short2long[short], name2dwarf[n])
+	}
+	sort.Strings(shorts)
+	for _, n := range shorts {
+		a := short2long[n]
 		if len(a) == 1 {
 			// the short name matches a unique long name.
 			name2dwarf[n] = a[0]
@@ -1532,8 +1488,8 @@ func typePropagate(d *Dump, execname string) {
 	}
 
 	// map from type address to dwarf type (for resolving efaces)
-	pc.type2dwarf = map[uint64]dwarfType{}
-	for _, typ := range d.TypeMap {
+	pc.type2dwarf = map[uint64]binutil.Type{}
+	for _, typ := range d.Types {
 		dt := name2dwarf[typ.Name]
 		if dt == nil {
 			log.Printf("can't find type %s", typ.Name)
@@ -1543,14 +1499,15 @@ func typePropagate(d *Dump, execname string) {
 			// We want typ to be the pointed-to object's type.
 			// Interfaces store pointers directly, so the target's type
 			// needs a dereference.
-			dt = dt.dwarfFields()[0].type_.(*dwarfPtrType).elem
+			dt = dt.Members()[0].Type.(*binutil.PtrType).Elem
 		}
 		pc.type2dwarf[typ.Addr] = dt
 	}
 
 	// map from itab entry to dwarf type (for resolving ifaces)
-	pc.itab2dwarf = map[uint64]dwarfType{}
-	for itab, taddr := range d.ItabMap {
+	pc.itab2dwarf = map[uint64]binutil.Type{}
+	for _, itab := range sortedAddrs(d.ItabMap) {
+		taddr := d.ItabMap[itab]
 		dt, ok := pc.type2dwarf[taddr]
 		pc.itab2dwarf[itab] = dt
 		if !ok {
@@ -1559,27 +1516,27 @@ func typePropagate(d *Dump, execname string) {
 	}
 
 	// map from heap address to type at that address
-	pc.htypes = map[uint64]dwarfType{}
+	pc.htypes = map[uint64]binutil.Type{}
 
 	// set types of objects which are pointed to by globals
 	log.Printf("  Global variables...")
-	for _, r := range globalRoots(d, w, t) {
+	for _, r := range allGlobalRoots(bins) {
 		var data []byte
 		switch {
-		case r.offset >= d.Data.Addr && r.offset < d.Data.Addr+uint64(len(d.Data.Data)):
-			data = d.Data.Data[r.offset-d.Data.Addr:]
-		case r.offset >= d.Bss.Addr && r.offset < d.Bss.Addr+uint64(len(d.Bss.Data)):
-			data = d.Bss.Data[r.offset-d.Bss.Addr:]
+		case r.Offset >= d.Data.Addr && r.Offset < d.Data.Addr+uint64(len(d.Data.Data)):
+			data = d.Data.Data[r.Offset-d.Data.Addr:]
+		case r.Offset >= d.Bss.Addr && r.Offset < d.Bss.Addr+uint64(len(d.Bss.Data)):
+			data = d.Bss.Data[r.Offset-d.Bss.Addr:]
 		default:
 			// this happens for globals in, e.g., noptrbss
-			//log.Printf("global address %s %x not in data [%x %x] or bss [%x %x]", r.name, r.offset, d.Data.Addr, d.Data.Addr+uint64(len(d.Data.Data)), d.Bss.Addr, d.Bss.Addr+uint64(len(d.Bss.Data)))
+			//log.Printf("global address %s %x not in data [%x %x] or bss [%x %x]", r.Name, r.Offset, d.Data.Addr, d.Data.Addr+uint64(len(d.Data.Data)), d.Bss.Addr, d.Bss.Addr+uint64(len(d.Bss.Data)))
 			continue
 		}
-		scanType(&pc, data[:r.type_.Size()], r.type_)
+		scanType(&pc, data[:r.Type.Size()], r.Type)
 	}
 
 	// set types of objects which are pointed to by stacks
-	layouts := frameLayouts(d, w, t)
+	layouts := allFrameLayouts(bins)
 	log.Printf("  Stacks...")
 	live := map[uint64]bool{}
 	for _, g := range d.Goroutines {
@@ -1596,10 +1553,18 @@ func typePropagate(d *Dump, execname string) {
 				}
 			}
 
-			// find live pointers, propagate types along them
-			for _, local := range layouts[r.Name].locals {
-				i := uint64(len(r.Data)) - local.offset
-				for j := uint64(0); j < local.type_.Size(); j += d.PtrSize {
+			// find live pointers, propagate types along them.  A
+			// variable must be in its slot at the frame's pc, as
+			// well as the slot holding a live pointer, or the slot
+			// may hold a stale value of some other type.
+			layout := layouts[r.Name]
+			rpc := r.lookupPC()
+			for k, local := range layout.Locals {
+				if !layout.LocalLive(k, rpc) {
+					continue
+				}
+				i := uint64(len(r.Data)) - local.Offset
+				for j := uint64(0); j < local.Type.Size(); j += d.PtrSize {
 					if live[i+j] {
 						goto islive
 					}
@@ -1609,13 +1574,16 @@ func typePropagate(d *Dump, execname string) {
 				// dead, not a mix of the two.
 				continue
 			islive:
-				//log.Printf("  local %s/%s @ %x", r.Name, local.name, local.offset)
-				scanType(&pc, r.Data[i:], local.type_)
+				//log.Printf("  local %s/%s @ %x", r.Name, local.Name, local.Offset)
+				scanType(&pc, r.Data[i:], local.Type)
 			}
 
-			for _, arg := range layouts[r.Name].args {
-				//log.Printf("  arg %s/%s @ %x", r.Name, arg.name, arg.offset)
-				scanType(&pc, r.Parent.Data[arg.offset:], arg.type_)
+			for k, arg := range layout.Args {
+				if !layout.ArgLive(k, rpc) {
+					continue
+				}
+				//log.Printf("  arg %s/%s @ %x", r.Name, arg.Name, arg.Offset)
+				scanType(&pc, r.Parent.Data[arg.Offset:], arg.Type)
 			}
 		}
 	}
@@ -1642,14 +1610,14 @@ func typePropagate(d *Dump, execname string) {
 	}
 
 	// update types of known objects
-	dwarfToFull := map[dwarfType]*FullType{}
+	dwarfToFull := map[binutil.Type]*FullType{}
 	for i := 0; i < d.NumObjects(); i++ {
 		x := ObjId(i)
 		addr := d.Addr(x)
 		if t, ok := pc.htypes[addr]; ok {
 			ft, ok := dwarfToFull[t]
 			if !ok {
-				ft = &FullType{len(d.FTList), t.Size(), "", t.Name(), nil, t}
+				ft = &FullType{len(d.FTList), t.Size(), "", t.Name(), nil, t, TypeKindObject}
 				d.FTList = append(d.FTList, ft)
 				dwarfToFull[t] = ft
 			}
@@ -1658,24 +1626,40 @@ func typePropagate(d *Dump, execname string) {
 	}
 }
 
+// sortedAddrs returns the keys of m in increasing order.
+func sortedAddrs(m map[uint64]uint64) []uint64 {
+	r := make([]uint64, 0, len(m))
+	for a := range m {
+		r = append(r, a)
+	}
+	sort.Sort(byUint64(r))
+	return r
+}
+
+type byUint64 []uint64
+
+func (a byUint64) Len() int           { return len(a) }
+func (a byUint64) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byUint64) Less(i, j int) bool { return a[i] < a[j] }
+
 // "Scan" the object data as if it was the given type, possibly finding types
 // of other objects that this one points to.
-func scanType(pc *propagateContext, data []byte, typ dwarfType) {
+func scanType(pc *propagateContext, data []byte, typ binutil.Type) {
 	d := pc.d
-	for _, f := range typ.dwarfFields() {
-		if f.offset+f.type_.Size() > uint64(len(data)) {
+	for _, f := range typ.Members() {
+		if f.Offset+f.Type.Size() > uint64(len(data)) {
 			log.Fatalf("field past end of object %s %#v", typ.Name(), f)
 		}
-		switch t := f.type_.(type) {
-		case *dwarfPtrType:
-			if t.elem == nil {
-				// t.elem is nil for unsafe.Pointer-like pointers
+		switch t := f.Type.(type) {
+		case *binutil.PtrType:
+			if t.Elem == nil {
+				// t.Elem is nil for unsafe.Pointer-like pointers
 				continue
 			}
-			p := readPtr(d, data[f.offset:])
-			setType(pc, p, t.elem)
-		case *dwarfIfaceType:
-			itab := readPtr(d, data[f.offset:])
+			p := readPtr(d, data[f.Offset:])
+			setType(pc, p, t.Elem)
+		case *binutil.IfaceType:
+			itab := readPtr(d, data[f.Offset:])
 			if itab == 0 {
 				continue
 			}
@@ -1687,10 +1671,10 @@ func scanType(pc *propagateContext, data []byte, typ dwarfType) {
 				log.Printf("  typ=%s", d.TypeMap[d.ItabMap[itab]].Name)
 				continue
 			}
-			p := readPtr(d, data[f.offset+d.PtrSize:])
+			p := readPtr(d, data[f.Offset+d.PtrSize:])
 			setType(pc, p, it)
-		case *dwarfEfaceType:
-			addr := readPtr(d, data[f.offset:])
+		case *binutil.EfaceType:
+			addr := readPtr(d, data[f.Offset:])
 			if addr == 0 {
 				continue
 			}
@@ -1701,9 +1685,9 @@ func scanType(pc *propagateContext, data []byte, typ dwarfType) {
 				log.Printf("  typ=%s", d.TypeMap[addr].Name)
 				continue
 			}
-			p := readPtr(d, data[f.offset+d.PtrSize:])
+			p := readPtr(d, data[f.Offset+d.PtrSize:])
 			setType(pc, p, it)
-		case *dwarfBaseType:
+		case *binutil.BaseType:
 			// nothing to do
 		default:
 			log.Fatalf("unknown type for field %#v", f)
@@ -1711,10 +1695,10 @@ func scanType(pc *propagateContext, data []byte, typ dwarfType) {
 	}
 }
 
-func setType(pc *propagateContext, addr uint64, typ dwarfType) {
+func setType(pc *propagateContext, addr uint64, typ binutil.Type) {
 	d := pc.d
-	if addr < d.HeapStart || addr >= d.HeapEnd {
-		return
+	if addr < d.idxStart || addr >= d.idxEnd {
+		return // outside the heap, or the window loaded
 	}
 	obj := d.FindObj(addr)
 	if obj == ObjNil {
@@ -1756,7 +1740,7 @@ func setType(pc *propagateContext, addr uint64, typ dwarfType) {
 // Check to make sure our type information is consistent.
 // Dwarf info claims that the object at addr has type typ.  Check this info
 // against the gcinfo types recorded in the dump.
-func checkType(d *Dump, addr uint64, typ dwarfType) {
+func checkType(d *Dump, addr uint64, typ binutil.Type) {
 	// TODO: dwarf and runtime disagree about the layout of hchan<nonptrtype>
 	if len(typ.Name()) >= 6 && typ.Name()[:6] == "hchan<" {
 		return
@@ -1767,13 +1751,13 @@ func checkType(d *Dump, addr uint64, typ dwarfType) {
 	start := addr - d.Addr(obj)
 	if start%d.PtrSize != 0 {
 		// not aligned to a pointer - shouldn't contain any pointers
-		for _, f := range typ.dwarfFields() {
-			switch f.type_.(type) {
-			case *dwarfPtrType:
+		for _, f := range typ.Members() {
+			switch f.Type.(type) {
+			case *binutil.PtrType:
 				log.Fatalf("unaligned type %s has a pointer in it", typ.Name())
-			case *dwarfIfaceType:
+			case *binutil.IfaceType:
 				log.Fatalf("unaligned type %s has an iface in it", typ.Name())
-			case *dwarfEfaceType:
+			case *binutil.EfaceType:
 				log.Fatalf("unaligned type %s has an eface in it", typ.Name())
 			}
 		}
@@ -1793,20 +1777,20 @@ func checkType(d *Dump, addr uint64, typ dwarfType) {
 	// TODO: figure out how to check arrays.  Right now we only check one T at the target of any *T,
 	// but for slices we should check lots of T (up to the capacity of the slice).
 	n := 0
-	for _, f := range typ.dwarfFields() {
-		off := f.offset / d.PtrSize
-		switch f.type_.(type) {
-		case *dwarfPtrType:
+	for _, f := range typ.Members() {
+		off := f.Offset / d.PtrSize
+		switch f.Type.(type) {
+		case *binutil.PtrType:
 			if off >= uint64(len(s)) || s[off] != 'P' {
 				log.Fatalf("dwarf type %s has pointer @ %d, gc type %s does not", typ.Name(), off, s)
 			}
 			n++
-		case *dwarfIfaceType:
+		case *binutil.IfaceType:
 			if off >= uint64(len(s)-1) || s[off] != 'I' && s[off+1] != 'I' {
 				log.Fatalf("dwarf type %s has iface, gc type %s does not", typ.Name(), s)
 			}
 			n += 2
-		case *dwarfEfaceType:
+		case *binutil.EfaceType:
 			if off >= uint64(len(s)-1) && s[off] != 'E' && s[off+1] != 'E' {
 				log.Fatalf("dwarf type %s has eface, gc type %s does not", typ.Name(), s)
 			}
@@ -1824,17 +1808,14 @@ func checkType(d *Dump, addr uint64, typ dwarfType) {
 }
 
 type nameType struct {
-	name  string
-	type_ dwarfType
+	name string
+	Type binutil.Type
 }
 
 // Names the fields it can for better debugging output
-func nameWithDwarf(d *Dump, execname string) {
-	w := getDwarf(execname)
-	t := dwarfTypeMap(d, w)
-
+func nameWithDwarf(d *Dump, bins []*binutil.Binary) {
 	// name all frame fields
-	layouts := frameLayouts(d, w, t)
+	layouts := allFrameLayouts(bins)
 	for _, g := range d.Goroutines {
 		var c *StackFrame
 		for r := g.Bos; r != nil; r = r.Parent {
@@ -1844,9 +1825,13 @@ func nameWithDwarf(d *Dump, execname string) {
 			}
 			// make maps from offset to field name & type
 			vars := map[uint64]nameType{}
-			for _, local := range layouts[r.Name].locals {
-				for _, f := range local.type_.dwarfFields() {
-					vars[uint64(len(r.Data))-local.offset+f.offset] = nameType{joinNames(local.name, f.name), f.type_}
+			layout := layouts[r.Name]
+			for k, local := range layout.Locals {
+				if !layout.LocalLive(k, r.lookupPC()) {
+					continue
+				}
+				for _, f := range local.Type.Members() {
+					vars[uint64(len(r.Data))-local.Offset+f.Offset] = nameType{joinNames(local.Name, f.Name), f.Type}
 				}
 			}
 			if c != nil {
@@ -1854,9 +1839,13 @@ func nameWithDwarf(d *Dump, execname string) {
 				if !ok {
 					log.Printf("no locals layout for %s", c.Name)
 				}
-				for _, arg := range layouts[c.Name].args {
-					for _, f := range arg.type_.dwarfFields() {
-						vars[arg.offset+f.offset] = nameType{joinNames("outarg."+arg.name, f.name), f.type_}
+				cl := layouts[c.Name]
+				for k, arg := range cl.Args {
+					if !cl.ArgLive(k, c.lookupPC()) {
+						continue
+					}
+					for _, f := range arg.Type.Members() {
+						vars[arg.Offset+f.Offset] = nameType{joinNames("outarg."+arg.Name, f.Name), f.Type}
 					}
 				}
 			}
@@ -1872,7 +1861,7 @@ func nameWithDwarf(d *Dump, execname string) {
 					continue
 				}
 				r.Fields[i].Name = v.name
-				r.Fields[i].BaseType = baseType(v.type_)
+				r.Fields[i].BaseType = baseType(v.Type)
 			}
 			c = r
 		}
@@ -1880,11 +1869,13 @@ func nameWithDwarf(d *Dump, execname string) {
 
 	// name all globals
 	gm := map[uint64]nameType{}
-	for _, g := range globalRoots(d, w, t) {
-		for _, f := range g.type_.dwarfFields() {
-			gm[g.offset+f.offset] = nameType{joinNames(g.name, f.name), f.type_}
+	for _, g := range allGlobalRoots(bins) {
+		d.addGlobal(Global{g.Name, g.Offset, g.Type.Size(), g.Type.Name(), g.Type})
+		for _, f := range g.Type.Members() {
+			gm[g.Offset+f.Offset] = nameType{joinNames(g.Name, f.Name), f.Type}
 		}
 	}
+	d.sortGlobals()
 	for _, x := range []*Data{d.Data, d.Bss} {
 		for i, f := range x.Fields {
 			nt, ok := gm[x.Addr+f.Offset]
@@ -1892,7 +1883,7 @@ func nameWithDwarf(d *Dump, execname string) {
 				continue
 			}
 			x.Fields[i].Name = nt.name
-			x.Fields[i].BaseType = baseType(nt.type_)
+			x.Fields[i].BaseType = baseType(nt.Type)
 		}
 	}
 }
@@ -1901,26 +1892,78 @@ func link1(d *Dump) {
 	// sort objects in increasing address order
 	sort.Sort(byAddr(d.objects))
 
-	// initialize index array
-	d.idx = make([]ObjId, (d.HeapEnd-d.HeapStart+bucketSize-1)/bucketSize)
+	// check for objects which overlap.  A correct dump never has any,
+	// but a corrupt one might.
+	var end uint64 // highest end address of any object seen so far
+	for i := range d.objects {
+		x := &d.objects[i]
+		if i > 0 && x.Addr < end {
+			d.warnLimitedf("overlap", "object %x (size %d) overlaps an earlier object ending at %x", x.Addr, x.Ft.Size, end)
+		}
+		if x.Addr+x.Ft.Size > end {
+			end = x.Addr + x.Ft.Size
+		}
+	}
+
+	// initialize index array.  It covers only the window loaded, and
+	// the objects extending past its end.
+	d.idxStart, d.idxEnd = d.window.Lo, d.window.Hi
+	if end > d.idxEnd && d.idxEnd < d.HeapEnd {
+		d.idxEnd = end
+		if d.idxEnd > d.HeapEnd {
+			d.idxEnd = d.HeapEnd
+		}
+	}
+	checkLen("heap index", (d.idxEnd-d.idxStart)/bucketSize+1)
+	d.idx = make([]ObjId, (d.idxEnd-d.idxStart+bucketSize-1)/bucketSize)
 	for i := len(d.idx) - 1; i >= 0; i-- {
 		d.idx[i] = ObjId(len(d.objects))
 	}
+	if d.profileIndex {
+		d.bucketLookups = make([]uint64, len(d.idx))
+		d.bucketProbes = make([]uint64, len(d.idx))
+	}
 	for i := len(d.objects) - 1; i >= 0; i-- {
 		// Note: we iterate in reverse order so that the object with
 		// the lowest address that intersects a bucket will win.
-		lo := (d.objects[i].Addr - d.HeapStart) / bucketSize
-		hi := (d.objects[i].Addr + d.objects[i].Ft.Size - 1 - d.HeapStart) / bucketSize
+		lo := (d.objects[i].Addr - d.idxStart) / bucketSize
+		hi := lo
+		if d.objects[i].Ft.Size > 0 {
+			hi = (d.objects[i].Addr + d.objects[i].Ft.Size - 1 - d.idxStart) / bucketSize
+		}
 		for j := lo; j <= hi; j++ {
 			d.idx[j] = ObjId(i)
 		}
 	}
 
+	// Drop repeated goroutine and frame records.  A correct dump
+	// never has any, but a corrupt or concatenated one might, and
+	// linking them would build bogus frame chains.
+	gs := make(map[uint64]bool, len(d.Goroutines))
+	goroutines := d.Goroutines[:0]
+	for _, g := range d.Goroutines {
+		if gs[g.Addr] {
+			d.warnLimitedf("duplicate", "duplicate goroutine record %x ignored", g.Addr)
+			continue
+		}
+		gs[g.Addr] = true
+		goroutines = append(goroutines, g)
+	}
+	d.Goroutines = goroutines
+
 	// initialize some maps used for linking
 	frames := make(map[frameKey]*StackFrame, len(d.Frames))
+	uniqFrames := d.Frames[:0]
 	for _, x := range d.Frames {
-		frames[frameKey{x.Addr, x.Depth}] = x
+		k := frameKey{x.Addr, x.Depth}
+		if frames[k] != nil {
+			d.warnLimitedf("duplicate", "duplicate stack frame record %x depth %d (%s) ignored", x.Addr, x.Depth, x.Name)
+			continue
+		}
+		frames[k] = x
+		uniqFrames = append(uniqFrames, x)
 	}
+	d.Frames = uniqFrames
 
 	// link up frames in sequence
 	for _, f := range d.Frames {
@@ -1928,6 +1971,10 @@ func link1(d *Dump) {
 			continue
 		}
 		g := frames[frameKey{f.childaddr, f.Depth - 1}]
+		if g == nil {
+			d.warnLimitedf("frame", "stack frame %x depth %d (%s) has no child frame at %x", f.Addr, f.Depth, f.Name, f.childaddr)
+			continue
+		}
 		g.Parent = f
 	}
 
@@ -1935,34 +1982,166 @@ func link1(d *Dump) {
 	for _, g := range d.Goroutines {
 		g.Bos = frames[frameKey{g.bosaddr, 0}]
 		if g.Bos == nil {
-			log.Fatal("bos missing")
+			d.warnLimitedf("frame", "goroutine %x has no stack frames", g.Addr)
 		}
 		for f := g.Bos; f != nil; f = f.Parent {
+			if f.Goroutine != nil {
+				d.warnLimitedf("frame", "stack frame %x (%s) is on goroutines %x and %x", f.Addr, f.Name, f.Goroutine.Addr, g.Addr)
+				break
+			}
 			f.Goroutine = g
 		}
-		x := d.FindObj(g.ctxtaddr)
-		if x != ObjNil {
-			g.Ctxt = x
+		g.Ctxt = d.FindObj(g.ctxtaddr)
+	}
+
+	linkDefers(d)
+
+	// resolve itabs to their concrete types
+	sort.Sort(byItabAddr(d.itabs))
+	for _, t := range d.itabs {
+		t.Type = d.TypeMap[t.TypeAddr]
+	}
+}
+
+// linkDefers attaches the defer and panic records to their
+// goroutines, and names the deferred functions where stack frames
+// tell us the names of their entry points.
+func linkDefers(d *Dump) {
+	defers := make(map[uint64]*Defer, len(d.Defers))
+	for _, x := range d.Defers {
+		defers[x.Addr] = x
+	}
+	panics := make(map[uint64]*Panic, len(d.Panics))
+	for _, p := range d.Panics {
+		panics[p.Addr] = p
+		p.Defer = defers[p.defr]
+		p.ValueType = d.TypeMap[p.Type]
+	}
+	for _, g := range d.Goroutines {
+		seen := map[uint64]bool{}
+		for a := g.deferaddr; a != 0; {
+			x := defers[a]
+			if x == nil {
+				d.warnLimitedf("defer", "goroutine %x: defer record %x missing", g.Addr, a)
+				break
+			}
+			if seen[a] || x.gp != g.Addr {
+				d.warnLimitedf("defer", "goroutine %x: bad defer chain at %x", g.Addr, a)
+				break
+			}
+			seen[a] = true
+			g.Defers = append(g.Defers, x)
+			a = x.link
+		}
+		for a := g.panicaddr; a != 0; {
+			p := panics[a]
+			if p == nil {
+				d.warnLimitedf("defer", "goroutine %x: panic record %x missing", g.Addr, a)
+				break
+			}
+			if seen[a] || p.gp != g.Addr {
+				d.warnLimitedf("defer", "goroutine %x: bad panic chain at %x", g.Addr, a)
+				break
+			}
+			seen[a] = true
+			g.Panics = append(g.Panics, p)
+			a = p.link
+		}
+	}
+
+	entries := map[uint64]string{}
+	for _, f := range d.Frames {
+		entries[f.entry] = f.Name
+	}
+	for _, x := range d.Defers {
+		x.Func = entries[x.Code]
+	}
+}
+
+// nameDefers names the deferred functions which no stack frame
+// named, using the executables' dwarf info.
+func nameDefers(d *Dump, bins []*binutil.Binary) {
+	var funcs [][]binutil.Func
+	for _, x := range d.Defers {
+		if x.Func != "" {
+			continue
+		}
+		if funcs == nil {
+			for _, b := range bins {
+				funcs = append(funcs, b.Funcs())
+			}
+		}
+		for _, fs := range funcs {
+			if f, ok := binutil.FindFunc(fs, x.Code); ok {
+				x.Func = f.Name
+				break
+			}
+		}
+	}
+}
+
+// lookupPC returns the pc to look up f's function and variables at.
+func (f *StackFrame) lookupPC() uint64 {
+	if f.Depth > 0 {
+		// Outer frames are stopped at a return address, which may
+		// be the first instruction of the next line or function.
+		return f.pc - 1
+	}
+	return f.pc
+}
+
+// sourceFrames returns the logical frames executing at pc, from the
+// executables' dwarf info, or nil if they don't describe pc.  Looking
+// them up reads a compilation unit's line table, so the result is
+// remembered for each pc.
+func (d *Dump) sourceFrames(pc uint64) []binutil.SourceFrame {
+	if len(d.bins) == 0 {
+		return nil
+	}
+	d.sourceMu.Lock()
+	defer d.sourceMu.Unlock()
+	if s, ok := d.sourceByPC[pc]; ok {
+		return s
+	}
+	var s []binutil.SourceFrame
+	for _, b := range d.bins {
+		if s = b.SourceFrames(pc); s != nil {
+			break
 		}
 	}
+	if d.sourceByPC == nil {
+		d.sourceByPC = map[uint64][]binutil.SourceFrame{}
+	}
+	d.sourceByPC[pc] = s
+	return s
 }
 
 func link2(d *Dump) {
 	// link stack frames to objects
 	for _, f := range d.Frames {
-		f.Edges = d.appendFields(f.Edges, f.Data, f.Fields)
+		e := f.edges(nil, true)
+		if d.keepEdges(e) {
+			f.Edges = e
+		} else {
+			f.streamed = true
+		}
 	}
 
 	// link data roots
-	for _, x := range []*Data{d.Data, d.Bss} {
-		x.Edges = d.appendFields(x.Edges, x.Data, x.Fields)
+	for _, s := range []*Data{d.Data, d.Bss} {
+		e := s.edges(nil, true)
+		if d.keepEdges(e) {
+			s.Edges = e
+		} else {
+			s.streamed = true
+		}
 	}
 
 	// link other roots
 	for _, r := range d.Otherroots {
 		x := d.FindObj(r.toaddr)
 		if x != ObjNil {
-			r.Edges = append(r.Edges, Edge{x, 0, r.toaddr - d.objects[x].Addr, ""})
+			r.Edges = append(r.Edges, Edge{x, 0, r.toaddr - d.objects[x].Addr, "", false})
 		}
 	}
 
@@ -1983,7 +2162,7 @@ func link2(d *Dump) {
 		for _, addr := range []uint64{f.obj, f.fn, f.fint, f.ot} {
 			x := d.FindObj(addr)
 			if x != ObjNil {
-				f.Edges = append(f.Edges, Edge{x, 0, addr - d.objects[x].Addr, ""})
+				f.Edges = append(f.Edges, Edge{x, 0, addr - d.objects[x].Addr, "", false})
 			}
 		}
 	}
@@ -2021,16 +2200,13 @@ func nameFullTypes(d *Dump) {
 	}
 }
 
+// nameRaw gives a type without dwarf information the fields that
+// its gc signature says may hold pointers.  Only these are needed to
+// find edges; the scalar words in between, of which there may be
+// thousands, are filled in by TypeFields when the type is displayed.
 func nameRaw(d *Dump, ft *FullType) {
 	for i := 0; i < len(ft.GCSig); i++ {
 		switch ft.GCSig[i] {
-		case 'S':
-			// TODO: byte arrays instead?
-			if d.PtrSize == 8 {
-				ft.Fields = append(ft.Fields, Field{FieldKindBytes8, uint64(i) * d.PtrSize, fmt.Sprintf("%d", i), ""})
-			} else {
-				ft.Fields = append(ft.Fields, Field{FieldKindBytes4, uint64(i) * d.PtrSize, fmt.Sprintf("%d", i), ""})
-			}
 		case 'P':
 			ft.Fields = append(ft.Fields, Field{FieldKindPtr, uint64(i) * d.PtrSize, fmt.Sprintf("%d", i), ""})
 		case 'I':
@@ -2041,66 +2217,96 @@ func nameRaw(d *Dump, ft *FullType) {
 			i++
 		}
 	}
+}
+
+// TypeFields returns the complete field list of type ft, for
+// describing objects of that type.  For types with dwarf information
+// this is just ft.Fields.  For other types, ft.Fields has only the
+// pointer fields, and TypeFields adds a field for each scalar word,
+// up to 64KB.  The result is built on each call and not retained.
+func (d *Dump) TypeFields(ft *FullType) []Field {
+	if ft.Type != nil {
+		return ft.Fields
+	}
+	var scalar FieldKind = FieldKindBytes8
+	if d.PtrSize == 4 {
+		scalar = FieldKindBytes4
+	}
+	var r []Field
+	ptrs := ft.Fields
+	for i := 0; i < len(ft.GCSig); i++ {
+		off := uint64(i) * d.PtrSize
+		if len(ptrs) > 0 && ptrs[0].Offset == off {
+			r = append(r, ptrs[0])
+			if ptrs[0].Kind != FieldKindPtr {
+				i++
+			}
+			ptrs = ptrs[1:]
+			continue
+		}
+		if ft.GCSig[i] == 'S' {
+			// TODO: byte arrays instead?
+			r = append(r, Field{scalar, off, fmt.Sprintf("%d", i), ""})
+		}
+	}
 	// after gc signature, there may be more data bytes
 	for i := uint64(len(ft.GCSig)) * d.PtrSize; i < ft.Size; i += d.PtrSize {
-		if d.PtrSize == 8 {
-			ft.Fields = append(ft.Fields, Field{FieldKindBytes8, i, fmt.Sprintf("%d", i/d.PtrSize), ""})
-		} else {
-			ft.Fields = append(ft.Fields, Field{FieldKindBytes4, i, fmt.Sprintf("%d", i/d.PtrSize), ""})
-		}
+		r = append(r, Field{scalar, i, fmt.Sprintf("%d", i/d.PtrSize), ""})
 		if i >= 1<<16 {
 			// ignore >64KB of data
-			ft.Fields = append(ft.Fields, Field{FieldKindBytesElided, i, fmt.Sprintf("%d", i/d.PtrSize), ""})
+			r = append(r, Field{FieldKindBytesElided, i, fmt.Sprintf("%d", i/d.PtrSize), ""})
 			break
 		}
 	}
+	return r
 }
+
 func nameDwarf(d *Dump, ft *FullType) {
 	t := ft.Type
-	for _, f := range t.dwarfFields() {
-		switch typ := f.type_.(type) {
-		case *dwarfPtrType:
-			if typ.elem == nil {
+	for _, f := range t.Members() {
+		switch typ := f.Type.(type) {
+		case *binutil.PtrType:
+			if typ.Elem == nil {
 				// TODO: viewer should escape <, so we can use that instead of &lt;
-				ft.Fields = append(ft.Fields, Field{FieldKindPtr, f.offset, f.name, "&lt;untyped&gt;"})
+				ft.Fields = append(ft.Fields, Field{FieldKindPtr, f.Offset, f.Name, "&lt;untyped&gt;"})
 			} else {
-				ft.Fields = append(ft.Fields, Field{FieldKindPtr, f.offset, f.name, typ.elem.Name()})
+				ft.Fields = append(ft.Fields, Field{FieldKindPtr, f.Offset, f.Name, typ.Elem.Name()})
 			}
-		case *dwarfBaseType:
+		case *binutil.BaseType:
 			switch {
-			case typ.encoding == dw_ate_boolean:
-				ft.Fields = append(ft.Fields, Field{FieldKindBool, f.offset, f.name, ""})
-			case typ.encoding == dw_ate_signed && typ.size == 1:
-				ft.Fields = append(ft.Fields, Field{FieldKindSInt8, f.offset, f.name, ""})
-			case typ.encoding == dw_ate_unsigned && typ.size == 1:
-				ft.Fields = append(ft.Fields, Field{FieldKindUInt8, f.offset, f.name, ""})
-			case typ.encoding == dw_ate_signed && typ.size == 2:
-				ft.Fields = append(ft.Fields, Field{FieldKindSInt16, f.offset, f.name, ""})
-			case typ.encoding == dw_ate_unsigned && typ.size == 2:
-				ft.Fields = append(ft.Fields, Field{FieldKindUInt16, f.offset, f.name, ""})
-			case typ.encoding == dw_ate_signed && typ.size == 4:
-				ft.Fields = append(ft.Fields, Field{FieldKindSInt32, f.offset, f.name, ""})
-			case typ.encoding == dw_ate_unsigned && typ.size == 4:
-				ft.Fields = append(ft.Fields, Field{FieldKindUInt32, f.offset, f.name, ""})
-			case typ.encoding == dw_ate_signed && typ.size == 8:
-				ft.Fields = append(ft.Fields, Field{FieldKindSInt64, f.offset, f.name, ""})
-			case typ.encoding == dw_ate_unsigned && typ.size == 8:
-				ft.Fields = append(ft.Fields, Field{FieldKindUInt64, f.offset, f.name, ""})
-			case typ.encoding == dw_ate_float && typ.size == 4:
-				ft.Fields = append(ft.Fields, Field{FieldKindFloat32, f.offset, f.name, ""})
-			case typ.encoding == dw_ate_float && typ.size == 8:
-				ft.Fields = append(ft.Fields, Field{FieldKindFloat64, f.offset, f.name, ""})
-			case typ.encoding == dw_ate_complex_float && typ.size == 8:
-				ft.Fields = append(ft.Fields, Field{FieldKindComplex64, f.offset, f.name, ""})
-			case typ.encoding == dw_ate_complex_float && typ.size == 16:
-				ft.Fields = append(ft.Fields, Field{FieldKindComplex128, f.offset, f.name, ""})
+			case typ.Encoding == binutil.AteBoolean:
+				ft.Fields = append(ft.Fields, Field{FieldKindBool, f.Offset, f.Name, ""})
+			case typ.Encoding == binutil.AteSigned && typ.Size() == 1:
+				ft.Fields = append(ft.Fields, Field{FieldKindSInt8, f.Offset, f.Name, ""})
+			case typ.Encoding == binutil.AteUnsigned && typ.Size() == 1:
+				ft.Fields = append(ft.Fields, Field{FieldKindUInt8, f.Offset, f.Name, ""})
+			case typ.Encoding == binutil.AteSigned && typ.Size() == 2:
+				ft.Fields = append(ft.Fields, Field{FieldKindSInt16, f.Offset, f.Name, ""})
+			case typ.Encoding == binutil.AteUnsigned && typ.Size() == 2:
+				ft.Fields = append(ft.Fields, Field{FieldKindUInt16, f.Offset, f.Name, ""})
+			case typ.Encoding == binutil.AteSigned && typ.Size() == 4:
+				ft.Fields = append(ft.Fields, Field{FieldKindSInt32, f.Offset, f.Name, ""})
+			case typ.Encoding == binutil.AteUnsigned && typ.Size() == 4:
+				ft.Fields = append(ft.Fields, Field{FieldKindUInt32, f.Offset, f.Name, ""})
+			case typ.Encoding == binutil.AteSigned && typ.Size() == 8:
+				ft.Fields = append(ft.Fields, Field{FieldKindSInt64, f.Offset, f.Name, ""})
+			case typ.Encoding == binutil.AteUnsigned && typ.Size() == 8:
+				ft.Fields = append(ft.Fields, Field{FieldKindUInt64, f.Offset, f.Name, ""})
+			case typ.Encoding == binutil.AteFloat && typ.Size() == 4:
+				ft.Fields = append(ft.Fields, Field{FieldKindFloat32, f.Offset, f.Name, ""})
+			case typ.Encoding == binutil.AteFloat && typ.Size() == 8:
+				ft.Fields = append(ft.Fields, Field{FieldKindFloat64, f.Offset, f.Name, ""})
+			case typ.Encoding == binutil.AteComplexFloat && typ.Size() == 8:
+				ft.Fields = append(ft.Fields, Field{FieldKindComplex64, f.Offset, f.Name, ""})
+			case typ.Encoding == binutil.AteComplexFloat && typ.Size() == 16:
+				ft.Fields = append(ft.Fields, Field{FieldKindComplex128, f.Offset, f.Name, ""})
 			default:
-				log.Fatalf("unknown encoding encoding=%d size=%d", typ.encoding, typ.size)
+				log.Fatalf("unknown encoding encoding=%d size=%d", typ.Encoding, typ.Size())
 			}
-		case *dwarfIfaceType:
-			ft.Fields = append(ft.Fields, Field{FieldKindIface, f.offset, f.name, ""})
-		case *dwarfEfaceType:
-			ft.Fields = append(ft.Fields, Field{FieldKindEface, f.offset, f.name, ""})
+		case *binutil.IfaceType:
+			ft.Fields = append(ft.Fields, Field{FieldKindIface, f.Offset, f.Name, ""})
+		case *binutil.EfaceType:
+			ft.Fields = append(ft.Fields, Field{FieldKindEface, f.Offset, f.Name, ""})
 		default:
 			log.Fatalf("bad dwarf type %v", typ)
 		}
@@ -2109,32 +2315,205 @@ func nameDwarf(d *Dump, ft *FullType) {
 
 type byAddr []object
 
-func (a byAddr) Len() int           { return len(a) }
-func (a byAddr) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a byAddr) Less(i, j int) bool { return a[i].Addr < a[j].Addr }
+func (a byAddr) Len() int      { return len(a) }
+func (a byAddr) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byAddr) Less(i, j int) bool {
+	if a[i].Addr != a[j].Addr {
+		return a[i].Addr < a[j].Addr
+	}
+	// A zero-sized object sorts before a non-empty object at the same
+	// address, so it doesn't look like it overlaps it.
+	if zi, zj := a[i].Ft.Size == 0, a[j].Ft.Size == 0; zi != zj {
+		return zi
+	}
+	// Objects at the same address shouldn't happen, but if they do
+	// keep them in dump order so that FindObj is deterministic.
+	return a[i].offset < a[j].offset
+}
 
+// Read reads the heap dump in dumpname.  If execname is not empty, it
+// names the executable which generated the dump, and its dwarf
+// information is used to type the objects in the heap.
 func Read(dumpname, execname string) *Dump {
-	d := rawRead(dumpname)
-	link1(d)
+	var opts Options
 	if execname != "" {
-		typePropagate(d, execname)
-		nameWithDwarf(d, execname)
+		opts.Executables = []Executable{{Path: execname}}
+	}
+	return ReadWithOptions(dumpname, &opts)
+}
+
+// ReadWithOptions reads the heap dump in dumpname as directed by opts.
+// If opts lists no executables, it looks for them with FindExecutables.
+// If an executable can't be read, the dump is read without any, with a
+// warning.
+// A dump split across several files is read from opts.Parts, or from
+// the files matching dumpname if it is a pattern; see DumpParts.  Its
+// executables are looked for with the first file's name.
+func ReadWithOptions(dumpname string, opts *Options) *Dump {
+	start := time.Now()
+
+	parts := opts.Parts
+	if len(parts) == 0 {
+		var err error
+		parts, err = DumpParts(dumpname)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	dumpname = parts[0]
+
+	if len(opts.Executables) == 0 {
+		servers := opts.SymbolServers
+		if servers == nil {
+			servers = DefaultSymbolServers()
+		}
+		execs, err := FindExecutables(dumpname, opts.SymbolDirs, servers)
+		if err != nil {
+			log.Printf("reading without executables: %v", err)
+		}
+		for _, e := range execs {
+			log.Printf("using executable %s", e.Path)
+		}
+		o := *opts
+		o.Executables = execs
+		opts = &o
+	}
+
+	// Reading the executables' dwarf info needs only the params
+	// record, so do it while the rest of the dump is read and indexed.
+	var bins []*binutil.Binary
+	var tabs []*binutil.FuncTable // of executables without dwarf info
+	var dwarfTime time.Duration
+	var dwarfErr error
+	dwarfDone := make(chan struct{})
+	dwarfStarted := false
+	startDwarf := func(p Params) {
+		if dwarfStarted || len(opts.Executables) == 0 {
+			return
+		}
+		dwarfStarted = true
+		go func() {
+			start := time.Now()
+			bins, tabs, dwarfErr = loadExecs(p, opts.Executables, opts.NameRewrites, opts.Binaries)
+			dwarfTime = time.Since(start)
+			close(dwarfDone)
+		}()
+	}
+
+	d := rawRead(parts, opts.Window, startDwarf, opts.hooks())
+	d.window = d.HeapRange()
+	if w := opts.Window; !w.IsZero() {
+		if w.Lo > d.window.Lo {
+			d.window.Lo = w.Lo
+		}
+		if w.Hi < d.window.Hi {
+			d.window.Hi = w.Hi
+		}
+		if d.window.Lo > d.window.Hi {
+			d.window.Lo = d.window.Hi
+		}
+	}
+	startDwarf(d.Params) // in case the dump has no params record
+	d.ifacePolicy = opts.IfacePolicy
+	d.conservative = opts.Conservative
+	d.profileIndex = opts.ProfileIndex
+	d.edgeMemory = -1
+	if opts.MaxEdgeMemory > 0 {
+		d.edgeMemory = opts.MaxEdgeMemory
+	} else if opts.MaxEdgeMemory < 0 {
+		d.edgeMemory = 0
+	}
+	d.indexStore = opts.IndexStore
+	if opts.CacheSize > 0 {
+		d.cache = newBlockCache(d.r, opts.CacheSize)
+		d.r = d.cache
+	}
+	d.stats.ReadTime = time.Since(start)
+	d.notePeak()
+
+	d.timePhase(&d.stats.LinkTime, func() { link1(d) })
+	if len(opts.Executables) > 0 {
+		<-dwarfDone
+		d.stats.DwarfTime = dwarfTime
+		d.notePeak()
+		if dwarfErr != nil {
+			d.warnf("reading without executables: %v", dwarfErr)
+		}
+	}
+	if len(bins) > 0 {
+		d.timePhase(&d.stats.TypeTime, func() { typePropagate(d, bins) })
+		d.timePhase(&d.stats.NameTime, func() { nameWithDwarf(d, bins) })
+		checkRuntimeGlobals(d)
+		d.timePhase(&d.stats.NameTime, func() { nameDefers(d, bins) })
+		d.bins = bins
+		d.hasDwarf = true
 	} else {
-		nameFallback(d)
+		d.timePhase(&d.stats.NameTime, func() { nameFallback(d) })
+	}
+	if len(tabs) > 0 {
+		for _, t := range tabs {
+			d.warnf("%s has no dwarf info; naming its functions' frame slots from its function table", t.Path)
+		}
+		d.timePhase(&d.stats.NameTime, func() { nameWithFuncTables(d, tabs) })
 	}
-	nameFullTypes(d)
-	link2(d)
+	d.timePhase(&d.stats.NameTime, func() { nameFullTypes(d) })
+	if d.conservative {
+		markConservative(d)
+	}
+	d.timePhase(&d.stats.LinkTime, func() { resolveIfaces(d) })
+	d.timePhase(&d.stats.LinkTime, func() { link2(d) })
+	d.flushWarnings()
+	d.stats.LoadTime = time.Since(start)
 	return d
 }
 
-func readPtr(d *Dump, b []byte) uint64 {
-	switch d.PtrSize {
-	case 4:
-		return uint64(d.Order.Uint32(b))
-	case 8:
-		return d.Order.Uint64(b)
-	default:
-		log.Fatal("unsupported PtrSize=%d", d.PtrSize)
-		return 0
+// Warnings returns the problems found while reading the dump.  A dump
+// with warnings was still loaded, but results computed from it may be
+// inaccurate.
+func (d *Dump) Warnings() []string {
+	return d.warnings
+}
+
+// warnf records a warning about the dump, and logs it.
+func (d *Dump) warnf(format string, args ...interface{}) {
+	s := fmt.Sprintf(format, args...)
+	log.Print(s)
+	d.warnings = append(d.warnings, s)
+}
+
+// Maximum number of warnings of any one kind to report individually.
+const maxWarningsPerKind = 10
+
+// warnLimitedf records a warning of the given kind, unless there have
+// already been too many of that kind.  flushWarnings reports how many
+// were suppressed.
+func (d *Dump) warnLimitedf(kind string, format string, args ...interface{}) {
+	if d.warnCounts == nil {
+		d.warnCounts = map[string]int{}
+	}
+	d.warnCounts[kind]++
+	if d.warnCounts[kind] <= maxWarningsPerKind {
+		d.warnf(format, args...)
+	}
+}
+
+// flushWarnings records the number of suppressed warnings of each kind.
+func (d *Dump) flushWarnings() {
+	var kinds []string
+	for k := range d.warnCounts {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	for _, k := range kinds {
+		if n := d.warnCounts[k]; n > maxWarningsPerKind {
+			d.warnf("%d more %s warnings suppressed", n-maxWarningsPerKind, k)
+			d.warnCounts[k] = maxWarningsPerKind
+		}
 	}
 }
+
+// readPtr decodes the pointer at the start of b.  The pointer size
+// was checked when the params record was read, so this can't fail.
+func readPtr(d *Dump, b []byte) uint64 {
+	return d.Word(b)
+}
@@ -0,0 +1,134 @@
+package read
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testHeap = 0xc000000000
+
+// A testObject is an object of a test dump: its address, contents,
+// and the offsets of the pointers in it.
+type testObject struct {
+	addr uint64
+	data []byte
+	ptrs []uint64
+}
+
+// readTestDump writes a go1.5 heap dump of objs, in that order, with a
+// data section holding the pointer words roots, and reads it.
+func readTestDump(t *testing.T, objs []testObject, roots ...uint64) *Dump {
+	var b []byte
+	u := func(xs ...uint64) {
+		for _, x := range xs {
+			b = binary.AppendUvarint(b, x)
+		}
+	}
+	bytes := func(p []byte) {
+		u(uint64(len(p)))
+		b = append(b, p...)
+	}
+	b = append(b, "go1.5 heap dump\n"...)
+	u(6, 0, 8, testHeap, testHeap+1<<20, '6', 0, 4) // params
+	for _, o := range objs {
+		u(1, o.addr)
+		bytes(o.data)
+		for _, p := range o.ptrs {
+			u(1, p)
+		}
+		u(0)
+	}
+	u(12, 0x600000) // data segment
+	bytes(words(roots...))
+	for i := range roots {
+		u(1, uint64(8*i))
+	}
+	u(0)
+	u(13, 0x700000) // bss segment
+	bytes(words(0))
+	u(0)
+	u(10) // memstats
+	for i := 0; i < 24; i++ {
+		u(1000)
+	}
+	for i := 0; i < 256; i++ {
+		u(0)
+	}
+	u(1) // gc count
+	u(0) // eof
+	name := filepath.Join(t.TempDir(), "test.dump")
+	if err := os.WriteFile(name, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	return ReadWithOptions(name, &Options{})
+}
+
+// words returns ws as little-endian 8-byte words.
+func words(ws ...uint64) []byte {
+	var b []byte
+	for _, w := range ws {
+		b = binary.LittleEndian.AppendUint64(b, w)
+	}
+	return b
+}
+
+func TestZeroSizedObjects(t *testing.T) {
+	d := readTestDump(t, []testObject{
+		{testHeap + 32, words(testHeap, testHeap+16, testHeap+64), []uint64{0, 8, 16}},
+		{testHeap + 16, words(1, 2), nil},
+		{testHeap + 16, nil, nil}, // after the non-empty object in the dump
+		{testHeap + 64, nil, nil},
+		{testHeap, nil, nil}, // at HeapStart
+	}, testHeap+32)
+
+	// Zero-sized objects sort before a non-empty one at their address.
+	want := []struct{ addr, size uint64 }{
+		{testHeap, 0},
+		{testHeap + 16, 0},
+		{testHeap + 16, 16},
+		{testHeap + 32, 24},
+		{testHeap + 64, 0},
+	}
+	if d.NumObjects() != len(want) {
+		t.Fatalf("%d objects, want %d", d.NumObjects(), len(want))
+	}
+	for i, w := range want {
+		x := ObjId(i)
+		if d.Addr(x) != w.addr || d.Size(x) != w.size {
+			t.Errorf("object %d at %x of size %d, want %x of size %d", i, d.Addr(x), d.Size(x), w.addr, w.size)
+		}
+	}
+
+	finds := []struct {
+		addr uint64
+		want ObjId
+	}{
+		{testHeap, 0}, // zero-sized, at HeapStart
+		{testHeap + 8, ObjNil},
+		{testHeap + 16, 2}, // the non-empty object contains it
+		{testHeap + 31, 2},
+		{testHeap + 64, 4}, // zero-sized, exact address
+		{testHeap + 65, ObjNil},
+	}
+	for _, tt := range finds {
+		if got := d.FindObj(tt.addr); got != tt.want {
+			t.Errorf("FindObj(%x) = %d, want %d", tt.addr, got, tt.want)
+		}
+	}
+
+	// The pointers to zero-sized objects make them reachable, but
+	// they retain nothing.
+	for _, x := range []ObjId{0, 4} {
+		if d.Depth(x) != 1 {
+			t.Errorf("object %d has depth %d, want 1", x, d.Depth(x))
+		}
+		if r := d.RetainedSize(x); r != 0 {
+			t.Errorf("RetainedSize(%d) = %d, want 0", x, r)
+		}
+	}
+	if r := d.RetainedSize(3); r != 24+16 {
+		t.Errorf("RetainedSize(3) = %d, want %d", r, 24+16)
+	}
+}
@@ -0,0 +1,166 @@
+package read
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A MultiFile reads a dump split across several files, as collection
+// pipelines which chunk large dumps write it, as if the files had been
+// concatenated.  It is an io.ReaderAt.
+type MultiFile struct {
+	files []*os.File
+	ends  []int64 // offset just past each file
+}
+
+// OpenParts opens the files in paths, the parts of a dump in order.
+func OpenParts(paths []string) (*MultiFile, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no dump files")
+	}
+	m := &MultiFile{}
+	var end int64
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			m.Close()
+			return nil, err
+		}
+		end += fi.Size()
+		m.files = append(m.files, f)
+		m.ends = append(m.ends, end)
+	}
+	return m, nil
+}
+
+// Size returns the total size of the parts.
+func (m *MultiFile) Size() int64 {
+	if len(m.ends) == 0 {
+		return 0
+	}
+	return m.ends[len(m.ends)-1]
+}
+
+// ReadAt reads len(p) bytes at offset off of the concatenated parts,
+// from as many parts as they span.
+func (m *MultiFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	i := sort.Search(len(m.ends), func(i int) bool { return m.ends[i] > off })
+	n := 0
+	for n < len(p) {
+		if i == len(m.files) {
+			return n, io.EOF
+		}
+		start := int64(0)
+		if i > 0 {
+			start = m.ends[i-1]
+		}
+		want := p[n:]
+		if rest := m.ends[i] - off; int64(len(want)) > rest {
+			want = want[:rest]
+		}
+		k, err := m.files[i].ReadAt(want, off-start)
+		n += k
+		off += int64(k)
+		if k < len(want) {
+			if err == nil || err == io.EOF {
+				err = fmt.Errorf("%s: changed size while being read", m.files[i].Name())
+			}
+			return n, err
+		}
+		i++
+	}
+	return n, nil
+}
+
+// Close closes the parts.
+func (m *MultiFile) Close() error {
+	var err error
+	for _, f := range m.files {
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// DumpParts returns the files making up the dump named name.  If a
+// file of that name exists, it is the whole dump.  Otherwise name is a
+// filepath.Match pattern, like "heap.dump.part*", matching the parts,
+// which are ordered by name with runs of digits compared as numbers,
+// so that part10 follows part9.  Files ending in .meta, which hold the
+// metadata of the dump next to its first part, are not parts.
+func DumpParts(name string) ([]string, error) {
+	if _, err := os.Stat(name); err == nil {
+		return []string{name}, nil
+	}
+	all, err := filepath.Glob(name)
+	if err != nil {
+		return nil, err
+	}
+	var m []string
+	for _, p := range all {
+		if !strings.HasSuffix(p, ".meta") {
+			m = append(m, p)
+		}
+	}
+	if len(m) == 0 {
+		return nil, fmt.Errorf("%s: no such dump file", name)
+	}
+	sort.Slice(m, func(i, j int) bool { return naturalLess(m[i], m[j]) })
+	return m, nil
+}
+
+// naturalLess reports whether a sorts before b, comparing runs of
+// digits by their numeric value.
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			i, j := digits(a), digits(b)
+			x, y := trimZeros(a[:i]), trimZeros(b[:j])
+			if len(x) != len(y) {
+				return len(x) < len(y)
+			}
+			if x != y {
+				return x < y
+			}
+			a, b = a[i:], b[j:]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// digits returns the length of the run of digits s starts with.
+func digits(s string) int {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return i
+}
+
+func trimZeros(s string) string {
+	for len(s) > 1 && s[0] == '0' {
+		s = s[1:]
+	}
+	return s
+}
@@ -0,0 +1,53 @@
+package read
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"part9", "part10", true},
+		{"part10", "part9", false},
+		{"part09", "part10", true},
+		{"part010", "part9", false},
+		{"a", "b", true},
+		{"part1", "part1", false},
+		{"part", "part1", true},
+		{"part1a", "part1b", true},
+		{"x2y10", "x2y9", false},
+	}
+	for _, tt := range tests {
+		if got := naturalLess(tt.a, tt.b); got != tt.want {
+			t.Errorf("naturalLess(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDumpParts(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"heap.dump.part10", "heap.dump.part9", "heap.dump.part1", "heap.dump.part1.meta"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := DumpParts(filepath.Join(dir, "heap.dump.part*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, name := range []string{"heap.dump.part1", "heap.dump.part9", "heap.dump.part10"} {
+		want = append(want, filepath.Join(dir, name))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DumpParts = %q, want %q", got, want)
+	}
+	if _, err := DumpParts(filepath.Join(dir, "other*")); err == nil {
+		t.Errorf("DumpParts matched no files without an error")
+	}
+}
@@ -0,0 +1,73 @@
+package read
+
+import "strings"
+
+// RawWidth returns the size of a field of kind k holding raw bytes:
+// 4, 8 or 16 for FieldKindBytes4, FieldKindBytes8 and FieldKindBytes16,
+// and 0 for other kinds.
+func RawWidth(k FieldKind) int {
+	switch k {
+	case FieldKindBytes4:
+		return 4
+	case FieldKindBytes8:
+		return 8
+	case FieldKindBytes16:
+		return 16
+	}
+	return 0
+}
+
+// RawBytes returns a copy of the bytes of the raw byte field f, in
+// memory order, from b, the contents of the object, frame or section
+// holding it.  Fixed-size byte arrays such as hashes and UUIDs, and
+// the words of objects without dwarf types, are raw byte fields.  It
+// reports false if f isn't one or doesn't fit in b.
+func RawBytes(b []byte, f Field) ([]byte, bool) {
+	n := uint64(RawWidth(f.Kind))
+	if n == 0 || f.Offset > uint64(len(b)) || uint64(len(b))-f.Offset < n {
+		return nil, false
+	}
+	return append([]byte(nil), b[f.Offset:f.Offset+n]...), true
+}
+
+// RawWords decodes raw, the bytes of a raw byte field, as unsigned
+// integers in the dump's byte order: one for a 4- or 8-byte field,
+// two 8-byte ones for a 16-byte field.
+func (d *Dump) RawWords(raw []byte) []uint64 {
+	if len(raw) == 4 {
+		return []uint64{uint64(d.Order.Uint32(raw))}
+	}
+	var r []uint64
+	for ; len(raw) >= 8; raw = raw[8:] {
+		r = append(r, d.Order.Uint64(raw))
+	}
+	return r
+}
+
+// HexBytes formats b as two hex digits per byte, separated by spaces,
+// in memory order.
+func HexBytes(b []byte) string {
+	const digits = "0123456789abcdef"
+	var s strings.Builder
+	for i, c := range b {
+		if i > 0 {
+			s.WriteByte(' ')
+		}
+		s.WriteByte(digits[c>>4])
+		s.WriteByte(digits[c&15])
+	}
+	return s.String()
+}
+
+// PrintableBytes formats b as text, with a '.' for each byte which
+// isn't a printable ASCII character.
+func PrintableBytes(b []byte) string {
+	s := make([]byte, len(b))
+	for i, c := range b {
+		if c <= ' ' || c >= 127 {
+			c = '.'
+		}
+		s[i] = c
+	}
+	return string(s)
+}
@@ -0,0 +1,116 @@
+package read
+
+import "math/bits"
+
+// An ObjSet is a set of the objects of a dump, as a bitmap indexed
+// by ObjId.  Sets from the same dump can be combined with Union,
+// Intersect and Minus.
+type ObjSet []uint64
+
+// NewObjSet returns an empty set of d's objects.
+func (d *Dump) NewObjSet() ObjSet {
+	return make(ObjSet, (d.NumObjects()+63)/64)
+}
+
+// Has reports whether x is in s.
+func (s ObjSet) Has(x ObjId) bool {
+	return s[x/64]>>(uint(x)%64)&1 != 0
+}
+
+// Add adds x to s.
+func (s ObjSet) Add(x ObjId) {
+	s[x/64] |= 1 << (uint(x) % 64)
+}
+
+// Len returns the number of objects in s.
+func (s ObjSet) Len() int {
+	n := 0
+	for _, w := range s {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// Objs returns the objects in s, in increasing ObjId order.
+func (s ObjSet) Objs() []ObjId {
+	var r []ObjId
+	for i, w := range s {
+		for w != 0 {
+			j := bits.TrailingZeros64(w)
+			r = append(r, ObjId(i*64+j))
+			w &^= 1 << uint(j)
+		}
+	}
+	return r
+}
+
+// Bytes returns the total size of the objects of d in s.
+func (s ObjSet) Bytes(d *Dump) uint64 {
+	var n uint64
+	for _, x := range s.Objs() {
+		n += d.Size(x)
+	}
+	return n
+}
+
+// Union returns the objects in s or t.
+func (s ObjSet) Union(t ObjSet) ObjSet {
+	r := make(ObjSet, len(s))
+	for i := range s {
+		r[i] = s[i] | t[i]
+	}
+	return r
+}
+
+// Intersect returns the objects in both s and t.
+func (s ObjSet) Intersect(t ObjSet) ObjSet {
+	r := make(ObjSet, len(s))
+	for i := range s {
+		r[i] = s[i] & t[i]
+	}
+	return r
+}
+
+// Minus returns the objects in s but not in t.
+func (s ObjSet) Minus(t ObjSet) ObjSet {
+	r := make(ObjSet, len(s))
+	for i := range s {
+		r[i] = s[i] &^ t[i]
+	}
+	return r
+}
+
+// Reachable returns the objects reachable from the roots: from
+// goroutine stacks, the data and bss sections, the runtime's other
+// roots and the finalizer queue.  The rest are garbage the collector
+// hadn't freed when the dump was written.  The set is computed once
+// and shared, so it must not be modified.
+func (d *Dump) Reachable() ObjSet {
+	if d.reachable == nil {
+		d.reachable = d.ReachableFrom(d.Roots())
+	}
+	return d.reachable
+}
+
+// ReachableFrom returns the objects reachable from the given roots.
+func (d *Dump) ReachableFrom(roots []Root) ObjSet {
+	s := d.NewObjSet()
+	var q []ObjId
+	for _, r := range roots {
+		if !s.Has(r.Edge.To) {
+			s.Add(r.Edge.To)
+			q = append(q, r.Edge.To)
+		}
+	}
+	for len(q) > 0 {
+		x := q[len(q)-1]
+		q = q[:len(q)-1]
+		for _, e := range d.Edges(x) {
+			if !s.Has(e.To) {
+				s.Add(e.To)
+				q = append(q, e.To)
+			}
+		}
+	}
+	return s
+}
@@ -0,0 +1,200 @@
+package read
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// recordLayouts gives the fields of the records WriteRedacted copies
+// field by field: u for a uvarint, p for a uvarint which is zeroed
+// unless it points into the heap, b for a bool and s for a string.
+// Objects, stack frames, globals and memory profile records, which
+// hold memory or lists, are copied by hand.
+var recordLayouts = [...]string{
+	tagOtherRoot:   "su",
+	tagType:        "uusb",
+	tagGoRoutine:   "uuuuubbusuuuu",
+	tagParams:      "uuuuusu",
+	tagFinalizer:   "uuuuu",
+	tagItab:        "uu",
+	tagOSThread:    "uuu",
+	tagMemStats:    memStatsLayout,
+	tagQFinal:      "uuuuu",
+	tagDefer:       "uuuuuuu",
+	tagPanic:       "uuupuu", // the value's data word may hold the value itself
+	tagAllocSample: "uu",
+}
+
+// goarchParamsLayout is the layout of the params record in formats
+// which give the architecture by its GOARCH string.
+const goarchParamsLayout = "uuuussu"
+
+// memStatsLayout is the 24 counters, 256 pause times and GC count of a
+// memstats record.
+var memStatsLayout = func() string {
+	b := make([]byte, 24+256+1)
+	for i := range b {
+		b[i] = 'u'
+	}
+	return string(b)
+}()
+
+// WriteRedacted writes a copy of the dump to w with the program's data
+// taken out, so that dumps of processes which handled private data can
+// be shared.  The memory of objects, stack frames and globals keeps
+// only the words pointing into the heap and the type words of
+// interfaces, and is otherwise zeroed, so the copy has the same object
+// graph, types, sizes and stacks, but no strings or numbers.  Objects
+// for which keep returns false are left out; keep may be nil to keep
+// them all.  The copy of a truncated dump ends after its last complete
+// record, so it is read as truncated too.
+func (d *Dump) WriteRedacted(w io.Writer, keep func(x ObjId) bool) (err error) {
+	r := &myReader{r: bufio.NewReader(io.NewSectionReader(d.r, 0, d.stats.Bytes))}
+	bw := bufio.NewWriter(w)
+	defer func() {
+		if e := recover(); e != nil {
+			if _, ok := e.(errTruncated); !ok {
+				panic(e)
+			}
+			err = fmt.Errorf("dump ended unexpectedly while redacting it")
+		}
+	}()
+
+	hdr, _, err := r.ReadLine()
+	if err != nil {
+		return err
+	}
+	bw.Write(hdr)
+	bw.WriteByte('\n')
+
+	var buf [binary.MaxVarintLen64]byte
+	u := func(x uint64) {
+		bw.Write(buf[:binary.PutUvarint(buf[:], x)])
+	}
+	bytes := func(b []byte) {
+		u(uint64(len(b)))
+		bw.Write(b)
+	}
+	fields := func(fs []Field) {
+		for _, f := range fs {
+			u(uint64(f.Kind))
+			u(f.Offset)
+		}
+		u(uint64(FieldKindEol))
+	}
+
+	for {
+		kind := readUint64(r)
+		switch kind {
+		case tagEOF:
+			u(kind)
+			return bw.Flush()
+		case tagObject:
+			addr := readUint64(r)
+			b := readBytes(r)
+			fs := readFields(r)
+			if keep == nil || keepObject(d, addr, keep) {
+				u(kind)
+				u(addr)
+				bytes(d.redact(b, fs))
+				fields(fs)
+			}
+		case tagStackFrame:
+			u(kind)
+			u(readUint64(r)) // addr
+			u(readUint64(r)) // depth
+			u(readUint64(r)) // child
+			b := readBytes(r)
+			entry, pc, contpc := readUint64(r), readUint64(r), readUint64(r)
+			name := readBytes(r)
+			fs := readFields(r)
+			bytes(d.redact(b, fs))
+			u(entry)
+			u(pc)
+			u(contpc)
+			bytes(name)
+			fields(fs)
+		case tagData, tagBss:
+			u(kind)
+			u(readUint64(r)) // addr
+			b := readBytes(r)
+			fs := readFields(r)
+			bytes(d.redact(b, fs))
+			fields(fs)
+		case tagMemProf:
+			u(kind)
+			u(readUint64(r)) // key
+			u(readUint64(r)) // size
+			n := readUint64(r)
+			u(n)
+			for i := uint64(0); i < n; i++ {
+				bytes(readBytes(r)) // func
+				bytes(readBytes(r)) // file
+				u(readUint64(r))    // line
+			}
+			u(readUint64(r)) // allocs
+			u(readUint64(r)) // frees
+		default:
+			if kind >= uint64(len(recordLayouts)) || recordLayouts[kind] == "" {
+				return fmt.Errorf("unknown record kind %d", kind)
+			}
+			layout := recordLayouts[kind]
+			if kind == tagParams && formats[d.format].goarch {
+				layout = goarchParamsLayout
+			}
+			u(kind)
+			for _, c := range layout {
+				switch c {
+				case 'u':
+					u(readUint64(r))
+				case 'p':
+					if x := readUint64(r); x >= d.HeapStart && x < d.HeapEnd {
+						u(x)
+					} else {
+						u(0)
+					}
+				case 'b':
+					if readBool(r) {
+						bw.WriteByte(1)
+					} else {
+						bw.WriteByte(0)
+					}
+				case 's':
+					bytes(readBytes(r))
+				}
+			}
+		}
+		if r.Count() == d.stats.Bytes {
+			// A truncated dump has no eof record, and neither
+			// does its copy.
+			return bw.Flush()
+		}
+	}
+}
+
+// keepObject reports whether keep keeps the object at addr.
+func keepObject(d *Dump, addr uint64, keep func(x ObjId) bool) bool {
+	x := d.FindObj(addr)
+	return x != ObjNil && d.Addr(x) == addr && keep(x)
+}
+
+// redact returns a copy of b, memory described by fields, with only
+// the words pointing into the heap and the type words of interfaces
+// kept.
+func (d *Dump) redact(b []byte, fields []Field) []byte {
+	r := make([]byte, len(b))
+	w := d.PtrSize
+	for off := uint64(0); off+w <= uint64(len(b)); off += w {
+		if v := d.Word(b[off:]); v >= d.HeapStart && v < d.HeapEnd {
+			copy(r[off:off+w], b[off:off+w])
+		}
+	}
+	for _, f := range fields {
+		if (f.Kind == FieldKindIface || f.Kind == FieldKindEface) && f.Offset+w <= uint64(len(b)) {
+			copy(r[f.Offset:f.Offset+w], b[f.Offset:f.Offset+w])
+		}
+	}
+	return r
+}
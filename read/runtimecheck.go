@@ -0,0 +1,83 @@
+package read
+
+import "fmt"
+
+// A RuntimeCheck compares a value the dump's records give with the
+// same value decoded from the runtime's own global variables.  The
+// two disagree when the executable isn't the one which wrote the dump,
+// or the dump was misparsed.
+type RuntimeCheck struct {
+	What    string // e.g. "goroutines"
+	Global  string // where the runtime's value came from, e.g. "runtime.allglen"
+	Dump    uint64
+	Runtime uint64
+	OK      bool // the values agree
+}
+
+func (c RuntimeCheck) String() string {
+	s := "agrees"
+	if !c.OK {
+		s = "disagrees"
+	}
+	return fmt.Sprintf("%s: dump %d, %s %d: %s", c.What, c.Dump, c.Global, c.Runtime, s)
+}
+
+// RuntimeChecks returns the checks of the dump's records against the
+// runtime's globals made when it was read.  There are none unless the
+// dump was read with the dwarf info of its executable.
+func (d *Dump) RuntimeChecks() []RuntimeCheck {
+	return d.runtimeChecks
+}
+
+// checkRuntimeGlobals cross-checks the goroutine count, heap bounds
+// and cpu count against the runtime's allglen, mheap_ and ncpu, as far
+// as the executable has them, and warns about disagreements.
+func checkRuntimeGlobals(d *Dump) {
+	check := func(what, global, field string, dump uint64, ok func(dump, rt uint64) bool) {
+		b, found := d.globalField(global, field)
+		if !found {
+			return
+		}
+		rt, valid := d.uintAt(b)
+		if !valid {
+			return
+		}
+		if field != "" {
+			global += "." + field
+		}
+		c := RuntimeCheck{what, global, dump, rt, ok(dump, rt)}
+		d.runtimeChecks = append(d.runtimeChecks, c)
+		if !c.OK {
+			d.warnf("%s: the dump has %d but %s is %d; was the dump written by this executable?", what, dump, global, rt)
+		}
+	}
+	equal := func(dump, rt uint64) bool { return dump == rt }
+
+	// The dump leaves out dead goroutines, which allgs keeps.
+	atLeast := func(dump, rt uint64) bool { return rt >= dump }
+	if _, found := d.globalField("runtime.allglen", ""); found {
+		check("goroutines", "runtime.allglen", "", uint64(len(d.Goroutines)), atLeast)
+	} else {
+		check("goroutines", "runtime.allgs", "len", uint64(len(d.Goroutines)), atLeast)
+	}
+	check("heap start", "runtime.mheap_", "arena_start", d.HeapStart, equal)
+	check("heap end", "runtime.mheap_", "arena_used", d.HeapEnd, equal)
+	if d.Ncpu != 0 {
+		check("cpus", "runtime.ncpu", "", d.Ncpu, equal)
+	}
+}
+
+// uintAt decodes b, 1, 2, 4 or 8 bytes long, as an unsigned integer.
+func (d *Dump) uintAt(b []byte) (uint64, bool) {
+	switch len(b) {
+	case 1:
+		return uint64(b[0]), true
+	case 2:
+		return uint64(d.Order.Uint16(b)), true
+	case 4:
+		return uint64(d.Order.Uint32(b)), true
+	case 8:
+		return d.Order.Uint64(b), true
+	}
+	return 0, false
+}
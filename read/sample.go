@@ -0,0 +1,104 @@
+package read
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// SampleObjects returns a uniform random sample of n distinct objects,
+// in increasing ObjId order.  If the dump has n objects or fewer, it
+// returns them all.  The same seed always gives the same sample of
+// the same dump.
+//
+// An analysis too expensive to run on every object can run on the
+// sample instead and scale up: with k of the n sampled objects having
+// some property, about k/n of the heap's objects have it.
+func (d *Dump) SampleObjects(n int, seed int64) []ObjId {
+	m := d.NumObjects()
+	if n >= m {
+		r := make([]ObjId, m)
+		for i := range r {
+			r[i] = ObjId(i)
+		}
+		return r
+	}
+	if n <= 0 {
+		return nil
+	}
+	// Floyd's algorithm picks n distinct values from [0,m) with n
+	// random numbers.
+	rnd := rand.New(rand.NewSource(seed))
+	picked := make(map[ObjId]bool, n)
+	r := make([]ObjId, 0, n)
+	for j := m - n; j < m; j++ {
+		x := ObjId(rnd.Intn(j + 1))
+		if picked[x] {
+			x = ObjId(j)
+		}
+		picked[x] = true
+		r = append(r, x)
+	}
+	sort.Sort(byObjId(r))
+	return r
+}
+
+// SampleObjectsBySize returns a sample of n objects, each chosen with
+// probability proportional to its size, in increasing ObjId order.
+// Objects are chosen independently, so a large object may appear more
+// than once.  Every byte of the heap is equally likely to be sampled,
+// so with k of the n samples having some property, about k/n of the
+// heap's bytes are in objects having it.
+func (d *Dump) SampleObjectsBySize(n int, seed int64) []ObjId {
+	m := d.NumObjects()
+	if n <= 0 || m == 0 {
+		return nil
+	}
+	// cum[i] is the total size of objects 0 through i.
+	cum := make([]uint64, m)
+	var total uint64
+	for i := 0; i < m; i++ {
+		total += d.Size(ObjId(i))
+		cum[i] = total
+	}
+	if total == 0 {
+		return nil
+	}
+	rnd := rand.New(rand.NewSource(seed))
+	r := make([]ObjId, n)
+	for j := range r {
+		b := uint64(rnd.Int63n(int64(total)))
+		r[j] = ObjId(sort.Search(m, func(i int) bool { return cum[i] > b }))
+	}
+	sort.Sort(byObjId(r))
+	return r
+}
+
+// SampleEdges returns a uniform random sample of at most n of the
+// edges of x, in increasing offset order, and the number of edges x
+// has.  The same seed always gives the same sample of the same
+// object.  Like the result of Edges, the sample is overwritten by the
+// next call to either.
+//
+// A map or slice with millions of entries has millions of edges.
+// Traversals and drawings which only need the graph's shape can follow
+// a sample of them instead, and use the count to scale up.
+func (d *Dump) SampleEdges(x ObjId, n int, seed int64) ([]Edge, int) {
+	e := d.Edges(x)
+	if len(e) <= n {
+		return e, len(e)
+	}
+	if n <= 0 {
+		return nil, len(e)
+	}
+	// Reservoir sampling, in place: e[:n] holds a sample of the edges
+	// seen so far.
+	rnd := rand.New(rand.NewSource(seed ^ int64(x)))
+	for i := n; i < len(e); i++ {
+		if j := rnd.Intn(i + 1); j < n {
+			e[j] = e[i]
+		}
+	}
+	s := e[:n]
+	sort.Slice(s, func(i, j int) bool { return s[i].FromOffset < s[j].FromOffset })
+	return s, len(e)
+}
@@ -0,0 +1,108 @@
+package read
+
+import "math"
+
+// A Scalar is a non-pointer value in an object: an integer, float or
+// bool field, the length or capacity of a string or slice, or, for
+// objects without dwarf types, a word the gc signature says holds no
+// pointer.
+type Scalar struct {
+	// Name is the field's name, with ".len" or ".cap" appended for
+	// the lengths and capacities of strings and slices.  In objects
+	// without dwarf types it is the index of the word.
+	Name   string
+	Offset uint64
+	// Kind is the field's kind.  Untyped words have kind
+	// FieldKindBytes4 or FieldKindBytes8, and are treated as unsigned.
+	Kind FieldKind
+	// Bits holds the value as it is stored, zero-extended.
+	Bits uint64
+}
+
+// Signed reports whether s holds a signed integer.
+func (s Scalar) Signed() bool {
+	switch s.Kind {
+	case FieldKindSInt8, FieldKindSInt16, FieldKindSInt32, FieldKindSInt64:
+		return true
+	}
+	return false
+}
+
+// Int returns s as a signed integer, sign-extending signed kinds.
+func (s Scalar) Int() int64 {
+	switch s.Kind {
+	case FieldKindSInt8:
+		return int64(int8(s.Bits))
+	case FieldKindSInt16:
+		return int64(int16(s.Bits))
+	case FieldKindSInt32:
+		return int64(int32(s.Bits))
+	}
+	return int64(s.Bits)
+}
+
+// Uint returns s as an unsigned integer.
+func (s Scalar) Uint() uint64 {
+	return s.Bits
+}
+
+// Float returns the value of s: floats are decoded, integers
+// converted, and bools are 0 or 1.
+func (s Scalar) Float() float64 {
+	switch {
+	case s.Kind == FieldKindFloat32:
+		return float64(math.Float32frombits(uint32(s.Bits)))
+	case s.Kind == FieldKindFloat64:
+		return math.Float64frombits(s.Bits)
+	case s.Signed():
+		return float64(s.Int())
+	}
+	return float64(s.Bits)
+}
+
+// Scalars decodes the non-pointer values of object x, in offset
+// order.  Complex numbers, 16-byte untyped fields and whatever
+// TypeFields elides are left out.
+func (d *Dump) Scalars(x ObjId) []Scalar {
+	b := d.Contents(x)
+	var lenKind FieldKind = FieldKindUInt64
+	if d.PtrSize == 4 {
+		lenKind = FieldKindUInt32
+	}
+	var r []Scalar
+	add := func(name string, off uint64, kind FieldKind, size uint64) {
+		if off+size > uint64(len(b)) {
+			return
+		}
+		var v uint64
+		switch size {
+		case 1:
+			v = uint64(b[off])
+		case 2:
+			v = uint64(d.Order.Uint16(b[off:]))
+		case 4:
+			v = uint64(d.Order.Uint32(b[off:]))
+		case 8:
+			v = d.Order.Uint64(b[off:])
+		}
+		r = append(r, Scalar{name, off, kind, v})
+	}
+	for _, f := range d.TypeFields(d.Ft(x)) {
+		switch f.Kind {
+		case FieldKindBool, FieldKindUInt8, FieldKindSInt8:
+			add(f.Name, f.Offset, f.Kind, 1)
+		case FieldKindUInt16, FieldKindSInt16:
+			add(f.Name, f.Offset, f.Kind, 2)
+		case FieldKindUInt32, FieldKindSInt32, FieldKindFloat32, FieldKindBytes4:
+			add(f.Name, f.Offset, f.Kind, 4)
+		case FieldKindUInt64, FieldKindSInt64, FieldKindFloat64, FieldKindBytes8:
+			add(f.Name, f.Offset, f.Kind, 8)
+		case FieldKindString:
+			add(f.Name+".len", f.Offset+d.PtrSize, lenKind, d.PtrSize)
+		case FieldKindSlice:
+			add(f.Name+".len", f.Offset+d.PtrSize, lenKind, d.PtrSize)
+			add(f.Name+".cap", f.Offset+2*d.PtrSize, lenKind, d.PtrSize)
+		}
+	}
+	return r
+}
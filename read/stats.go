@@ -0,0 +1,166 @@
+package read
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// names of the record kinds, indexed by tag
+var tagNames = [...]string{
+	tagEOF:         "eof",
+	tagObject:      "object",
+	tagOtherRoot:   "otherroot",
+	tagType:        "type",
+	tagGoRoutine:   "goroutine",
+	tagStackFrame:  "stackframe",
+	tagParams:      "params",
+	tagFinalizer:   "finalizer",
+	tagItab:        "itab",
+	tagOSThread:    "osthread",
+	tagMemStats:    "memstats",
+	tagQFinal:      "qfinal",
+	tagData:        "data",
+	tagBss:         "bss",
+	tagDefer:       "defer",
+	tagPanic:       "panic",
+	tagMemProf:     "memprof",
+	tagAllocSample: "allocsample",
+}
+
+// Stats describes the work done to load a dump.
+type Stats struct {
+	// Records maps record kinds ("object", "stackframe", ...) to the
+	// number of records of that kind in the dump.
+	Records map[string]uint64
+	// Bytes is the number of bytes read from the dump file.
+	Bytes int64
+
+	// LoadTime is the elapsed time to load the dump.  It may be
+	// less than the sum of the phases, since reading dwarf info
+	// overlaps reading and linking the dump.
+	LoadTime time.Duration
+
+	// Time spent in each phase of loading.
+	ReadTime  time.Duration // parsing the dump file
+	LinkTime  time.Duration // building indexes and linking records together
+	DwarfTime time.Duration // reading dwarf info from executables
+	TypeTime  time.Duration // propagating dwarf types through the heap
+	NameTime  time.Duration // naming fields
+
+	// PeakHeap is the largest amount of Go heap in use by the loader
+	// at the end of any phase.
+	PeakHeap uint64
+	// ObjectBytes and IndexBytes estimate the memory used by the object
+	// table and by the FindObj index, respectively.
+	ObjectBytes uint64
+	IndexBytes  uint64
+
+	// ObjectsOutsideWindow is the number of objects left out because
+	// they start outside Options.Window.
+	ObjectsOutsideWindow uint64
+
+	// UnresolvedIfaces is the number of distinct type and itab addresses
+	// found in interface values which the dump doesn't describe, and
+	// UnresolvedIfaceValues is the number of interface values, in the
+	// heap, stack frames and globals, holding them.  Both are counted
+	// at load.
+	UnresolvedIfaces      int
+	UnresolvedIfaceValues uint64
+
+	// With Options.ProfileIndex, IndexLookups counts the FindObj calls
+	// so far, IndexProbes the objects they examined, and IndexMisses
+	// the calls which found no object, including those for addresses
+	// outside the heap.
+	// IndexBucketSize is the number of bytes of heap each bucket of
+	// the index covers.
+	IndexLookups, IndexProbes, IndexMisses uint64
+	IndexBucketSize                        uint64
+
+	// CacheHits and CacheMisses count the blocks of the dump file
+	// found and not found in the block cache.  Both are zero if the
+	// dump was read without a cache.
+	CacheHits   uint64
+	CacheMisses uint64
+}
+
+// Stats returns statistics about the loading of the dump.
+func (d *Dump) Stats() Stats {
+	s := d.stats
+	s.Records = map[string]uint64{}
+	for tag, n := range d.recordCounts {
+		if n != 0 {
+			s.Records[tagNames[tag]] = n
+		}
+	}
+	s.ObjectBytes = uint64(cap(d.objects)) * uint64(unsafe.Sizeof(object{}))
+	s.IndexBytes = uint64(cap(d.idx)) * uint64(unsafe.Sizeof(ObjNil))
+	s.IndexLookups = atomic.LoadUint64(&d.findLookups)
+	s.IndexProbes = atomic.LoadUint64(&d.findProbes)
+	s.IndexMisses = atomic.LoadUint64(&d.findMisses)
+	s.IndexBucketSize = bucketSize
+	s.UnresolvedIfaces = len(d.unresolved)
+	for _, n := range d.unresolved {
+		s.UnresolvedIfaceValues += n
+	}
+	if d.cache != nil {
+		s.CacheHits = d.cache.hits
+		s.CacheMisses = d.cache.misses
+	}
+	return s
+}
+
+func (s Stats) String() string {
+	var kinds []string
+	for k := range s.Records {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	var b strings.Builder
+	fmt.Fprintf(&b, "read %d bytes:", s.Bytes)
+	for _, k := range kinds {
+		fmt.Fprintf(&b, " %s=%d", k, s.Records[k])
+	}
+	fmt.Fprintf(&b, "\nloaded in %v: read %v, link %v, dwarf %v, types %v, names %v",
+		s.LoadTime, s.ReadTime, s.LinkTime, s.DwarfTime, s.TypeTime, s.NameTime)
+	fmt.Fprintf(&b, "\npeak heap %d bytes, objects %d bytes, index %d bytes",
+		s.PeakHeap, s.ObjectBytes, s.IndexBytes)
+	if s.IndexLookups > 0 {
+		fmt.Fprintf(&b, "\nindex %d lookups, %.2f objects examined each, %d misses",
+			s.IndexLookups, float64(s.IndexProbes)/float64(s.IndexLookups), s.IndexMisses)
+	}
+	if s.ObjectsOutsideWindow > 0 {
+		fmt.Fprintf(&b, "\n%d objects outside the window left out", s.ObjectsOutsideWindow)
+	}
+	if s.UnresolvedIfaces > 0 {
+		fmt.Fprintf(&b, "\n%d unresolved interface types in %d interface values",
+			s.UnresolvedIfaces, s.UnresolvedIfaceValues)
+	}
+	if n := s.CacheHits + s.CacheMisses; n > 0 {
+		fmt.Fprintf(&b, "\ncache %d hits, %d misses (%.1f%% hit rate)",
+			s.CacheHits, s.CacheMisses, 100*float64(s.CacheHits)/float64(n))
+	}
+	return b.String()
+}
+
+// timePhase runs f and adds the time it took to *t.  It also
+// updates the peak memory estimate.
+func (d *Dump) timePhase(t *time.Duration, f func()) {
+	start := time.Now()
+	f()
+	*t += time.Since(start)
+	d.notePeak()
+}
+
+// notePeak records the current heap size, if it is a new maximum.
+func (d *Dump) notePeak() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	if m.HeapAlloc > d.stats.PeakHeap {
+		d.stats.PeakHeap = m.HeapAlloc
+	}
+}
@@ -0,0 +1,92 @@
+package read
+
+import "strings"
+
+// A Truncation describes a dump which ends before its eof record, as
+// the dumps of processes which die while writing them do.  Such a dump
+// is loaded up to its last complete record, and is partial: the
+// records the runtime writes after the heap, like goroutines, stack
+// frames, globals and memstats, may be missing, and with them the
+// roots which keep objects alive.
+type Truncation struct {
+	// Offset is the number of bytes of the dump up to the end of the
+	// last complete record.
+	Offset int64
+	// Record is the kind of the record cut off, such as "object", or
+	// "" if the dump ends between records.
+	Record string
+	// HeapCovered is the number of bytes from the start of the heap
+	// to the end of the highest object read, out of HeapSize.  The
+	// runtime writes objects a span at a time, mostly in address
+	// order, so this is roughly how much of the heap segment was
+	// dumped.
+	HeapCovered, HeapSize uint64
+	// Missing lists the kinds of records, such as "goroutine" or
+	// "data", which every complete dump has but this one doesn't.
+	Missing []string
+}
+
+// errTruncated is panicked by the functions reading records when the
+// dump ends in the middle of one, and recovered by rawRead.
+type errTruncated struct{}
+
+// kinds of records every complete dump has
+var requiredTags = []int{tagParams, tagObject, tagGoRoutine, tagStackFrame, tagData, tagBss, tagMemStats}
+
+// Partial reports whether the dump was truncated, so that some of its
+// records are missing.
+func (d *Dump) Partial() bool {
+	return d.truncation != nil
+}
+
+// Truncation describes how the dump was truncated, or is nil if it is
+// complete.
+func (d *Dump) Truncation() *Truncation {
+	return d.truncation
+}
+
+// finishTruncated records that d, read up to offset, ends in the
+// middle of a record of kind tag, or between records if tag is -1.
+// heapEnd is the end of the highest object read.
+func finishTruncated(d *Dump, offset int64, tag int, heapEnd uint64) {
+	t := &Truncation{Offset: offset, HeapSize: d.HeapEnd - d.HeapStart}
+	if tag >= 0 {
+		t.Record = "unknown"
+		if tag < len(tagNames) {
+			t.Record = tagNames[tag]
+			d.recordCounts[tag]--
+		}
+	}
+	if heapEnd > d.HeapStart {
+		t.HeapCovered = heapEnd - d.HeapStart
+	}
+	for _, tag := range requiredTags {
+		if d.recordCounts[tag] == 0 {
+			t.Missing = append(t.Missing, tagNames[tag])
+		}
+	}
+	d.truncation = t
+	d.stats.Bytes = offset
+
+	addMissingSections(d)
+
+	where := "between records"
+	if t.Record != "" {
+		where = "with a " + t.Record + " record cut off"
+	}
+	d.warnf("dump is truncated after %d bytes, %s; the objects read cover %d of the heap's %d bytes", offset, where, t.HeapCovered, t.HeapSize)
+	if t.Missing != nil {
+		d.warnf("truncated dump has no %s records", strings.Join(t.Missing, ", "))
+	}
+}
+
+// addMissingSections gives d empty data and bss sections if it has no
+// records for them, as the rest of the package expects both.
+func addMissingSections(d *Dump) {
+	if d.Data == nil {
+		d.Data = &Data{name: "data", d: d}
+	}
+	if d.Bss == nil {
+		d.Bss = &Data{name: "bss", d: d}
+	}
+}
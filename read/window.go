@@ -0,0 +1,133 @@
+package read
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// An AddrRange is the range of addresses [Lo, Hi).  The zero
+// AddrRange stands for the whole address space.
+type AddrRange struct {
+	Lo, Hi uint64
+}
+
+// IsZero reports whether r is the zero AddrRange.
+func (r AddrRange) IsZero() bool {
+	return r == AddrRange{}
+}
+
+// Contains reports whether addr is in r.
+func (r AddrRange) Contains(addr uint64) bool {
+	return r.IsZero() || addr >= r.Lo && addr < r.Hi
+}
+
+func (r AddrRange) String() string {
+	return fmt.Sprintf("%x-%x", r.Lo, r.Hi)
+}
+
+// ParseAddrRange parses a range written as String writes it, two hex
+// addresses separated by a dash, like "c000000000-c004000000".
+func ParseAddrRange(s string) (AddrRange, error) {
+	i := strings.Index(s, "-")
+	if i < 0 {
+		return AddrRange{}, fmt.Errorf("address range %q: want lo-hi", s)
+	}
+	lo, err := strconv.ParseUint(strings.TrimPrefix(s[:i], "0x"), 16, 64)
+	if err != nil {
+		return AddrRange{}, fmt.Errorf("address range %q: %v", s, err)
+	}
+	hi, err := strconv.ParseUint(strings.TrimPrefix(s[i+1:], "0x"), 16, 64)
+	if err != nil {
+		return AddrRange{}, fmt.Errorf("address range %q: %v", s, err)
+	}
+	if lo >= hi {
+		return AddrRange{}, fmt.Errorf("address range %q is empty", s)
+	}
+	return AddrRange{lo, hi}, nil
+}
+
+// Split divides r into n ranges of about the same size, each a
+// multiple of align bytes except perhaps the last, for analyzing a
+// dump in shards.  It returns fewer than n if r is too small.
+func (r AddrRange) Split(n int, align uint64) []AddrRange {
+	if n < 1 {
+		n = 1
+	}
+	if align == 0 {
+		align = 1
+	}
+	step := (r.Hi - r.Lo) / uint64(n)
+	step = (step + align - 1) / align * align
+	if step == 0 {
+		step = align
+	}
+	var s []AddrRange
+	for lo := r.Lo; lo < r.Hi; {
+		hi := lo + step
+		if hi > r.Hi || hi < lo {
+			hi = r.Hi
+		}
+		s = append(s, AddrRange{lo, hi})
+		lo = hi // not lo += step, which can wrap around past r.Hi
+	}
+	return s
+}
+
+// HeapRange returns the range of the dumped process's heap.
+func (d *Dump) HeapRange() AddrRange {
+	return AddrRange{d.HeapStart, d.HeapEnd}
+}
+
+// Window returns the range of addresses the dump's objects were
+// loaded from: Options.Window, within the heap, or the whole heap.
+func (d *Dump) Window() AddrRange {
+	return d.window
+}
+
+// OutsidePointers returns the pointers in object x to heap addresses
+// outside the window the dump was loaded with.  Edges leaves them out,
+// as they point to no loaded object; analyses of a heap split into
+// windows follow them in the windows they point into.  In objects
+// scanned conservatively, every such word counts.
+func (d *Dump) OutsidePointers(x ObjId) []uint64 {
+	if d.window == d.HeapRange() {
+		return nil
+	}
+	var r []uint64
+	add := func(p uint64) {
+		if p >= d.HeapStart && p < d.HeapEnd && !d.window.Contains(p) && d.FindObj(p) == ObjNil {
+			r = append(r, p)
+		}
+	}
+	b := d.Contents(x)
+	ft := d.objects[x].Ft
+	if ft.Kind == TypeKindConservative {
+		for off := uint64(0); off+d.PtrSize <= uint64(len(b)); off += d.PtrSize {
+			add(readPtr(d, b[off:]))
+		}
+		return r
+	}
+	for _, f := range ft.Fields {
+		if (f.Kind == FieldKindEface || f.Kind == FieldKindIface) && f.Offset+2*d.PtrSize > uint64(len(b)) {
+			continue
+		}
+		switch f.Kind {
+		case FieldKindPtr:
+			add(readPtr(d, b[f.Offset:]))
+		case FieldKindEface:
+			if t := readPtr(d, b[f.Offset:]); t != 0 {
+				if ptr, _ := d.efaceHasPtr(t); ptr {
+					add(readPtr(d, b[f.Offset+d.PtrSize:]))
+				}
+			}
+		case FieldKindIface:
+			if t := readPtr(d, b[f.Offset:]); t != 0 {
+				if ptr, _ := d.ifaceHasPtr(t); ptr {
+					add(readPtr(d, b[f.Offset+d.PtrSize:]))
+				}
+			}
+		}
+	}
+	return r
+}
@@ -0,0 +1,50 @@
+package read
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAddrRange(t *testing.T) {
+	tests := []struct {
+		s    string
+		want AddrRange
+		ok   bool
+	}{
+		{"c000000000-c004000000", AddrRange{0xc000000000, 0xc004000000}, true},
+		{"0x1000-0x2000", AddrRange{0x1000, 0x2000}, true},
+		{"1000", AddrRange{}, false},
+		{"2000-1000", AddrRange{}, false},
+		{"1000-1000", AddrRange{}, false},
+		{"1000-zz", AddrRange{}, false},
+	}
+	for _, tt := range tests {
+		r, err := ParseAddrRange(tt.s)
+		if (err == nil) != tt.ok || r != tt.want {
+			t.Errorf("ParseAddrRange(%q) = %v, %v; want %v, ok %v", tt.s, r, err, tt.want, tt.ok)
+		}
+		if r2, err := ParseAddrRange(r.String()); tt.ok && (err != nil || r2 != r) {
+			t.Errorf("ParseAddrRange(%q) = %v, %v; want %v", r.String(), r2, err, r)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		r     AddrRange
+		n     int
+		align uint64
+		want  []AddrRange
+	}{
+		{AddrRange{0, 100}, 4, 0, []AddrRange{{0, 25}, {25, 50}, {50, 75}, {75, 100}}},
+		{AddrRange{0, 100}, 3, 16, []AddrRange{{0, 48}, {48, 96}, {96, 100}}},
+		{AddrRange{0, 10}, 0, 1, []AddrRange{{0, 10}}},
+		{AddrRange{0, 4}, 8, 8, []AddrRange{{0, 4}}},
+		{AddrRange{^uint64(0) - 10, ^uint64(0)}, 2, 8, []AddrRange{{^uint64(0) - 10, ^uint64(0) - 2}, {^uint64(0) - 2, ^uint64(0)}}},
+	}
+	for _, tt := range tests {
+		if got := tt.r.Split(tt.n, tt.align); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v.Split(%d, %d) = %v, want %v", tt.r, tt.n, tt.align, got, tt.want)
+		}
+	}
+}